@@ -3,19 +3,52 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
 	openrouter "github.com/revrost/go-openrouter"
 )
 
+// Режимы работы сервиса (переменная LLM_MODE).
+const (
+	modeStub             = "stub"               // всегда отдаём заглушку, модель не вызывается
+	modeLive             = "live"               // только настоящая модель, ошибки апстрима возвращаются клиенту
+	modeLiveWithFallback = "live-with-fallback" // настоящая модель, при ошибке — заглушка
+)
+
+const (
+	sourceHeader = "X-LLM-Source"
+	sourceStub   = "stub"
+	sourceLive   = "live"
+)
+
+// Заголовки ответа об обезличивании: сколько значений заменено и id
+// зашифрованной таблицы замен в хранилище.
+const (
+	piiRedactedHeader    = "X-PII-Redacted"
+	piiRedactionIDHeader = "X-PII-Redaction-ID"
+)
+
+const defaultModel = "openai/gpt-4o"
+
+// providerOpenRouter — единственный бэкенд, который поддерживает сервис; его
+// ждёт messages-service в поле provider задачи.
+const providerOpenRouter = "openrouter"
+
 var (
-	fullPrompt   string
+	prompts      *Prompts
 	client       *openrouter.Client
 	stubResponse json.RawMessage
+	mode         string
+	llmModel     string
+	defaultOrg   []byte // org.json — для задач без оргструктуры ящика
+	piiPolicies  PIIPolicies
+	vault        *Vault // nil — таблицы замен не сохраняются
 )
 
 func main() {
@@ -30,28 +63,62 @@ func main() {
 		log.Fatalf("LLM_STUB_RESPONSE is not valid JSON")
 	}
 
-	stubResponse = json.RawMessage(rawStub)
+	var err error
+	stubResponse, err = markStub(rawStub)
+	if err != nil {
+		log.Fatalf("failed to prepare stub response: %v", err)
+	}
 
-	if apiKey == "" {
-		log.Print("OPENROUTER_API_KEY not provided — running in stub mode")
-	} else {
+	mode, err = resolveMode(os.Getenv("LLM_MODE"), apiKey)
+	if err != nil {
+		log.Fatalf("invalid LLM_MODE: %v", err)
+	}
+
+	if mode != modeStub {
 		client = openrouter.NewClient(
 			apiKey,
 			openrouter.WithXTitle("My App"),
 			openrouter.WithHTTPReferer("https://myapp.com"),
 		)
 	}
-	systemPromptBytes, err := os.ReadFile("systemprompt.txt")
+	log.Printf("llm mode: %s", mode)
+
+	llmModel = strings.TrimSpace(os.Getenv("LLM_MODEL"))
+	if llmModel == "" {
+		llmModel = defaultModel
+	}
+
+	piiPolicies, err = loadPIIPolicies(strings.TrimSpace(os.Getenv("PII_POLICY_FILE")))
 	if err != nil {
-		log.Fatalf("Ошибка чтения systemprompt.txt: %v", err)
+		log.Fatalf("failed to load PII policy: %v", err)
+	}
+	if err := piiPolicies.validate(); err != nil {
+		log.Fatalf("invalid PII policy: %v", err)
+	}
+	redactor, _ := piiPolicies.redactorFor(llmModel)
+	log.Printf("pii redaction for %s: %t", llmModel, redactor != nil)
+
+	if dir := strings.TrimSpace(os.Getenv("PII_VAULT_DIR")); dir != "" {
+		vault, err = NewVault(dir, strings.TrimSpace(os.Getenv("PII_VAULT_KEY")))
+		if err != nil {
+			log.Fatalf("failed to open PII vault: %v", err)
+		}
 	}
 
-	orgBytes, err := os.ReadFile("org.json")
+	defaultOrg, err = os.ReadFile("org.json")
 	if err != nil {
 		log.Fatalf("Ошибка чтения org.json: %v", err)
 	}
 
-	fullPrompt = strings.Replace(string(systemPromptBytes), "<ORGANIZATION_JSON>", string(orgBytes), 1)
+	prompts, err = loadPrompts(
+		"systemprompt.txt",
+		strings.TrimSpace(os.Getenv("PROMPT_DIR")),
+		strings.TrimSpace(os.Getenv("DEFAULT_PROMPT_VERSION")),
+	)
+	if err != nil {
+		log.Fatalf("Ошибка чтения промптов: %v", err)
+	}
+	log.Printf("prompt versions: %s", strings.Join(prompts.Versions(), ", "))
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/process", processHandler)
@@ -84,27 +151,74 @@ func processHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userInput := string(body)
+	task := taskOptionsOf(body)
+	userInput := task.Input
 
-	if client == nil {
+	if mode == modeStub {
 		writeStub(w)
 		return
 	}
 
+	// Версию промпта и оргструктуру выбирает messages-service по общему ящику
+	// письма; неизвестная версия — ошибка конфигурации, а не повод подменить
+	// промпт.
+	org := task.Organization
+	if org == nil {
+		org = defaultOrg
+	}
+	systemPrompt, err := prompts.Get(task.PromptVersion, org)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Провайдера и модель задаёт тенант письма; без них работает LLM_MODEL.
+	if task.Provider != "" && task.Provider != providerOpenRouter {
+		http.Error(w, "unsupported provider "+strconv.Quote(task.Provider), http.StatusBadRequest)
+		return
+	}
+	model := task.Model
+	if model == "" {
+		model = llmModel
+	}
+
+	// Персональные данные не уходят внешнему провайдеру: модель видит
+	// плейсхолдеры, а в recommended_response исходные значения возвращаются.
+	// Политика выбирается по провайдеру модели этой задачи.
+	redactor, err := piiPolicies.redactorFor(model)
+	if err != nil {
+		log.Printf("invalid PII policy for %s: %v", model, err)
+		http.Error(w, "invalid PII policy", http.StatusInternalServerError)
+		return
+	}
+	var redaction *Redaction
+	if redactor != nil {
+		redaction = redactor.Redact(userInput)
+		userInput = redaction.Text
+	}
+
 	resp, err := client.CreateChatCompletion(
 		context.Background(),
 		openrouter.ChatCompletionRequest{
-			Model: "openai/gpt-4o",
+			Model: model,
 			Messages: []openrouter.ChatCompletionMessage{
-				openrouter.SystemMessage(fullPrompt),
+				openrouter.SystemMessage(systemPrompt),
 				openrouter.UserMessage(userInput),
 			},
 			MaxTokens: 1500,
 		},
 	)
+	if err == nil && len(resp.Choices) == 0 {
+		err = errors.New("model returned no choices")
+	}
 	if err != nil {
-		log.Printf("ChatCompletion error, falling back to stub: %v", err)
-		writeStub(w)
+		if mode == modeLiveWithFallback {
+			log.Printf("ChatCompletion error, falling back to stub: %v", err)
+			writeStub(w)
+			return
+		}
+		log.Printf("ChatCompletion error: %v", err)
+		writeUpstreamError(w, err)
 		return
 	}
 
@@ -116,16 +230,103 @@ func processHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if redaction != nil && len(redaction.Values) > 0 {
+		jsonOnly, err = restoreResponse(jsonOnly, redaction)
+		if err != nil {
+			http.Error(w, "failed to restore redacted values", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(piiRedactedHeader, strconv.Itoa(len(redaction.Values)))
+		if vault != nil {
+			id, err := vault.Save(model, redaction)
+			if err != nil {
+				log.Printf("failed to store redaction map: %v", err)
+			} else {
+				w.Header().Set(piiRedactionIDHeader, id)
+			}
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(sourceHeader, sourceLive)
 	_, _ = w.Write([]byte(jsonOnly))
 }
 
 func writeStub(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-LLM-Source", "stub")
+	w.Header().Set(sourceHeader, sourceStub)
 	_, _ = w.Write(stubResponse)
 }
 
+// writeUpstreamError отдаёт ошибку апстрима клиенту: 503, если провайдер
+// временно недоступен или ограничивает запросы, иначе 502.
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	status := http.StatusBadGateway
+	upstreamStatus := 0
+
+	var apiErr *openrouter.APIError
+	var reqErr *openrouter.RequestError
+	switch {
+	case errors.As(err, &apiErr):
+		upstreamStatus = apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		upstreamStatus = reqErr.HTTPStatusCode
+	}
+
+	switch upstreamStatus {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(sourceHeader, sourceLive)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":           "upstream llm request failed",
+		"details":         err.Error(),
+		"upstream_status": upstreamStatus,
+	})
+}
+
+func resolveMode(raw string, apiKey string) (string, error) {
+	switch strings.TrimSpace(raw) {
+	case "":
+		// Поведение по умолчанию сохраняет прежнюю логику: без ключа — заглушка,
+		// с ключом — модель с откатом на заглушку.
+		if apiKey == "" {
+			log.Print("OPENROUTER_API_KEY not provided — running in stub mode")
+			return modeStub, nil
+		}
+		return modeLiveWithFallback, nil
+	case modeStub:
+		return modeStub, nil
+	case modeLive, modeLiveWithFallback:
+		if apiKey == "" {
+			return "", errors.New("OPENROUTER_API_KEY is required for " + raw + " mode")
+		}
+		return strings.TrimSpace(raw), nil
+	default:
+		return "", errors.New("unknown mode " + raw)
+	}
+}
+
+// markStub добавляет в ответ-заглушку поле "source":"stub", чтобы потребители
+// могли отличить её от настоящей классификации даже без HTTP-заголовков.
+func markStub(raw string) (json.RawMessage, error) {
+	var body map[string]any
+	if err := json.Unmarshal([]byte(raw), &body); err != nil {
+		return nil, err
+	}
+	body["source"] = sourceStub
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 func extractJSON(text string) string {
 	start := strings.Index(text, "{")
 	end := strings.LastIndex(text, "}")
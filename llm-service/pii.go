@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Detector находит в тексте персональные данные одного вида.
+type Detector interface {
+	// Kind — вид данных; из него строится плейсхолдер вида [CARD_1].
+	Kind() string
+	// Find возвращает пары [start, end) найденных значений.
+	Find(text string) [][2]int
+}
+
+// regexDetector находит значения по регулярному выражению и, если задан
+// valid, отбрасывает совпадения, не прошедшие проверку (контрольную сумму).
+type regexDetector struct {
+	kind  string
+	re    *regexp.Regexp
+	valid func(match string) bool
+}
+
+func (d regexDetector) Kind() string { return d.kind }
+
+func (d regexDetector) Find(text string) [][2]int {
+	var spans [][2]int
+	for _, loc := range d.re.FindAllStringIndex(text, -1) {
+		if d.valid == nil || d.valid(text[loc[0]:loc[1]]) {
+			spans = append(spans, [2]int{loc[0], loc[1]})
+		}
+	}
+	return spans
+}
+
+// detectors — встроенные детекторы в порядке приоритета: при пересечении
+// совпадений побеждает детектор, стоящий раньше.
+var detectors = []Detector{
+	regexDetector{kind: "CARD", re: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: validCard},
+	regexDetector{kind: "ACCOUNT", re: regexp.MustCompile(`\b\d{20}\b`)},
+	regexDetector{kind: "SNILS", re: regexp.MustCompile(`\b\d{3}-?\d{3}-?\d{3}[ -]?\d{2}\b`), valid: validSNILS},
+	regexDetector{kind: "INN", re: regexp.MustCompile(`\b(?:\d{12}|\d{10})\b`), valid: validINN},
+	regexDetector{kind: "PASSPORT", re: regexp.MustCompile(`\b\d{2} ?\d{2} (?:№ ?)?\d{6}\b`)},
+	regexDetector{kind: "PHONE", re: regexp.MustCompile(`(?:\+7|\b8)[ (-]*\d{3}[ )-]*\d{3}[ -]*\d{2}[ -]*\d{2}\b`)},
+	regexDetector{kind: "EMAIL", re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+}
+
+func digitsOf(s string) []int {
+	digits := make([]int, 0, len(s))
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, int(r-'0'))
+		}
+	}
+	return digits
+}
+
+// validCard — номер карты из 13–19 цифр с верной контрольной суммой Луна.
+func validCard(match string) bool {
+	digits := digitsOf(match)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := digits[i]
+		if (len(digits)-1-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// validSNILS проверяет контрольное число СНИЛС.
+func validSNILS(match string) bool {
+	digits := digitsOf(match)
+	if len(digits) != 11 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += digits[i] * (9 - i)
+	}
+	check := sum % 101
+	if check == 100 {
+		check = 0
+	}
+	return check == digits[9]*10+digits[10]
+}
+
+// validINN проверяет контрольные цифры ИНН организации (10 цифр) или
+// физического лица (12 цифр).
+func validINN(match string) bool {
+	digits := digitsOf(match)
+	checksum := func(weights []int) int {
+		sum := 0
+		for i, w := range weights {
+			sum += digits[i] * w
+		}
+		return sum % 11 % 10
+	}
+	switch len(digits) {
+	case 10:
+		return checksum([]int{2, 4, 10, 3, 5, 9, 4, 6, 8}) == digits[9]
+	case 12:
+		return checksum([]int{7, 2, 4, 10, 3, 5, 9, 4, 6, 8}) == digits[10] &&
+			checksum([]int{3, 7, 2, 4, 10, 3, 5, 9, 4, 6, 8}) == digits[11]
+	default:
+		return false
+	}
+}
+
+// Redaction — результат обезличивания: текст с плейсхолдерами и таблица
+// для обратной подстановки.
+type Redaction struct {
+	Text   string
+	Values map[string]string // плейсхолдер → исходное значение
+}
+
+// Restore возвращает исходные значения на место плейсхолдеров.
+func (r *Redaction) Restore(text string) string {
+	if len(r.Values) == 0 {
+		return text
+	}
+	pairs := make([]string, 0, len(r.Values)*2)
+	for placeholder, value := range r.Values {
+		pairs = append(pairs, placeholder, value)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// Redactor заменяет найденные значения плейсхолдерами. Одинаковые значения
+// получают один плейсхолдер, чтобы модель видела, что это одно и то же.
+type Redactor struct {
+	detectors []Detector
+}
+
+func NewRedactor(kinds []string) (*Redactor, error) {
+	if len(kinds) == 0 {
+		return &Redactor{detectors: detectors}, nil
+	}
+
+	var selected []Detector
+	for _, kind := range kinds {
+		found := false
+		for _, d := range detectors {
+			if strings.EqualFold(kind, d.Kind()) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown pii detector %q", kind)
+		}
+	}
+	// Порядок детекторов сохраняется встроенный: от него зависит приоритет.
+	for _, d := range detectors {
+		for _, kind := range kinds {
+			if strings.EqualFold(kind, d.Kind()) {
+				selected = append(selected, d)
+				break
+			}
+		}
+	}
+	return &Redactor{detectors: selected}, nil
+}
+
+func (r *Redactor) Redact(text string) *Redaction {
+	type span struct {
+		start, end int
+		kind       string
+	}
+	var spans []span
+	overlaps := func(start, end int) bool {
+		for _, s := range spans {
+			if start < s.end && s.start < end {
+				return true
+			}
+		}
+		return false
+	}
+	for _, d := range r.detectors {
+		for _, loc := range d.Find(text) {
+			if !overlaps(loc[0], loc[1]) {
+				spans = append(spans, span{start: loc[0], end: loc[1], kind: d.Kind()})
+			}
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	redaction := &Redaction{Values: make(map[string]string)}
+	byValue := make(map[string]string)
+	counters := make(map[string]int)
+
+	var b strings.Builder
+	prev := 0
+	for _, s := range spans {
+		value := text[s.start:s.end]
+		placeholder, ok := byValue[s.kind+"\x00"+value]
+		if !ok {
+			counters[s.kind]++
+			placeholder = fmt.Sprintf("[%s_%d]", s.kind, counters[s.kind])
+			byValue[s.kind+"\x00"+value] = placeholder
+			redaction.Values[placeholder] = value
+		}
+		b.WriteString(text[prev:s.start])
+		b.WriteString(placeholder)
+		prev = s.end
+	}
+	b.WriteString(text[prev:])
+	redaction.Text = b.String()
+	return redaction
+}
+
+// PIIPolicy — настройки обезличивания для одного провайдера модели.
+type PIIPolicy struct {
+	Enabled   bool     `json:"enabled"`
+	Detectors []string `json:"detectors"` // пусто — все встроенные детекторы
+}
+
+// PIIPolicies — политики по провайдерам (часть имени модели до "/",
+// например "openai") и политика по умолчанию для остальных.
+type PIIPolicies struct {
+	Default   PIIPolicy            `json:"default"`
+	Providers map[string]PIIPolicy `json:"providers"`
+}
+
+// loadPIIPolicies читает политики из JSON-файла. Без файла все данные
+// обезличиваются для любого провайдера.
+func loadPIIPolicies(path string) (PIIPolicies, error) {
+	policies := PIIPolicies{Default: PIIPolicy{Enabled: true}}
+	if path == "" {
+		return policies, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return policies, err
+	}
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return policies, err
+	}
+	return policies, nil
+}
+
+// redactorFor строит обезличиватель для модели; nil — для провайдера
+// обезличивание выключено.
+func (p PIIPolicies) redactorFor(model string) (*Redactor, error) {
+	provider, _, _ := strings.Cut(model, "/")
+	policy, ok := p.Providers[provider]
+	if !ok {
+		policy = p.Default
+	}
+	if !policy.Enabled {
+		return nil, nil
+	}
+	return NewRedactor(policy.Detectors)
+}
+
+// validate проверяет детекторы всех политик, чтобы ошибка в файле политик
+// обнаружилась при старте, а не на первом письме для модели провайдера.
+func (p PIIPolicies) validate() error {
+	if _, err := NewRedactor(p.Default.Detectors); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for provider, policy := range p.Providers {
+		if _, err := NewRedactor(policy.Detectors); err != nil {
+			return fmt.Errorf("provider %s: %w", provider, err)
+		}
+	}
+	return nil
+}
+
+// restoreResponse подставляет исходные значения во все строки ответа модели,
+// включая вложенные объекты и массивы: плейсхолдеры нужны только провайдеру,
+// а messages-service разбирает из ответа реквизиты, резюме и текст ответа.
+func restoreResponse(answer string, redaction *Redaction) (string, error) {
+	if redaction == nil || len(redaction.Values) == 0 {
+		return answer, nil
+	}
+
+	var body any
+	if err := json.Unmarshal([]byte(answer), &body); err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(restoreValue(body, redaction))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func restoreValue(value any, redaction *Redaction) any {
+	switch v := value.(type) {
+	case string:
+		return redaction.Restore(v)
+	case map[string]any:
+		for key, item := range v {
+			v[key] = restoreValue(item, redaction)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = restoreValue(item, redaction)
+		}
+	}
+	return value
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidCard(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: "4111111111111111", want: true},
+		{value: "4111 1111 1111 1111", want: true},
+		{value: "5500-0000-0000-0004", want: true},
+		{value: "4222222222222", want: true},
+		{value: "4111111111111112", want: false},
+		{value: "411111111111", want: false},
+		{value: "41111111111111111111", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := validCard(tt.value); got != tt.want {
+			t.Errorf("validCard(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidSNILS(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: "112-233-445 95", want: true},
+		{value: "11223344595", want: true},
+		{value: "112-233-445 96", want: false},
+		// Остаток 100 даёт контрольное число 00.
+		{value: "920-000-003 00", want: true},
+		{value: "123-456-789 00", want: false},
+		{value: "112-233-445 9", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := validSNILS(tt.value); got != tt.want {
+			t.Errorf("validSNILS(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidINN(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: "7707083893", want: true},
+		{value: "7707083894", want: false},
+		{value: "500100732259", want: true},
+		{value: "500100732250", want: false},
+		{value: "500100732269", want: false},
+		{value: "77070838", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := validINN(tt.value); got != tt.want {
+			t.Errorf("validINN(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDetectors(t *testing.T) {
+	tests := []struct {
+		kind string
+		text string
+		want []string
+	}{
+		{kind: "CARD", text: "карта 4111 1111 1111 1111, старая 4111 1111 1111 1112", want: []string{"4111 1111 1111 1111"}},
+		{kind: "ACCOUNT", text: "р/с 40702810938000012345.", want: []string{"40702810938000012345"}},
+		{kind: "SNILS", text: "СНИЛС 112-233-445 95 и 112-233-445 96", want: []string{"112-233-445 95"}},
+		{kind: "INN", text: "ИНН 7707083893, ИНН ИП 500100732259, номер 1234567890", want: []string{"7707083893", "500100732259"}},
+		{kind: "PASSPORT", text: "паспорт 45 08 123456, 4508 № 654321", want: []string{"45 08 123456", "4508 № 654321"}},
+		{kind: "PHONE", text: "+7 (912) 345-67-89 или 8 912 345 67 89, но не 18 912 345 67 89", want: []string{"+7 (912) 345-67-89", "8 912 345 67 89"}},
+		{kind: "EMAIL", text: "пишите на Ivan.Petrov+inv@mail.example.ru.", want: []string{"Ivan.Petrov+inv@mail.example.ru"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			var detector Detector
+			for _, d := range detectors {
+				if d.Kind() == tt.kind {
+					detector = d
+				}
+			}
+			if detector == nil {
+				t.Fatalf("no detector %s", tt.kind)
+			}
+
+			var got []string
+			for _, loc := range detector.Find(tt.text) {
+				got = append(got, tt.text[loc[0]:loc[1]])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Find = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactRestore(t *testing.T) {
+	text := "Оплатите с карты 4111 1111 1111 1111 на р/с 40702810938000012345 " +
+		"(ИНН 7707083893). Вопросы: ivan@example.com, +7 912 345-67-89. " +
+		"Повторяю карту: 4111 1111 1111 1111, копия на ivan@example.com."
+
+	redactor, err := NewRedactor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redaction := redactor.Redact(text)
+
+	want := "Оплатите с карты [CARD_1] на р/с [ACCOUNT_1] " +
+		"(ИНН [INN_1]). Вопросы: [EMAIL_1], [PHONE_1]. " +
+		"Повторяю карту: [CARD_1], копия на [EMAIL_1]."
+	if redaction.Text != want {
+		t.Errorf("Redact text = %q, want %q", redaction.Text, want)
+	}
+	if len(redaction.Values) != 5 {
+		t.Errorf("Values = %v, want 5 placeholders", redaction.Values)
+	}
+	if got := redaction.Restore(redaction.Text); got != text {
+		t.Errorf("Restore = %q, want the original text", got)
+	}
+}
+
+// Детекторы выбираются по имени без учёта регистра, а приоритет остаётся
+// встроенным: 20 цифр счёта не распознаются как ИНН или карта.
+func TestNewRedactor(t *testing.T) {
+	redactor, err := NewRedactor([]string{"inn", "Account"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	redaction := redactor.Redact("ИНН 7707083893, р/с 40702810938000012345, почта a@example.com")
+	if want := "ИНН [INN_1], р/с [ACCOUNT_1], почта a@example.com"; redaction.Text != want {
+		t.Errorf("Redact = %q, want %q", redaction.Text, want)
+	}
+
+	if _, err := NewRedactor([]string{"INN", "IBAN"}); err == nil {
+		t.Error("NewRedactor: want error for an unknown detector")
+	}
+}
+
+func TestRedactionRestoreEmpty(t *testing.T) {
+	redaction := (&Redactor{detectors: detectors}).Redact("без персональных данных [CARD_1]")
+	if len(redaction.Values) != 0 {
+		t.Fatalf("Values = %v, want none", redaction.Values)
+	}
+	if got := redaction.Restore("[CARD_1]"); got != "[CARD_1]" {
+		t.Errorf("Restore = %q, want text unchanged", got)
+	}
+}
+
+func TestRedactorFor(t *testing.T) {
+	policies := PIIPolicies{
+		Default: PIIPolicy{Enabled: true, Detectors: []string{"EMAIL"}},
+		Providers: map[string]PIIPolicy{
+			"local":  {Enabled: false},
+			"openai": {Enabled: true, Detectors: []string{"PHONE"}},
+			"broken": {Enabled: true, Detectors: []string{"NOPE"}},
+		},
+	}
+	const text = "a@example.com +7 912 345-67-89"
+
+	tests := []struct {
+		model string
+		want  string // "" — обезличивание выключено
+	}{
+		{model: "local/llama", want: ""},
+		{model: "openai/gpt-4o", want: "a@example.com [PHONE_1]"},
+		{model: "anthropic/claude", want: "[EMAIL_1] +7 912 345-67-89"},
+		{model: "no-provider", want: "[EMAIL_1] +7 912 345-67-89"},
+	}
+	for _, tt := range tests {
+		redactor, err := policies.redactorFor(tt.model)
+		if err != nil {
+			t.Fatalf("redactorFor(%q): %v", tt.model, err)
+		}
+		if tt.want == "" {
+			if redactor != nil {
+				t.Errorf("redactorFor(%q) = redactor, want nil", tt.model)
+			}
+			continue
+		}
+		if redactor == nil {
+			t.Fatalf("redactorFor(%q) = nil", tt.model)
+		}
+		if got := redactor.Redact(text).Text; got != tt.want {
+			t.Errorf("redactorFor(%q).Redact = %q, want %q", tt.model, got, tt.want)
+		}
+	}
+
+	if _, err := policies.redactorFor("broken/model"); err == nil {
+		t.Error("redactorFor: want error for an unknown detector in the policy")
+	}
+	if err := policies.validate(); err == nil {
+		t.Error("validate: want error for an unknown detector in the policy")
+	}
+	delete(policies.Providers, "broken")
+	if err := policies.validate(); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+}
+
+func TestRestoreResponse(t *testing.T) {
+	redaction := &Redaction{Values: map[string]string{
+		"[INN_1]":   "7707083893",
+		"[EMAIL_1]": "ivan@example.com",
+	}}
+	answer := `{"inn":"[INN_1]","reply":{"text":"Ответим на [EMAIL_1]","cc":["[EMAIL_1]"]},"amount":100,"ok":true}`
+
+	restored, err := restoreResponse(answer, redaction)
+	if err != nil {
+		t.Fatalf("restoreResponse: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal([]byte(restored), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"inn":    "7707083893",
+		"reply":  map[string]any{"text": "Ответим на ivan@example.com", "cc": []any{"ivan@example.com"}},
+		"amount": float64(100),
+		"ok":     true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restoreResponse = %s", restored)
+	}
+
+	// Без подстановок ответ возвращается как есть, даже если это не JSON.
+	if got, err := restoreResponse("not json", &Redaction{}); err != nil || got != "not json" {
+		t.Errorf("restoreResponse without values = %q, %v", got, err)
+	}
+	if _, err := restoreResponse("not json", redaction); err == nil {
+		t.Error("restoreResponse: want error for a non-JSON answer")
+	}
+	if strings.Contains(restored, "[INN_1]") {
+		t.Error("placeholder left in the answer")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const defaultPromptVersion = "v1"
+
+// Prompts — системные промпты по версиям (поле prompt_version задачи).
+// systemprompt.txt — версия по умолчанию, остальные версии читаются из
+// каталога файлами <версия>.txt. Оргструктура подставляется в промпт при
+// каждом запросе: у каждого общего ящика она своя.
+type Prompts struct {
+	defaultVersion string
+	byVersion      map[string]string
+}
+
+// loadPrompts читает systemprompt.txt как версию defaultVersion и, если dir
+// задан, все *.txt из dir; файл dir/<defaultVersion>.txt заменяет
+// systemprompt.txt.
+func loadPrompts(defaultFile, dir, defaultVersion string) (*Prompts, error) {
+	if defaultVersion == "" {
+		defaultVersion = defaultPromptVersion
+	}
+	p := &Prompts{defaultVersion: defaultVersion, byVersion: make(map[string]string)}
+
+	add := func(version, path string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		p.byVersion[version] = string(data)
+		return nil
+	}
+
+	if err := add(defaultVersion, defaultFile); err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return p, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		version := strings.TrimSuffix(filepath.Base(file), ".txt")
+		if err := add(version, file); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Get возвращает промпт версии с оргструктурой org на месте
+// <ORGANIZATION_JSON>; пустая версия — версия по умолчанию.
+func (p *Prompts) Get(version string, org []byte) (string, error) {
+	if version == "" {
+		version = p.defaultVersion
+	}
+	prompt, ok := p.byVersion[version]
+	if !ok {
+		return "", fmt.Errorf("unknown prompt_version %q", version)
+	}
+	return strings.Replace(prompt, "<ORGANIZATION_JSON>", string(org), 1), nil
+}
+
+// Versions — известные версии по алфавиту, для лога при старте.
+func (p *Prompts) Versions() []string {
+	versions := make([]string, 0, len(p.byVersion))
+	for version := range p.byVersion {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// taskOptions — поля задачи messages-service, которые настраивают запрос к
+// модели, а не описывают письмо.
+type taskOptions struct {
+	PromptVersion string
+	Provider      string          // бэкенд модели; пусто — openrouter
+	Model         string          // модель тенанта; пусто — LLM_MODEL
+	Organization  json.RawMessage // оргструктура ящика; nil — берётся org.json
+	Input         string          // тело без служебных полей, уходит модели
+}
+
+// taskOptionsOf разбирает задачу messages-service. Оргструктура из задачи
+// попадает только в системный промпт, поэтому из пользовательского
+// сообщения она убирается. Тело, которое не является JSON-объектом (сырой
+// текст письма), передаётся как есть с настройками по умолчанию.
+func taskOptionsOf(body []byte) taskOptions {
+	opts := taskOptions{Input: string(body)}
+
+	var task map[string]json.RawMessage
+	if err := json.Unmarshal(body, &task); err != nil || task == nil {
+		return opts
+	}
+	opts.PromptVersion = stringField(task, "prompt_version")
+	opts.Provider = stringField(task, "provider")
+	opts.Model = stringField(task, "model")
+	if raw, ok := task["organization"]; ok {
+		if string(raw) != "null" {
+			opts.Organization = raw
+		}
+		delete(task, "organization")
+		if input, err := json.Marshal(task); err == nil {
+			opts.Input = string(input)
+		}
+	}
+	return opts
+}
+
+// stringField — строковое поле задачи без пробелов по краям; поле другого
+// типа считается незаданным.
+func stringField(task map[string]json.RawMessage, key string) string {
+	var value string
+	if raw, ok := task[key]; ok && json.Unmarshal(raw, &value) == nil {
+		return strings.TrimSpace(value)
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTaskOptionsOf(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		want  taskOptions
+		input map[string]any // nil — тело уходит модели как есть
+	}{
+		{
+			name: "raw text",
+			body: "Добрый день, пришлите счёт",
+			want: taskOptions{Input: "Добрый день, пришлите счёт"},
+		},
+		{
+			name: "task without organization",
+			body: `{"id":"1","input":"текст","prompt_version":" compliance-v2 "}`,
+			want: taskOptions{PromptVersion: "compliance-v2", Input: `{"id":"1","input":"текст","prompt_version":" compliance-v2 "}`},
+		},
+		{
+			name: "tenant provider and model",
+			body: `{"id":"1","provider":"openrouter","model":" anthropic/claude-3.5-sonnet ","prompt_version":7}`,
+			want: taskOptions{
+				Provider: "openrouter",
+				Model:    "anthropic/claude-3.5-sonnet",
+				Input:    `{"id":"1","provider":"openrouter","model":" anthropic/claude-3.5-sonnet ","prompt_version":7}`,
+			},
+		},
+		{
+			name:  "organization is removed from the input",
+			body:  `{"id":"1","input":"текст","organization":{"organization":{"departments":[]}}}`,
+			want:  taskOptions{Organization: json.RawMessage(`{"organization":{"departments":[]}}`)},
+			input: map[string]any{"id": "1", "input": "текст"},
+		},
+		{
+			name:  "null organization",
+			body:  `{"id":"1","organization":null}`,
+			want:  taskOptions{},
+			input: map[string]any{"id": "1"},
+		},
+		{
+			name: "json array",
+			body: `["a"]`,
+			want: taskOptions{Input: `["a"]`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := taskOptionsOf([]byte(tt.body))
+			if got.PromptVersion != tt.want.PromptVersion || got.Provider != tt.want.Provider || got.Model != tt.want.Model ||
+				string(got.Organization) != string(tt.want.Organization) {
+				t.Errorf("taskOptionsOf = %+v, want %+v", got, tt.want)
+			}
+			if tt.input == nil {
+				if got.Input != tt.want.Input {
+					t.Errorf("Input = %q, want %q", got.Input, tt.want.Input)
+				}
+				return
+			}
+			var input map[string]any
+			if err := json.Unmarshal([]byte(got.Input), &input); err != nil || !reflect.DeepEqual(input, tt.input) {
+				t.Errorf("Input = %s, want %v", got.Input, tt.input)
+			}
+		})
+	}
+}
+
+func TestPromptsGet(t *testing.T) {
+	dir := t.TempDir()
+	defaultFile := filepath.Join(dir, "systemprompt.txt")
+	if err := os.WriteFile(defaultFile, []byte("v1 <ORGANIZATION_JSON>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	versions := filepath.Join(dir, "versions")
+	if err := os.Mkdir(versions, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(versions, "compliance-v2.txt"), []byte("compliance <ORGANIZATION_JSON>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := loadPrompts(defaultFile, versions, "")
+	if err != nil {
+		t.Fatalf("loadPrompts: %v", err)
+	}
+
+	tests := []struct {
+		version string
+		org     string
+		want    string
+	}{
+		{version: "", org: `{"a":1}`, want: `v1 {"a":1}`},
+		{version: "v1", org: `{"b":2}`, want: `v1 {"b":2}`},
+		{version: "compliance-v2", org: `{"c":3}`, want: `compliance {"c":3}`},
+	}
+	for _, tt := range tests {
+		got, err := p.Get(tt.version, []byte(tt.org))
+		if err != nil || got != tt.want {
+			t.Errorf("Get(%q) = %q, %v, want %q", tt.version, got, err, tt.want)
+		}
+	}
+
+	if _, err := p.Get("v3", nil); err == nil {
+		t.Error("Get: want error for an unknown version")
+	}
+}
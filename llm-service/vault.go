@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Vault хранит таблицы обезличивания зашифрованными AES-256-GCM, по файлу
+// на запрос: при разборе инцидентов по ним видно, какие значения были скрыты
+// от провайдера. Без ключа файлы бесполезны.
+type Vault struct {
+	dir  string
+	aead cipher.AEAD
+}
+
+type vaultRecord struct {
+	Model     string            `json:"model"`
+	Values    map[string]string `json:"values"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// NewVault создаёт хранилище в dir. key — 32 байта в base64.
+func NewVault(dir, key string) (*Vault, error) {
+	if key == "" {
+		return nil, errors.New("PII_VAULT_KEY is required when PII_VAULT_DIR is set")
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("decode PII_VAULT_KEY: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("PII_VAULT_KEY must be 32 bytes, got %d", len(raw))
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create vault dir: %w", err)
+	}
+	return &Vault{dir: dir, aead: aead}, nil
+}
+
+// Save шифрует таблицу и возвращает id записи. id используется как
+// дополнительные данные AEAD, поэтому файл нельзя выдать за другой запрос.
+func (v *Vault) Save(model string, redaction *Redaction) (string, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(idBytes)
+
+	plaintext, err := json.Marshal(vaultRecord{
+		Model:     model,
+		Values:    redaction.Values,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := v.aead.Seal(nonce, nonce, plaintext, []byte(id))
+
+	if err := os.WriteFile(filepath.Join(v.dir, id+".bin"), sealed, 0o600); err != nil {
+		return "", err
+	}
+	return id, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testVaultKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+
+// open расшифровывает запись так же, как это делается при разборе инцидента.
+func (v *Vault) open(id string) (vaultRecord, error) {
+	var record vaultRecord
+	sealed, err := os.ReadFile(filepath.Join(v.dir, id+".bin"))
+	if err != nil {
+		return record, err
+	}
+	nonce, ciphertext := sealed[:v.aead.NonceSize()], sealed[v.aead.NonceSize():]
+	plaintext, err := v.aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return record, err
+	}
+	err = json.Unmarshal(plaintext, &record)
+	return record, err
+}
+
+func TestVaultSave(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "vault")
+	vault, err := NewVault(dir, testVaultKey)
+	if err != nil {
+		t.Fatalf("NewVault: %v", err)
+	}
+
+	values := map[string]string{"[CARD_1]": "4111 1111 1111 1111", "[INN_1]": "7707083893"}
+	id, err := vault.Save("openai/gpt-4o", &Redaction{Values: values})
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	sealed, err := os.ReadFile(filepath.Join(dir, id+".bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("7707083893")) {
+		t.Error("vault file contains a plaintext value")
+	}
+	if info, err := os.Stat(filepath.Join(dir, id+".bin")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("vault file mode = %v, %v", info.Mode().Perm(), err)
+	}
+
+	record, err := vault.open(id)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if record.Model != "openai/gpt-4o" || !reflect.DeepEqual(record.Values, values) || record.CreatedAt.IsZero() {
+		t.Errorf("record = %+v", record)
+	}
+
+	// id — дополнительные данные AEAD: под чужим именем файл не расшифруется.
+	other, err := vault.Save("openai/gpt-4o", &Redaction{Values: values})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == id {
+		t.Fatal("Save returned the same id twice")
+	}
+	if err := os.Rename(filepath.Join(dir, id+".bin"), filepath.Join(dir, other+".bin")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.open(other); err == nil {
+		t.Error("open: want error for a file saved under another id")
+	}
+
+	// Другой ключ не расшифровывает записи.
+	otherKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{8}, 32))
+	otherVault, err := NewVault(dir, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := vault.Save("local/llama", &Redaction{Values: values})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherVault.open(third); err == nil {
+		t.Error("open: want error for a wrong key")
+	}
+}
+
+func TestNewVaultKey(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{name: "empty", key: ""},
+		{name: "not base64", key: "not a key!"},
+		{name: "short", key: base64.StdEncoding.EncodeToString(make([]byte, 16))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewVault(t.TempDir(), tt.key); err == nil {
+				t.Fatal("NewVault: want error")
+			}
+		})
+	}
+}
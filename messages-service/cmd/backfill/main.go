@@ -0,0 +1,126 @@
+// Команда backfill заполняет колонки category, urgency, main_approver,
+// required_approvers, tags и legal_risks для писем, обработанных до
+// миграции 014_answer_fields, и привязывает их отправителей к организациям
+// по реквизитам (018_correspondents). Читает тот же конфиг, что и сервис
+// (CONFIG_PATH).
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"messages-service/internal/config"
+	"messages-service/internal/fieldcrypt"
+	"messages-service/internal/logger"
+	"messages-service/internal/messages"
+	"messages-service/internal/storage"
+	"messages-service/internal/storage/postgresql"
+)
+
+func main() {
+	batchSize := flag.Int("batch", 500, "сколько писем читать за один запрос")
+	all := flag.Bool("all", false, "пересчитать колонки и для уже заполненных писем")
+	dryRun := flag.Bool("dry-run", false, "только разобрать ответы, ничего не записывая")
+	flag.Parse()
+
+	cfg := config.MustLoad()
+	log := logger.New(cfg.Env)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Незавершённый проход должен быть виден вызывающему скрипту, иначе он
+	// сочтёт колонки заполненными.
+	if err := run(ctx, cfg, log, *batchSize, *all, *dryRun); err != nil {
+		log.Error("backfill failed", slog.Any("error", err))
+		stop()
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, cfg *config.Config, log *slog.Logger, batchSize int, all, dryRun bool) error {
+	dbStorage, err := postgresql.New(cfg.PostgreSQL)
+	if err != nil {
+		return fmt.Errorf("connect to postgresql: %w", err)
+	}
+	defer func() {
+		if err := dbStorage.Close(); err != nil {
+			log.Warn("failed to close postgresql connection", slog.Any("error", err))
+		}
+	}()
+
+	var repoOpts []storage.Option
+	if cfg.Encryption.Enabled {
+		kms, err := fieldcrypt.LoadKeyFile(cfg.Encryption.KeyFile)
+		if err != nil {
+			return fmt.Errorf("load encryption keys: %w", err)
+		}
+		repoOpts = append(repoOpts, storage.WithCipher(fieldcrypt.NewCipher(kms)))
+	}
+	repo := storage.NewMessagesRepo(dbStorage.DB, repoOpts...)
+
+	var updated, skipped, failed int
+	var lastID string
+	for {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("interrupted after %s: %w", lastID, err)
+		}
+
+		rows, err := repo.ListAnswersForBackfill(ctx, lastID, batchSize, !all)
+		if err != nil {
+			return fmt.Errorf("read model answers after %q: %w", lastID, err)
+		}
+
+		for _, row := range rows {
+			lastID = row.ID
+
+			answer, err := messages.ParseModelAnswer(row.ModelAnswer)
+			if err != nil {
+				log.Warn("failed to parse model answer", slog.Any("error", err), slog.String("id", row.ID))
+				skipped++
+				continue
+			}
+			if dryRun {
+				updated++
+				continue
+			}
+			if err := repo.SaveAnswerFields(ctx, row.ID, answer); err != nil {
+				log.Error("failed to save answer fields", slog.Any("error", err), slog.String("id", row.ID))
+				failed++
+				continue
+			}
+			if err := repo.LinkSenderOrganization(ctx, row.ID, messages.ParseRequisites(answer.Requisites)); err != nil {
+				log.Error("failed to link sender organization", slog.Any("error", err), slog.String("id", row.ID))
+				failed++
+				continue
+			}
+			updated++
+		}
+
+		log.Info("backfill batch done",
+			slog.Int("batch", len(rows)),
+			slog.Int("updated", updated),
+			slog.Int("skipped", skipped),
+			slog.Int("failed", failed),
+		)
+		if len(rows) < batchSize {
+			break
+		}
+	}
+
+	log.Info("backfill finished",
+		slog.Int("updated", updated),
+		slog.Int("skipped", skipped),
+		slog.Int("failed", failed),
+		slog.Bool("dry_run", dryRun),
+	)
+	if failed > 0 {
+		return fmt.Errorf("%d mails were not updated", failed)
+	}
+	return nil
+}
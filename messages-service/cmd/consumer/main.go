@@ -0,0 +1,113 @@
+// Команда consumer читает результаты обработки писем из Kafka (по умолчанию
+// processed_messages и messages_failed) и передаёт их обработчикам из
+// kafka.consumer.handlers: webhook рассылает сообщения на URL-ы, archive
+// пишет их в файлы JSONL. Каждый обработчик читает в своей группе
+// консьюмеров, поэтому команду можно запускать в нескольких экземплярах.
+// Читает тот же конфиг, что и сервис (CONFIG_PATH).
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"messages-service/internal/config"
+	"messages-service/internal/kafka"
+	"messages-service/internal/kafka/handlers"
+	"messages-service/internal/logger"
+)
+
+func main() {
+	cfg := config.MustLoad()
+	log := logger.New(cfg.Env)
+
+	consumerCfg := cfg.Kafka.Consumer
+	if len(consumerCfg.Handlers) == 0 {
+		log.Error("no kafka consumer handlers configured")
+		return
+	}
+
+	topics := make([]string, 0, len(consumerCfg.Handlers)+1)
+	if consumerCfg.PoisonTopic != "" {
+		topics = append(topics, consumerCfg.PoisonTopic)
+	}
+	for _, h := range consumerCfg.Handlers {
+		topics = append(topics, h.Topics...)
+	}
+	if err := kafka.EnsureTopics(context.Background(), cfg.Kafka.Brokers, log, topics...); err != nil {
+		log.Error("failed to ensure kafka topics", slog.Any("error", err))
+		panic(err)
+	}
+
+	producer, err := kafka.NewProducer(cfg.Kafka, log)
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err := producer.Close(); err != nil {
+			log.Warn("failed to close kafka producer", slog.Any("error", err))
+		}
+	}()
+
+	var consumers []*kafka.Consumer
+	for _, hc := range consumerCfg.Handlers {
+		handler, err := newHandler(hc)
+		if err != nil {
+			panic(err)
+		}
+		if closer, ok := handler.(io.Closer); ok {
+			defer func() {
+				if err := closer.Close(); err != nil {
+					log.Warn("failed to close consumer handler", slog.Any("error", err), slog.String("handler", hc.Name))
+				}
+			}()
+		}
+
+		groupID := hc.GroupID
+		if groupID == "" {
+			groupID = "messages-consumer-" + hc.Name
+		}
+		consumer, err := kafka.NewConsumer(cfg.Kafka, kafka.ConsumerSpec{
+			Name:        hc.Name,
+			GroupID:     groupID,
+			Topics:      hc.Topics,
+			Concurrency: hc.Concurrency,
+			Handler:     handler,
+		}, producer, log)
+		if err != nil {
+			panic(err)
+		}
+		consumers = append(consumers, consumer)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	for _, consumer := range consumers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consumer.Run(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func newHandler(cfg config.ConsumerHandlerConfig) (kafka.Handler, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("consumer handler of type %q has no name", cfg.Type)
+	}
+	switch cfg.Type {
+	case "webhook":
+		return handlers.NewWebhook(cfg.Webhook)
+	case "archive":
+		return handlers.NewArchive(cfg.Archive)
+	default:
+		return nil, fmt.Errorf("consumer handler %s: unknown type %q", cfg.Name, cfg.Type)
+	}
+}
@@ -2,17 +2,36 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"messages-service/internal/blobstore"
+	"messages-service/internal/cache"
 	"messages-service/internal/config"
+	"messages-service/internal/fieldcrypt"
+	imapingest "messages-service/internal/ingest/imap"
+	smtpingest "messages-service/internal/ingest/smtp"
 	"messages-service/internal/kafka"
 	"messages-service/internal/logger"
 	"messages-service/internal/messages"
+	"messages-service/internal/outbound"
+	"messages-service/internal/prefilter"
+	"messages-service/internal/ratelimit"
+	"messages-service/internal/retention"
+	"messages-service/internal/sla"
 	"messages-service/internal/storage"
 	"messages-service/internal/storage/postgresql"
+	"messages-service/internal/stream"
+	authhttp "messages-service/internal/transport/http/auth"
+	eventshttp "messages-service/internal/transport/http/events"
 	messageshttp "messages-service/internal/transport/http/messages"
+	ratelimithttp "messages-service/internal/transport/http/ratelimit"
+	webhookshttp "messages-service/internal/transport/http/webhooks"
+	"messages-service/internal/webhook"
 	"net/http"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 )
 
 func main() {
@@ -21,14 +40,21 @@ func main() {
 	log := logger.New(cfg.Env)
 	log.Info("starting app", slog.String("env", cfg.Env))
 
-	if err := kafka.EnsureTopics(
-		context.Background(),
-		cfg.Kafka.Brokers,
-		log,
-		cfg.Kafka.InputTopic,
-		cfg.Kafka.OutputTopic,
-		cfg.Kafka.DeadLetterTopic,
-	); err != nil {
+	topics := []string{cfg.Kafka.InputTopic, cfg.Kafka.OutputTopic, cfg.Kafka.DeadLetterTopic}
+	for _, topic := range cfg.Routing.DepartmentTopics {
+		topics = append(topics, topic)
+	}
+	if cfg.SLA.Enabled && cfg.SLA.EscalationTopic != "" {
+		topics = append(topics, cfg.SLA.EscalationTopic)
+	}
+	if cfg.Tenancy.Enabled {
+		for _, tc := range cfg.Tenancy.Tenants {
+			if tc.OutputTopic != "" {
+				topics = append(topics, tc.OutputTopic)
+			}
+		}
+	}
+	if err := kafka.EnsureTopics(context.Background(), cfg.Kafka.Brokers, log, topics...); err != nil {
 		log.Error("failed to ensure kafka topics", slog.Any("error", err))
 		panic(err)
 	}
@@ -43,7 +69,24 @@ func main() {
 		}
 	}()
 
-	repo := storage.NewMessagesRepo(dbStorage.DB)
+	var repoOpts []storage.Option
+	if cfg.Encryption.Enabled {
+		kms, err := fieldcrypt.LoadKeyFile(cfg.Encryption.KeyFile)
+		if err != nil {
+			panic(err)
+		}
+		repoOpts = append(repoOpts, storage.WithCipher(fieldcrypt.NewCipher(kms)))
+		log.Info("field encryption enabled", slog.String("key_id", kms.CurrentKeyID()))
+		// Кэш хранит ответы модели открыто, поэтому вместе с шифрованием
+		// допустим только кэш в памяти.
+		if cfg.Cache.Backend == "postgres" {
+			panic("encryption.enabled is incompatible with cache.backend=postgres: cached llm answers are stored unencrypted")
+		}
+	}
+	if cfg.Tenancy.Enabled {
+		repoOpts = append(repoOpts, storage.WithTenantPools(dbStorage))
+	}
+	repo := storage.NewMessagesRepo(dbStorage.DB, repoOpts...)
 
 	producer, err := kafka.NewProducer(cfg.Kafka, log)
 	if err != nil {
@@ -55,6 +98,103 @@ func main() {
 		}
 	}()
 
+	resultCache, err := cache.New(cfg.Cache, dbStorage.DB)
+	if err != nil {
+		panic(err)
+	}
+
+	blobs, err := blobstore.New(cfg.Attachments)
+	if err != nil {
+		panic(err)
+	}
+
+	opts := []messages.Option{
+		messages.WithStubAnswers(cfg.LLM.AllowStubAnswers),
+		messages.WithAttachments(blobs, cfg.Attachments.MaxTextChars),
+		messages.WithThreading(cfg.Threading.SubjectWindow, cfg.Threading.ContextMessages, cfg.Threading.ContextChars),
+		messages.WithRouting(cfg.Routing.DefaultQueue, cfg.Routing.DepartmentTopics),
+		messages.WithPromptVersion(cfg.LLM.PromptVersion),
+		messages.WithLLM(cfg.LLM.Provider, cfg.LLM.Model),
+		messages.WithCorrespondents(messages.CorrespondentPolicy{
+			ComplaintCategories: cfg.Correspondents.ComplaintCategories,
+			RepeatComplaints:    cfg.Correspondents.RepeatComplaints,
+			HistoryLimit:        cfg.Correspondents.HistoryLimit,
+		}),
+	}
+	if cfg.SLA.Enabled {
+		opts = append(opts, messages.WithSLA(messages.SLAPolicy{
+			Default:    cfg.SLA.Default,
+			Urgency:    cfg.SLA.Urgency,
+			Categories: cfg.SLA.Categories,
+		}))
+	}
+	if cfg.Approvals.Enabled {
+		opts = append(opts, messages.WithApprovalPolicy(approvalPolicy(cfg.Approvals)))
+	}
+	if cfg.Tenancy.Enabled {
+		tenants, err := tenantConfigs(cfg.Tenancy)
+		if err != nil {
+			panic(err)
+		}
+		if err := checkTenantIsolation(dbStorage, tenants); err != nil {
+			log.Error("tenant isolation check failed", slog.Any("error", err))
+			panic(err)
+		}
+		opts = append(opts, messages.WithTenants(tenants))
+		log.Info("tenancy enabled", slog.Int("tenants", len(tenants)))
+	}
+	if len(cfg.Mailboxes) > 0 {
+		mailboxes, err := mailboxConfigs(cfg)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, messages.WithMailboxes(mailboxes))
+		log.Info("mailboxes configured", slog.Int("mailboxes", len(mailboxes)))
+	}
+	if cfg.Prefilter.Enabled {
+		filter, err := prefilter.New(cfg.Prefilter)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, messages.WithPreFilter(filter))
+		log.Info("prefilter enabled", slog.Int("rules", len(cfg.Prefilter.Rules)))
+	}
+	var replies *outbound.Service
+	if cfg.Outbound.Enabled {
+		replies = outbound.NewService(cfg.Outbound, repo, outbound.NewSMTPSender(cfg.Outbound.SMTP), log)
+		opts = append(opts, messages.WithReplyQueue(replies))
+	}
+	var sinks []messages.EventSink
+	var hooks *webhook.Service
+	if cfg.Webhooks.Enabled {
+		hooks = webhook.NewService(cfg.Webhooks, repo, log)
+		sinks = append(sinks, hooks)
+	}
+	var broker *stream.Broker
+	if cfg.Events.Enabled {
+		broker = stream.NewBroker(cfg.Events, repo, log)
+		sinks = append(sinks, broker)
+	}
+	if len(sinks) > 0 {
+		opts = append(opts, messages.WithEvents(sinks...))
+	}
+	var limiter *ratelimit.Limiter
+	if cfg.RateLimit.Enabled {
+		limiter, err = ratelimit.New(cfg.RateLimit, dbStorage.DB, log)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, messages.WithUsageLimiter(limiter))
+		log.Info("rate limiting enabled",
+			slog.String("backend", cfg.RateLimit.Backend),
+			slog.String("key_by", limiter.KeyBy()),
+		)
+	}
+	if resultCache != nil {
+		opts = append(opts, messages.WithResultCache(resultCache, cfg.LLM.PromptVersion, cfg.LLM.Model))
+		log.Info("llm result cache enabled", slog.String("backend", cfg.Cache.Backend))
+	}
+
 	svc := messages.NewService(
 		repo,
 		producer,
@@ -64,16 +204,38 @@ func main() {
 		cfg.Kafka.OutputTopic,
 		cfg.Kafka.DeadLetterTopic,
 		cfg.Org.FilePath,
+		opts...,
 	)
 
 	handler := messageshttp.New(svc, log)
 
 	mux := http.NewServeMux()
 	handler.Register(mux)
+	if hooks != nil {
+		webhookshttp.New(hooks, log).Register(mux)
+	}
+	if broker != nil {
+		eventshttp.New(broker, cfg.Events.Heartbeat, log).Register(mux)
+	}
+
+	var httpHandler http.Handler = mux
+	if limiter != nil {
+		admin := authhttp.NewAdmin(cfg.RateLimit.AdminKeys, cfg.RateLimit.AdminKeysEnv, log)
+		limits := ratelimithttp.New(limiter, cfg.Tenancy.Enabled, admin, log)
+		limits.Register(mux)
+		httpHandler = limits.Middleware(httpHandler)
+	}
+	if cfg.Tenancy.Enabled {
+		authenticator, err := authhttp.New(cfg.Tenancy, log)
+		if err != nil {
+			panic(err)
+		}
+		httpHandler = authenticator.Middleware(httpHandler)
+	}
 
 	server := &http.Server{
 		Addr:         cfg.HTTPServer.Address,
-		Handler:      mux,
+		Handler:      httpHandler,
 		ReadTimeout:  cfg.HTTPServer.Timeout,
 		WriteTimeout: cfg.HTTPServer.Timeout,
 		IdleTimeout:  cfg.HTTPServer.IdleTimeout,
@@ -90,6 +252,94 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// Фоновые воркеры останавливаются по ctx; ждём их до закрытия БД и Kafka.
+	var workers sync.WaitGroup
+	defer workers.Wait()
+
+	if cfg.IMAP.Enabled {
+		poller := imapingest.NewPoller(cfg.IMAP, svc, repo, log)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			poller.Run(ctx)
+		}()
+		log.Info("imap poller started", slog.Int("mailboxes", len(cfg.IMAP.Mailboxes)))
+	}
+
+	if replies != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			replies.Run(ctx)
+		}()
+		log.Info("outbound reply worker started", slog.String("relay", cfg.Outbound.SMTP.Address))
+	}
+
+	if cfg.SLA.Enabled {
+		scheduler := sla.NewScheduler(cfg.SLA, repo, producer, log)
+		scheduler.WithEvents(sinks...)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			scheduler.Run(ctx)
+		}()
+		log.Info("sla scheduler started", slog.Duration("interval", cfg.SLA.CheckInterval))
+	}
+
+	if hooks != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			hooks.Run(ctx)
+		}()
+		log.Info("webhook delivery worker started", slog.Duration("interval", cfg.Webhooks.PollInterval))
+	}
+
+	if broker != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			broker.Run(ctx)
+		}()
+		log.Info("event stream started", slog.Duration("interval", cfg.Events.PollInterval))
+	}
+
+	if cfg.Retention.Enabled {
+		purger, err := retention.NewPurger(cfg.Retention, repo, blobs, log)
+		if err != nil {
+			panic(err)
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			purger.Run(ctx)
+		}()
+		log.Info("retention purger started", slog.Int("rules", len(cfg.Retention.Rules)))
+	}
+
+	if cfg.Encryption.Enabled {
+		rotator := fieldcrypt.NewRotator(cfg.Encryption, repo, log)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			rotator.Run(ctx)
+		}()
+		log.Info("re-encryption worker started", slog.Duration("interval", cfg.Encryption.RotationInterval))
+	}
+
+	var smtpServer *smtpingest.Server
+	if cfg.SMTP.Enabled {
+		smtpServer, err = smtpingest.NewServer(cfg.SMTP, svc, log)
+		if err != nil {
+			panic(err)
+		}
+		go func() {
+			if err := smtpServer.ListenAndServe(); err != nil {
+				log.Error("smtp server error", slog.Any("error", err))
+			}
+		}()
+	}
+
 	<-ctx.Done()
 	log.Info("shutdown signal received")
 
@@ -100,4 +350,119 @@ func main() {
 	} else {
 		log.Info("http server stopped")
 	}
+
+	if smtpServer != nil {
+		if err := smtpServer.Shutdown(shutdownCtx); err != nil {
+			log.Error("smtp server shutdown failed", slog.Any("error", err))
+		}
+	}
+}
+
+// mailboxConfigs переводит секцию mailboxes в настройки сервиса и проверяет,
+// что ящики IMAP ссылаются на существующие общие ящики.
+func mailboxConfigs(cfg *config.Config) ([]messages.MailboxConfig, error) {
+	mailboxes := make([]messages.MailboxConfig, 0, len(cfg.Mailboxes))
+	for _, mc := range cfg.Mailboxes {
+		mb := messages.MailboxConfig{
+			ID:            mc.ID,
+			Addresses:     mc.Addresses,
+			HierarchyPath: mc.OrgFile,
+			PromptVersion: mc.PromptVersion,
+			Tenant:        mc.Tenant,
+		}
+		if mc.Tenant != "" && mc.Tenant != messages.DefaultTenant && !tenantConfigured(cfg.Tenancy, mc.Tenant) {
+			return nil, fmt.Errorf("mailbox %s: unknown tenant %q", mc.ID, mc.Tenant)
+		}
+		if mc.Routing != nil {
+			mb.DefaultQueue = mc.Routing.DefaultQueue
+			mb.DepartmentTopics = mc.Routing.DepartmentTopics
+		}
+		if mc.SLA != nil && cfg.SLA.Enabled {
+			mb.SLA = &messages.SLAPolicy{
+				Default:    mc.SLA.Default,
+				Urgency:    mc.SLA.Urgency,
+				Categories: mc.SLA.Categories,
+			}
+		}
+		mailboxes = append(mailboxes, mb)
+	}
+	if err := messages.ValidateMailboxes(mailboxes); err != nil {
+		return nil, fmt.Errorf("mailboxes: %w", err)
+	}
+
+	known := make(map[string]bool, len(mailboxes))
+	for _, mb := range mailboxes {
+		known[mb.ID] = true
+	}
+	for _, mb := range cfg.IMAP.Mailboxes {
+		if mb.Mailbox != "" && !known[mb.Mailbox] {
+			return nil, fmt.Errorf("imap mailbox %s: unknown mailbox %q", mb.Name, mb.Mailbox)
+		}
+	}
+	return mailboxes, nil
+}
+
+// tenantConfigs переводит секцию tenancy в настройки сервиса.
+func tenantConfigs(cfg config.TenancyConfig) ([]messages.TenantConfig, error) {
+	tenants := make([]messages.TenantConfig, 0, len(cfg.Tenants))
+	for _, tc := range cfg.Tenants {
+		tenants = append(tenants, messages.TenantConfig{
+			ID:            tc.ID,
+			HierarchyPath: tc.OrgFile,
+			PromptVersion: tc.PromptVersion,
+			Provider:      tc.LLM.Provider,
+			Model:         tc.LLM.Model,
+			OutputTopic:   tc.OutputTopic,
+			MailsPerDay:   tc.Quotas.MailsPerDay,
+		})
+	}
+	if err := messages.ValidateTenants(tenants); err != nil {
+		return nil, fmt.Errorf("tenancy: %w", err)
+	}
+	return tenants, nil
+}
+
+// checkTenantIsolation до приёма запросов проверяет, что сессии каждого
+// тенанта видят только свои письма.
+func checkTenantIsolation(db *postgresql.Storage, tenants []messages.TenantConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := db.CheckTenantIsolation(ctx, messages.DefaultTenant); err != nil {
+		return err
+	}
+	for _, tc := range tenants {
+		if err := db.CheckTenantIsolation(ctx, tc.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tenantConfigured(cfg config.TenancyConfig, id string) bool {
+	if !cfg.Enabled {
+		return false
+	}
+	for _, tc := range cfg.Tenants {
+		if tc.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func approvalPolicy(cfg config.ApprovalsConfig) messages.ApprovalPolicy {
+	policy := messages.ApprovalPolicy{
+		DefaultMode:     cfg.DefaultMode,
+		LegalTeam:       cfg.LegalTeam,
+		LegalCategories: cfg.LegalCategories,
+		Categories:      make(map[string]messages.CategoryPolicy, len(cfg.Categories)),
+	}
+	for category, c := range cfg.Categories {
+		policy.Categories[category] = messages.CategoryPolicy{
+			Mode:       c.Mode,
+			ExtraTeams: c.ExtraTeams,
+		}
+	}
+	return policy
 }
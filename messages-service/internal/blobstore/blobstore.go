@@ -0,0 +1,25 @@
+package blobstore
+
+import (
+	"fmt"
+
+	"messages-service/internal/config"
+	"messages-service/internal/messages"
+)
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// New создаёт хранилище вложений по настройкам.
+func New(cfg config.AttachmentsConfig) (messages.BlobStore, error) {
+	switch cfg.Storage {
+	case "", BackendLocal:
+		return NewLocal(cfg.LocalDir)
+	case BackendS3:
+		return NewS3(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown attachments storage %q", cfg.Storage)
+	}
+}
@@ -0,0 +1,102 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"messages-service/internal/config"
+)
+
+func TestLocalRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "blobs")
+	store, err := NewLocal(dir)
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+
+	const key = "2024/01/mail-1/0-invoice.pdf"
+	if err := store.Put(ctx, key, []byte("v1"), "application/pdf"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// Повторная запись ключа заменяет содержимое.
+	if err := store.Put(ctx, key, []byte("v2"), "application/pdf"); err != nil {
+		t.Fatalf("Put again: %v", err)
+	}
+
+	got, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(got, []byte("v2")) {
+		t.Errorf("Get = %q, want %q", got, "v2")
+	}
+	if _, err := os.Stat(filepath.Join(dir, key+".tmp")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Get after Delete error = %v, want not exist", err)
+	}
+	// Удаление отсутствующего blob'а не ошибка: очистка может повторяться.
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete missing: %v", err)
+	}
+}
+
+func TestLocalRejectsKeysOutsideDir(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	store, err := NewLocal(filepath.Join(root, "blobs"))
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+
+	for _, key := range []string{"", "/", "../escape", "a/../../escape", "a/.."} {
+		if err := store.Put(ctx, key, []byte("x"), ""); err == nil {
+			t.Errorf("Put(%q): want error", key)
+		}
+		if _, err := store.Get(ctx, key); err == nil {
+			t.Errorf("Get(%q): want error", key)
+		}
+		if err := store.Delete(ctx, key); err == nil {
+			t.Errorf("Delete(%q): want error", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "escape")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("blob written outside the storage dir: %v", err)
+	}
+
+	// Абсолютный ключ остаётся внутри каталога хранилища.
+	if err := store.Put(ctx, "/abs/key", []byte("x"), ""); err != nil {
+		t.Fatalf("Put absolute key: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "blobs", "abs", "key")); err != nil {
+		t.Errorf("absolute key not stored under the dir: %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	if store, err := New(config.AttachmentsConfig{LocalDir: dir}); err != nil {
+		t.Errorf("New with default backend: %v", err)
+	} else if _, ok := store.(*Local); !ok {
+		t.Errorf("New with default backend = %T, want *Local", store)
+	}
+	if _, err := New(config.AttachmentsConfig{Storage: BackendLocal}); err == nil {
+		t.Error("New local without dir: want error")
+	}
+	if _, err := New(config.AttachmentsConfig{Storage: BackendS3}); err == nil {
+		t.Error("New s3 without endpoint: want error")
+	}
+	if _, err := New(config.AttachmentsConfig{Storage: "ftp", LocalDir: dir}); err == nil {
+		t.Error("New with unknown backend: want error")
+	}
+}
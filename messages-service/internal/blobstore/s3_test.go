@@ -0,0 +1,99 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+
+	"messages-service/internal/config"
+)
+
+// Интеграционный тест S3-хранилища. Ему нужен MinIO или другой
+// S3-совместимый сервер: адрес задаётся в MESSAGES_TEST_S3_ENDPOINT
+// (host:port), ключи — в MESSAGES_TEST_S3_ACCESS_KEY и
+// MESSAGES_TEST_S3_SECRET_KEY. Без адреса тест пропускается. Тест создаёт
+// бакет со случайным именем и удаляет его после себя. Подходит MinIO из
+// docker-compose.yml (ключи minioadmin/minioadmin).
+const testS3EndpointEnv = "MESSAGES_TEST_S3_ENDPOINT"
+
+func TestS3RoundTrip(t *testing.T) {
+	endpoint := os.Getenv(testS3EndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s is not set", testS3EndpointEnv)
+	}
+
+	ctx := context.Background()
+	cfg := config.S3Config{
+		Endpoint:  endpoint,
+		Bucket:    "messages-test-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:12],
+		AccessKey: os.Getenv("MESSAGES_TEST_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("MESSAGES_TEST_S3_SECRET_KEY"),
+	}
+
+	// Бакета ещё нет: NewS3 создаёт его.
+	store, err := NewS3(cfg)
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	t.Cleanup(func() {
+		for obj := range store.client.ListObjects(ctx, cfg.Bucket, minio.ListObjectsOptions{Recursive: true}) {
+			_ = store.client.RemoveObject(ctx, cfg.Bucket, obj.Key, minio.RemoveObjectOptions{})
+		}
+		if err := store.client.RemoveBucket(ctx, cfg.Bucket); err != nil {
+			t.Logf("remove bucket %s: %v", cfg.Bucket, err)
+		}
+	})
+
+	// Существующий бакет подхватывается без ошибки.
+	if _, err := NewS3(cfg); err != nil {
+		t.Fatalf("NewS3 with existing bucket: %v", err)
+	}
+
+	const key = "2024/01/mail-1/0-invoice.pdf"
+	data := bytes.Repeat([]byte("%PDF-1.4 "), 1000)
+	if err := store.Put(ctx, key, data, "application/pdf"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	got, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Get returned %d bytes, want %d", len(got), len(data))
+	}
+
+	info, err := store.client.StatObject(ctx, cfg.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		t.Fatalf("StatObject: %v", err)
+	}
+	if info.ContentType != "application/pdf" {
+		t.Errorf("content type = %q, want application/pdf", info.ContentType)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, key); err == nil {
+		t.Error("Get after Delete: want error")
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete missing: %v", err)
+	}
+}
+
+func TestNewS3RequiresEndpointAndBucket(t *testing.T) {
+	for _, cfg := range []config.S3Config{
+		{Bucket: "b"},
+		{Endpoint: "localhost:9000"},
+	} {
+		if _, err := NewS3(cfg); err == nil {
+			t.Errorf("NewS3(%+v): want error", cfg)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package cache
+
+import (
+	"database/sql"
+	"fmt"
+
+	"messages-service/internal/config"
+	"messages-service/internal/messages"
+)
+
+const (
+	BackendNone     = "none"
+	BackendMemory   = "memory"
+	BackendPostgres = "postgres"
+)
+
+// New создаёт кэш результатов LLM по настройкам. Для backend=none возвращает nil.
+func New(cfg config.CacheConfig, db *sql.DB) (messages.ResultCache, error) {
+	switch cfg.Backend {
+	case "", BackendNone:
+		return nil, nil
+	case BackendMemory:
+		return NewMemory(cfg.MaxEntries, cfg.TTL), nil
+	case BackendPostgres:
+		return NewPostgres(db, cfg.TTL), nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"messages-service/internal/messages"
+)
+
+// Memory — LRU-кэш в памяти процесса с ограничением по числу записей и TTL.
+type Memory struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type memoryItem struct {
+	key       string
+	result    messages.CachedResult
+	expiresAt time.Time
+}
+
+func NewMemory(maxEntries int, ttl time.Duration) *Memory {
+	return &Memory{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (m *Memory) Get(_ context.Context, key string) (*messages.CachedResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.items[key]
+	if !ok {
+		return nil, nil
+	}
+
+	item := el.Value.(*memoryItem)
+	if !item.expiresAt.IsZero() && time.Now().After(item.expiresAt) {
+		m.removeElement(el)
+		return nil, nil
+	}
+
+	m.ll.MoveToFront(el)
+	result := item.result
+	return &result, nil
+}
+
+func (m *Memory) Set(_ context.Context, key string, result messages.CachedResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expiresAt time.Time
+	if m.ttl > 0 {
+		expiresAt = time.Now().Add(m.ttl)
+	}
+
+	if el, ok := m.items[key]; ok {
+		item := el.Value.(*memoryItem)
+		item.result = result
+		item.expiresAt = expiresAt
+		m.ll.MoveToFront(el)
+		return nil
+	}
+
+	el := m.ll.PushFront(&memoryItem{key: key, result: result, expiresAt: expiresAt})
+	m.items[key] = el
+
+	if m.maxEntries > 0 && m.ll.Len() > m.maxEntries {
+		m.removeElement(m.ll.Back())
+	}
+
+	return nil
+}
+
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[key]; ok {
+		m.removeElement(el)
+	}
+	return nil
+}
+
+func (m *Memory) removeElement(el *list.Element) {
+	m.ll.Remove(el)
+	delete(m.items, el.Value.(*memoryItem).key)
+}
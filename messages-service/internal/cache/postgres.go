@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"messages-service/internal/messages"
+)
+
+// Postgres хранит кэш в таблице llm_cache и подходит для нескольких реплик сервиса.
+type Postgres struct {
+	db  *sql.DB
+	ttl time.Duration
+}
+
+func NewPostgres(db *sql.DB, ttl time.Duration) *Postgres {
+	return &Postgres{db: db, ttl: ttl}
+}
+
+func (p *Postgres) Get(ctx context.Context, key string) (*messages.CachedResult, error) {
+	const query = `
+SELECT classification, model_answer
+FROM llm_cache
+WHERE key = $1
+AND (expires_at IS NULL OR expires_at > NOW());
+`
+
+	var result messages.CachedResult
+	err := p.db.QueryRowContext(ctx, query, key).Scan(&result.Classification, &result.ModelAnswer)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (p *Postgres) Set(ctx context.Context, key string, result messages.CachedResult) error {
+	const query = `
+INSERT INTO llm_cache (key, classification, model_answer, expires_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (key) DO UPDATE
+SET classification = EXCLUDED.classification,
+model_answer = EXCLUDED.model_answer,
+expires_at = EXCLUDED.expires_at,
+created_at = NOW();
+`
+
+	var expiresAt sql.NullTime
+	if p.ttl > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().UTC().Add(p.ttl), Valid: true}
+	}
+
+	_, err := p.db.ExecContext(ctx, query, key, result.Classification, result.ModelAnswer, expiresAt)
+	return err
+}
+
+func (p *Postgres) Delete(ctx context.Context, key string) error {
+	_, err := p.db.ExecContext(ctx, `DELETE FROM llm_cache WHERE key = $1;`, key)
+	return err
+}
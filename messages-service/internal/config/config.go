@@ -10,11 +10,30 @@ import (
 type Config struct {
 	Env string `yaml:"env" env-default:"local"`
 
-	HTTPServer HTTPServerConfig `yaml:"http_server"`
-	Kafka      KafkaConfig      `yaml:"kafka"`
-	Retries    RetriesConfig    `yaml:"retries"`
-	PostgreSQL PostgreConfig    `yaml:"postgresql"`
-	Org        OrgConfig        `yaml:"org"`
+	HTTPServer     HTTPServerConfig     `yaml:"http_server"`
+	Kafka          KafkaConfig          `yaml:"kafka"`
+	Retries        RetriesConfig        `yaml:"retries"`
+	PostgreSQL     PostgreConfig        `yaml:"postgresql"`
+	Org            OrgConfig            `yaml:"org"`
+	LLM            LLMConfig            `yaml:"llm"`
+	Cache          CacheConfig          `yaml:"cache"`
+	Attachments    AttachmentsConfig    `yaml:"attachments"`
+	IMAP           IMAPConfig           `yaml:"imap"`
+	SMTP           SMTPConfig           `yaml:"smtp"`
+	Outbound       OutboundConfig       `yaml:"outbound"`
+	Threading      ThreadingConfig      `yaml:"threading"`
+	Routing        RoutingConfig        `yaml:"routing"`
+	Approvals      ApprovalsConfig      `yaml:"approvals"`
+	SLA            SLAConfig            `yaml:"sla"`
+	Webhooks       WebhooksConfig       `yaml:"webhooks"`
+	Events         EventsConfig         `yaml:"events"`
+	Retention      RetentionConfig      `yaml:"retention"`
+	Encryption     EncryptionConfig     `yaml:"encryption"`
+	Prefilter      PrefilterConfig      `yaml:"prefilter"`
+	Correspondents CorrespondentsConfig `yaml:"correspondents"`
+	Mailboxes      []MailboxConfig      `yaml:"mailboxes"`
+	Tenancy        TenancyConfig        `yaml:"tenancy"`
+	RateLimit      RateLimitConfig      `yaml:"rate_limit"`
 }
 
 type HTTPServerConfig struct {
@@ -29,6 +48,7 @@ type KafkaConfig struct {
 	OutputTopic     string         `yaml:"output_topic" env-default:"processed_messages"`
 	DeadLetterTopic string         `yaml:"dead_letter_topic" env-default:"messages_failed"`
 	Producer        ProducerConfig `yaml:"producer"`
+	Consumer        ConsumerConfig `yaml:"consumer"`
 }
 
 type ProducerConfig struct {
@@ -36,6 +56,50 @@ type ProducerConfig struct {
 	Timeout time.Duration `yaml:"timeout" env-default:"3s"`
 }
 
+// ConsumerConfig — настройки консьюмеров cmd/consumer.
+type ConsumerConfig struct {
+	// StartOffset — откуда читать партицию без закоммиченного смещения:
+	// earliest или latest.
+	StartOffset    string        `yaml:"start_offset" env-default:"earliest"`
+	CommitInterval time.Duration `yaml:"commit_interval" env-default:"1s"`
+	// MaxAttempts и RetryBackoff — попытки обработать сообщение; задержка
+	// удваивается с каждой попыткой, но не больше минуты.
+	MaxAttempts  int           `yaml:"max_attempts" env-default:"5"`
+	RetryBackoff time.Duration `yaml:"retry_backoff" env-default:"1s"`
+	// DrainTimeout — сколько ждать обработки прочитанных сообщений при
+	// ребалансировке и остановке.
+	DrainTimeout time.Duration `yaml:"drain_timeout" env-default:"30s"`
+	// PoisonTopic — куда отправлять сообщения, которые не удалось
+	// обработать; пусто — только журналировать.
+	PoisonTopic string                  `yaml:"poison_topic"`
+	Handlers    []ConsumerHandlerConfig `yaml:"handlers"`
+}
+
+type ConsumerHandlerConfig struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"` // webhook / archive
+	// GroupID — группа консьюмеров; по умолчанию messages-consumer-<name>.
+	GroupID     string                `yaml:"group_id"`
+	Topics      []string              `yaml:"topics"`
+	Concurrency int                   `yaml:"concurrency"`
+	Webhook     ConsumerWebhookConfig `yaml:"webhook"`
+	Archive     ConsumerArchiveConfig `yaml:"archive"`
+}
+
+type ConsumerWebhookConfig struct {
+	URLs   []string `yaml:"urls"`
+	Secret string   `yaml:"secret"`
+	// SecretEnv — переменная окружения с секретом подписи.
+	SecretEnv string        `yaml:"secret_env"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+type ConsumerArchiveConfig struct {
+	Dir string `yaml:"dir"`
+	// Sync — fsync после каждой записи.
+	Sync bool `yaml:"sync"`
+}
+
 type RetriesConfig struct {
 	MaxLLMAttempts int `yaml:"max_llm_attempts" env-default:"5"`
 }
@@ -47,12 +111,321 @@ type PostgreConfig struct {
 	Password string `yaml:"password" env-default:"postgres"`
 	DBName   string `yaml:"dbname" env-default:"postgres"`
 	SSLMode  string `yaml:"sslmode" env-default:"disable"`
+	// TenantRole — роль без BYPASSRLS, под которой работают пулы тенантов.
+	// Нужна, если user — суперпользователь или владеет ролью с BYPASSRLS.
+	TenantRole string `yaml:"tenant_role" env:"POSTGRES_TENANT_ROLE"`
 }
 
 type OrgConfig struct {
 	FilePath string `yaml:"file_path" env-default:"./configs/hierarchy.json"`
 }
 
+type LLMConfig struct {
+	// AllowStubAnswers разрешает принимать ответы-заглушки llm-service
+	// (source=stub). В проде должно быть выключено.
+	AllowStubAnswers bool `yaml:"allow_stub_answers" env:"LLM_ALLOW_STUB_ANSWERS" env-default:"false"`
+	// PromptVersion и Model входят в ключ кэша ответов.
+	PromptVersion string `yaml:"prompt_version" env-default:"v1"`
+	Model         string `yaml:"model" env-default:"openai/gpt-4o"`
+	// Provider — бэкенд LLM, которому воркер отдаёт задачу.
+	Provider string `yaml:"provider" env-default:"openrouter"`
+}
+
+type CacheConfig struct {
+	Backend    string        `yaml:"backend" env:"LLM_CACHE_BACKEND" env-default:"none"` // none / memory / postgres
+	TTL        time.Duration `yaml:"ttl" env-default:"24h"`
+	MaxEntries int           `yaml:"max_entries" env-default:"10000"`
+}
+
+type AttachmentsConfig struct {
+	Storage      string   `yaml:"storage" env:"ATTACHMENTS_STORAGE" env-default:"local"` // local / s3
+	LocalDir     string   `yaml:"local_dir" env-default:"./data/attachments"`
+	MaxTextChars int      `yaml:"max_text_chars" env-default:"20000"`
+	S3           S3Config `yaml:"s3"`
+}
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint" env:"S3_ENDPOINT"`
+	Bucket    string `yaml:"bucket" env:"S3_BUCKET" env-default:"mail-attachments"`
+	AccessKey string `yaml:"access_key" env:"S3_ACCESS_KEY"`
+	SecretKey string `yaml:"secret_key" env:"S3_SECRET_KEY"`
+	Region    string `yaml:"region" env:"S3_REGION"`
+	UseSSL    bool   `yaml:"use_ssl" env:"S3_USE_SSL" env-default:"false"`
+}
+
+type IMAPConfig struct {
+	Enabled      bool                `yaml:"enabled" env:"IMAP_ENABLED" env-default:"false"`
+	PollInterval time.Duration       `yaml:"poll_interval" env-default:"1m"`
+	Mailboxes    []IMAPMailboxConfig `yaml:"mailboxes"`
+}
+
+type IMAPMailboxConfig struct {
+	Name     string `yaml:"name"`     // ключ checkpoint-а в imap_checkpoints
+	Address  string `yaml:"address"`  // host:port
+	Security string `yaml:"security"` // tls (по умолчанию) / starttls / none
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// PasswordEnv — имя переменной окружения с паролем, приоритетнее Password.
+	PasswordEnv string `yaml:"password_env"`
+	Folder      string `yaml:"folder"` // по умолчанию INBOX
+	// Mailbox — id общего ящика из mailboxes для всех писем этого ящика IMAP;
+	// пусто — ящик определяется по адресам письма.
+	Mailbox            string `yaml:"mailbox"`
+	MoveTo             string `yaml:"move_to"` // если пусто — письмо помечается \Seen
+	BatchSize          int    `yaml:"batch_size"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+type SMTPConfig struct {
+	Enabled         bool          `yaml:"enabled" env:"SMTP_ENABLED" env-default:"false"`
+	Address         string        `yaml:"address" env-default:"0.0.0.0:2525"`
+	Domain          string        `yaml:"domain" env-default:"localhost"`
+	MaxMessageBytes int64         `yaml:"max_message_bytes" env-default:"26214400"`
+	MaxRecipients   int           `yaml:"max_recipients" env-default:"50"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" env-default:"60s"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" env-default:"60s"`
+	// AllowedDomains — домены получателей, для которых принимается почта;
+	// обязателен при enabled.
+	AllowedDomains []string `yaml:"allowed_domains"`
+	TLSCertFile    string   `yaml:"tls_cert_file" env:"SMTP_TLS_CERT_FILE"`
+	TLSKeyFile     string   `yaml:"tls_key_file" env:"SMTP_TLS_KEY_FILE"`
+}
+
+type OutboundConfig struct {
+	Enabled         bool               `yaml:"enabled" env:"OUTBOUND_ENABLED" env-default:"false"`
+	FromAddress     string             `yaml:"from_address" env-default:"support@example.com"`
+	MessageIDDomain string             `yaml:"message_id_domain" env-default:"example.com"`
+	MaxAttempts     int                `yaml:"max_attempts" env-default:"5"`
+	RetryBackoff    time.Duration      `yaml:"retry_backoff" env-default:"1m"`
+	PollInterval    time.Duration      `yaml:"poll_interval" env-default:"10s"`
+	BatchSize       int                `yaml:"batch_size" env-default:"20"`
+	SMTP            OutboundSMTPConfig `yaml:"smtp"`
+}
+
+type OutboundSMTPConfig struct {
+	Address            string `yaml:"address" env:"OUTBOUND_SMTP_ADDRESS" env-default:"localhost:1025"`
+	Username           string `yaml:"username"`
+	Password           string `yaml:"password"`
+	PasswordEnv        string `yaml:"password_env"`
+	StartTLS           bool   `yaml:"starttls"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+type ThreadingConfig struct {
+	// SubjectWindow — за какой период искать письмо того же отправителя с той
+	// же темой, если в заголовках нет ссылок на известные письма. 0 отключает поиск.
+	SubjectWindow time.Duration `yaml:"subject_window" env-default:"720h"`
+	// ContextMessages — сколько предыдущих писем ветки передавать в LLM.
+	ContextMessages int `yaml:"context_messages" env-default:"5"`
+	// ContextChars ограничивает текст одного письма ветки в задаче LLM.
+	ContextChars int `yaml:"context_chars" env-default:"2000"`
+}
+
+type RoutingConfig struct {
+	// DefaultQueue — очередь для писем, чей main_approver не найден в оргструктуре.
+	DefaultQueue string `yaml:"default_queue" env-default:"unassigned"`
+	// DepartmentTopics — id департамента → топик Kafka, куда дополнительно
+	// публикуются его обработанные письма.
+	DepartmentTopics map[string]string `yaml:"department_topics"`
+}
+
+type ApprovalsConfig struct {
+	// Enabled включает многошаговое согласование; без него письмо утверждается
+	// одним POST /approve.
+	Enabled     bool   `yaml:"enabled" env:"APPROVALS_ENABLED" env-default:"false"`
+	DefaultMode string `yaml:"default_mode" env-default:"parallel"` // sequential / parallel
+	// LegalTeam согласует регуляторные письма и письма с legal_risks.
+	LegalTeam       string                            `yaml:"legal_team" env-default:"legal_corporate"`
+	LegalCategories []string                          `yaml:"legal_categories" env-default:"регуляторный запрос"`
+	Categories      map[string]ApprovalCategoryConfig `yaml:"categories"`
+}
+
+type ApprovalCategoryConfig struct {
+	Mode       string   `yaml:"mode"`
+	ExtraTeams []string `yaml:"extra_teams"`
+}
+
+type SLAConfig struct {
+	Enabled       bool          `yaml:"enabled" env:"SLA_ENABLED" env-default:"false"`
+	CheckInterval time.Duration `yaml:"check_interval" env-default:"1m"`
+	// AtRiskRatio — доля срока, после которой письмо помечается at_risk.
+	AtRiskRatio float64 `yaml:"at_risk_ratio" env-default:"0.75"`
+	// Default — срок с приёма письма до ответа модели и для urgency без своей
+	// настройки; 0 — без SLA.
+	Default time.Duration `yaml:"default" env-default:"72h"`
+	// Urgency — сроки по urgency (low / medium / high / immediate).
+	Urgency map[string]time.Duration `yaml:"urgency"`
+	// Categories — сроки по urgency для отдельных категорий.
+	Categories      map[string]map[string]time.Duration `yaml:"categories"`
+	EscalationTopic string                              `yaml:"escalation_topic" env-default:"mail_escalations"`
+	WebhookURL      string                              `yaml:"webhook_url" env:"SLA_WEBHOOK_URL"`
+	WebhookTimeout  time.Duration                       `yaml:"webhook_timeout" env-default:"5s"`
+}
+
+type WebhooksConfig struct {
+	Enabled      bool          `yaml:"enabled" env:"WEBHOOKS_ENABLED" env-default:"false"`
+	PollInterval time.Duration `yaml:"poll_interval" env-default:"5s"`
+	BatchSize    int           `yaml:"batch_size" env-default:"50"`
+	MaxAttempts  int           `yaml:"max_attempts" env-default:"8"`
+	RetryBackoff time.Duration `yaml:"retry_backoff" env-default:"30s"`
+	Timeout      time.Duration `yaml:"timeout" env-default:"10s"`
+}
+
+type EventsConfig struct {
+	Enabled      bool          `yaml:"enabled" env:"EVENTS_ENABLED" env-default:"false"`
+	PollInterval time.Duration `yaml:"poll_interval" env-default:"1s"`
+	Heartbeat    time.Duration `yaml:"heartbeat" env-default:"15s"`
+	BufferSize   int           `yaml:"buffer_size" env-default:"256"`
+	ReplayLimit  int           `yaml:"replay_limit" env-default:"1000"` // страница докачки по Last-Event-ID
+	Retention    time.Duration `yaml:"retention" env-default:"168h"`
+}
+
+type RetentionConfig struct {
+	Enabled   bool          `yaml:"enabled" env:"RETENTION_ENABLED" env-default:"false"`
+	Interval  time.Duration `yaml:"interval" env-default:"1h"`
+	BatchSize int           `yaml:"batch_size" env-default:"500"`
+	// Rules применяются по порядку; письмо с legal_hold не трогает ни одно.
+	Rules []RetentionRuleConfig `yaml:"rules"`
+}
+
+type RetentionRuleConfig struct {
+	Action     string        `yaml:"action"`     // purge_body / purge_content / delete
+	Statuses   []string      `yaml:"statuses"`   // пусто — любой статус
+	Categories []string      `yaml:"categories"` // пусто — любая категория
+	After      time.Duration `yaml:"after"`      // возраст письма по received_at
+}
+
+type EncryptionConfig struct {
+	// Enabled шифрует текст писем, ответы модели и ассистента и текст
+	// вложений. Выключать нельзя, пока в БД есть зашифрованные значения.
+	Enabled bool   `yaml:"enabled" env:"ENCRYPTION_ENABLED" env-default:"false"`
+	KeyFile string `yaml:"key_file" env:"ENCRYPTION_KEY_FILE" env-default:"./configs/encryption-keys.json"`
+	// RotationInterval — как часто искать значения, записанные открыто или
+	// не текущим мастер-ключом.
+	RotationInterval time.Duration `yaml:"rotation_interval" env-default:"1h"`
+	BatchSize        int           `yaml:"batch_size" env-default:"200"`
+}
+
+type PrefilterConfig struct {
+	Enabled bool `yaml:"enabled" env:"PREFILTER_ENABLED" env-default:"false"`
+	// AutoReplies отсеивает автоответы по Auto-Submitted, Precedence: auto_reply,
+	// X-Autoreply и X-Autorespond.
+	AutoReplies bool `yaml:"auto_replies" env-default:"true"`
+	// Bounces отсеивает отчёты о недоставке (DSN) и письма от MAILER-DAEMON.
+	Bounces bool `yaml:"bounces" env-default:"true"`
+	// Bulk отсеивает рассылки: Precedence: bulk/list/junk и List-Unsubscribe.
+	Bulk bool `yaml:"bulk" env-default:"false"`
+	// AllowSenders и DenySenders — адреса (user@example.com) или домены
+	// (@example.com). Разрешённых отправителей не отсеивают deny-список и правила.
+	AllowSenders []string              `yaml:"allow_senders"`
+	DenySenders  []string              `yaml:"deny_senders"`
+	Rules        []PrefilterRuleConfig `yaml:"rules"`
+}
+
+type PrefilterRuleConfig struct {
+	Name   string `yaml:"name"`
+	Action string `yaml:"action"` // filter (по умолчанию) / allow
+	// Match — поле → регулярное выражение; правило срабатывает, когда
+	// совпали все поля. Поля: from, to, subject, body, attachment,
+	// header:<Имя>.
+	Match map[string]string `yaml:"match"`
+}
+
+// MailboxConfig — общий ящик (support@, compliance@, partners@) со своими
+// настройками. Незаданные поля берутся из общих секций org, llm, routing и sla.
+type MailboxConfig struct {
+	ID        string   `yaml:"id"`
+	Addresses []string `yaml:"addresses"`
+	// Tenant — тенант ящика; пусто — default. Незаданные поля ящик берёт
+	// из настроек тенанта.
+	Tenant string `yaml:"tenant"`
+	// OrgFile — файл оргструктуры ящика вместо org.file_path.
+	OrgFile string `yaml:"org_file"`
+	// PromptVersion передаётся в задаче LLM и входит в ключ кэша ответов.
+	PromptVersion string         `yaml:"prompt_version"`
+	Routing       *RoutingConfig `yaml:"routing"`
+	// SLA — сроки ящика; действуют, только если включена секция sla.
+	SLA *MailboxSLAConfig `yaml:"sla"`
+}
+
+type MailboxSLAConfig struct {
+	Default    time.Duration                       `yaml:"default"`
+	Urgency    map[string]time.Duration            `yaml:"urgency"`
+	Categories map[string]map[string]time.Duration `yaml:"categories"`
+}
+
+type TenancyConfig struct {
+	// Enabled включает аутентификацию по API-ключам и изоляцию тенантов.
+	// Выключенная — все письма принадлежат тенанту default.
+	Enabled bool `yaml:"enabled" env:"TENANCY_ENABLED" env-default:"false"`
+	// ServiceKeys — ключи воркера LLM: с ними доступен только
+	// POST /validate_processed_message, тенант берётся из письма.
+	ServiceKeys []string `yaml:"service_keys"`
+	// ServiceKeysEnv — переменная окружения с ключами через запятую.
+	ServiceKeysEnv string         `yaml:"service_keys_env"`
+	Tenants        []TenantConfig `yaml:"tenants"`
+}
+
+// TenantConfig — дочерняя компания, работающая в общей инсталляции.
+// Незаданные поля берутся из общих секций org, llm и kafka.
+type TenantConfig struct {
+	ID      string   `yaml:"id"` // латиница в нижнем регистре, цифры, - и _
+	APIKeys []string `yaml:"api_keys"`
+	// APIKeysEnv — переменная окружения с ключами через запятую.
+	APIKeysEnv    string            `yaml:"api_keys_env"`
+	OrgFile       string            `yaml:"org_file"`
+	PromptVersion string            `yaml:"prompt_version"`
+	LLM           TenantLLMConfig   `yaml:"llm"`
+	OutputTopic   string            `yaml:"output_topic"`
+	Quotas        TenantQuotaConfig `yaml:"quotas"`
+}
+
+type TenantLLMConfig struct {
+	Provider string `yaml:"provider"`
+	Model    string `yaml:"model"`
+}
+
+type TenantQuotaConfig struct {
+	// MailsPerDay — сколько писем тенант может передать за сутки UTC; 0 — без ограничения.
+	MailsPerDay int `yaml:"mails_per_day"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool   `yaml:"enabled" env:"RATE_LIMIT_ENABLED" env-default:"false"`
+	Backend string `yaml:"backend" env:"RATE_LIMIT_BACKEND" env-default:"memory"` // memory / postgres
+	// KeyBy — по чему различаются клиенты: api_key или tenant. Запросы без
+	// ключа различаются по IP.
+	KeyBy  string             `yaml:"key_by" env-default:"api_key"`
+	Limits ClientLimitsConfig `yaml:"limits"`
+	// Tenants — лимиты клиентов тенанта; незаданные поля берутся из limits.
+	Tenants map[string]ClientLimitsConfig `yaml:"tenants"`
+	// AdminKeys — ключи, с которыми доступен GET /admin/usage; без них
+	// эндпоинт не регистрируется.
+	AdminKeys []string `yaml:"admin_keys"`
+	// AdminKeysEnv — переменная окружения с ключами через запятую.
+	AdminKeysEnv string `yaml:"admin_keys_env"`
+}
+
+type ClientLimitsConfig struct {
+	// RequestsPerSecond и Burst — скорость пополнения и ёмкость корзины
+	// запросов; 0 — без ограничения.
+	RequestsPerSecond float64 `yaml:"requests_per_second" env-default:"10"`
+	Burst             int     `yaml:"burst" env-default:"20"`
+	// MailsPerDay и LLMTokensPerDay — суточные квоты UTC; 0 — без ограничения.
+	MailsPerDay     int64 `yaml:"mails_per_day"`
+	LLMTokensPerDay int64 `yaml:"llm_tokens_per_day"`
+}
+
+type CorrespondentsConfig struct {
+	// ComplaintCategories — категории ответа модели, которые считаются жалобами.
+	ComplaintCategories []string `yaml:"complaint_categories" env-default:"жалоба"`
+	// RepeatComplaints — с какого числа жалоб отправитель помечается
+	// повторным жалобщиком.
+	RepeatComplaints int `yaml:"repeat_complaints" env-default:"2"`
+	// HistoryLimit — сколько последних писем отдаёт GET /senders/{id}.
+	HistoryLimit int `yaml:"history_limit" env-default:"50"`
+}
+
 func MustLoad() *Config {
 	configPath := os.Getenv("CONFIG_PATH")
 	if configPath == "" {
@@ -0,0 +1,137 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"messages-service/internal/mailparse"
+)
+
+// maxArchiveEntryBytes ограничивает распакованный размер XML внутри
+// DOCX/XLSX/ODT, чтобы zip-бомба не съела память.
+const maxArchiveEntryBytes = 32 << 20
+
+// ErrUnsupported возвращается для форматов, из которых текст не извлекается.
+var ErrUnsupported = errors.New("unsupported attachment format")
+
+// Text извлекает текст из вложения текстового формата: text/*, JSON, XML,
+// HTML, PDF, DOCX, XLSX и ODT. Результат обрезается до maxChars символов (0 — без ограничения).
+func Text(filename, contentType string, data []byte, maxChars int) (string, error) {
+	ext := strings.ToLower(path.Ext(filename))
+	contentType = strings.ToLower(contentType)
+
+	var (
+		text string
+		err  error
+	)
+	switch {
+	case contentType == "text/html" || ext == ".html" || ext == ".htm":
+		text = mailparse.HTMLToText(string(data))
+	case strings.HasPrefix(contentType, "text/"),
+		contentType == "application/json",
+		contentType == "application/xml",
+		ext == ".txt", ext == ".csv", ext == ".json", ext == ".xml", ext == ".md", ext == ".eml":
+		if !utf8.Valid(data) {
+			return "", ErrUnsupported
+		}
+		text = string(data)
+	case ext == ".pdf" || contentType == "application/pdf":
+		text, err = pdfText(data)
+	case ext == ".docx" || contentType == "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		text, err = docxText(data)
+	case ext == ".xlsx" || contentType == "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":
+		text, err = xlsxText(data)
+	case ext == ".odt" || contentType == "application/vnd.oasis.opendocument.text":
+		text, err = zipXMLText(data, "content.xml", paragraphTags("p", "h"))
+	default:
+		return "", ErrUnsupported
+	}
+	if err != nil {
+		return "", err
+	}
+
+	text = strings.TrimSpace(text)
+	if maxChars > 0 && utf8.RuneCountInString(text) > maxChars {
+		text = string([]rune(text)[:maxChars])
+	}
+	return text, nil
+}
+
+func docxText(data []byte) (string, error) {
+	return zipXMLText(data, "word/document.xml", paragraphTags("p"))
+}
+
+// xlsxText извлекает таблицу общих строк книги. Значения ячеек листов не
+// читаются: для LLM важны подписи и реквизиты, а не расчёты.
+func xlsxText(data []byte) (string, error) {
+	return zipXMLText(data, "xl/sharedStrings.xml", paragraphTags("si"))
+}
+
+func paragraphTags(names ...string) map[string]bool {
+	tags := make(map[string]bool, len(names))
+	for _, n := range names {
+		tags[n] = true
+	}
+	return tags
+}
+
+// zipXMLText читает XML-файл из zip-архива и собирает его текстовые узлы,
+// разделяя абзацы переводом строки.
+func zipXMLText(data []byte, name string, paragraphs map[string]bool) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+
+		return xmlText(io.LimitReader(rc, maxArchiveEntryBytes), paragraphs)
+	}
+
+	return "", nil
+}
+
+func xmlText(r io.Reader, paragraphs map[string]bool) (string, error) {
+	dec := xml.NewDecoder(r)
+	var sb strings.Builder
+
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := tok.(type) {
+		case xml.CharData:
+			sb.Write(t)
+		case xml.StartElement:
+			if t.Name.Local == "tab" {
+				sb.WriteByte('\t')
+			}
+		case xml.EndElement:
+			if paragraphs[t.Name.Local] {
+				sb.WriteByte('\n')
+			}
+		}
+	}
+
+	return sb.String(), nil
+}
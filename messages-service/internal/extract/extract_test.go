@@ -0,0 +1,182 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// zipOf собирает zip-архив из пар имя → содержимое.
+func zipOf(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Офисные документы в фикстурах записаны без переводов строк между тегами,
+// как их сохраняют Word, Excel и LibreOffice.
+const docxXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+	`<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+	`<w:body>` +
+	`<w:p><w:r><w:t>Договор № 7</w:t></w:r></w:p>` +
+	`<w:p><w:r><w:t>ИНН</w:t></w:r><w:r><w:tab/><w:t xml:space="preserve">7707083893 </w:t></w:r><w:r><w:t>&amp; КПП</w:t></w:r></w:p>` +
+	`<w:tbl><w:tr><w:tc><w:p><w:r><w:t>Ячейка</w:t></w:r></w:p></w:tc></w:tr></w:tbl>` +
+	`</w:body>` +
+	`</w:document>`
+
+const xlsxSharedStrings = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+	`<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" count="3" uniqueCount="3">` +
+	`<si><t>Наименование</t></si>` +
+	`<si><r><t>Сумма</t></r><r><rPr><b/></rPr><t>, руб.</t></r></si>` +
+	`<si><t>Итого</t></si>` +
+	`</sst>`
+
+const odtContent = `<?xml version="1.0" encoding="UTF-8"?>` +
+	`<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0">` +
+	`<office:body><office:text>` +
+	`<text:h>Претензия</text:h>` +
+	`<text:p>Просим вернуть<text:tab/>оплату</text:p>` +
+	`</office:text></office:body>` +
+	`</office:document-content>`
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name        string
+		filename    string
+		contentType string
+		data        []byte
+		maxChars    int
+		want        string
+		err         error
+	}{
+		{
+			name:     "docx",
+			filename: "contract.DOCX",
+			data:     zipOf(t, map[string]string{"word/document.xml": docxXML, "word/styles.xml": "<w:styles/>"}),
+			want:     "Договор № 7\nИНН\t7707083893 & КПП\nЯчейка",
+		},
+		{
+			name:        "docx by content type",
+			filename:    "attachment",
+			contentType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+			data:        zipOf(t, map[string]string{"word/document.xml": docxXML}),
+			want:        "Договор № 7\nИНН\t7707083893 & КПП\nЯчейка",
+		},
+		{
+			name:     "xlsx shared strings",
+			filename: "report.xlsx",
+			data:     zipOf(t, map[string]string{"xl/sharedStrings.xml": xlsxSharedStrings, "xl/worksheets/sheet1.xml": "<worksheet/>"}),
+			want:     "Наименование\nСумма, руб.\nИтого",
+		},
+		{
+			name:     "xlsx without strings",
+			filename: "numbers.xlsx",
+			data:     zipOf(t, map[string]string{"xl/worksheets/sheet1.xml": "<worksheet/>"}),
+			want:     "",
+		},
+		{
+			name:     "odt",
+			filename: "claim.odt",
+			data:     zipOf(t, map[string]string{"content.xml": odtContent}),
+			want:     "Претензия\nПросим вернуть\tоплату",
+		},
+		{
+			name:     "truncated to max chars",
+			filename: "contract.docx",
+			data:     zipOf(t, map[string]string{"word/document.xml": docxXML}),
+			maxChars: 7,
+			want:     "Договор",
+		},
+		{
+			name:        "plain text",
+			filename:    "notes",
+			contentType: "Text/Plain",
+			data:        []byte("  строка\n"),
+			want:        "строка",
+		},
+		{
+			name:     "csv not utf-8",
+			filename: "data.csv",
+			data:     []byte{0xcf, 0xf0, 0xe8},
+			err:      ErrUnsupported,
+		},
+		{
+			name:     "html",
+			filename: "page.htm",
+			data:     []byte("<p>a&amp;b</p><p>c</p>"),
+			want:     "a&b\n\nc",
+		},
+		{
+			name:        "image",
+			filename:    "scan.png",
+			contentType: "image/png",
+			data:        []byte("\x89PNG"),
+			err:         ErrUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Text(tt.filename, tt.contentType, tt.data, tt.maxChars)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Text error = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("Text = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextBrokenArchives(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		data     []byte
+	}{
+		{name: "not a zip", filename: "a.docx", data: []byte("PK but not really")},
+		{name: "truncated zip", filename: "a.docx", data: zipOf(t, map[string]string{"word/document.xml": docxXML})[:60]},
+		{name: "invalid xml", filename: "a.xlsx", data: zipOf(t, map[string]string{"xl/sharedStrings.xml": "<sst><si><t>open"})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Text(tt.filename, "", tt.data, 0); err == nil {
+				t.Fatal("Text: want error")
+			}
+		})
+	}
+}
+
+// Распакованный XML больше maxArchiveEntryBytes читается не целиком: zip-бомба
+// даёт ошибку разбора, а не съедает память.
+func TestTextZipBomb(t *testing.T) {
+	var xml strings.Builder
+	xml.WriteString("<w:document><w:body><w:p><w:t>")
+	xml.WriteString(strings.Repeat("a", maxArchiveEntryBytes))
+	xml.WriteString("</w:t></w:p></w:body></w:document>")
+
+	data := zipOf(t, map[string]string{"word/document.xml": xml.String()})
+	if len(data) > maxArchiveEntryBytes/100 {
+		t.Fatalf("archive is %d bytes, expected a well-compressed bomb", len(data))
+	}
+	if _, err := Text("bomb.docx", "", data, 0); err == nil {
+		t.Fatal("Text: want error for an entry over the limit")
+	}
+}
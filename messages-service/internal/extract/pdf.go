@@ -0,0 +1,704 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Извлечение текста из PDF без сторонних библиотек. Поддерживаются потоки
+// без сжатия и с FlateDecode, потоки объектов (PDF 1.5+) и шрифты с
+// ToUnicode — так кодируют текст Word, LibreOffice и браузеры, в том числе
+// кириллицу. Сканы без текстового слоя и зашифрованные файлы не читаются.
+
+var (
+	errPDFEncrypted = errors.New("pdf is encrypted")
+
+	pdfObjRe      = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	pdfRefRe      = regexp.MustCompile(`(\d+)\s+\d+\s+R\b`)
+	pdfFontDictRe = regexp.MustCompile(`/Font\s*<<((?:[^<>]|<<[^<>]*>>)*)>>`)
+	pdfFontRefRe  = regexp.MustCompile(`/Font\s+(\d+)\s+\d+\s+R`)
+	pdfNameRefRe  = regexp.MustCompile(`/([^\s/<>\[\]()]+)\s+(\d+)\s+\d+\s+R`)
+	pdfToUnicRe   = regexp.MustCompile(`/ToUnicode\s+(\d+)\s+\d+\s+R`)
+	pdfContentsRe = regexp.MustCompile(`/Contents\s*(\[[^\]]*\]|\d+\s+\d+\s+R)`)
+	pdfKidsRe     = regexp.MustCompile(`/Kids\s*\[([^\]]*)\]`)
+	pdfPagesRe    = regexp.MustCompile(`/Pages\s+(\d+)\s+\d+\s+R`)
+	pdfLengthRe   = regexp.MustCompile(`/Length\s+(\d+)(\s+\d+\s+R)?`)
+	pdfFirstRe    = regexp.MustCompile(`/First\s+(\d+)`)
+	pdfCatalogRe  = regexp.MustCompile(`/Type\s*/Catalog\b`)
+	pdfObjStmRe   = regexp.MustCompile(`/Type\s*/ObjStm\b`)
+	pdfPageRe     = regexp.MustCompile(`/Type\s*/Page\b`)
+	// Потоки, в которых заведомо нет операторов текста.
+	pdfBinaryRe = regexp.MustCompile(`/Length[123]\b|/Subtype\s*/(Image|XML|Type1C|CIDFontType0C|OpenType)\b|/Type\s*/(XRef|ObjStm|Metadata)\b`)
+)
+
+type pdfObject struct {
+	dict   string // словарь или значение объекта без потока
+	stream []byte // поток до распаковки, nil — объект без потока
+}
+
+type pdfDoc struct {
+	objects map[int]pdfObject
+	fonts   map[string]*pdfCMap // имя ресурса шрифта → ToUnicode
+}
+
+func pdfText(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("%PDF-")) {
+		return "", ErrUnsupported
+	}
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return "", errPDFEncrypted
+	}
+
+	doc := &pdfDoc{objects: parsePDFObjects(data)}
+	doc.expandObjectStreams()
+	doc.loadFonts()
+
+	var sb strings.Builder
+	streams := doc.pageContents()
+	if len(streams) == 0 {
+		// Дерево страниц не разобралось — читаем все текстовые потоки
+		// в порядке номеров объектов.
+		for _, num := range doc.sortedNums() {
+			if obj := doc.objects[num]; obj.stream != nil && !pdfBinaryRe.MatchString(obj.dict) {
+				streams = append(streams, num)
+			}
+		}
+	}
+	for _, num := range streams {
+		content, err := doc.decode(num)
+		if err != nil {
+			continue
+		}
+		doc.contentText(&sb, content)
+		sb.WriteByte('\n')
+	}
+	return sb.String(), nil
+}
+
+// parsePDFObjects находит объекты "N G obj ... endobj". Таблица xref не
+// читается: после инкрементальных правок последняя версия объекта в файле
+// перекрывает предыдущие.
+func parsePDFObjects(data []byte) map[int]pdfObject {
+	objects := make(map[int]pdfObject)
+	for _, loc := range pdfObjRe.FindAllSubmatchIndex(data, -1) {
+		num, err := strconv.Atoi(string(data[loc[2]:loc[3]]))
+		if err != nil {
+			continue
+		}
+		body := data[loc[1]:]
+		end := bytes.Index(body, []byte("endobj"))
+		streamAt := bytes.Index(body, []byte("stream"))
+		if streamAt < 0 || (end >= 0 && streamAt > end) {
+			if end < 0 {
+				continue
+			}
+			objects[num] = pdfObject{dict: string(body[:end])}
+			continue
+		}
+
+		dict := string(body[:streamAt])
+		start := streamAt + len("stream")
+		if start < len(body) && body[start] == '\r' {
+			start++
+		}
+		if start < len(body) && body[start] == '\n' {
+			start++
+		}
+		// Прямая длина надёжнее поиска endstream: он может встретиться в
+		// сжатых данных.
+		var stream []byte
+		if m := pdfLengthRe.FindStringSubmatch(dict); m != nil && m[2] == "" {
+			if n, err := strconv.Atoi(m[1]); err == nil && start+n <= len(body) {
+				stream = body[start : start+n]
+			}
+		}
+		if stream == nil {
+			stop := bytes.Index(body[start:], []byte("endstream"))
+			if stop < 0 {
+				continue
+			}
+			stream = bytes.TrimRight(body[start:start+stop], "\r\n")
+		}
+		objects[num] = pdfObject{dict: dict, stream: stream}
+	}
+	return objects
+}
+
+func (d *pdfDoc) sortedNums() []int {
+	nums := make([]int, 0, len(d.objects))
+	for num := range d.objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	return nums
+}
+
+// decode распаковывает поток объекта. Поддерживается только FlateDecode.
+func (d *pdfDoc) decode(num int) ([]byte, error) {
+	obj, ok := d.objects[num]
+	if !ok || obj.stream == nil {
+		return nil, ErrUnsupported
+	}
+	if !strings.Contains(obj.dict, "/Filter") {
+		return obj.stream, nil
+	}
+	if !strings.Contains(obj.dict, "/FlateDecode") || strings.Count(obj.dict, "Decode") > 1 {
+		return nil, ErrUnsupported
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(obj.stream))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, maxArchiveEntryBytes))
+	// Обрезанный поток всё равно может содержать текст.
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+// expandObjectStreams добавляет объекты из потоков объектов (/Type /ObjStm).
+func (d *pdfDoc) expandObjectStreams() {
+	for _, num := range d.sortedNums() {
+		obj := d.objects[num]
+		if obj.stream == nil || !pdfObjStmRe.MatchString(obj.dict) {
+			continue
+		}
+		m := pdfFirstRe.FindStringSubmatch(obj.dict)
+		if m == nil {
+			continue
+		}
+		first, _ := strconv.Atoi(m[1])
+		data, err := d.decode(num)
+		if err != nil || first > len(data) {
+			continue
+		}
+
+		header := strings.Fields(string(data[:first]))
+		for i := 0; i+1 < len(header); i += 2 {
+			objNum, err1 := strconv.Atoi(header[i])
+			offset, err2 := strconv.Atoi(header[i+1])
+			if err1 != nil || err2 != nil || first+offset > len(data) {
+				continue
+			}
+			end := len(data)
+			if i+3 < len(header) {
+				if next, err := strconv.Atoi(header[i+3]); err == nil && first+next <= len(data) && next >= offset {
+					end = first + next
+				}
+			}
+			if _, exists := d.objects[objNum]; !exists {
+				d.objects[objNum] = pdfObject{dict: string(data[first+offset : end])}
+			}
+		}
+	}
+}
+
+// loadFonts связывает имена шрифтов из ресурсов страниц с их ToUnicode.
+// Имена ресурсов на разных страницах могут совпадать у разных шрифтов; для
+// текста, который уходит в LLM, такой точности достаточно.
+func (d *pdfDoc) loadFonts() {
+	d.fonts = make(map[string]*pdfCMap)
+	cmaps := make(map[int]*pdfCMap)
+
+	addFonts := func(dict string) {
+		for _, m := range pdfNameRefRe.FindAllStringSubmatch(dict, -1) {
+			fontNum, _ := strconv.Atoi(m[2])
+			font, ok := d.objects[fontNum]
+			if !ok {
+				continue
+			}
+			tu := pdfToUnicRe.FindStringSubmatch(font.dict)
+			if tu == nil {
+				continue
+			}
+			cmapNum, _ := strconv.Atoi(tu[1])
+			cmap, ok := cmaps[cmapNum]
+			if !ok {
+				if data, err := d.decode(cmapNum); err == nil {
+					cmap = parseCMap(data)
+				}
+				cmaps[cmapNum] = cmap
+			}
+			if cmap != nil {
+				d.fonts[m[1]] = cmap
+			}
+		}
+	}
+
+	for _, num := range d.sortedNums() {
+		dict := d.objects[num].dict
+		for _, m := range pdfFontDictRe.FindAllStringSubmatch(dict, -1) {
+			addFonts(m[1])
+		}
+		for _, m := range pdfFontRefRe.FindAllStringSubmatch(dict, -1) {
+			ref, _ := strconv.Atoi(m[1])
+			addFonts(d.objects[ref].dict)
+		}
+	}
+}
+
+// pageContents возвращает потоки содержимого страниц по порядку страниц.
+func (d *pdfDoc) pageContents() []int {
+	var root int
+	for _, num := range d.sortedNums() {
+		if pdfCatalogRe.MatchString(d.objects[num].dict) {
+			if m := pdfPagesRe.FindStringSubmatch(d.objects[num].dict); m != nil {
+				root, _ = strconv.Atoi(m[1])
+			}
+		}
+	}
+	if root == 0 {
+		return nil
+	}
+
+	var contents []int
+	seen := make(map[int]bool)
+	var walk func(num int)
+	walk = func(num int) {
+		if seen[num] {
+			return
+		}
+		seen[num] = true
+		dict := d.objects[num].dict
+		if m := pdfKidsRe.FindStringSubmatch(dict); m != nil {
+			for _, kid := range pdfRefRe.FindAllStringSubmatch(m[1], -1) {
+				n, _ := strconv.Atoi(kid[1])
+				walk(n)
+			}
+			return
+		}
+		if !pdfPageRe.MatchString(dict) {
+			return
+		}
+		m := pdfContentsRe.FindStringSubmatch(dict)
+		if m == nil {
+			return
+		}
+		for _, ref := range pdfRefRe.FindAllStringSubmatch(m[1], -1) {
+			n, _ := strconv.Atoi(ref[1])
+			// /Contents может ссылаться на массив потоков.
+			if obj := d.objects[n]; obj.stream == nil {
+				for _, inner := range pdfRefRe.FindAllStringSubmatch(obj.dict, -1) {
+					in, _ := strconv.Atoi(inner[1])
+					contents = append(contents, in)
+				}
+				continue
+			}
+			contents = append(contents, n)
+		}
+	}
+	walk(root)
+	return contents
+}
+
+// contentText выполняет операторы текста потока содержимого: Tf выбирает
+// шрифт, Tj, TJ, ' и " выводят строки, перемещения по вертикали и ET
+// переводят строку.
+func (d *pdfDoc) contentText(sb *strings.Builder, content []byte) {
+	lex := pdfLexer{data: content}
+	var (
+		operands []pdfToken
+		font     *pdfCMap
+	)
+	newline := func() {
+		if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteByte('\n')
+		}
+	}
+
+	for {
+		tok, ok := lex.next()
+		if !ok {
+			return
+		}
+		if tok.kind != pdfTokOperator {
+			operands = append(operands, tok)
+			continue
+		}
+
+		switch tok.text {
+		case "Tf":
+			if len(operands) >= 2 && operands[len(operands)-2].kind == pdfTokName {
+				font = d.fonts[operands[len(operands)-2].text]
+			}
+		case "Tj":
+			if len(operands) > 0 {
+				sb.WriteString(decodePDFString(operands[len(operands)-1].str, font))
+			}
+		case "'", "\"":
+			newline()
+			if len(operands) > 0 {
+				sb.WriteString(decodePDFString(operands[len(operands)-1].str, font))
+			}
+		case "TJ":
+			for _, item := range operands {
+				switch item.kind {
+				case pdfTokString:
+					sb.WriteString(decodePDFString(item.str, font))
+				case pdfTokNumber:
+					// Большой отрицательный сдвиг в TJ — пробел между словами.
+					if n, err := strconv.ParseFloat(item.text, 64); err == nil && n < -200 {
+						sb.WriteByte(' ')
+					}
+				}
+			}
+		case "T*", "ET":
+			newline()
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				if ty, err := strconv.ParseFloat(operands[len(operands)-1].text, 64); err == nil && ty != 0 {
+					newline()
+				} else if sb.Len() > 0 && !strings.HasSuffix(sb.String(), " ") {
+					sb.WriteByte(' ')
+				}
+			}
+		case "ID":
+			lex.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+}
+
+// decodePDFString переводит строку в текст через ToUnicode шрифта, UTF-16BE
+// с BOM или побайтно как Latin-1 (близко к WinAnsiEncoding).
+func decodePDFString(s []byte, cmap *pdfCMap) string {
+	if cmap != nil {
+		return cmap.decode(s)
+	}
+	if len(s) >= 2 && s[0] == 0xFE && s[1] == 0xFF {
+		return utf16BE(s[2:])
+	}
+	runes := make([]rune, 0, len(s))
+	for _, b := range s {
+		runes = append(runes, rune(b))
+	}
+	return string(runes)
+}
+
+func utf16BE(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// pdfCMap — таблица ToUnicode шрифта: код символа → текст.
+type pdfCMap struct {
+	codes    map[string]string
+	codeLens []int // длины кодов в байтах, от длинных к коротким
+}
+
+var (
+	cmapBfCharRe  = regexp.MustCompile(`(?s)beginbfchar(.*?)endbfchar`)
+	cmapBfRangeRe = regexp.MustCompile(`(?s)beginbfrange(.*?)endbfrange`)
+	cmapHexRe     = regexp.MustCompile(`<([0-9A-Fa-f\s]*)>|\[|\]`)
+)
+
+func parseCMap(data []byte) *pdfCMap {
+	c := &pdfCMap{codes: make(map[string]string)}
+	lens := make(map[int]bool)
+	add := func(code []byte, text string) {
+		c.codes[string(code)] = text
+		lens[len(code)] = true
+	}
+
+	for _, block := range cmapBfCharRe.FindAllSubmatch(data, -1) {
+		items := cmapHexItems(block[1])
+		for i := 0; i+1 < len(items); i += 2 {
+			if items[i].hex != nil && items[i+1].hex != nil {
+				add(items[i].hex, utf16BE(items[i+1].hex))
+			}
+		}
+	}
+
+	for _, block := range cmapBfRangeRe.FindAllSubmatch(data, -1) {
+		items := cmapHexItems(block[1])
+		for i := 0; i+2 < len(items); {
+			lo, hi := items[i].hex, items[i+1].hex
+			if lo == nil || hi == nil || len(lo) != len(hi) || len(lo) > 4 {
+				i++
+				continue
+			}
+			from, to := beUint(lo), beUint(hi)
+			if to < from || to-from > 0xFFFF {
+				i++
+				continue
+			}
+
+			if items[i+2].open {
+				// <lo> <hi> [<d1> <d2> ...] — свой текст для каждого кода.
+				j := i + 3
+				for code := from; j < len(items) && !items[j].close; code++ {
+					if items[j].hex != nil && code <= to {
+						add(beBytes(code, len(lo)), utf16BE(items[j].hex))
+					}
+					j++
+				}
+				i = j + 1
+				continue
+			}
+
+			// <lo> <hi> <dst> — последний символ dst растёт вместе с кодом.
+			dst := items[i+2].hex
+			if dst == nil || len(dst) < 2 {
+				i += 3
+				continue
+			}
+			units := make([]uint16, 0, len(dst)/2)
+			for k := 0; k+1 < len(dst); k += 2 {
+				units = append(units, uint16(dst[k])<<8|uint16(dst[k+1]))
+			}
+			for code := from; code <= to; code++ {
+				shifted := append([]uint16(nil), units...)
+				shifted[len(shifted)-1] += uint16(code - from)
+				add(beBytes(code, len(lo)), string(utf16.Decode(shifted)))
+			}
+			i += 3
+		}
+	}
+
+	if len(c.codes) == 0 {
+		return nil
+	}
+	for n := range lens {
+		c.codeLens = append(c.codeLens, n)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(c.codeLens)))
+	return c
+}
+
+func (c *pdfCMap) decode(s []byte) string {
+	var sb strings.Builder
+	for len(s) > 0 {
+		matched := false
+		for _, n := range c.codeLens {
+			if n <= len(s) {
+				if text, ok := c.codes[string(s[:n])]; ok {
+					sb.WriteString(text)
+					s = s[n:]
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			// Код без отображения пропускается шириной самого короткого кода.
+			s = s[min(c.codeLens[len(c.codeLens)-1], len(s)):]
+		}
+	}
+	return sb.String()
+}
+
+type cmapItem struct {
+	hex         []byte
+	open, close bool
+}
+
+func cmapHexItems(block []byte) []cmapItem {
+	var items []cmapItem
+	for _, m := range cmapHexRe.FindAllSubmatch(block, -1) {
+		switch string(m[0]) {
+		case "[":
+			items = append(items, cmapItem{open: true})
+		case "]":
+			items = append(items, cmapItem{close: true})
+		default:
+			b, err := hex.DecodeString(string(bytes.Join(bytes.Fields(m[1]), nil)))
+			if err != nil {
+				b = []byte{}
+			}
+			items = append(items, cmapItem{hex: b})
+		}
+	}
+	return items
+}
+
+func beUint(b []byte) uint32 {
+	var v uint32
+	for _, x := range b {
+		v = v<<8 | uint32(x)
+	}
+	return v
+}
+
+func beBytes(v uint32, n int) []byte {
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	return b
+}
+
+const (
+	pdfTokOperator = iota
+	pdfTokNumber
+	pdfTokName
+	pdfTokString
+	pdfTokOther
+)
+
+type pdfToken struct {
+	kind int
+	text string
+	str  []byte // для строк
+}
+
+// pdfLexer разбирает поток содержимого на операнды и операторы. Массивы
+// разворачиваются в последовательность операндов, словари пропускаются.
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+func isPDFDelim(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func (l *pdfLexer) next() (pdfToken, bool) {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case isPDFSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		case c == '(':
+			return pdfToken{kind: pdfTokString, str: l.literal()}, true
+		case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+			l.pos += 2
+			return pdfToken{kind: pdfTokOther, text: "<<"}, true
+		case c == '>' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '>':
+			l.pos += 2
+			return pdfToken{kind: pdfTokOther, text: ">>"}, true
+		case c == '<':
+			end := bytes.IndexByte(l.data[l.pos:], '>')
+			if end < 0 {
+				l.pos = len(l.data)
+				return pdfToken{}, false
+			}
+			raw := bytes.Join(bytes.Fields(l.data[l.pos+1:l.pos+end]), nil)
+			l.pos += end + 1
+			if len(raw)%2 == 1 {
+				raw = append(raw, '0')
+			}
+			b, _ := hex.DecodeString(string(raw))
+			return pdfToken{kind: pdfTokString, str: b}, true
+		case c == '[' || c == ']' || c == '{' || c == '}':
+			l.pos++
+		case c == '/':
+			start := l.pos + 1
+			l.pos++
+			for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelim(l.data[l.pos]) {
+				l.pos++
+			}
+			return pdfToken{kind: pdfTokName, text: string(l.data[start:l.pos])}, true
+		default:
+			start := l.pos
+			for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelim(l.data[l.pos]) {
+				l.pos++
+			}
+			if l.pos == start {
+				// Одиночный разделитель вроде ')' или '>' — пропускаем.
+				l.pos++
+				continue
+			}
+			word := string(l.data[start:l.pos])
+			if _, err := strconv.ParseFloat(word, 64); err == nil {
+				return pdfToken{kind: pdfTokNumber, text: word}, true
+			}
+			return pdfToken{kind: pdfTokOperator, text: word}, true
+		}
+	}
+	return pdfToken{}, false
+}
+
+// literal читает строку в круглых скобках с экранированием и вложенными
+// скобками.
+func (l *pdfLexer) literal() []byte {
+	var out []byte
+	depth := 0
+	l.pos++ // '('
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+			out = append(out, c)
+		case ')':
+			if depth == 0 {
+				return out
+			}
+			depth--
+			out = append(out, c)
+		case '\\':
+			if l.pos >= len(l.data) {
+				return out
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r':
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for k := 0; k < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; k++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					out = append(out, byte(v))
+				} else {
+					out = append(out, e)
+				}
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// skipInlineImage пропускает данные встроенной картинки до оператора EI.
+func (l *pdfLexer) skipInlineImage() {
+	for l.pos+2 < len(l.data) {
+		if isPDFSpace(l.data[l.pos]) && l.data[l.pos+1] == 'E' && l.data[l.pos+2] == 'I' &&
+			(l.pos+3 == len(l.data) || isPDFSpace(l.data[l.pos+3])) {
+			l.pos += 3
+			return
+		}
+		l.pos++
+	}
+	l.pos = len(l.data)
+}
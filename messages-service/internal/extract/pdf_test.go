@@ -0,0 +1,202 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Эталоны в testdata/*.txt обновляются запуском go test -update.
+var update = flag.Bool("update", false, "перезаписать эталонный текст в testdata")
+
+// PDF в testdata собраны вручную и покрывают разные способы кодирования
+// текста: simple.pdf — два несжатых потока страниц со стандартным шрифтом,
+// tounicode.pdf — FlateDecode и двухбайтовые коды с ToUnicode (кириллица),
+// objstm.pdf — словари страниц внутри потока объектов PDF 1.5, nopages.pdf —
+// файл без дерева страниц со строкой UTF-16BE и потоком картинки.
+func TestPDFTextGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.pdf"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("find testdata: %v", err)
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Text(filepath.Base(file), "application/pdf", data, 0)
+			if err != nil {
+				t.Fatalf("Text: %v", err)
+			}
+
+			golden := strings.TrimSuffix(file, ".pdf") + ".txt"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got+"\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != strings.TrimSuffix(string(want), "\n") {
+				t.Errorf("text mismatch\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestPDFTextMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{name: "empty", data: "", err: ErrUnsupported},
+		{name: "not a pdf", data: "GIF89a", err: ErrUnsupported},
+		{name: "encrypted", data: "%PDF-1.4\n1 0 obj\n<< /Encrypt 2 0 R >>\nendobj\n", err: errPDFEncrypted},
+		{name: "header only", data: "%PDF-1.7\n"},
+		{name: "garbage", data: "%PDF-1.4\n" + strings.Repeat("obj stream endobj ( < [ /", 100)},
+		{name: "object without endobj", data: "%PDF-1.4\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\n"},
+		{name: "stream without endstream", data: "%PDF-1.4\n1 0 obj\n<< >>\nstream\nBT (lost) Tj ET"},
+		{name: "length beyond end", data: "%PDF-1.4\n1 0 obj\n<< /Length 9999 >>\nstream\nBT (x) Tj ET\nendstream\nendobj\n"},
+		{name: "broken flate", data: "%PDF-1.4\n1 0 obj\n<< /Filter /FlateDecode >>\nstream\nnot zlib\nendstream\nendobj\n"},
+		{name: "unsupported filter", data: "%PDF-1.4\n1 0 obj\n<< /Filter /DCTDecode >>\nstream\nBT (jpeg) Tj ET\nendstream\nendobj\n"},
+		{name: "cyclic page tree", data: "%PDF-1.4\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n2 0 obj\n<< /Type /Pages /Kids [2 0 R] >>\nendobj\n"},
+		{name: "unterminated hex string", data: "%PDF-1.4\n1 0 obj\n<< >>\nstream\nBT <0041 Tj ET\nendstream\nendobj\n"},
+		{name: "unterminated literal", data: "%PDF-1.4\n1 0 obj\n<< >>\nstream\nBT (abc\\\nendstream\nendobj\n"},
+		{name: "inline image without EI", data: "%PDF-1.4\n1 0 obj\n<< >>\nstream\nBI /W 1 ID \x00\x01\x02\nendstream\nendobj\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := pdfText([]byte(tt.data))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("pdfText error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// Обрезанный при пересылке файл не должен ронять разбор: текст уцелевших
+// объектов извлекается, остальное пропускается.
+func TestPDFTextTruncated(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < len(data); n++ {
+			if _, err := pdfText(data[:n]); err != nil && !errors.Is(err, ErrUnsupported) {
+				t.Fatalf("%s truncated to %d bytes: %v", file, n, err)
+			}
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join("testdata", "simple.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Файл обрывается перед потоком второй страницы.
+	cut := bytes.Index(data, []byte("6 0 obj"))
+	got, err := pdfText(data[:cut])
+	if err != nil {
+		t.Fatalf("pdfText: %v", err)
+	}
+	if !strings.Contains(got, "Invoice No. 42") || strings.Contains(got, "Payment") {
+		t.Errorf("truncated pdf text = %q, want only the first page", got)
+	}
+}
+
+// Сжатый поток распаковывается не больше чем на maxArchiveEntryBytes, а
+// результат Text обрезается до maxChars.
+func TestPDFTextOversized(t *testing.T) {
+	var content bytes.Buffer
+	content.WriteString("BT (head) Tj ET\n")
+	for content.Len() <= maxArchiveEntryBytes {
+		content.WriteString("BT (filler text) Tj ET\n")
+	}
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(content.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var pdf bytes.Buffer
+	fmt.Fprintf(&pdf, "%%PDF-1.4\n1 0 obj\n<< /Filter /FlateDecode /Length %d >>\nstream\n", compressed.Len())
+	pdf.Write(compressed.Bytes())
+	pdf.WriteString("\nendstream\nendobj\n")
+
+	doc := &pdfDoc{objects: parsePDFObjects(pdf.Bytes())}
+	decoded, err := doc.decode(1)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(decoded) != maxArchiveEntryBytes {
+		t.Errorf("decoded %d bytes, want limit %d", len(decoded), maxArchiveEntryBytes)
+	}
+
+	got, err := Text("big.pdf", "", pdf.Bytes(), 100)
+	if err != nil {
+		t.Fatalf("Text: %v", err)
+	}
+	if n := len([]rune(got)); n != 100 || !strings.HasPrefix(got, "head\n") {
+		t.Errorf("Text returned %d runes starting with %q", n, got[:min(len(got), 10)])
+	}
+}
+
+func TestParseCMap(t *testing.T) {
+	cmap := parseCMap([]byte(`
+2 beginbfchar
+<01> <0041>
+<0102> <D83DDE00>
+endbfchar
+1 beginbfrange
+<0010> <0012> <0430>
+endbfrange
+1 beginbfrange
+<0020> <0021> [<0078> <00790079>]
+endbfrange
+`))
+	if cmap == nil {
+		t.Fatal("parseCMap returned nil")
+	}
+
+	tests := []struct {
+		in   []byte
+		want string
+	}{
+		{in: []byte{0x01}, want: "A"},
+		{in: []byte{0x01, 0x02}, want: "😀"},
+		{in: []byte{0x00, 0x10, 0x00, 0x12}, want: "ав"},
+		{in: []byte{0x00, 0x20, 0x00, 0x21}, want: "xyy"},
+		{in: []byte{0xFF}, want: ""},
+	}
+	for _, tt := range tests {
+		if got := cmap.decode(tt.in); got != tt.want {
+			t.Errorf("decode(% x) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	if parseCMap([]byte("no mappings")) != nil {
+		t.Error("cmap without mappings must be nil")
+	}
+}
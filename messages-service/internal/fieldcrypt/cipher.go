@@ -0,0 +1,177 @@
+package fieldcrypt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	// version — первый байт зашифрованного значения, чтобы формат можно было
+	// поменять, не теряя старые данные.
+	version byte = 1
+
+	maxKeyIDLen = 255
+
+	// dekUses — сколько значений шифруется одним ключом данных: со случайным
+	// 96-битным nonce GCM безопасен до 2^32 сообщений на ключ, берём с запасом.
+	dekUses = 1 << 20
+
+	// unwrapCacheSize ограничивает кэш развёрнутых ключей данных.
+	unwrapCacheSize = 1024
+)
+
+var ErrMalformed = errors.New("malformed encrypted value")
+
+// Cipher реализует envelope-шифрование значений: значение шифруется
+// AES-256-GCM ключом данных, а ключ данных, обёрнутый мастер-ключом KMS,
+// хранится рядом с шифртекстом:
+//
+//	version | len(keyID) | keyID | len(wrapped) (2 байта) | wrapped | nonce | ciphertext
+//
+// Один ключ данных используется для многих значений, чтобы не обращаться к
+// KMS на каждую запись; развёрнутые ключи кэшируются по обёрнутому значению.
+type Cipher struct {
+	kms KMS
+
+	mu      sync.Mutex
+	dek     []byte
+	wrapped []byte
+	keyID   string
+	uses    int
+
+	unwrapped map[string][]byte
+}
+
+func NewCipher(kms KMS) *Cipher {
+	return &Cipher{kms: kms, unwrapped: make(map[string][]byte)}
+}
+
+// KeyID — мастер-ключ, которым шифруются новые значения.
+func (c *Cipher) KeyID() string {
+	return c.kms.CurrentKeyID()
+}
+
+// Encrypt шифрует plaintext. aad привязывает шифртекст к месту хранения
+// (например, id строки и колонке), чтобы его нельзя было подставить в другую строку.
+func (c *Cipher) Encrypt(ctx context.Context, plaintext, aad []byte) ([]byte, error) {
+	dek, wrapped, keyID, err := c.dataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, 4+len(keyID)+len(wrapped)+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	out = append(out, version, byte(len(keyID)))
+	out = append(out, keyID...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(wrapped)))
+	out = append(out, wrapped...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, aad), nil
+}
+
+func (c *Cipher) Decrypt(ctx context.Context, value, aad []byte) ([]byte, error) {
+	keyID, wrapped, rest, err := parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	dek, err := c.unwrap(ctx, keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, sealed := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt value: %w", err)
+	}
+	return plaintext, nil
+}
+
+// dataKey возвращает текущий ключ данных, создавая новый при смене
+// мастер-ключа или исчерпании лимита использований.
+func (c *Cipher) dataKey(ctx context.Context) (dek, wrapped []byte, keyID string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current := c.kms.CurrentKeyID()
+	if c.dek == nil || c.keyID != current || c.uses >= dekUses {
+		dek := make([]byte, 32)
+		if _, err := rand.Read(dek); err != nil {
+			return nil, nil, "", err
+		}
+		wrapped, err := c.kms.WrapKey(ctx, current, dek)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("wrap data key: %w", err)
+		}
+		if len(wrapped) > 0xFFFF {
+			return nil, nil, "", errors.New("wrapped data key is too long")
+		}
+		c.dek, c.wrapped, c.keyID, c.uses = dek, wrapped, current, 0
+	}
+	c.uses++
+	return c.dek, c.wrapped, c.keyID, nil
+}
+
+func (c *Cipher) unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	cacheKey := keyID + "\x00" + string(wrapped)
+
+	c.mu.Lock()
+	dek, ok := c.unwrapped[cacheKey]
+	c.mu.Unlock()
+	if ok {
+		return dek, nil
+	}
+
+	dek, err := c.kms.UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+
+	c.mu.Lock()
+	if len(c.unwrapped) >= unwrapCacheSize {
+		clear(c.unwrapped)
+	}
+	c.unwrapped[cacheKey] = dek
+	c.mu.Unlock()
+	return dek, nil
+}
+
+func parse(value []byte) (keyID string, wrapped, rest []byte, err error) {
+	if len(value) < 2 || value[0] != version {
+		return "", nil, nil, ErrMalformed
+	}
+	n := int(value[1])
+	value = value[2:]
+	if len(value) < n+2 {
+		return "", nil, nil, ErrMalformed
+	}
+	keyID, value = string(value[:n]), value[n:]
+
+	w := int(binary.BigEndian.Uint16(value))
+	value = value[2:]
+	if len(value) < w {
+		return "", nil, nil, ErrMalformed
+	}
+	return keyID, value[:w], value[w:], nil
+}
@@ -0,0 +1,164 @@
+package fieldcrypt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+// countingKMS считает обращения к KMS, чтобы проверить переиспользование
+// и кэш ключей данных.
+type countingKMS struct {
+	*LocalKMS
+	wraps, unwraps int
+}
+
+func (k *countingKMS) WrapKey(ctx context.Context, keyID string, dek []byte) ([]byte, error) {
+	k.wraps++
+	return k.LocalKMS.WrapKey(ctx, keyID, dek)
+}
+
+func (k *countingKMS) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	k.unwraps++
+	return k.LocalKMS.UnwrapKey(ctx, keyID, wrapped)
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	c := NewCipher(loadTestKMS(t, "2026-10"))
+	aad := []byte("input:mail-1")
+
+	for _, plaintext := range [][]byte{[]byte("Добрый день, счёт во вложении"), {}, bytes.Repeat([]byte("x"), 1<<16)} {
+		sealed, err := c.Encrypt(ctx, plaintext, aad)
+		if err != nil {
+			t.Fatalf("Encrypt: %v", err)
+		}
+		if len(plaintext) > 0 && bytes.Contains(sealed, plaintext) {
+			t.Error("sealed value contains the plaintext")
+		}
+		keyID, _, _, err := parse(sealed)
+		if err != nil || keyID != "2026-10" {
+			t.Errorf("parse = %q, %v", keyID, err)
+		}
+
+		got, err := c.Decrypt(ctx, sealed, aad)
+		if err != nil {
+			t.Fatalf("Decrypt: %v", err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("Decrypt = %q, want %q", got, plaintext)
+		}
+	}
+
+	// Другой экземпляр с тем же файлом ключей расшифровывает значение, как
+	// другая реплика сервиса.
+	sealed, err := c.Encrypt(ctx, []byte("text"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := NewCipher(loadTestKMS(t, "2026-04")).Decrypt(ctx, sealed, aad); err != nil || string(got) != "text" {
+		t.Errorf("Decrypt on another cipher = %q, %v", got, err)
+	}
+}
+
+func TestCipherDecryptErrors(t *testing.T) {
+	ctx := context.Background()
+	c := NewCipher(loadTestKMS(t, "2026-10"))
+	aad := []byte("input:mail-1")
+
+	sealed, err := c.Encrypt(ctx, []byte("Добрый день"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("aad mismatch", func(t *testing.T) {
+		for _, other := range [][]byte{[]byte("input:mail-2"), []byte("model_answer:mail-1"), nil} {
+			if _, err := c.Decrypt(ctx, sealed, other); err == nil {
+				t.Errorf("Decrypt with aad %q: want error", other)
+			}
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		for n := 0; n < len(sealed); n++ {
+			if _, err := c.Decrypt(ctx, sealed[:n], aad); err == nil {
+				t.Fatalf("Decrypt of %d of %d bytes: want error", n, len(sealed))
+			}
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		for i := range sealed {
+			tampered := bytes.Clone(sealed)
+			tampered[i] ^= 0x01
+			if _, err := c.Decrypt(ctx, tampered, aad); err == nil {
+				t.Fatalf("Decrypt with byte %d flipped: want error", i)
+			}
+		}
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		value := bytes.Clone(sealed)
+		value[0] = version + 1
+		if _, err := c.Decrypt(ctx, value, aad); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Decrypt error = %v, want ErrMalformed", err)
+		}
+	})
+
+	t.Run("unknown key id", func(t *testing.T) {
+		kms, err := LoadKeyFile(writeKeyFile(t, `{"current":"2026-04","keys":{"2026-04":"`+testKey(1)+`"}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewCipher(kms).Decrypt(ctx, sealed, aad); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Decrypt error = %v, want ErrUnknownKey", err)
+		}
+	})
+}
+
+// Ключ данных переиспользуется до смены мастер-ключа, а развёрнутые ключи
+// кэшируются, поэтому KMS вызывается один раз на ключ данных.
+func TestCipherDataKeyReuse(t *testing.T) {
+	ctx := context.Background()
+	kms := &countingKMS{LocalKMS: loadTestKMS(t, "2026-04")}
+	c := NewCipher(kms)
+
+	var old [][]byte
+	for i := 0; i < 3; i++ {
+		sealed, err := c.Encrypt(ctx, []byte("value"), []byte("aad"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		old = append(old, sealed)
+	}
+	if kms.wraps != 1 {
+		t.Errorf("wraps = %d, want 1", kms.wraps)
+	}
+	if bytes.Equal(old[0], old[1]) {
+		t.Error("two encryptions of one value are equal: nonce is reused")
+	}
+
+	// Смена current в файле ключей: новые значения шифруются новым ключом,
+	// старые по-прежнему расшифровываются.
+	kms.current = "2026-10"
+	sealed, err := c.Encrypt(ctx, []byte("value"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID, _, _, _ := parse(sealed); keyID != "2026-10" || c.KeyID() != "2026-10" {
+		t.Errorf("key id after rotation = %q, cipher = %q", keyID, c.KeyID())
+	}
+	if kms.wraps != 2 {
+		t.Errorf("wraps after rotation = %d, want 2", kms.wraps)
+	}
+
+	for _, value := range append(old, sealed) {
+		if got, err := c.Decrypt(ctx, value, []byte("aad")); err != nil || string(got) != "value" {
+			t.Fatalf("Decrypt = %q, %v", got, err)
+		}
+	}
+	if kms.unwraps != 2 {
+		t.Errorf("unwraps = %d, want one per data key", kms.unwraps)
+	}
+}
@@ -0,0 +1,114 @@
+package fieldcrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// KMS хранит мастер-ключи и оборачивает ими ключи данных. Мастер-ключи не
+// покидают KMS, поэтому локальный файл ключей можно заменить внешним
+// сервисом, не меняя формат зашифрованных значений.
+type KMS interface {
+	// CurrentKeyID — ключ, которым оборачиваются новые ключи данных.
+	CurrentKeyID() string
+	WrapKey(ctx context.Context, keyID string, dek []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+var ErrUnknownKey = errors.New("unknown master key")
+
+// keyFile — формат файла ключей:
+//
+//	{"current": "2026-10", "keys": {"2026-04": "<base64>", "2026-10": "<base64>"}}
+//
+// Ключи — 32 байта (AES-256) в base64. Старые ключи остаются в файле, пока
+// фоновое перешифрование не переведёт все значения на текущий.
+type keyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// LocalKMS — KMS на мастер-ключах из локального файла.
+type LocalKMS struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+func LoadKeyFile(path string) (*LocalKMS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse key file: %w", err)
+	}
+
+	kms := &LocalKMS{current: file.Current, keys: make(map[string]cipher.AEAD, len(file.Keys))}
+	for id, encoded := range file.Keys {
+		if id == "" || len(id) > maxKeyIDLen {
+			return nil, fmt.Errorf("key id %q: must be 1-%d bytes", id, maxKeyIDLen)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %s: must be 32 bytes, got %d", id, len(key))
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		kms.keys[id] = aead
+	}
+	if _, ok := kms.keys[kms.current]; !ok {
+		return nil, fmt.Errorf("current key %q is not in the key file", kms.current)
+	}
+	return kms, nil
+}
+
+func (k *LocalKMS) CurrentKeyID() string {
+	return k.current
+}
+
+// WrapKey шифрует ключ данных мастер-ключом; id ключа входит в AAD.
+func (k *LocalKMS) WrapKey(_ context.Context, keyID string, dek []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dek, []byte(keyID)), nil
+}
+
+func (k *LocalKMS) UnwrapKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key is too short")
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, []byte(keyID))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,105 @@
+package fieldcrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKey — 32-байтовый ключ в base64, заполненный байтом b.
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+// writeKeyFile записывает файл ключей во временный каталог теста.
+func writeKeyFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// loadTestKMS — KMS с ключами 2026-04 и 2026-10, текущий — current.
+func loadTestKMS(t *testing.T, current string) *LocalKMS {
+	t.Helper()
+
+	kms, err := LoadKeyFile(writeKeyFile(t, `{"current":"`+current+`","keys":{"2026-04":"`+testKey(1)+`","2026-10":"`+testKey(2)+`"}}`))
+	if err != nil {
+		t.Fatalf("LoadKeyFile: %v", err)
+	}
+	return kms
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	kms := loadTestKMS(t, "2026-10")
+	if kms.CurrentKeyID() != "2026-10" || len(kms.keys) != 2 {
+		t.Errorf("current = %q, keys = %d", kms.CurrentKeyID(), len(kms.keys))
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{name: "invalid json", content: `{"current":`, err: "parse key file"},
+		{name: "current missing", content: `{"current":"2026-11","keys":{"2026-10":"` + testKey(2) + `"}}`, err: "not in the key file"},
+		{name: "no keys", content: `{"current":""}`, err: "not in the key file"},
+		{name: "empty key id", content: `{"current":"","keys":{"":"` + testKey(2) + `"}}`, err: "must be 1-255 bytes"},
+		{name: "long key id", content: `{"current":"a","keys":{"` + strings.Repeat("a", 256) + `":"` + testKey(2) + `"}}`, err: "must be 1-255 bytes"},
+		{name: "not base64", content: `{"current":"k","keys":{"k":"not base64!"}}`, err: "key k"},
+		{name: "short key", content: `{"current":"k","keys":{"k":"` + base64.StdEncoding.EncodeToString(make([]byte, 16)) + `"}}`, err: "must be 32 bytes, got 16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadKeyFile(writeKeyFile(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("LoadKeyFile error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	if _, err := LoadKeyFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadKeyFile: want error for a missing file")
+	}
+}
+
+func TestLocalKMSWrap(t *testing.T) {
+	ctx := context.Background()
+	kms := loadTestKMS(t, "2026-10")
+	dek := bytes.Repeat([]byte{9}, 32)
+
+	wrapped, err := kms.WrapKey(ctx, "2026-04", dek)
+	if err != nil {
+		t.Fatalf("WrapKey: %v", err)
+	}
+	if bytes.Contains(wrapped, dek) {
+		t.Error("wrapped key contains the data key")
+	}
+
+	got, err := kms.UnwrapKey(ctx, "2026-04", wrapped)
+	if err != nil || !bytes.Equal(got, dek) {
+		t.Fatalf("UnwrapKey = %x, %v", got, err)
+	}
+
+	// id ключа входит в AAD: обёрнутое одним ключом не разворачивается другим.
+	if _, err := kms.UnwrapKey(ctx, "2026-10", wrapped); err == nil {
+		t.Error("UnwrapKey: want error for another master key")
+	}
+	if _, err := kms.UnwrapKey(ctx, "2026-04", wrapped[:5]); err == nil {
+		t.Error("UnwrapKey: want error for a truncated key")
+	}
+	if _, err := kms.WrapKey(ctx, "2025-01", dek); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("WrapKey error = %v, want ErrUnknownKey", err)
+	}
+	if _, err := kms.UnwrapKey(ctx, "2025-01", wrapped); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("UnwrapKey error = %v, want ErrUnknownKey", err)
+	}
+}
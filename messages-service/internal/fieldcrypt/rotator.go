@@ -0,0 +1,97 @@
+package fieldcrypt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"messages-service/internal/config"
+)
+
+type Store interface {
+	// ReencryptMails шифрует текущим ключом до limit писем, чьи поля хранятся
+	// открыто или зашифрованы другим мастер-ключом. Возвращает число писем.
+	ReencryptMails(ctx context.Context, limit int) (int, error)
+	// ReencryptAttachments делает то же для текста вложений.
+	ReencryptAttachments(ctx context.Context, limit int) (int, error)
+	// ReencryptEvents — данные событий журнала.
+	ReencryptEvents(ctx context.Context, limit int) (int, error)
+	// ReencryptWebhookDeliveries — тела доставок вебхуков.
+	ReencryptWebhookDeliveries(ctx context.Context, limit int) (int, error)
+}
+
+// Rotator в фоне доводит хранилище до текущего мастер-ключа: шифрует
+// данные, записанные до включения шифрования, и перешифровывает значения
+// после смены current в файле ключей.
+type Rotator struct {
+	cfg   config.EncryptionConfig
+	store Store
+	log   *slog.Logger
+}
+
+func NewRotator(cfg config.EncryptionConfig, store Store, log *slog.Logger) *Rotator {
+	return &Rotator{cfg: cfg, store: store, log: log}
+}
+
+// Run проходит по хранилищу каждые RotationInterval до отмены ctx.
+func (r *Rotator) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.RotationInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.rotate(ctx); err != nil && ctx.Err() == nil {
+			r.log.Error("re-encryption failed", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Rotator) rotate(ctx context.Context) error {
+	mails, err := r.drain(ctx, r.store.ReencryptMails)
+	if err != nil {
+		return fmt.Errorf("re-encrypt mails: %w", err)
+	}
+	attachments, err := r.drain(ctx, r.store.ReencryptAttachments)
+	if err != nil {
+		return fmt.Errorf("re-encrypt attachments: %w", err)
+	}
+	events, err := r.drain(ctx, r.store.ReencryptEvents)
+	if err != nil {
+		return fmt.Errorf("re-encrypt events: %w", err)
+	}
+	deliveries, err := r.drain(ctx, r.store.ReencryptWebhookDeliveries)
+	if err != nil {
+		return fmt.Errorf("re-encrypt webhook deliveries: %w", err)
+	}
+
+	if mails > 0 || attachments > 0 || events > 0 || deliveries > 0 {
+		r.log.Info("re-encryption pass done",
+			slog.Int("mails", mails),
+			slog.Int("attachments", attachments),
+			slog.Int("events", events),
+			slog.Int("webhook_deliveries", deliveries),
+		)
+	}
+	return nil
+}
+
+func (r *Rotator) drain(ctx context.Context, batch func(context.Context, int) (int, error)) (int, error) {
+	total := 0
+	for ctx.Err() == nil {
+		n, err := batch(ctx, r.cfg.BatchSize)
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < r.cfg.BatchSize {
+			break
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,103 @@
+package fieldcrypt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"messages-service/internal/config"
+)
+
+// fakeStore отдаёт заданное число строк каждой таблицы пачками по limit.
+type fakeStore struct {
+	left  map[string]int
+	calls map[string]int
+	err   map[string]error
+}
+
+func newFakeStore(left map[string]int) *fakeStore {
+	return &fakeStore{left: left, calls: make(map[string]int), err: make(map[string]error)}
+}
+
+func (s *fakeStore) batch(table string, limit int) (int, error) {
+	s.calls[table]++
+	if err := s.err[table]; err != nil {
+		return 0, err
+	}
+	n := min(s.left[table], limit)
+	s.left[table] -= n
+	return n, nil
+}
+
+func (s *fakeStore) ReencryptMails(_ context.Context, limit int) (int, error) {
+	return s.batch("mails", limit)
+}
+
+func (s *fakeStore) ReencryptAttachments(_ context.Context, limit int) (int, error) {
+	return s.batch("attachments", limit)
+}
+
+func (s *fakeStore) ReencryptEvents(_ context.Context, limit int) (int, error) {
+	return s.batch("events", limit)
+}
+
+func (s *fakeStore) ReencryptWebhookDeliveries(_ context.Context, limit int) (int, error) {
+	return s.batch("webhook_deliveries", limit)
+}
+
+func testRotator(store Store) *Rotator {
+	cfg := config.EncryptionConfig{RotationInterval: time.Hour, BatchSize: 10}
+	return NewRotator(cfg, store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// Проход перешифровывает пачками, пока пачка не окажется неполной.
+func TestRotatorRotate(t *testing.T) {
+	store := newFakeStore(map[string]int{"mails": 25, "attachments": 10, "events": 0, "webhook_deliveries": 3})
+	if err := testRotator(store).rotate(context.Background()); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+
+	for table, calls := range map[string]int{"mails": 3, "attachments": 2, "events": 1, "webhook_deliveries": 1} {
+		if store.calls[table] != calls {
+			t.Errorf("%s: %d batches, want %d", table, store.calls[table], calls)
+		}
+		if store.left[table] != 0 {
+			t.Errorf("%s: %d rows left", table, store.left[table])
+		}
+	}
+}
+
+// Ошибка таблицы прерывает проход, следующие таблицы ждут следующего.
+func TestRotatorRotateError(t *testing.T) {
+	store := newFakeStore(map[string]int{"mails": 5})
+	store.err["attachments"] = errors.New("db is down")
+
+	err := testRotator(store).rotate(context.Background())
+	if !errors.Is(err, store.err["attachments"]) {
+		t.Fatalf("rotate error = %v", err)
+	}
+	if store.left["mails"] != 0 || store.calls["events"] != 0 {
+		t.Errorf("mails left = %d, events batches = %d", store.left["mails"], store.calls["events"])
+	}
+}
+
+func TestRotatorRunStops(t *testing.T) {
+	store := newFakeStore(map[string]int{"mails": 15})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		testRotator(store).Run(ctx)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not stop after cancel")
+	}
+}
@@ -0,0 +1,318 @@
+package imapingest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+
+	"messages-service/internal/config"
+	"messages-service/internal/messages"
+)
+
+// Ingestor — путь приёма писем, общий с HTTP (messages.Service).
+type Ingestor interface {
+	ProcessIncomingMessage(ctx context.Context, dto messages.IncomingMessageDTO) (string, error)
+}
+
+// CheckpointStore хранит UIDVALIDITY и последний обработанный UID по каждому
+// ящику, чтобы после рестарта не ставить письма в очередь повторно.
+type CheckpointStore interface {
+	GetIMAPCheckpoint(ctx context.Context, mailbox string) (uidValidity uint32, lastUID uint32, err error)
+	SaveIMAPCheckpoint(ctx context.Context, mailbox string, uidValidity uint32, lastUID uint32) error
+}
+
+type Poller struct {
+	cfg         config.IMAPConfig
+	ingestor    Ingestor
+	checkpoints CheckpointStore
+	log         *slog.Logger
+}
+
+func NewPoller(cfg config.IMAPConfig, ingestor Ingestor, checkpoints CheckpointStore, log *slog.Logger) *Poller {
+	return &Poller{
+		cfg:         cfg,
+		ingestor:    ingestor,
+		checkpoints: checkpoints,
+		log:         log,
+	}
+}
+
+// Run опрашивает все настроенные ящики с интервалом PollInterval до отмены ctx.
+func (p *Poller) Run(ctx context.Context) {
+	interval := p.cfg.PollInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, mb := range p.cfg.Mailboxes {
+			if err := p.pollMailbox(ctx, mb); err != nil && ctx.Err() == nil {
+				p.log.Error("imap poll failed",
+					slog.Any("error", err),
+					slog.String("mailbox", mb.Name),
+				)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Poller) pollMailbox(ctx context.Context, mb config.IMAPMailboxConfig) error {
+	c, err := dial(mb)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer func() { _ = c.Logout() }()
+
+	if err := c.Login(mb.Username, password(mb)); err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+
+	folder := mb.Folder
+	if folder == "" {
+		folder = "INBOX"
+	}
+
+	status, err := c.Select(folder, false)
+	if err != nil {
+		return fmt.Errorf("select %s: %w", folder, err)
+	}
+
+	storedValidity, lastUID, err := p.checkpoints.GetIMAPCheckpoint(ctx, mb.Name)
+	if err != nil {
+		return fmt.Errorf("load checkpoint: %w", err)
+	}
+	if storedValidity != 0 && storedValidity != status.UidValidity {
+		// Сервер пересоздал ящик: старые UID больше ничего не значат.
+		p.log.Warn("imap uidvalidity changed, resetting checkpoint",
+			slog.String("mailbox", mb.Name),
+			slog.Any("old", storedValidity),
+			slog.Any("new", status.UidValidity),
+		)
+		lastUID = 0
+	}
+
+	uids, err := p.searchNew(c, lastUID)
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+	if len(uids) == 0 {
+		return p.checkpoints.SaveIMAPCheckpoint(ctx, mb.Name, status.UidValidity, lastUID)
+	}
+
+	batchSize := mb.BatchSize
+	if batchSize <= 0 {
+		batchSize = 50
+	}
+	if len(uids) > batchSize {
+		uids = uids[:batchSize]
+	}
+
+	for _, uid := range uids {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		raw, err := fetchRaw(c, uid)
+		if err != nil {
+			return fmt.Errorf("fetch uid %d: %w", uid, err)
+		}
+
+		id, err := p.ingest(ctx, mb, raw)
+		switch {
+		case err == nil:
+			p.log.Info("imap message ingested",
+				slog.String("mailbox", mb.Name),
+				slog.Any("uid", uid),
+				slog.String("id", id),
+			)
+		case errors.Is(err, messages.ErrNotQueued):
+			// Письмо уже сохранено и переотправляется через /reprocess;
+			// повторный приём создал бы дубль с новым id.
+			p.log.Warn("imap message stored but not queued",
+				slog.Any("error", err),
+				slog.String("mailbox", mb.Name),
+				slog.Any("uid", uid),
+				slog.String("id", id),
+			)
+		case errors.Is(err, messages.ErrInvalidMessage):
+			// Битое письмо не должно блокировать ящик: пропускаем его и двигаем checkpoint.
+			p.log.Warn("imap message skipped",
+				slog.Any("error", err),
+				slog.String("mailbox", mb.Name),
+				slog.Any("uid", uid),
+			)
+		default:
+			return fmt.Errorf("ingest uid %d: %w", uid, err)
+		}
+
+		// Checkpoint сохраняем до пометки письма: если упадём между этими шагами,
+		// письмо останется непрочитанным, но повторно в очередь не попадёт.
+		if err := p.checkpoints.SaveIMAPCheckpoint(ctx, mb.Name, status.UidValidity, uid); err != nil {
+			return fmt.Errorf("save checkpoint: %w", err)
+		}
+
+		if err := markDone(c, mb, uid); err != nil {
+			p.log.Warn("failed to mark imap message",
+				slog.Any("error", err),
+				slog.String("mailbox", mb.Name),
+				slog.Any("uid", uid),
+			)
+		}
+	}
+
+	return nil
+}
+
+// ingest разбирает письмо и передаёт его в сервис вместе с общим ящиком
+// из конфига.
+func (p *Poller) ingest(ctx context.Context, mb config.IMAPMailboxConfig, raw []byte) (string, error) {
+	dto, err := messages.RawToDTO(bytes.NewReader(raw))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", messages.ErrInvalidMessage, err)
+	}
+	dto.Mailbox = mb.Mailbox
+	return p.ingestor.ProcessIncomingMessage(ctx, dto)
+}
+
+// searchNew ищет непрочитанные письма с UID больше последнего обработанного.
+func (p *Poller) searchNew(c *client.Client, lastUID uint32) ([]uint32, error) {
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag, imap.DeletedFlag}
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(lastUID+1, 0)
+
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return nil, err
+	}
+
+	// Диапазон "n:*" всегда включает последнее письмо, даже если его UID меньше n.
+	filtered := uids[:0]
+	for _, uid := range uids {
+		if uid > lastUID {
+			filtered = append(filtered, uid)
+		}
+	}
+	sort.Slice(filtered, func(i, j int) bool { return filtered[i] < filtered[j] })
+
+	return filtered, nil
+}
+
+func fetchRaw(c *client.Client, uid uint32) ([]byte, error) {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uid)
+
+	section := &imap.BodySectionName{Peek: true}
+	ch := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seqset, []imap.FetchItem{section.FetchItem()}, ch)
+	}()
+
+	// Канал дочитывается до конца даже после ошибки: иначе UidFetch не
+	// завершится и соединение останется занятым.
+	var (
+		raw     []byte
+		readErr error
+	)
+	for msg := range ch {
+		body := msg.GetBody(section)
+		if body == nil || readErr != nil {
+			continue
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			readErr = err
+			continue
+		}
+		raw = data
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	if raw == nil {
+		return nil, errors.New("message body is empty")
+	}
+
+	return raw, nil
+}
+
+// markDone перемещает письмо в MoveTo, если папка задана, иначе ставит \Seen.
+func markDone(c *client.Client, mb config.IMAPMailboxConfig, uid uint32) error {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uid)
+
+	if mb.MoveTo != "" {
+		return c.UidMove(seqset, mb.MoveTo)
+	}
+
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	return c.UidStore(seqset, item, []any{imap.SeenFlag}, nil)
+}
+
+func dial(mb config.IMAPMailboxConfig) (*client.Client, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	tlsConfig := &tls.Config{ServerName: host(mb.Address), InsecureSkipVerify: mb.InsecureSkipVerify}
+
+	var (
+		c   *client.Client
+		err error
+	)
+	switch mb.Security {
+	case "tls", "":
+		c, err = client.DialWithDialerTLS(dialer, mb.Address, tlsConfig)
+	case "starttls":
+		c, err = client.DialWithDialer(dialer, mb.Address)
+		if err == nil {
+			err = c.StartTLS(tlsConfig)
+		}
+	case "none":
+		c, err = client.DialWithDialer(dialer, mb.Address)
+	default:
+		return nil, fmt.Errorf("unknown imap security %q", mb.Security)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.Timeout = time.Minute
+	return c, nil
+}
+
+func password(mb config.IMAPMailboxConfig) string {
+	if mb.PasswordEnv != "" {
+		return os.Getenv(mb.PasswordEnv)
+	}
+	return mb.Password
+}
+
+func host(addr string) string {
+	h, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return h
+}
@@ -0,0 +1,216 @@
+package imapingest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+
+	"messages-service/internal/config"
+	"messages-service/internal/messages"
+)
+
+// Тесты поднимают IMAP-сервер go-imap с ящиком в памяти. В memory-бэкенде
+// есть пользователь username/password и одно прочитанное письмо в INBOX.
+
+type fakeIngestor struct {
+	mu   sync.Mutex
+	dtos []messages.IncomingMessageDTO
+	err  error
+}
+
+func (f *fakeIngestor) ProcessIncomingMessage(_ context.Context, dto messages.IncomingMessageDTO) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dtos = append(f.dtos, dto)
+	return "id-" + dto.Subject, f.err
+}
+
+type checkpoint struct {
+	validity, uid uint32
+}
+
+type fakeCheckpoints struct {
+	mu     sync.Mutex
+	byName map[string]checkpoint
+}
+
+func (f *fakeCheckpoints) GetIMAPCheckpoint(_ context.Context, mailbox string) (uint32, uint32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cp := f.byName[mailbox]
+	return cp.validity, cp.uid, nil
+}
+
+func (f *fakeCheckpoints) SaveIMAPCheckpoint(_ context.Context, mailbox string, uidValidity, lastUID uint32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.byName[mailbox] = checkpoint{validity: uidValidity, uid: lastUID}
+	return nil
+}
+
+func startServer(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := server.New(memory.New())
+	s.AllowInsecureAuth = true
+	go func() { _ = s.Serve(ln) }()
+	t.Cleanup(func() { _ = s.Close() })
+	return ln.Addr().String()
+}
+
+func appendMessage(t *testing.T, addr, subject string) {
+	t.Helper()
+
+	c, err := client.Dial(addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = c.Logout() }()
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	body := "From: Sender <sender@example.com>\r\n" +
+		"To: support@example.com\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Message-ID: <" + subject + "@example.com>\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"Текст письма " + subject + "\r\n"
+	if err := c.Append("INBOX", nil, time.Now(), strings.NewReader(body)); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+}
+
+func unseenCount(t *testing.T, addr string) int {
+	t.Helper()
+
+	c, err := client.Dial(addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = c.Logout() }()
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if _, err := c.Select("INBOX", true); err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag}
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	return len(uids)
+}
+
+func newTestPoller(addr string, ingestor Ingestor, checkpoints CheckpointStore) (*Poller, config.IMAPMailboxConfig) {
+	mb := config.IMAPMailboxConfig{
+		Name:     "support",
+		Address:  addr,
+		Security: "none",
+		Username: "username",
+		Password: "password",
+		Mailbox:  "support",
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return NewPoller(config.IMAPConfig{Mailboxes: []config.IMAPMailboxConfig{mb}}, ingestor, checkpoints, log), mb
+}
+
+func TestPollMailboxIngestsUnseenMessages(t *testing.T) {
+	addr := startServer(t)
+	appendMessage(t, addr, "first")
+	appendMessage(t, addr, "second")
+
+	ingestor := &fakeIngestor{}
+	checkpoints := &fakeCheckpoints{byName: make(map[string]checkpoint)}
+	p, mb := newTestPoller(addr, ingestor, checkpoints)
+
+	if err := p.pollMailbox(context.Background(), mb); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+
+	if len(ingestor.dtos) != 2 {
+		t.Fatalf("ingested %d messages, want 2", len(ingestor.dtos))
+	}
+	last := ingestor.dtos[1]
+	if last.Subject != "second" || last.Mailbox != "support" || !strings.Contains(last.Input, "Текст письма second") {
+		t.Errorf("unexpected dto: subject=%q mailbox=%q input=%q", last.Subject, last.Mailbox, last.Input)
+	}
+	if cp := checkpoints.byName["support"]; cp.uid == 0 || cp.validity == 0 {
+		t.Errorf("checkpoint was not saved: %+v", cp)
+	}
+	if n := unseenCount(t, addr); n != 0 {
+		t.Errorf("%d messages left unseen", n)
+	}
+
+	// Повторный опрос ничего не находит.
+	if err := p.pollMailbox(context.Background(), mb); err != nil {
+		t.Fatalf("second poll: %v", err)
+	}
+	if len(ingestor.dtos) != 2 {
+		t.Errorf("second poll ingested %d more messages", len(ingestor.dtos)-2)
+	}
+}
+
+func TestPollMailboxSkipsCheckpointedMessages(t *testing.T) {
+	addr := startServer(t)
+	appendMessage(t, addr, "new")
+
+	ingestor := &fakeIngestor{}
+	checkpoints := &fakeCheckpoints{byName: make(map[string]checkpoint)}
+	p, mb := newTestPoller(addr, ingestor, checkpoints)
+
+	if err := p.pollMailbox(context.Background(), mb); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	ingestor.dtos = nil
+	appendMessage(t, addr, "later")
+	if err := p.pollMailbox(context.Background(), mb); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if len(ingestor.dtos) != 1 || ingestor.dtos[0].Subject != "later" {
+		t.Fatalf("ingested %+v, want only the later message", ingestor.dtos)
+	}
+}
+
+func TestPollMailboxAdvancesCheckpointWhenNotQueued(t *testing.T) {
+	addr := startServer(t)
+	appendMessage(t, addr, "stored")
+
+	// Письмо сохранено, но Kafka недоступна.
+	ingestor := &fakeIngestor{err: fmt.Errorf("%w: kafka is down", messages.ErrNotQueued)}
+	checkpoints := &fakeCheckpoints{byName: make(map[string]checkpoint)}
+	p, mb := newTestPoller(addr, ingestor, checkpoints)
+
+	if err := p.pollMailbox(context.Background(), mb); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if cp := checkpoints.byName["support"]; cp.uid == 0 {
+		t.Fatalf("checkpoint was not advanced: %+v", cp)
+	}
+
+	ingestor.err = nil
+	if err := p.pollMailbox(context.Background(), mb); err != nil {
+		t.Fatalf("second poll: %v", err)
+	}
+	if len(ingestor.dtos) != 1 {
+		t.Fatalf("message ingested %d times, want once", len(ingestor.dtos))
+	}
+}
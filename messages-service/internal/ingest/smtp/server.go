@@ -0,0 +1,203 @@
+package smtpingest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+
+	"messages-service/internal/config"
+	"messages-service/internal/messages"
+)
+
+// ingestTimeout ограничивает время сохранения письма внутри SMTP-транзакции.
+const ingestTimeout = 30 * time.Second
+
+// Ingestor — путь приёма писем, общий с HTTP (messages.Service).
+type Ingestor interface {
+	ProcessIncomingMessage(ctx context.Context, dto messages.IncomingMessageDTO) (string, error)
+}
+
+type Server struct {
+	srv *smtp.Server
+	log *slog.Logger
+}
+
+func NewServer(cfg config.SMTPConfig, ingestor Ingestor, log *slog.Logger) (*Server, error) {
+	// Без списка доменов сервер принимал бы почту на любые адреса и стал бы
+	// открытым приёмником для чужой почты.
+	allowed := make(map[string]bool, len(cfg.AllowedDomains))
+	for _, d := range cfg.AllowedDomains {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			allowed[d] = true
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, errors.New("smtp.allowed_domains must not be empty")
+	}
+
+	be := &backend{
+		ingestor: ingestor,
+		allowed:  allowed,
+		log:      log,
+	}
+
+	srv := smtp.NewServer(be)
+	srv.Addr = cfg.Address
+	srv.Domain = cfg.Domain
+	srv.MaxMessageBytes = cfg.MaxMessageBytes
+	srv.MaxRecipients = cfg.MaxRecipients
+	srv.ReadTimeout = cfg.ReadTimeout
+	srv.WriteTimeout = cfg.WriteTimeout
+
+	if cfg.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load smtp tls certificate: %w", err)
+		}
+		// С TLSConfig сервер объявляет STARTTLS в EHLO.
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	return &Server{srv: srv, log: log}, nil
+}
+
+// ListenAndServe блокируется до Shutdown; штатная остановка не считается ошибкой.
+func (s *Server) ListenAndServe() error {
+	s.log.Info("listening smtp",
+		slog.String("address", s.srv.Addr),
+		slog.Bool("starttls", s.srv.TLSConfig != nil),
+	)
+	if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, smtp.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+type backend struct {
+	ingestor Ingestor
+	allowed  map[string]bool
+	log      *slog.Logger
+}
+
+func (b *backend) NewSession(_ *smtp.Conn) (smtp.Session, error) {
+	return &session{backend: b}, nil
+}
+
+type session struct {
+	backend *backend
+	from    string
+	rcpts   []string
+}
+
+func (s *session) Mail(from string, _ *smtp.MailOptions) error {
+	s.from = from
+	return nil
+}
+
+func (s *session) Rcpt(to string, _ *smtp.RcptOptions) error {
+	addr, err := mail.ParseAddress(to)
+	if err != nil {
+		return &smtp.SMTPError{
+			Code:         501,
+			EnhancedCode: smtp.EnhancedCode{5, 1, 3},
+			Message:      "Bad recipient address syntax",
+		}
+	}
+
+	domain := strings.ToLower(addr.Address[strings.LastIndexByte(addr.Address, '@')+1:])
+	if !s.backend.allowed[domain] {
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+			Message:      "Relaying denied",
+		}
+	}
+
+	s.rcpts = append(s.rcpts, strings.ToLower(addr.Address))
+	return nil
+}
+
+// Data отвечает 250 только после того, как письмо сохранено в БД.
+func (s *session) Data(r io.Reader) error {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	dto, err := messages.RawToDTO(bytes.NewReader(raw))
+	if err != nil {
+		s.backend.log.Warn("smtp message rejected", slog.Any("error", err), slog.String("from", s.from))
+		return &smtp.SMTPError{
+			Code:         554,
+			EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+			Message:      "Message could not be parsed",
+		}
+	}
+	// Получатели из конверта точнее заголовков определяют общий ящик:
+	// письмо могло прийти как Bcc или через пересылку. Письма без To в
+	// заголовках адресуем по конверту.
+	dto.DeliveredTo = s.rcpts
+	if len(dto.To) == 0 {
+		dto.To = s.rcpts
+	}
+	// Return-Path добавляет принимающий сервер; пустой MAIL FROM означает
+	// отчёт о недоставке или автоответ, это учитывает префильтр.
+	if _, ok := dto.Headers["Return-Path"]; !ok {
+		dto.Headers["Return-Path"] = "<" + s.from + ">"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ingestTimeout)
+	defer cancel()
+
+	id, err := s.backend.ingestor.ProcessIncomingMessage(ctx, dto)
+	switch {
+	case err == nil:
+	case errors.Is(err, messages.ErrNotQueued):
+		// Письмо уже сохранено, его можно переотправить через /reprocess;
+		// временная ошибка привела бы к дублю при повторной доставке.
+		s.backend.log.Warn("smtp message stored but not queued", slog.Any("error", err), slog.String("id", id))
+	case errors.Is(err, messages.ErrInvalidMessage):
+		s.backend.log.Warn("smtp message rejected", slog.Any("error", err), slog.String("from", s.from))
+		return &smtp.SMTPError{
+			Code:         554,
+			EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+			Message:      "Message rejected",
+		}
+	default:
+		s.backend.log.Error("failed to ingest smtp message", slog.Any("error", err), slog.String("from", s.from))
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Temporary failure, try again later",
+		}
+	}
+
+	s.backend.log.Info("smtp message accepted",
+		slog.String("id", id),
+		slog.String("from", s.from),
+		slog.Any("rcpts", s.rcpts),
+	)
+	return nil
+}
+
+func (s *session) Reset() {
+	s.from = ""
+	s.rcpts = nil
+}
+
+func (s *session) Logout() error {
+	return nil
+}
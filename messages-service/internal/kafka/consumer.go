@@ -0,0 +1,475 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"messages-service/internal/config"
+)
+
+// maxRetryBackoff ограничивает задержку между попытками обработки.
+const maxRetryBackoff = time.Minute
+
+// Record — сообщение, прочитанное консьюмером.
+type Record struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+	Time      time.Time
+}
+
+// Handler обрабатывает сообщения консьюмера. Ошибка — повторить попытку;
+// ошибка, обёрнутая в Permanent, — сразу считать сообщение ядовитым.
+// Сообщения с одним ключом обрабатываются по порядку, с разными —
+// параллельно.
+type Handler interface {
+	Handle(ctx context.Context, rec Record) error
+}
+
+type HandlerFunc func(ctx context.Context, rec Record) error
+
+func (f HandlerFunc) Handle(ctx context.Context, rec Record) error {
+	return f(ctx, rec)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent помечает ошибку, которую бесполезно повторять: неразбираемое
+// сообщение, отказ получателя по существу запроса.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// PoisonMessage — сообщение, которое не удалось обработать, в poison_topic.
+type PoisonMessage struct {
+	Group     string    `json:"group"`
+	Topic     string    `json:"topic"`
+	Partition int       `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Key       string    `json:"key,omitempty"`
+	Value     []byte    `json:"value"` // base64 в JSON: сообщение может быть не JSON
+	Error     string    `json:"error"`
+	Attempts  int       `json:"attempts"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// PoisonSender отправляет необработанные сообщения в poison_topic; его
+// реализует Producer.
+type PoisonSender interface {
+	Send(ctx context.Context, topic string, key string, value []byte) error
+}
+
+// ConsumerSpec — что и как читает консьюмер.
+type ConsumerSpec struct {
+	Name    string // для журнала
+	GroupID string
+	Topics  []string
+	// Concurrency — сколько сообщений обрабатывается одновременно.
+	Concurrency int
+	Handler     Handler
+}
+
+// Consumer читает топики в группе консьюмеров и коммитит смещения вручную:
+// смещение партиции продвигается только за сообщениями, которые обработаны
+// или отправлены в poison_topic, поэтому доставка — не меньше одного раза.
+type Consumer struct {
+	cfg    config.KafkaConfig
+	spec   ConsumerSpec
+	poison PoisonSender
+	log    *slog.Logger
+
+	jobs []chan job
+}
+
+type job struct {
+	ctx     context.Context
+	msg     kafka.Message
+	tracker *offsetTracker
+	done    func()
+}
+
+// NewConsumer создаёт консьюмер. poison может быть nil, если poison_topic не
+// задан.
+func NewConsumer(cfg config.KafkaConfig, spec ConsumerSpec, poison PoisonSender, log *slog.Logger) (*Consumer, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("no kafka brokers provided")
+	}
+	if spec.GroupID == "" || len(spec.Topics) == 0 || spec.Handler == nil {
+		return nil, fmt.Errorf("consumer %s: group, topics and handler are required", spec.Name)
+	}
+	if spec.Concurrency <= 0 {
+		spec.Concurrency = 1
+	}
+	if cfg.Consumer.CommitInterval <= 0 {
+		return nil, fmt.Errorf("consumer %s: commit_interval must be positive", spec.Name)
+	}
+	switch cfg.Consumer.StartOffset {
+	case "", "earliest", "latest":
+	default:
+		return nil, fmt.Errorf("consumer %s: unknown start_offset %q", spec.Name, cfg.Consumer.StartOffset)
+	}
+
+	return &Consumer{
+		cfg:    cfg,
+		spec:   spec,
+		poison: poison,
+		log: log.With(
+			slog.String("consumer", spec.Name),
+			slog.String("group", spec.GroupID),
+		),
+	}, nil
+}
+
+// Run читает сообщения до отмены ctx. При остановке и ребалансировке
+// консьюмер перестаёт читать отданные партиции, ждёт обработки прочитанных
+// сообщений не дольше drain_timeout и коммитит их смещения.
+func (c *Consumer) Run(ctx context.Context) {
+	startOffset := kafka.FirstOffset
+	if c.cfg.Consumer.StartOffset == "latest" {
+		startOffset = kafka.LastOffset
+	}
+
+	group, err := kafka.NewConsumerGroup(kafka.ConsumerGroupConfig{
+		ID:                    c.spec.GroupID,
+		Brokers:               c.cfg.Brokers,
+		Topics:                c.spec.Topics,
+		StartOffset:           startOffset,
+		WatchPartitionChanges: true,
+	})
+	if err != nil {
+		c.log.Error("failed to create consumer group", slog.Any("error", err))
+		return
+	}
+
+	// Обработчики не отменяются вместе с ctx: прочитанные сообщения
+	// дорабатываются, пока партиция ждёт drain_timeout.
+	workCtx, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+
+	var workers sync.WaitGroup
+	c.jobs = make([]chan job, c.spec.Concurrency)
+	for i := range c.jobs {
+		c.jobs[i] = make(chan job)
+		workers.Add(1)
+		go func(jobs <-chan job) {
+			defer workers.Done()
+			for j := range jobs {
+				c.process(j)
+			}
+		}(c.jobs[i])
+	}
+
+	var partitions sync.WaitGroup
+	go func() {
+		<-ctx.Done()
+		// Close завершает поколение и дожидается функций партиций.
+		if err := group.Close(); err != nil {
+			c.log.Warn("failed to close consumer group", slog.Any("error", err))
+		}
+	}()
+
+	c.log.Info("consumer started", slog.Any("topics", c.spec.Topics), slog.Int("concurrency", c.spec.Concurrency))
+	for {
+		gen, err := group.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, kafka.ErrGroupClosed) {
+				break
+			}
+			c.log.Warn("consumer group generation failed", slog.Any("error", err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		c.log.Info("partitions assigned",
+			slog.Int("generation", int(gen.ID)),
+			slog.Any("assignments", assignmentSummary(gen.Assignments)),
+		)
+		for topic, assignments := range gen.Assignments {
+			for _, a := range assignments {
+				partitions.Add(1)
+				gen.Start(func(genCtx context.Context) {
+					defer partitions.Done()
+					c.consumePartition(genCtx, workCtx, gen, topic, a.ID, a.Offset)
+				})
+			}
+		}
+	}
+
+	partitions.Wait()
+	for _, jobs := range c.jobs {
+		close(jobs)
+	}
+	workers.Wait()
+	c.log.Info("consumer stopped")
+}
+
+// consumePartition читает партицию, пока поколение активно, и периодически
+// коммитит смещение за последним обработанным подряд сообщением.
+func (c *Consumer) consumePartition(genCtx, workCtx context.Context, gen *kafka.Generation, topic string, partition int, offset int64) {
+	log := c.log.With(slog.String("topic", topic), slog.Int("partition", partition))
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   c.cfg.Brokers,
+		Topic:     topic,
+		Partition: partition,
+		MaxWait:   time.Second,
+	})
+	defer reader.Close()
+	if err := reader.SetOffset(offset); err != nil {
+		log.Error("failed to set partition offset", slog.Any("error", err), slog.Int64("offset", offset))
+		return
+	}
+
+	// Обработка сообщений партиции отменяется, если они не успели
+	// завершиться за drain_timeout после отзыва партиции.
+	partCtx, cancelPart := context.WithCancel(workCtx)
+	defer cancelPart()
+
+	tracker := newOffsetTracker()
+	var inflight sync.WaitGroup
+	commit := func() {
+		next, ok := tracker.commitOffset()
+		if !ok {
+			return
+		}
+		if err := gen.CommitOffsets(map[string]map[int]int64{topic: {partition: next}}); err != nil {
+			log.Warn("failed to commit offset", slog.Any("error", err), slog.Int64("offset", next))
+		}
+	}
+
+	stopCommits := make(chan struct{})
+	commitsDone := make(chan struct{})
+	go func() {
+		defer close(commitsDone)
+		ticker := time.NewTicker(c.cfg.Consumer.CommitInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				commit()
+			case <-stopCommits:
+				return
+			}
+		}
+	}()
+
+	for {
+		msg, err := reader.FetchMessage(genCtx)
+		if err != nil {
+			if genCtx.Err() != nil {
+				break
+			}
+			log.Warn("failed to fetch message", slog.Any("error", err))
+			select {
+			case <-genCtx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		tracker.add(msg.Offset)
+		inflight.Add(1)
+		j := job{ctx: partCtx, msg: msg, tracker: tracker, done: inflight.Done}
+		select {
+		case c.jobs[c.worker(msg.Key)] <- j:
+		case <-genCtx.Done():
+			inflight.Done()
+		}
+		if genCtx.Err() != nil {
+			break
+		}
+	}
+
+	close(stopCommits)
+	<-commitsDone
+
+	drained := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(c.cfg.Consumer.DrainTimeout):
+		log.Warn("partition revoked before in-flight messages were processed, they will be redelivered")
+	}
+	commit()
+	log.Info("partition released")
+}
+
+// worker выбирает обработчика по ключу, чтобы сообщения одного письма
+// обрабатывались по порядку.
+func (c *Consumer) worker(key []byte) int {
+	if len(key) == 0 || len(c.jobs) == 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return int(h.Sum32() % uint32(len(c.jobs)))
+}
+
+// process обрабатывает сообщение с повторами. Сообщение, которое не удалось
+// обработать за max_attempts или с Permanent-ошибкой, уходит в poison_topic;
+// после этого его смещение можно коммитить. Если обработка прервана
+// отменой, смещение не продвигается и сообщение будет прочитано снова.
+func (c *Consumer) process(j job) {
+	defer j.done()
+
+	rec := record(j.msg)
+	maxAttempts := max(c.cfg.Consumer.MaxAttempts, 1)
+
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		err = c.handle(j.ctx, rec)
+		if err == nil {
+			j.tracker.markDone(rec.Offset)
+			return
+		}
+		if j.ctx.Err() != nil {
+			return
+		}
+		if IsPermanent(err) || attempt >= maxAttempts {
+			break
+		}
+
+		c.log.Warn("failed to handle message, will retry",
+			slog.Any("error", err),
+			slog.String("topic", rec.Topic),
+			slog.Int("partition", rec.Partition),
+			slog.Int64("offset", rec.Offset),
+			slog.Int("attempt", attempt),
+		)
+		select {
+		case <-j.ctx.Done():
+			return
+		case <-time.After(c.backoff(attempt)):
+		}
+	}
+
+	if c.sendPoison(j.ctx, rec, err, attempt) {
+		j.tracker.markDone(rec.Offset)
+	}
+}
+
+// handle вызывает обработчик; паника считается ядовитым сообщением.
+func (c *Consumer) handle(ctx context.Context, rec Record) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = Permanent(fmt.Errorf("handler panic: %v", r))
+		}
+	}()
+	return c.spec.Handler.Handle(ctx, rec)
+}
+
+// sendPoison отправляет сообщение в poison_topic, повторяя отправку до
+// успеха или отмены. Без poison_topic сообщение только журналируется.
+func (c *Consumer) sendPoison(ctx context.Context, rec Record, handleErr error, attempts int) bool {
+	log := c.log.With(
+		slog.String("topic", rec.Topic),
+		slog.Int("partition", rec.Partition),
+		slog.Int64("offset", rec.Offset),
+	)
+	topic := c.cfg.Consumer.PoisonTopic
+	if topic == "" || c.poison == nil {
+		log.Error("poison message skipped", slog.Any("error", handleErr), slog.Int("attempts", attempts))
+		return true
+	}
+
+	data, err := json.Marshal(PoisonMessage{
+		Group:     c.spec.GroupID,
+		Topic:     rec.Topic,
+		Partition: rec.Partition,
+		Offset:    rec.Offset,
+		Key:       string(rec.Key),
+		Value:     rec.Value,
+		Error:     handleErr.Error(),
+		Attempts:  attempts,
+		FailedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		log.Error("failed to marshal poison message", slog.Any("error", err))
+		return false
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := c.poison.Send(ctx, topic, string(rec.Key), data)
+		if err == nil {
+			log.Warn("message moved to poison topic",
+				slog.Any("error", handleErr),
+				slog.String("poison_topic", topic),
+				slog.Int("attempts", attempts),
+			)
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(c.backoff(attempt)):
+		}
+	}
+}
+
+// backoff растёт экспоненциально от retry_backoff и ограничен минутой.
+func (c *Consumer) backoff(attempt int) time.Duration {
+	delay := c.cfg.Consumer.RetryBackoff
+	for i := 1; i < attempt && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryBackoff)
+}
+
+func record(msg kafka.Message) Record {
+	rec := Record{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Time:      msg.Time,
+	}
+	if len(msg.Headers) > 0 {
+		rec.Headers = make(map[string]string, len(msg.Headers))
+		for _, h := range msg.Headers {
+			rec.Headers[h.Key] = string(h.Value)
+		}
+	}
+	return rec
+}
+
+func assignmentSummary(assignments map[string][]kafka.PartitionAssignment) map[string][]int {
+	summary := make(map[string][]int, len(assignments))
+	for topic, parts := range assignments {
+		for _, p := range parts {
+			summary[topic] = append(summary[topic], p.ID)
+		}
+	}
+	return summary
+}
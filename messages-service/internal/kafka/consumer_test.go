@@ -0,0 +1,188 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"messages-service/internal/config"
+)
+
+// Тесты вызывают process напрямую: чтение партиций требует брокера, а
+// повторы, poison_topic и смещения от него не зависят.
+
+type fakePoison struct {
+	mu   sync.Mutex
+	sent []PoisonMessage
+	err  error
+}
+
+func (f *fakePoison) Send(_ context.Context, topic string, _ string, value []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	if topic != "poison" {
+		return errors.New("unexpected topic " + topic)
+	}
+	var msg PoisonMessage
+	if err := json.Unmarshal(value, &msg); err != nil {
+		return err
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+// countingHandler отвечает ошибками из errs по очереди, затем nil.
+type countingHandler struct {
+	mu    sync.Mutex
+	calls int
+	errs  []error
+}
+
+func (h *countingHandler) Handle(context.Context, Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls++
+	if h.calls <= len(h.errs) {
+		return h.errs[h.calls-1]
+	}
+	return nil
+}
+
+func newTestConsumer(t *testing.T, handler Handler, poison PoisonSender, poisonTopic string) *Consumer {
+	t.Helper()
+	cfg := config.KafkaConfig{
+		Brokers: []string{"localhost:9092"},
+		Consumer: config.ConsumerConfig{
+			CommitInterval: time.Second,
+			MaxAttempts:    3,
+			RetryBackoff:   time.Millisecond,
+			PoisonTopic:    poisonTopic,
+		},
+	}
+	c, err := NewConsumer(cfg, ConsumerSpec{
+		Name:    "test",
+		GroupID: "test-group",
+		Topics:  []string{"in"},
+		Handler: handler,
+	}, poison, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// runJob обрабатывает сообщение со смещением 7 и возвращает, можно ли
+// коммитить смещение за ним.
+func runJob(ctx context.Context, c *Consumer) bool {
+	tracker := newOffsetTracker()
+	tracker.add(7)
+	c.process(job{
+		ctx:     ctx,
+		msg:     kafka.Message{Topic: "in", Partition: 1, Offset: 7, Key: []byte("mail-1"), Value: []byte(`{"id":"mail-1"}`)},
+		tracker: tracker,
+		done:    func() {},
+	})
+	next, ok := tracker.commitOffset()
+	return ok && next == 8
+}
+
+func TestProcessRetriesUntilSuccess(t *testing.T) {
+	handler := &countingHandler{errs: []error{errors.New("timeout"), errors.New("timeout")}}
+	poison := &fakePoison{}
+	c := newTestConsumer(t, handler, poison, "poison")
+
+	if !runJob(context.Background(), c) {
+		t.Fatal("processed message must be committable")
+	}
+	if handler.calls != 3 {
+		t.Fatalf("handler calls = %d, want 3", handler.calls)
+	}
+	if len(poison.sent) != 0 {
+		t.Fatalf("poison messages = %d, want 0", len(poison.sent))
+	}
+}
+
+func TestProcessMovesToPoisonAfterMaxAttempts(t *testing.T) {
+	fail := errors.New("receiver is down")
+	handler := &countingHandler{errs: []error{fail, fail, fail, fail}}
+	poison := &fakePoison{}
+	c := newTestConsumer(t, handler, poison, "poison")
+
+	if !runJob(context.Background(), c) {
+		t.Fatal("message moved to poison topic must be committable")
+	}
+	if handler.calls != 3 {
+		t.Fatalf("handler calls = %d, want max_attempts = 3", handler.calls)
+	}
+	if len(poison.sent) != 1 {
+		t.Fatalf("poison messages = %d, want 1", len(poison.sent))
+	}
+	got := poison.sent[0]
+	if got.Group != "test-group" || got.Topic != "in" || got.Partition != 1 || got.Offset != 7 ||
+		got.Key != "mail-1" || string(got.Value) != `{"id":"mail-1"}` ||
+		got.Error != fail.Error() || got.Attempts != 3 {
+		t.Fatalf("unexpected poison message %+v", got)
+	}
+}
+
+func TestProcessPermanentAndPanicSkipRetries(t *testing.T) {
+	tests := map[string]Handler{
+		"permanent": &countingHandler{errs: []error{Permanent(errors.New("bad message")), nil}},
+		"panic": HandlerFunc(func(context.Context, Record) error {
+			panic("boom")
+		}),
+	}
+	for name, handler := range tests {
+		t.Run(name, func(t *testing.T) {
+			poison := &fakePoison{}
+			c := newTestConsumer(t, handler, poison, "poison")
+
+			if !runJob(context.Background(), c) {
+				t.Fatal("message moved to poison topic must be committable")
+			}
+			if len(poison.sent) != 1 || poison.sent[0].Attempts != 1 {
+				t.Fatalf("poison messages = %+v, want one after the first attempt", poison.sent)
+			}
+			if h, ok := handler.(*countingHandler); ok && h.calls != 1 {
+				t.Fatalf("handler calls = %d, want 1", h.calls)
+			}
+		})
+	}
+}
+
+func TestProcessWithoutPoisonTopicOnlyLogs(t *testing.T) {
+	handler := &countingHandler{errs: []error{Permanent(errors.New("bad message"))}}
+	poison := &fakePoison{}
+	c := newTestConsumer(t, handler, poison, "")
+
+	if !runJob(context.Background(), c) {
+		t.Fatal("skipped poison message must be committable")
+	}
+	if len(poison.sent) != 0 {
+		t.Fatalf("poison messages = %d, want 0 without poison_topic", len(poison.sent))
+	}
+}
+
+func TestProcessKeepsOffsetWhenPoisonUnavailable(t *testing.T) {
+	handler := &countingHandler{errs: []error{Permanent(errors.New("bad message"))}}
+	poison := &fakePoison{err: errors.New("broker unavailable")}
+	c := newTestConsumer(t, handler, poison, "poison")
+
+	// Отправка в poison_topic повторяется до отмены; после неё сообщение
+	// должно остаться непрочитанным.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if runJob(ctx, c) {
+		t.Fatal("message not delivered to poison topic must not be committable")
+	}
+}
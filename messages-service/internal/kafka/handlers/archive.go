@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"messages-service/internal/config"
+	"messages-service/internal/kafka"
+)
+
+// archiveLine — строка архива. Value пишется как есть, если это JSON, и
+// строкой в base64 — если нет.
+type archiveLine struct {
+	Topic     string          `json:"topic"`
+	Partition int             `json:"partition"`
+	Offset    int64           `json:"offset"`
+	Key       string          `json:"key,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// archiveIdleTimeout — через сколько без записей файл дня закрывается.
+// Сообщения приходят примерно по порядку времени, так что открытыми
+// остаются файлы текущего дня и опоздавших сообщений.
+const archiveIdleTimeout = time.Minute
+
+// Archive дописывает сообщения построчно в <dir>/<topic>/<YYYY-MM-DD>.jsonl
+// по времени сообщения в UTC. Повторно доставленное сообщение даёт
+// повторную строку; topic/partition/offset её однозначно определяют.
+type Archive struct {
+	dir  string
+	sync bool
+
+	mu    sync.Mutex
+	files map[string]*archiveFile
+}
+
+// archiveFile — открытый файл дня. refs — сколько записей держат файл:
+// закрыть можно только файл без них.
+type archiveFile struct {
+	mu       sync.Mutex
+	f        *os.File
+	refs     int
+	lastUsed time.Time
+}
+
+func NewArchive(cfg config.ConsumerArchiveConfig) (*Archive, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("archive handler: dir is required")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("archive handler: %w", err)
+	}
+	return &Archive{
+		dir:   cfg.Dir,
+		sync:  cfg.Sync,
+		files: make(map[string]*archiveFile),
+	}, nil
+}
+
+func (a *Archive) Handle(_ context.Context, rec kafka.Record) error {
+	ts := rec.Time.UTC()
+	if ts.IsZero() {
+		ts = time.Now().UTC()
+	}
+
+	value := json.RawMessage(rec.Value)
+	if !json.Valid(rec.Value) {
+		encoded, err := json.Marshal(rec.Value)
+		if err != nil {
+			return kafka.Permanent(err)
+		}
+		value = encoded
+	}
+	line, err := json.Marshal(archiveLine{
+		Topic:     rec.Topic,
+		Partition: rec.Partition,
+		Offset:    rec.Offset,
+		Key:       string(rec.Key),
+		Timestamp: ts,
+		Value:     value,
+	})
+	if err != nil {
+		return kafka.Permanent(err)
+	}
+	line = append(line, '\n')
+
+	file, err := a.acquire(rec.Topic, ts.Format(time.DateOnly))
+	if err != nil {
+		return err
+	}
+	defer a.release(file)
+
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if _, err := file.f.Write(line); err != nil {
+		return fmt.Errorf("archive write: %w", err)
+	}
+	if a.sync {
+		if err := file.f.Sync(); err != nil {
+			return fmt.Errorf("archive sync: %w", err)
+		}
+	}
+	return nil
+}
+
+// acquire возвращает файл дня, открывая его на дозапись, и держит его до
+// release. Заодно закрываются файлы, в которые не писали archiveIdleTimeout.
+func (a *Archive) acquire(topic, day string) (*archiveFile, error) {
+	// Имя топика идёт в путь, поэтому разделители в нём заменяются.
+	topic = strings.NewReplacer("/", "_", `\`, "_", "..", "_").Replace(topic)
+	dir := filepath.Join(a.dir, topic)
+	path := filepath.Join(dir, day+".jsonl")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closeIdle(time.Now())
+	if file, ok := a.files[path]; ok {
+		file.refs++
+		return file, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("archive: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("archive: %w", err)
+	}
+	file := &archiveFile{f: f, refs: 1}
+	a.files[path] = file
+	return file, nil
+}
+
+func (a *Archive) release(file *archiveFile) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	file.refs--
+	file.lastUsed = time.Now()
+}
+
+// closeIdle закрывает файлы без записей дольше archiveIdleTimeout. Вызывается
+// под a.mu.
+func (a *Archive) closeIdle(now time.Time) {
+	for path, file := range a.files {
+		if file.refs == 0 && now.Sub(file.lastUsed) >= archiveIdleTimeout {
+			_ = file.f.Close()
+			delete(a.files, path)
+		}
+	}
+}
+
+// Close закрывает все файлы; вызывается после остановки консьюмера, когда
+// записей уже нет.
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var errs []error
+	for path, file := range a.files {
+		file.mu.Lock()
+		errs = append(errs, file.f.Close())
+		file.mu.Unlock()
+		delete(a.files, path)
+	}
+	return errors.Join(errs...)
+}
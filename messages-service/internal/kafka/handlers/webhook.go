@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"messages-service/internal/config"
+	"messages-service/internal/kafka"
+	"messages-service/internal/webhook"
+)
+
+const defaultWebhookTimeout = 10 * time.Second
+
+// Webhook рассылает сообщения на URL-ы получателей тем же запросом, что и
+// подписки webhook: тело — сообщение как есть, X-Webhook-Event — топик,
+// X-Webhook-Delivery — "<topic>/<partition>/<offset>", подпись — как у
+// webhook.Sign. Если хотя бы один URL не ответил, сообщение повторяется
+// для всех, поэтому получатели отбрасывают повторы по X-Webhook-Delivery.
+type Webhook struct {
+	urls   []string
+	secret string
+	client *http.Client
+}
+
+func NewWebhook(cfg config.ConsumerWebhookConfig) (*Webhook, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, errors.New("webhook handler: no urls provided")
+	}
+	for _, raw := range cfg.URLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook handler: invalid url %q", raw)
+		}
+	}
+
+	secret := cfg.Secret
+	if cfg.SecretEnv != "" {
+		secret = os.Getenv(cfg.SecretEnv)
+		if secret == "" {
+			return nil, fmt.Errorf("webhook handler: secret env %s is empty", cfg.SecretEnv)
+		}
+	}
+	// Без секрета получатель не отличит рассылку от чужого запроса.
+	if secret == "" {
+		return nil, errors.New("webhook handler: secret or secret_env is required")
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+
+	return &Webhook{
+		urls:   cfg.URLs,
+		secret: secret,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Handle отправляет сообщение на все URL-ы параллельно. Ответ 4xx, кроме
+// 408 и 429, повторять бесполезно — сообщение уходит в poison_topic.
+func (h *Webhook) Handle(ctx context.Context, rec kafka.Record) error {
+	delivery := fmt.Sprintf("%s/%d/%d", rec.Topic, rec.Partition, rec.Offset)
+
+	errs := make([]error, len(h.urls))
+	var wg sync.WaitGroup
+	for i, target := range h.urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = h.post(ctx, target, delivery, rec)
+		}()
+	}
+	wg.Wait()
+
+	err := errors.Join(errs...)
+	if err == nil {
+		return nil
+	}
+	for _, e := range errs {
+		if e != nil && !kafka.IsPermanent(e) {
+			return err
+		}
+	}
+	return kafka.Permanent(err)
+}
+
+func (h *Webhook) post(ctx context.Context, target, delivery string, rec kafka.Record) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(rec.Value))
+	if err != nil {
+		return kafka.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.HeaderEvent, rec.Topic)
+	req.Header.Set(webhook.HeaderDelivery, delivery)
+	req.Header.Set(webhook.HeaderTimestamp, timestamp)
+	req.Header.Set(webhook.HeaderSignature, "sha256="+webhook.Sign(h.secret, timestamp, rec.Value))
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", target, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("%s responded with %s", target, resp.Status)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return kafka.Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package kafka
+
+import "sync"
+
+// offsetTracker следит за смещениями партиции, прочитанными, но ещё не
+// обработанными. Сообщения обрабатываются параллельно и завершаются не по
+// порядку, а коммитить можно только смещение, до которого обработано всё.
+type offsetTracker struct {
+	mu        sync.Mutex
+	pending   []int64 // прочитанные смещения по порядку чтения
+	done      map[int64]bool
+	committed int64 // последнее отданное на коммит смещение, -1 — ещё не было
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		done:      make(map[int64]bool),
+		committed: -1,
+	}
+}
+
+func (t *offsetTracker) add(offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, offset)
+}
+
+func (t *offsetTracker) markDone(offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done[offset] = true
+}
+
+// commitOffset возвращает смещение для коммита — следующее за последним
+// сообщением, до которого всё обработано, — если оно сдвинулось с прошлого
+// вызова.
+func (t *offsetTracker) commitOffset() (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	next := int64(-1)
+	for len(t.pending) > 0 && t.done[t.pending[0]] {
+		delete(t.done, t.pending[0])
+		next = t.pending[0] + 1
+		t.pending = t.pending[1:]
+	}
+	if next < 0 || next == t.committed {
+		return 0, false
+	}
+	t.committed = next
+	return next, true
+}
@@ -0,0 +1,56 @@
+package kafka
+
+import "testing"
+
+func TestOffsetTrackerCommitsContiguousPrefix(t *testing.T) {
+	tr := newOffsetTracker()
+	for _, offset := range []int64{10, 11, 12, 13} {
+		tr.add(offset)
+	}
+
+	if _, ok := tr.commitOffset(); ok {
+		t.Fatal("nothing processed yet, commitOffset must not move")
+	}
+
+	// 12 обработано раньше 10 и 11: коммитить его нельзя.
+	tr.markDone(12)
+	if _, ok := tr.commitOffset(); ok {
+		t.Fatal("offset 10 is still in flight, commitOffset must not move")
+	}
+
+	tr.markDone(10)
+	if next, ok := tr.commitOffset(); !ok || next != 11 {
+		t.Fatalf("commitOffset() = %d, %v; want 11, true", next, ok)
+	}
+
+	tr.markDone(11)
+	if next, ok := tr.commitOffset(); !ok || next != 13 {
+		t.Fatalf("commitOffset() = %d, %v; want 13, true", next, ok)
+	}
+
+	tr.markDone(13)
+	if next, ok := tr.commitOffset(); !ok || next != 14 {
+		t.Fatalf("commitOffset() = %d, %v; want 14, true", next, ok)
+	}
+}
+
+func TestOffsetTrackerDoesNotRepeatCommit(t *testing.T) {
+	tr := newOffsetTracker()
+	tr.add(5)
+	tr.markDone(5)
+
+	if next, ok := tr.commitOffset(); !ok || next != 6 {
+		t.Fatalf("commitOffset() = %d, %v; want 6, true", next, ok)
+	}
+	if _, ok := tr.commitOffset(); ok {
+		t.Fatal("second commitOffset without progress must not move")
+	}
+
+	// Смещения после пропуска (компакция, транзакционные маркеры) идут не
+	// подряд — коммитится следующее за последним обработанным.
+	tr.add(9)
+	tr.markDone(9)
+	if next, ok := tr.commitOffset(); !ok || next != 10 {
+		t.Fatalf("commitOffset() = %d, %v; want 10, true", next, ok)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/segmentio/kafka-go"
 
 	"messages-service/internal/config"
+	"messages-service/internal/messages"
 )
 
 type Producer struct {
@@ -79,6 +80,50 @@ func (p *Producer) Send(ctx context.Context, topic string, key string, value []b
 	return nil
 }
 
+// SendBatch отправляет все сообщения в топик одним вызовом WriteMessages.
+func (p *Producer) SendBatch(ctx context.Context, topic string, msgs []messages.ProducerMessage) error {
+	if topic == "" {
+		return fmt.Errorf("topic is empty")
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	batch := make([]kafka.Message, 0, len(msgs))
+	for _, m := range msgs {
+		batch = append(batch, kafka.Message{
+			Topic: topic,
+			Key:   []byte(m.Key),
+			Value: m.Value,
+			Time:  now,
+		})
+	}
+
+	timeout := p.cfg.Producer.Timeout
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if err := p.writer.WriteMessages(ctx, batch...); err != nil {
+		p.log.Error("failed to send batch to kafka",
+			slog.Any("error", err),
+			slog.String("topic", topic),
+			slog.Int("size", len(msgs)),
+		)
+		return err
+	}
+
+	p.log.Debug("batch sent to kafka",
+		slog.String("topic", topic),
+		slog.Int("size", len(msgs)),
+	)
+
+	return nil
+}
+
 func (p *Producer) Close() error {
 	if err := p.writer.Close(); err != nil {
 		p.log.Warn("failed to close kafka writer", slog.Any("error", err))
@@ -0,0 +1,74 @@
+package mailparse
+
+import (
+	"bufio"
+	"bytes"
+	"net/textproto"
+	"strings"
+)
+
+// DeliveryStatus — отчёт о недоставке (RFC 3464) из части
+// message/delivery-status письма-bounce.
+type DeliveryStatus struct {
+	ReportingMTA string
+	Recipients   []RecipientStatus
+}
+
+// RecipientStatus — результат доставки одному получателю.
+type RecipientStatus struct {
+	Recipient  string // Final-Recipient без типа адреса
+	Action     string // failed / delayed / delivered / relayed / expanded
+	Status     string // код вида 5.1.1
+	Diagnostic string // Diagnostic-Code без типа
+}
+
+// Failed возвращает первого получателя, доставка которому не удалась или
+// отложена; если таких нет — первого из отчёта.
+func (d *DeliveryStatus) Failed() (RecipientStatus, bool) {
+	for _, r := range d.Recipients {
+		if r.Action == "failed" || r.Action == "delayed" {
+			return r, true
+		}
+	}
+	if len(d.Recipients) > 0 {
+		return d.Recipients[0], true
+	}
+	return RecipientStatus{}, false
+}
+
+// parseDeliveryStatus разбирает блоки полей, разделённые пустыми строками:
+// первый описывает сообщение целиком, остальные — получателей.
+func parseDeliveryStatus(data []byte) *DeliveryStatus {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+
+	status := &DeliveryStatus{}
+	for {
+		fields, err := r.ReadMIMEHeader()
+		switch {
+		case fields.Get("Final-Recipient") != "" || fields.Get("Original-Recipient") != "":
+			recipient := typedValue(fields.Get("Final-Recipient"))
+			if recipient == "" {
+				recipient = typedValue(fields.Get("Original-Recipient"))
+			}
+			status.Recipients = append(status.Recipients, RecipientStatus{
+				Recipient:  strings.ToLower(recipient),
+				Action:     strings.ToLower(strings.TrimSpace(fields.Get("Action"))),
+				Status:     strings.TrimSpace(fields.Get("Status")),
+				Diagnostic: typedValue(fields.Get("Diagnostic-Code")),
+			})
+		case fields.Get("Reporting-MTA") != "":
+			status.ReportingMTA = typedValue(fields.Get("Reporting-MTA"))
+		}
+		if err != nil {
+			return status
+		}
+	}
+}
+
+// typedValue убирает тип из значений вида "rfc822; user@example.com".
+func typedValue(value string) string {
+	if _, v, ok := strings.Cut(value, ";"); ok {
+		value = v
+	}
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,45 @@
+package mailparse
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	reHTMLComment = regexp.MustCompile(`(?s)<!--.*?-->`)
+	reHTMLScript  = regexp.MustCompile(`(?is)<script\b.*?</script\s*>`)
+	reHTMLStyle   = regexp.MustCompile(`(?is)<style\b.*?</style\s*>`)
+	reHTMLHead    = regexp.MustCompile(`(?is)<head\b.*?</head\s*>`)
+	reHTMLBreak   = regexp.MustCompile(`(?i)<(br|/?p|/?div|/?li|/?tr|/?h[1-6]|/?table|/?blockquote)\b[^>]*>`)
+	reHTMLTag     = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// HTMLToText убирает разметку из HTML-тела письма, сохраняя абзацы.
+func HTMLToText(s string) string {
+	s = reHTMLComment.ReplaceAllString(s, "")
+	s = reHTMLScript.ReplaceAllString(s, "")
+	s = reHTMLStyle.ReplaceAllString(s, "")
+	s = reHTMLHead.ReplaceAllString(s, "")
+	s = reHTMLBreak.ReplaceAllString(s, "\n")
+	s = reHTMLTag.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	blank := false
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			if !blank && len(out) > 0 {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		out = append(out, line)
+		blank = false
+	}
+
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,331 @@
+package mailparse
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// maxPartDepth ограничивает вложенность multipart, чтобы не уйти в рекурсию
+// на специально собранных письмах.
+const maxPartDepth = 10
+
+// Message — результат разбора письма в формате RFC 5322 / MIME.
+type Message struct {
+	From        string
+	To          []string
+	Cc          []string
+	Subject     string
+	MessageID   string
+	InReplyTo   string
+	References  []string
+	Date        time.Time
+	Text        string // текстовое тело; если есть только HTML — HTML без разметки
+	Header      mail.Header
+	Attachments []Attachment
+	// DeliveryStatus — отчёт о недоставке, если письмо является DSN
+	// (multipart/report с частью message/delivery-status).
+	DeliveryStatus *DeliveryStatus
+}
+
+// Attachment — вложение письма с уже снятым transfer-encoding.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// Parse разбирает заголовки и MIME-части письма.
+func Parse(r io.Reader) (*Message, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("read message: %w", err)
+	}
+
+	parsed := &Message{Header: msg.Header}
+
+	from, err := parseAddressList(msg.Header, "From")
+	if err != nil {
+		return nil, fmt.Errorf("parse from: %w", err)
+	}
+	if len(from) == 0 {
+		return nil, errors.New("from header is missing")
+	}
+	parsed.From = from[0]
+
+	if parsed.To, err = parseAddressList(msg.Header, "To"); err != nil {
+		return nil, fmt.Errorf("parse to: %w", err)
+	}
+	if parsed.Cc, err = parseAddressList(msg.Header, "Cc"); err != nil {
+		return nil, fmt.Errorf("parse cc: %w", err)
+	}
+
+	parsed.Subject = decodeHeader(msg.Header.Get("Subject"))
+	parsed.MessageID = firstMessageID(msg.Header.Get("Message-Id"))
+	parsed.InReplyTo = firstMessageID(msg.Header.Get("In-Reply-To"))
+	parsed.References = messageIDs(msg.Header.Get("References"))
+
+	if date, err := msg.Header.Date(); err == nil {
+		parsed.Date = date.UTC()
+	}
+
+	var parts collectedParts
+	if err := walkPart(msg.Header, msg.Body, 0, &parts); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(parts.plain) > 0:
+		parsed.Text = strings.TrimSpace(strings.Join(parts.plain, "\n\n"))
+	case len(parts.html) > 0:
+		parsed.Text = HTMLToText(strings.Join(parts.html, "\n"))
+	}
+	parsed.Attachments = parts.attachments
+	parsed.DeliveryStatus = parts.deliveryStatus
+
+	return parsed, nil
+}
+
+// partHeader — общий интерфейс заголовков письма и MIME-части.
+type partHeader interface {
+	Get(key string) string
+}
+
+type collectedParts struct {
+	plain          []string
+	html           []string
+	attachments    []Attachment
+	deliveryStatus *DeliveryStatus
+}
+
+func walkPart(header partHeader, body io.Reader, depth int, parts *collectedParts) error {
+	if depth > maxPartDepth {
+		return errors.New("mime nesting is too deep")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		boundary := params["boundary"]
+		if boundary == "" {
+			return errors.New("multipart without boundary")
+		}
+
+		mr := multipart.NewReader(body, boundary)
+		for {
+			part, err := mr.NextPart()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("read mime part: %w", err)
+			}
+			err = walkPart(part.Header, part, depth+1, parts)
+			part.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if mediaType == "message/delivery-status" || mediaType == "message/global-delivery-status" {
+		data, err := io.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+		if err != nil {
+			return fmt.Errorf("read %s part: %w", mediaType, err)
+		}
+		if parts.deliveryStatus == nil {
+			parts.deliveryStatus = parseDeliveryStatus(data)
+		}
+		return nil
+	}
+
+	filename, attachment := attachmentName(header, params)
+	isBody := !attachment && (mediaType == "text/plain" || mediaType == "text/html")
+
+	if !isBody && !attachment && mediaType != "message/rfc822" {
+		// Инлайн-картинки без имени и прочие служебные части не сохраняем.
+		return nil
+	}
+
+	data, err := io.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("read %s part: %w", mediaType, err)
+	}
+
+	if !isBody {
+		if filename == "" {
+			filename = "attached.eml"
+		}
+		parts.attachments = append(parts.attachments, Attachment{
+			Filename:    filename,
+			ContentType: mediaType,
+			Data:        data,
+		})
+		return nil
+	}
+
+	text := decodeCharset(data, params["charset"])
+	if mediaType == "text/html" {
+		parts.html = append(parts.html, text)
+	} else {
+		parts.plain = append(parts.plain, text)
+	}
+
+	return nil
+}
+
+// attachmentName определяет, является ли часть вложением, и возвращает имя
+// файла из Content-Disposition или параметра name у Content-Type.
+func attachmentName(header partHeader, contentTypeParams map[string]string) (string, bool) {
+	disposition, params, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err != nil {
+		disposition, params = "", map[string]string{}
+	}
+
+	filename := params["filename"]
+	if filename == "" {
+		filename = contentTypeParams["name"]
+	}
+	filename = decodeHeader(filename)
+
+	return filename, disposition == "attachment" || filename != ""
+}
+
+// decodeTransfer снимает transfer-encoding. multipart.Reader сам декодирует
+// quoted-printable у частей и удаляет заголовок, поэтому повторно не декодируем.
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &whitespaceStripper{r: body})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+func decodeCharset(data []byte, charset string) string {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if charset == "" || charset == "utf-8" || charset == "us-ascii" {
+		return strings.ToValidUTF8(string(data), "�")
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return strings.ToValidUTF8(string(data), "�")
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return strings.ToValidUTF8(string(data), "�")
+	}
+	return string(decoded)
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return strings.TrimSpace(value)
+	}
+	return strings.TrimSpace(decoded)
+}
+
+func parseAddressList(header mail.Header, key string) ([]string, error) {
+	if header.Get(key) == "" {
+		return nil, nil
+	}
+
+	parser := mail.AddressParser{WordDecoder: wordDecoder}
+	list, err := parser.ParseList(header.Get(key))
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(list))
+	for _, a := range list {
+		addrs = append(addrs, strings.ToLower(a.Address))
+	}
+	return addrs, nil
+}
+
+func firstMessageID(value string) string {
+	ids := messageIDs(value)
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[0]
+}
+
+// messageIDs извлекает идентификаторы вида <id@host> из заголовка.
+func messageIDs(value string) []string {
+	var ids []string
+	for {
+		start := strings.IndexByte(value, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(value[start:], '>')
+		if end < 0 {
+			break
+		}
+		// Идентификатор с пробелами или скобками внутри не годится для
+		// заголовков ответа и пропускается.
+		if id := strings.TrimSpace(value[start+1 : start+end]); id != "" && !strings.ContainsAny(id, "< \t\r\n") {
+			ids = append(ids, id)
+		}
+		value = value[start+end+1:]
+	}
+
+	// Некоторые клиенты присылают Message-ID без угловых скобок.
+	if len(ids) == 0 {
+		if v := strings.TrimSpace(value); v != "" && !strings.ContainsAny(v, " \t") {
+			ids = append(ids, v)
+		}
+	}
+	return ids
+}
+
+// whitespaceStripper убирает переводы строк и пробелы из base64-потока.
+type whitespaceStripper struct {
+	r io.Reader
+}
+
+func (w *whitespaceStripper) Read(p []byte) (int, error) {
+	for {
+		n, err := w.r.Read(p)
+		out := bytes.Map(func(r rune) rune {
+			switch r {
+			case '\r', '\n', ' ', '\t':
+				return -1
+			}
+			return r
+		}, p[:n])
+		copy(p, out)
+		if len(out) > 0 || err != nil {
+			return len(out), err
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package mailparse
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// msg собирает письмо из строк, соединяя их CRLF, как в SMTP.
+func msg(lines ...string) string {
+	return strings.Join(lines, "\r\n")
+}
+
+func TestParseBody(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		text string
+	}{
+		{
+			name: "plain utf-8",
+			raw: msg(
+				"From: a@example.com",
+				"Content-Type: text/plain; charset=utf-8",
+				"",
+				"Привет, мир",
+			),
+			text: "Привет, мир",
+		},
+		{
+			name: "no content type",
+			raw: msg(
+				"From: a@example.com",
+				"",
+				"plain body",
+			),
+			text: "plain body",
+		},
+		{
+			name: "quoted-printable with soft line break",
+			raw: msg(
+				"From: a@example.com",
+				"Content-Type: text/plain; charset=utf-8",
+				"Content-Transfer-Encoding: quoted-printable",
+				"",
+				"=D0=94=D0=BE=D0=B1=D1=80=D1=8B=D0=B9=20=D0=B4=D0=B5=D0=BD=D1=8C=2C=20=",
+				"=D0=BA=D0=BE=D0=BB=D0=BB=D0=B5=D0=B3=D0=B8",
+			),
+			text: "Добрый день, коллеги",
+		},
+		{
+			name: "base64 split across lines",
+			raw: msg(
+				"From: a@example.com",
+				"Content-Type: text/plain; charset=utf-8",
+				"Content-Transfer-Encoding: base64",
+				"",
+				"0J/RgNC40LLQtdGC",
+				"LCDQvNC40YA=",
+			),
+			text: "Привет, мир",
+		},
+		{
+			name: "windows-1251",
+			raw: msg(
+				"From: a@example.com",
+				"Content-Type: text/plain; charset=windows-1251",
+				"Content-Transfer-Encoding: 8bit",
+				"",
+				"\xc4\xee\xe1\xf0\xfb\xe9 \xe4\xe5\xed\xfc",
+			),
+			text: "Добрый день",
+		},
+		{
+			name: "koi8-r",
+			raw: msg(
+				"From: a@example.com",
+				"Content-Type: text/plain; charset=\"KOI8-R\"",
+				"",
+				"\xe4\xcf\xc2\xd2\xd9\xca \xc4\xc5\xce\xd8",
+			),
+			text: "Добрый день",
+		},
+		{
+			name: "unknown charset keeps valid utf-8",
+			raw: msg(
+				"From: a@example.com",
+				"Content-Type: text/plain; charset=x-unknown",
+				"",
+				"hello \xff",
+			),
+			text: "hello �",
+		},
+		{
+			name: "html only",
+			raw: msg(
+				"From: a@example.com",
+				"Content-Type: text/html; charset=utf-8",
+				"",
+				"<html><head><title>t</title><style>p{}</style></head>",
+				"<body><p>Счёт&nbsp;№ 5</p><script>x()</script><div>Оплатите &lt;до пятницы&gt;</div></body></html>",
+			),
+			text: "Счёт № 5\n\nОплатите <до пятницы>",
+		},
+		{
+			name: "alternative prefers plain",
+			raw: msg(
+				"From: a@example.com",
+				"Content-Type: multipart/alternative; boundary=alt",
+				"",
+				"--alt",
+				"Content-Type: text/plain; charset=utf-8",
+				"",
+				"plain part",
+				"--alt",
+				"Content-Type: text/html; charset=utf-8",
+				"",
+				"<p>html part</p>",
+				"--alt--",
+			),
+			text: "plain part",
+		},
+		{
+			name: "nested multipart with attachment",
+			raw: msg(
+				"From: a@example.com",
+				"Content-Type: multipart/mixed; boundary=outer",
+				"",
+				"--outer",
+				"Content-Type: multipart/alternative; boundary=inner",
+				"",
+				"--inner",
+				"Content-Type: text/plain; charset=windows-1251",
+				"Content-Transfer-Encoding: quoted-printable",
+				"",
+				"=CF=F0=E8=E2=E5=F2",
+				"--inner",
+				"Content-Type: text/html; charset=utf-8",
+				"",
+				"<p>ignored</p>",
+				"--inner--",
+				"--outer",
+				"Content-Type: text/plain",
+				"Content-Disposition: attachment; filename=notes.txt",
+				"",
+				"attachment text",
+				"--outer",
+				"Content-Type: text/plain; charset=utf-8",
+				"Content-Transfer-Encoding: base64",
+				"",
+				"0J/RgNC40LLQtdGCLCDQvNC40YA=",
+				"--outer--",
+			),
+			text: "Привет\n\nПривет, мир",
+		},
+		{
+			name: "html inside nested multipart",
+			raw: msg(
+				"From: a@example.com",
+				"Content-Type: multipart/mixed; boundary=outer",
+				"",
+				"--outer",
+				"Content-Type: multipart/related; boundary=inner",
+				"",
+				"--inner",
+				"Content-Type: text/html; charset=utf-8",
+				"",
+				"<div>first</div><div>second</div>",
+				"--inner",
+				"Content-Type: image/png",
+				"",
+				"png",
+				"--inner--",
+				"--outer--",
+			),
+			text: "first\n\nsecond",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := Parse(strings.NewReader(tt.raw))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if parsed.Text != tt.text {
+				t.Errorf("Text = %q, want %q", parsed.Text, tt.text)
+			}
+		})
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		subject string
+	}{
+		{name: "plain", header: "Subject: Invoice 42", subject: "Invoice 42"},
+		{name: "utf-8 base64", header: "Subject: =?UTF-8?B?0J/RgNC40LLQtdGC?=", subject: "Привет"},
+		{name: "windows-1251 q", header: "Subject: =?windows-1251?Q?=CF=F0=E8=E2=E5=F2?=", subject: "Привет"},
+		{name: "koi8-r base64", header: "Subject: =?koi8-r?B?896j1CDOwSDP0MzB1NU=?=", subject: "Счёт на оплату"},
+		{
+			name:    "mixed words",
+			header:  "Subject: Re: =?UTF-8?B?0J/RgNC40LLQtdGC?=\r\n =?UTF-8?B?LCDQvNC40YA=?=",
+			subject: "Re: Привет, мир",
+		},
+		{name: "broken word kept as is", header: "Subject: =?x-unknown?Q?abc?=", subject: "=?x-unknown?Q?abc?="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := msg(
+				"From: =?UTF-8?B?0J/RgNC40LLQtdGC?= <Sender@Example.com>",
+				"To: a@example.com, \"B\" <b@example.com>",
+				"Cc: c@example.com",
+				tt.header,
+				"Message-ID: <m1@example.com>",
+				"In-Reply-To: <m0@example.com>",
+				"References: <r1@example.com> <m0@example.com>",
+				"Date: Mon, 02 Jan 2006 15:04:05 +0300",
+				"",
+				"body",
+			)
+			parsed, err := Parse(strings.NewReader(raw))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if parsed.Subject != tt.subject {
+				t.Errorf("Subject = %q, want %q", parsed.Subject, tt.subject)
+			}
+			if parsed.From != "sender@example.com" {
+				t.Errorf("From = %q", parsed.From)
+			}
+			if got := strings.Join(parsed.To, ","); got != "a@example.com,b@example.com" {
+				t.Errorf("To = %q", got)
+			}
+			if got := strings.Join(parsed.Cc, ","); got != "c@example.com" {
+				t.Errorf("Cc = %q", got)
+			}
+			if parsed.MessageID != "m1@example.com" || parsed.InReplyTo != "m0@example.com" {
+				t.Errorf("MessageID = %q, InReplyTo = %q", parsed.MessageID, parsed.InReplyTo)
+			}
+			if got := strings.Join(parsed.References, " "); got != "r1@example.com m0@example.com" {
+				t.Errorf("References = %q", got)
+			}
+			if want := time.Date(2006, 1, 2, 12, 4, 5, 0, time.UTC); !parsed.Date.Equal(want) {
+				t.Errorf("Date = %v, want %v", parsed.Date, want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{name: "no from", raw: msg("To: a@example.com", "", "body")},
+		{name: "bad from", raw: msg("From: not an address", "", "body")},
+		{
+			name: "multipart without boundary",
+			raw:  msg("From: a@example.com", "Content-Type: multipart/mixed", "", "body"),
+		},
+		{
+			name: "too deep",
+			raw: func() string {
+				var b strings.Builder
+				b.WriteString("From: a@example.com\r\nContent-Type: multipart/mixed; boundary=b0\r\n\r\n")
+				for i := 1; i <= maxPartDepth+1; i++ {
+					b.WriteString("--b" + strconv.Itoa(i-1) + "\r\n")
+					b.WriteString("Content-Type: multipart/mixed; boundary=b" + strconv.Itoa(i) + "\r\n\r\n")
+				}
+				return b.String()
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tt.raw)); err == nil {
+				t.Fatal("Parse: want error")
+			}
+		})
+	}
+}
+
+func TestMessageIDs(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "<a@x> <b@x>", want: "a@x b@x"},
+		{value: "  <a@x>\r\n\t<b@x>", want: "a@x b@x"},
+		{value: "a@x", want: "a@x"},
+		{value: "a@x b@x", want: ""},
+		{value: "<>", want: ""},
+		{value: "<a b@x> <c@x>", want: "c@x"},
+		{value: "<<a@x>", want: ""},
+		{value: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := strings.Join(messageIDs(tt.value), " "); got != tt.want {
+			t.Errorf("messageIDs(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
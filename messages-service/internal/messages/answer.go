@@ -0,0 +1,65 @@
+package messages
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ModelAnswer — типизированный model_answer по схеме из systemprompt.txt
+// llm-service. В БД и Kafka по-прежнему хранится исходный JSON.
+type ModelAnswer struct {
+	Category             string     `json:"category"`
+	Urgency              string     `json:"urgency"`
+	FormalityLevel       string     `json:"formality_level"`
+	RequiredApprovers    stringList `json:"required_approvers"`
+	LegalRisks           string     `json:"legal_risks"`
+	RequestSummary       string     `json:"request_summary"`
+	ContactDetails       string     `json:"contact_details"`
+	Requisites           string     `json:"requisites"`
+	RegulatoryReferences stringList `json:"regulatory_references"`
+	SenderExpectations   string     `json:"sender_expectations"`
+	Tags                 stringList `json:"tags"`
+	RecommendedResponse  string     `json:"recommended_response"`
+	MainApprover         string     `json:"main_approver"`
+}
+
+// ParseModelAnswer разбирает model_answer. Поля, которых нет в ответе,
+// остаются пустыми. Urgency приводится к нижнему регистру, чтобы колонка,
+// фильтры и сроки SLA не зависели от написания модели.
+func ParseModelAnswer(raw json.RawMessage) (*ModelAnswer, error) {
+	var answer ModelAnswer
+	if err := json.Unmarshal(raw, &answer); err != nil {
+		return nil, err
+	}
+	answer.Urgency = strings.ToLower(strings.TrimSpace(answer.Urgency))
+	return &answer, nil
+}
+
+// stringList принимает как массив строк, так и одну строку: модель не всегда
+// соблюдает схему для списков.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*l = compact(list)
+		return nil
+	}
+
+	var single string
+	if err := json.Unmarshal(data, &single); err != nil {
+		return err
+	}
+	*l = compact([]string{single})
+	return nil
+}
+
+func compact(values []string) []string {
+	out := values[:0]
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,363 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Режимы согласования: команды подписывают письмо по очереди или одновременно.
+const (
+	ApprovalSequential = "sequential"
+	ApprovalParallel   = "parallel"
+)
+
+// Статусы шага согласования.
+const (
+	StepPending  = "pending"
+	StepApproved = "approved"
+	StepRejected = "rejected"
+	StepSkipped  = "skipped" // шаг больше не нужен: письмо отклонено другой командой
+)
+
+// Решения согласующего.
+const (
+	DecisionApprove = "approve"
+	DecisionReject  = "reject"
+)
+
+// Итоговые статусы письма после согласования.
+const (
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+// ErrInvalidApproval — в запросе на согласование не хватает полей.
+var ErrInvalidApproval = errors.New("invalid approval")
+
+// ErrApprovalConflict — шаг команды уже решён или ещё не его очередь, либо
+// письмо уже утверждено.
+var ErrApprovalConflict = errors.New("approval step is not actionable")
+
+// ApprovalPolicy задаёт, какие команды согласуют письмо и в каком порядке.
+type ApprovalPolicy struct {
+	// DefaultMode — режим для категорий без своей настройки.
+	DefaultMode string
+	// Categories — настройки по category из ответа модели.
+	Categories map[string]CategoryPolicy
+	// LegalTeam подписывает письма из LegalCategories и письма с непустым
+	// legal_risks, если среди согласующих ещё нет команды его департамента.
+	LegalTeam       string
+	LegalCategories []string
+}
+
+type CategoryPolicy struct {
+	Mode       string
+	ExtraTeams []string // команды, которые согласуют письма категории всегда
+}
+
+// ApprovalStep — подпись одной команды. Шаги с одинаковым Order
+// согласуются параллельно, следующий Order открывается после них.
+type ApprovalStep struct {
+	ID        string     `json:"id"`
+	MailID    string     `json:"mail_id"`
+	Order     int        `json:"order"`
+	Team      string     `json:"team"`
+	Status    string     `json:"status"`
+	Approver  string     `json:"approver,omitempty"`
+	Comment   string     `json:"comment,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+}
+
+// ApprovalDecision — решение согласующего по шагу своей команды.
+type ApprovalDecision struct {
+	Team     string
+	Approver string
+	Decision string
+	Comment  string
+}
+
+type ApprovalState struct {
+	MailID string         `json:"mail_id"`
+	Status string         `json:"status"` // pending / approved / rejected
+	Steps  []ApprovalStep `json:"steps"`
+}
+
+// WithApprovalPolicy включает многошаговое согласование по required_approvers.
+// Без политики письмо утверждается одним вызовом ApproveMessage.
+func WithApprovalPolicy(policy ApprovalPolicy) Option {
+	return func(s *Service) {
+		s.approvals = &policy
+	}
+}
+
+// approvalSteps строит шаги согласования: команда очереди письма, затем
+// остальные известные required_approvers, команды категории и, для
+// регуляторных писем и писем с правовыми рисками, юристы.
+func (s *Service) approvalSteps(mb *mailbox, mailID string, answer *ModelAnswer, route Route) []ApprovalStep {
+	if s.approvals == nil || answer == nil {
+		return nil
+	}
+	policy := s.approvals
+	category := policy.Categories[answer.Category]
+
+	var teams []string
+	add := func(team string) {
+		if team == "" || slices.Contains(teams, team) {
+			return
+		}
+		if _, ok := mb.hierarchy.Unit(team); !ok {
+			return
+		}
+		teams = append(teams, team)
+	}
+
+	if route.Queue != mb.defaultQueue {
+		add(route.Queue)
+	}
+	for _, team := range answer.RequiredApprovers {
+		add(team)
+	}
+	for _, team := range category.ExtraTeams {
+		add(team)
+	}
+	if s.needsLegal(answer) {
+		legal, ok := mb.hierarchy.Unit(policy.LegalTeam)
+		covered := slices.ContainsFunc(teams, func(team string) bool {
+			unit, _ := mb.hierarchy.Unit(team)
+			return ok && unit.Department == legal.Department
+		})
+		if !covered {
+			add(policy.LegalTeam)
+		}
+	}
+
+	mode := category.Mode
+	if mode == "" {
+		mode = policy.DefaultMode
+	}
+
+	steps := make([]ApprovalStep, 0, len(teams))
+	for i, team := range teams {
+		order := 1
+		if mode == ApprovalSequential {
+			order = i + 1
+		}
+		steps = append(steps, ApprovalStep{
+			MailID: mailID,
+			Order:  order,
+			Team:   team,
+			Status: StepPending,
+		})
+	}
+	return steps
+}
+
+func (s *Service) needsLegal(answer *ModelAnswer) bool {
+	if s.approvals.LegalTeam == "" {
+		return false
+	}
+	if strings.TrimSpace(answer.LegalRisks) != "" {
+		return true
+	}
+	return slices.Contains(s.approvals.LegalCategories, answer.Category)
+}
+
+// ApproveMessage записывает решение согласующего. Если у письма нет шагов
+// согласования, оно утверждается сразу. Иначе решение применяется к шагу
+// команды dto.Team, и письмо становится approved, только когда подписаны все
+// шаги; отказ любой команды отклоняет письмо. Согласующий — автор запроса из
+// ctx; dto.Approver используется только без аутентификации. Повторный аппрув
+// утверждённого письма ничего не меняет. Возвращает итоговый статус.
+func (s *Service) ApproveMessage(ctx context.Context, dto ApproveDTO) (string, error) {
+	if caller := CallerFromContext(ctx); caller != "" {
+		dto.Approver = caller
+	}
+	if dto.ID == "" {
+		return "", fmt.Errorf("%w: id is empty", ErrInvalidApproval)
+	}
+	decision := dto.Decision
+	if decision == "" {
+		decision = DecisionApprove
+	}
+	if decision != DecisionApprove && decision != DecisionReject {
+		return "", fmt.Errorf("%w: unknown decision %q", ErrInvalidApproval, dto.Decision)
+	}
+
+	steps, err := s.repo.ListApprovalSteps(ctx, dto.ID)
+	if err != nil {
+		return "", fmt.Errorf("list approval steps: %w", err)
+	}
+
+	if len(steps) == 0 {
+		return s.approveWithoutSteps(ctx, dto, decision)
+	}
+
+	if approvalStatus(steps) == StatusApproved && decision == DecisionApprove {
+		// Все шаги уже подписаны, например до повторной обработки: письмо
+		// утверждается, если ещё не утверждено, иначе ничего не происходит.
+		return s.approveWithoutSteps(ctx, dto, decision)
+	}
+
+	team := dto.Team
+	if team == "" {
+		actionable := actionableSteps(steps)
+		if len(actionable) != 1 {
+			return "", fmt.Errorf("%w: team is required", ErrInvalidApproval)
+		}
+		team = actionable[0].Team
+	}
+	if dto.Approver == "" {
+		return "", fmt.Errorf("%w: approver is required", ErrInvalidApproval)
+	}
+	if !slices.ContainsFunc(actionableSteps(steps), func(step ApprovalStep) bool { return step.Team == team }) {
+		return "", fmt.Errorf("%w: team %s", ErrApprovalConflict, team)
+	}
+
+	status, err := s.repo.DecideApprovalStep(ctx, dto.ID, ApprovalDecision{
+		Team:     team,
+		Approver: dto.Approver,
+		Decision: decision,
+		Comment:  dto.Comment,
+	}, s.replies != nil)
+	if err != nil {
+		return "", fmt.Errorf("decide approval step: %w", err)
+	}
+
+	s.log.Info("approval step decided",
+		slog.String("id", dto.ID),
+		slog.String("team", team),
+		slog.String("approver", dto.Approver),
+		slog.String("decision", decision),
+		slog.String("status", status),
+	)
+
+	s.emitDecision(ctx, dto.ID, status, map[string]any{
+		"team":     team,
+		"approver": dto.Approver,
+		"decision": decision,
+	})
+	return status, nil
+}
+
+func (s *Service) approveWithoutSteps(ctx context.Context, dto ApproveDTO, decision string) (string, error) {
+	if decision == DecisionReject {
+		if err := s.repo.RejectMail(ctx, dto.ID); err != nil {
+			return "", fmt.Errorf("reject mail: %w", err)
+		}
+		s.emitDecision(ctx, dto.ID, StatusRejected, map[string]any{"approver": dto.Approver})
+		return StatusRejected, nil
+	}
+
+	approved, err := s.repo.ApproveMail(ctx, dto.ID, s.replies != nil)
+	if err != nil {
+		return "", fmt.Errorf("approve mail: %w", err)
+	}
+	if approved {
+		s.emitDecision(ctx, dto.ID, StatusApproved, map[string]any{"approver": dto.Approver})
+	}
+	return StatusApproved, nil
+}
+
+// emitDecision публикует mail.approved или mail.rejected, когда согласование
+// завершено; промежуточные подписи событий не порождают.
+func (s *Service) emitDecision(ctx context.Context, id, status string, data map[string]any) {
+	var event Event
+	switch status {
+	case StatusApproved:
+		event = NewEvent(EventMailApproved, id)
+	case StatusRejected:
+		event = NewEvent(EventMailRejected, id)
+	default:
+		return
+	}
+	event.Status = status
+	event.Data = data
+	s.emit(ctx, event)
+}
+
+// ResendReply возвращает неотправленный ответ на утверждённое письмо в
+// очередь отправки, например после /add-assistant-response. Повторный
+// аппрув этого не делает: он идемпотентен.
+func (s *Service) ResendReply(ctx context.Context, id string) error {
+	if s.replies == nil {
+		return fmt.Errorf("%w: outbound is disabled", ErrApprovalConflict)
+	}
+	mailEntity, err := s.repo.GetMail(ctx, id)
+	if err != nil {
+		return fmt.Errorf("get mail: %w", err)
+	}
+	if !mailEntity.IsApproved {
+		return fmt.Errorf("%w: mail %s is not approved", ErrApprovalConflict, id)
+	}
+	if err := s.replies.EnqueueReply(ctx, id); err != nil {
+		return fmt.Errorf("enqueue reply: %w", err)
+	}
+	return nil
+}
+
+// GetApprovals возвращает шаги согласования письма и его итоговый статус.
+func (s *Service) GetApprovals(ctx context.Context, id string) (*ApprovalState, error) {
+	mailEntity, err := s.repo.GetMail(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("get mail: %w", err)
+	}
+
+	steps, err := s.repo.ListApprovalSteps(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("list approval steps: %w", err)
+	}
+
+	state := &ApprovalState{MailID: id, Status: approvalStatus(steps), Steps: steps}
+	if len(steps) == 0 {
+		switch {
+		case mailEntity.IsApproved:
+			state.Status = StatusApproved
+		case mailEntity.Status == StatusRejected:
+			state.Status = StatusRejected
+		}
+	}
+	if state.Steps == nil {
+		state.Steps = []ApprovalStep{}
+	}
+	return state, nil
+}
+
+// actionableSteps — ожидающие шаги с наименьшим порядком: только их можно
+// решать сейчас.
+func actionableSteps(steps []ApprovalStep) []ApprovalStep {
+	var actionable []ApprovalStep
+	for _, step := range steps {
+		if step.Status != StepPending {
+			continue
+		}
+		if len(actionable) > 0 && step.Order > actionable[0].Order {
+			continue
+		}
+		if len(actionable) > 0 && step.Order < actionable[0].Order {
+			actionable = actionable[:0]
+		}
+		actionable = append(actionable, step)
+	}
+	return actionable
+}
+
+func approvalStatus(steps []ApprovalStep) string {
+	approved := 0
+	for _, step := range steps {
+		switch step.Status {
+		case StepRejected:
+			return StatusRejected
+		case StepApproved:
+			approved++
+		}
+	}
+	if len(steps) > 0 && approved == len(steps) {
+		return StatusApproved
+	}
+	return StepPending
+}
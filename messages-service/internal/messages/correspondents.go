@@ -0,0 +1,254 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrSenderNotFound — отправителя нет в справочнике корреспондентов.
+var ErrSenderNotFound = errors.New("sender not found")
+
+// Organization — контрагент, найденный по реквизитам из ответа модели.
+type Organization struct {
+	ID   string `json:"id"`
+	INN  string `json:"inn,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// Requisites — ИНН и название организации из поля requisites ответа модели.
+type Requisites struct {
+	INN          string
+	Organization string
+}
+
+func (r Requisites) Empty() bool {
+	return r.INN == "" && r.Organization == ""
+}
+
+// SenderSummary — отправитель со статистикой для списков писем: по ней
+// оператор сразу видит повторного жалобщика или корпоративного клиента.
+type SenderSummary struct {
+	ID                string        `json:"id"`
+	Email             string        `json:"email"`
+	Organization      *Organization `json:"organization,omitempty"`
+	MailCount         int           `json:"mail_count"`
+	ComplaintCount    int           `json:"complaint_count"`
+	RepeatComplainant bool          `json:"repeat_complainant"`
+	CorporateClient   bool          `json:"corporate_client"`
+}
+
+// SenderProfile — карточка отправителя с историей писем, от новых к старым.
+type SenderProfile struct {
+	SenderSummary
+	DisplayName string         `json:"display_name,omitempty"`
+	FirstSeenAt *time.Time     `json:"first_seen_at,omitempty"`
+	LastSeenAt  *time.Time     `json:"last_seen_at,omitempty"`
+	Categories  map[string]int `json:"categories"` // category → число писем
+	Mails       []Mail         `json:"mails"`
+}
+
+// CorrespondentPolicy задаёт, что считать жалобой и повторным жалобщиком.
+type CorrespondentPolicy struct {
+	// ComplaintCategories — категории ответа модели, которые считаются жалобами.
+	ComplaintCategories []string
+	// RepeatComplaints — с какого числа жалоб отправитель повторный жалобщик.
+	RepeatComplaints int
+	// HistoryLimit — сколько последних писем отдавать в карточке отправителя.
+	HistoryLimit int
+}
+
+// DefaultCorrespondentPolicy используется, если WithCorrespondents не задан.
+var DefaultCorrespondentPolicy = CorrespondentPolicy{
+	ComplaintCategories: []string{"жалоба"},
+	RepeatComplaints:    2,
+	HistoryLimit:        50,
+}
+
+// WithCorrespondents задаёт правила статистики по отправителям.
+func WithCorrespondents(policy CorrespondentPolicy) Option {
+	return func(s *Service) {
+		if len(policy.ComplaintCategories) == 0 {
+			policy.ComplaintCategories = DefaultCorrespondentPolicy.ComplaintCategories
+		}
+		if policy.RepeatComplaints <= 0 {
+			policy.RepeatComplaints = DefaultCorrespondentPolicy.RepeatComplaints
+		}
+		if policy.HistoryLimit <= 0 {
+			policy.HistoryLimit = DefaultCorrespondentPolicy.HistoryLimit
+		}
+		s.correspondents = policy
+	}
+}
+
+func (p CorrespondentPolicy) annotate(summary *SenderSummary) {
+	summary.RepeatComplainant = summary.ComplaintCount >= p.RepeatComplaints
+	summary.CorporateClient = summary.Organization != nil && summary.Organization.INN != ""
+}
+
+// SenderAddress возвращает адрес отправителя без имени в нижнем регистре и
+// отображаемое имя из заголовка From.
+func SenderAddress(from string) (email, name string) {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		return strings.ToLower(addr.Address), strings.TrimSpace(addr.Name)
+	}
+	return strings.ToLower(strings.TrimSpace(from)), ""
+}
+
+var (
+	innPattern = regexp.MustCompile(`\b(\d{12}|\d{10})\b`)
+	// Кириллица для \b не буква, поэтому граница перед формой задаётся явно.
+	legalFormPattern = regexp.MustCompile(
+		`(?:^|[^\p{L}])(ФГУП|ГУП|МУП|ООО|ОАО|ЗАО|ПАО|НАО|АО|АНО|НКО|ИП)` +
+			`(?:\s*(?:«([^»]+)»|"([^"]+)"|“([^”]+)”)|\s+([^,;()\n«"“]+))`)
+	spacesPattern = regexp.MustCompile(`\s+`)
+)
+
+// emptyRequisites — что модель пишет вместо реквизитов, если их нет.
+var emptyRequisites = map[string]bool{
+	"пусто": true, "нет": true, "не указано": true, "не указаны": true,
+	"отсутствует": true, "отсутствуют": true, "n/a": true, "none": true, "-": true,
+}
+
+// ParseRequisites извлекает ИНН и название организации из свободного текста
+// поля requisites. ИНН принимается только с верной контрольной суммой;
+// название приводится к виду «ООО «Ромашка»», чтобы одна организация не
+// заводилась дважды из-за кавычек и пробелов.
+func ParseRequisites(text string) Requisites {
+	var req Requisites
+	for _, candidate := range innPattern.FindAllString(text, -1) {
+		if validINN(candidate) {
+			req.INN = candidate
+			break
+		}
+	}
+
+	if m := legalFormPattern.FindStringSubmatch(text); m != nil {
+		form := m[1]
+		name := ""
+		for _, group := range m[2:] {
+			if group != "" {
+				name = group
+				break
+			}
+		}
+		name = strings.Trim(spacesPattern.ReplaceAllString(name, " "), " .")
+		switch {
+		case name == "":
+		case form == "ИП":
+			req.Organization = form + " " + name
+		default:
+			req.Organization = form + " «" + name + "»"
+		}
+		return req
+	}
+
+	// Название без организационно-правовой формы: первая часть без цифр,
+	// чтобы не принять за название «ИНН 7707083893» или «р/с ...».
+	for _, part := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ';' || r == '\n' }) {
+		part = strings.TrimSpace(spacesPattern.ReplaceAllString(part, " "))
+		if part == "" || emptyRequisites[strings.ToLower(part)] || strings.ContainsAny(part, "0123456789") {
+			continue
+		}
+		if len([]rune(part)) > 200 {
+			continue
+		}
+		req.Organization = part
+		break
+	}
+	return req
+}
+
+// validINN проверяет контрольные цифры ИНН юрлица (10 цифр) или физлица (12).
+func validINN(inn string) bool {
+	digits := make([]int, len(inn))
+	for i, r := range inn {
+		digits[i] = int(r - '0')
+	}
+	check := func(weights []int) int {
+		sum := 0
+		for i, w := range weights {
+			sum += w * digits[i]
+		}
+		return sum % 11 % 10
+	}
+
+	switch len(digits) {
+	case 10:
+		return check([]int{2, 4, 10, 3, 5, 9, 4, 6, 8}) == digits[9]
+	case 12:
+		return check([]int{7, 2, 4, 10, 3, 5, 9, 4, 6, 8}) == digits[10] &&
+			check([]int{3, 7, 2, 4, 10, 3, 5, 9, 4, 6, 8}) == digits[11]
+	}
+	return false
+}
+
+// GetSender возвращает карточку отправителя по id или адресу.
+func (s *Service) GetSender(ctx context.Context, key string) (*SenderProfile, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, fmt.Errorf("%w: sender id is empty", ErrInvalidMessage)
+	}
+
+	id := key
+	if strings.Contains(key, "@") {
+		email, _ := SenderAddress(key)
+		found, err := s.repo.FindSenderID(ctx, email)
+		if err != nil {
+			return nil, fmt.Errorf("find sender: %w", err)
+		}
+		if found == "" {
+			return nil, ErrSenderNotFound
+		}
+		id = found
+	} else if _, err := uuid.Parse(key); err != nil {
+		return nil, fmt.Errorf("%w: sender id must be uuid or email", ErrInvalidMessage)
+	}
+
+	profile, err := s.repo.GetSender(ctx, id, s.correspondents.ComplaintCategories, s.correspondents.HistoryLimit)
+	if err != nil {
+		return nil, fmt.Errorf("get sender: %w", err)
+	}
+	if profile == nil {
+		return nil, ErrSenderNotFound
+	}
+	s.correspondents.annotate(&profile.SenderSummary)
+	return profile, nil
+}
+
+// attachSenders добавляет к письмам сводку по отправителю. Без сводки список
+// всё равно отдаётся: она справочная.
+func (s *Service) attachSenders(ctx context.Context, mails []Mail) {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, m := range mails {
+		if m.SenderID != "" && !seen[m.SenderID] {
+			seen[m.SenderID] = true
+			ids = append(ids, m.SenderID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	summaries, err := s.repo.SenderSummaries(ctx, ids, s.correspondents.ComplaintCategories)
+	if err != nil {
+		s.log.Warn("failed to load sender summaries", slog.Any("error", err))
+		return
+	}
+	for i := range mails {
+		summary, ok := summaries[mails[i].SenderID]
+		if !ok {
+			continue
+		}
+		s.correspondents.annotate(&summary)
+		mails[i].Sender = &summary
+	}
+}
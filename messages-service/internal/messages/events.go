@@ -0,0 +1,85 @@
+package messages
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Типы событий жизненного цикла письма.
+const (
+	EventMailQueued                 = "mail.queued"
+	EventMailFiltered               = "mail.filtered"
+	EventMailProcessed              = "mail.processed"
+	EventMailFailed                 = "mail.failed"
+	EventMailApproved               = "mail.approved"
+	EventMailRejected               = "mail.rejected"
+	EventMailSLAAtRisk              = "mail.sla_at_risk"
+	EventMailSLABreached            = "mail.sla_breached"
+	EventMailAssistantResponseAdded = "mail.assistant_response_added"
+)
+
+// Event — событие по письму для внешних подписчиков.
+type Event struct {
+	ID       string         `json:"id"`
+	Type     string         `json:"type"`
+	MailID   string         `json:"mail_id"`
+	TenantID string         `json:"tenant_id,omitempty"` // пусто — тенант письма
+	Team     string         `json:"team,omitempty"`      // очередь письма
+	Status   string         `json:"status,omitempty"`    // статус письма после события
+	Data     map[string]any `json:"data,omitempty"`
+	At       time.Time      `json:"at"`
+}
+
+// EventSink получает события сервиса. Ошибка доставки события не отменяет
+// операцию, которая его породила.
+type EventSink interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// WithEvents подключает получателей событий жизненного цикла писем.
+func WithEvents(sinks ...EventSink) Option {
+	return func(s *Service) {
+		s.events = append(s.events, sinks...)
+	}
+}
+
+// NewEvent заполняет id и время события.
+func NewEvent(eventType, mailID string) Event {
+	return Event{
+		ID:     uuid.NewString(),
+		Type:   eventType,
+		MailID: mailID,
+		At:     time.Now().UTC(),
+	}
+}
+
+func (s *Service) emit(ctx context.Context, event Event) {
+	if event.TenantID == "" {
+		event.TenantID = TenantFromContext(ctx)
+	}
+	for _, sink := range s.events {
+		if err := sink.Publish(ctx, event); err != nil {
+			s.log.Warn("failed to publish mail event",
+				slog.Any("error", err),
+				slog.String("id", event.MailID),
+				slog.String("type", event.Type),
+			)
+		}
+	}
+}
+
+// emitQueued сообщает, что задача по письму отправлена в LLM.
+func (s *Service) emitQueued(ctx context.Context, m *Mail) {
+	event := NewEvent(EventMailQueued, m.ID)
+	event.Team = m.Queue
+	event.Status = "queued"
+	event.Data = map[string]any{
+		"from":      m.From,
+		"subject":   m.Subject,
+		"thread_id": m.ThreadID,
+	}
+	s.emit(ctx, event)
+}
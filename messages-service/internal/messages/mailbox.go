@@ -0,0 +1,208 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"messages-service/internal/org"
+)
+
+// AddressList — список адресов. В JSON принимается и массивом, и строкой с
+// одним адресом: так клиенты, передающие "to" строкой, продолжают работать.
+type AddressList []string
+
+func (l *AddressList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		if strings.TrimSpace(single) == "" {
+			*l = nil
+		} else {
+			*l = AddressList{single}
+		}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("address list must be a string or an array of strings")
+	}
+	*l = list
+	return nil
+}
+
+// MailboxConfig — общий ящик (support@, compliance@, partners@) со своей
+// оргструктурой, промптом, маршрутизацией и SLA. Пустые поля берутся из
+// настроек тенанта ящика.
+type MailboxConfig struct {
+	ID               string
+	Addresses        []string
+	Tenant           string // пусто — DefaultTenant
+	HierarchyPath    string
+	PromptVersion    string
+	DefaultQueue     string
+	DepartmentTopics map[string]string
+	SLA              *SLAPolicy
+}
+
+// mailbox — настройки, с которыми обрабатывается письмо ящика.
+type mailbox struct {
+	id               string
+	tenant           *tenant
+	hierarchy        *org.Hierarchy
+	organization     json.RawMessage // hierarchy для задачи LLM; nil без оргструктуры
+	promptVersion    string
+	defaultQueue     string
+	departmentTopics map[string]string
+	sla              *SLAPolicy
+}
+
+// WithMailboxes задаёт реестр общих ящиков. Письмо относится к ящику по
+// первому совпавшему адресу доставки, To или Cc; письма на другие адреса
+// обрабатываются с настройками тенанта.
+func WithMailboxes(configs []MailboxConfig) Option {
+	return func(s *Service) {
+		s.mailboxConfigs = configs
+	}
+}
+
+// ValidateMailboxes проверяет, что id ящиков и их адреса не повторяются.
+func ValidateMailboxes(configs []MailboxConfig) error {
+	ids := make(map[string]bool, len(configs))
+	owners := make(map[string]string)
+	for _, cfg := range configs {
+		if cfg.ID == "" {
+			return errors.New("mailbox id is empty")
+		}
+		if ids[cfg.ID] {
+			return fmt.Errorf("duplicate mailbox id %q", cfg.ID)
+		}
+		ids[cfg.ID] = true
+		if len(cfg.Addresses) == 0 {
+			return fmt.Errorf("mailbox %s: addresses are empty", cfg.ID)
+		}
+		for _, address := range cfg.Addresses {
+			address = addressOf(address)
+			if owner, ok := owners[address]; ok {
+				return fmt.Errorf("address %s belongs to mailboxes %s and %s", address, owner, cfg.ID)
+			}
+			owners[address] = cfg.ID
+		}
+	}
+	return nil
+}
+
+// initMailboxes собирает реестр после initTenants, когда известны
+// настройки тенантов, которые ящики наследуют.
+func (s *Service) initMailboxes() {
+	s.mailboxes = make(map[string]*mailbox, len(s.mailboxConfigs))
+	s.mailboxByAddress = make(map[string]*mailbox)
+
+	for _, cfg := range s.mailboxConfigs {
+		tenantID := cfg.Tenant
+		if tenantID == "" {
+			tenantID = DefaultTenant
+		}
+		mb := *s.tenant(tenantID).defaults
+		mb.id = cfg.ID
+		if cfg.HierarchyPath != "" {
+			mb.hierarchy = loadHierarchy(cfg.HierarchyPath, s.log.With(slog.String("mailbox", cfg.ID)))
+			mb.organization = s.organizationJSON(mb.hierarchy)
+		}
+		if cfg.PromptVersion != "" {
+			mb.promptVersion = cfg.PromptVersion
+		}
+		if cfg.DefaultQueue != "" {
+			mb.defaultQueue = cfg.DefaultQueue
+		}
+		if cfg.DepartmentTopics != nil {
+			mb.departmentTopics = cfg.DepartmentTopics
+		}
+		if cfg.SLA != nil {
+			mb.sla = cfg.SLA
+		}
+
+		s.mailboxes[cfg.ID] = &mb
+		for _, address := range cfg.Addresses {
+			s.mailboxByAddress[addressOf(address)] = &mb
+		}
+	}
+}
+
+// organizationJSON сериализует оргструктуру ящика для задачи LLM. Без
+// оргструктуры задача идёт без неё, и llm-service берёт свой org.json.
+func (s *Service) organizationJSON(h *org.Hierarchy) json.RawMessage {
+	if h == nil {
+		return nil
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		s.log.Warn("failed to encode hierarchy", slog.Any("error", err))
+		return nil
+	}
+	return data
+}
+
+// mailbox возвращает настройки письма: его ящика, а для пустого или
+// удалённого из конфига ящика — его тенанта.
+func (s *Service) mailbox(m *Mail) *mailbox {
+	if mb, ok := s.mailboxes[m.Mailbox]; ok && mb.tenant.id == m.TenantID {
+		return mb
+	}
+	return s.tenant(m.TenantID).defaults
+}
+
+// assignMailbox определяет ящик и тенанта письма. Ящик — явно указанный,
+// затем по адресам доставки (конверт SMTP, Delivered-To), To и Cc. Письмо
+// запроса тенанта относится только к его ящикам; письмо без тенанта (SMTP,
+// IMAP) получает тенанта ящика, а не попав в ящик — DefaultTenant.
+func (s *Service) assignMailbox(ctx context.Context, m *Mail, dto IncomingMessageDTO) error {
+	tenantID := TenantFromContext(ctx)
+	inTenant := func(mb *mailbox) bool {
+		return tenantID == "" || mb.tenant.id == tenantID
+	}
+
+	if dto.Mailbox != "" {
+		mb, ok := s.mailboxes[dto.Mailbox]
+		if !ok || !inTenant(mb) {
+			return fmt.Errorf("unknown mailbox %q", dto.Mailbox)
+		}
+		m.Mailbox = mb.id
+		m.TenantID = mb.tenant.id
+		return nil
+	}
+
+	for _, list := range [][]string{dto.DeliveredTo, m.To, m.Cc} {
+		for _, address := range list {
+			if mb, ok := s.mailboxByAddress[addressOf(address)]; ok && inTenant(mb) {
+				m.Mailbox = mb.id
+				m.TenantID = mb.tenant.id
+				return nil
+			}
+		}
+	}
+
+	m.TenantID = requestTenant(ctx)
+	return nil
+}
+
+// knownQueue — есть ли очередь с таким id в каком-нибудь ящике тенанта.
+func (s *Service) knownQueue(tenantID, team string) bool {
+	t := s.tenant(tenantID)
+	candidates := []*mailbox{t.defaults}
+	for _, mb := range s.mailboxes {
+		if mb.tenant == t {
+			candidates = append(candidates, mb)
+		}
+	}
+
+	for _, mb := range candidates {
+		if _, ok := mb.hierarchy.Unit(team); ok || team == mb.defaultQueue {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package messages
+
+import (
+	"context"
+	"log/slog"
+)
+
+// StatusFiltered — конечный статус письма, отсеянного префильтром без
+// обращения к LLM.
+const StatusFiltered = "filtered"
+
+// Виды срабатывания префильтра.
+const (
+	FilterAutoReply    = "auto_reply"
+	FilterBounce       = "bounce"
+	FilterBulk         = "bulk"
+	FilterDeniedSender = "denied_sender"
+	FilterRule         = "rule"
+)
+
+// FilterVerdict — причина, по которой письмо не отправляется в LLM.
+type FilterVerdict struct {
+	Kind   string
+	Reason string
+}
+
+// PreFilter решает до отправки задачи в LLM, нужна ли письму модель:
+// автоответы, bounce-и, рассылки и явный спам отсеиваются заранее.
+type PreFilter interface {
+	// Check возвращает nil, если письмо нужно отправить в LLM.
+	Check(ctx context.Context, m *Mail) (*FilterVerdict, error)
+}
+
+// WithPreFilter включает предварительную фильтрацию входящих писем.
+func WithPreFilter(f PreFilter) Option {
+	return func(s *Service) {
+		s.prefilter = f
+	}
+}
+
+// applyPreFilter помечает письмо как отсеянное, если префильтр его не
+// пропустил. Ошибка префильтра не задерживает письмо: оно уходит в LLM.
+func (s *Service) applyPreFilter(ctx context.Context, m *Mail) bool {
+	if s.prefilter == nil {
+		return false
+	}
+
+	verdict, err := s.prefilter.Check(ctx, m)
+	if err != nil {
+		s.log.Warn("prefilter failed, sending mail to llm",
+			slog.Any("error", err),
+			slog.String("id", m.ID),
+		)
+		return false
+	}
+	if verdict == nil {
+		return false
+	}
+
+	m.Status = StatusFiltered
+	m.FilterKind = verdict.Kind
+	m.FilterReason = verdict.Reason
+	return true
+}
+
+// emitFiltered сообщает, что письмо отсеяно префильтром.
+func (s *Service) emitFiltered(ctx context.Context, m *Mail) {
+	s.log.Info("incoming message filtered",
+		slog.String("id", m.ID),
+		slog.String("kind", m.FilterKind),
+		slog.String("reason", m.FilterReason),
+	)
+
+	event := NewEvent(EventMailFiltered, m.ID)
+	event.Status = StatusFiltered
+	event.Data = map[string]any{
+		"from":      m.From,
+		"subject":   m.Subject,
+		"thread_id": m.ThreadID,
+		"kind":      m.FilterKind,
+		"reason":    m.FilterReason,
+	}
+	s.emit(ctx, event)
+}
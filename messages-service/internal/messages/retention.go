@@ -0,0 +1,99 @@
+package messages
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// forgetBatchSize — сколько писем удалять одним запросом при удалении данных
+// отправителя.
+const forgetBatchSize = 500
+
+// ForgetResult — итог удаления данных отправителя.
+type ForgetResult struct {
+	Email   string `json:"email"`
+	Deleted int    `json:"deleted"`
+	Held    int    `json:"held"` // письма на legal hold, они не удалены
+}
+
+// SetLegalHold ставит письмо на legal hold или снимает его. Пока hold стоит,
+// письмо не трогают ни правила хранения, ни удаление по запросу отправителя.
+func (s *Service) SetLegalHold(ctx context.Context, id string, hold bool, reason string) error {
+	if id == "" {
+		return fmt.Errorf("%w: id is empty", ErrInvalidMessage)
+	}
+	if err := s.repo.SetLegalHold(ctx, id, hold, reason); err != nil {
+		return fmt.Errorf("set legal hold: %w", err)
+	}
+
+	s.log.Info("legal hold updated",
+		slog.String("id", id),
+		slog.Bool("legal_hold", hold),
+		slog.String("reason", reason),
+	)
+	return nil
+}
+
+// ForgetSender удаляет все письма отправителя по запросу на удаление
+// персональных данных: сами письма, вложения в BlobStore, события, шаги
+// согласования, исходящие ответы, ответы модели в кэше и запись в
+// справочнике корреспондентов.
+func (s *Service) ForgetSender(ctx context.Context, email string) (*ForgetResult, error) {
+	email = addressOf(email)
+	if email == "" || !strings.Contains(email, "@") {
+		return nil, fmt.Errorf("%w: invalid email", ErrInvalidMessage)
+	}
+
+	ids, held, err := s.repo.ListSenderMails(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("list sender mails: %w", err)
+	}
+
+	result := &ForgetResult{Email: email, Held: held}
+	for start := 0; start < len(ids); start += forgetBatchSize {
+		batch := ids[start:min(start+forgetBatchSize, len(ids))]
+
+		// Ключ кэша считается по содержимому письма, поэтому его нужно
+		// получить до удаления.
+		s.forgetCached(ctx, batch)
+
+		deleted, keys, err := s.repo.DeleteMails(ctx, batch)
+		if err != nil {
+			return result, fmt.Errorf("delete mails: %w", err)
+		}
+		result.Deleted += deleted
+		s.deleteBlobs(ctx, keys)
+	}
+
+	// Письма на legal hold по-прежнему ссылаются на отправителя, поэтому
+	// запись о нём остаётся вместе с ними.
+	if result.Held == 0 {
+		if err := s.repo.DeleteSender(ctx, email); err != nil {
+			return result, fmt.Errorf("delete sender: %w", err)
+		}
+	}
+
+	s.log.Info("sender data deleted",
+		slog.Int("deleted", result.Deleted),
+		slog.Int("held", result.Held),
+	)
+	return result, nil
+}
+
+func (s *Service) forgetCached(ctx context.Context, ids []string) {
+	if s.cache == nil {
+		return
+	}
+	for _, id := range ids {
+		m, err := s.repo.GetMail(ctx, id)
+		if err != nil {
+			s.log.Warn("failed to get mail for cache removal", slog.Any("error", err), slog.String("id", id))
+			continue
+		}
+		if err := s.cache.Delete(ctx, s.cacheKey(m)); err != nil {
+			s.log.Warn("failed to delete llm result from cache", slog.Any("error", err), slog.String("id", id))
+		}
+	}
+}
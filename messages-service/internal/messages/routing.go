@@ -0,0 +1,81 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// DefaultQueue — очередь для писем, главного согласующего которых нет в оргструктуре.
+const DefaultQueue = "unassigned"
+
+// ErrUnknownTeam возвращается для запроса очереди несуществующей команды.
+var ErrUnknownTeam = errors.New("unknown team")
+
+// Route — очередь, в которую попадает обработанное письмо.
+type Route struct {
+	Queue      string // id команды или департамента главного согласующего
+	Department string // id департамента; пусто для DefaultQueue
+}
+
+// WithRouting задаёт очередь для нераспознанных согласующих и топики Kafka
+// по департаментам. Обработанные письма департамента из departmentTopics
+// публикуются и в общий output_topic, и в свой топик.
+func WithRouting(defaultQueue string, departmentTopics map[string]string) Option {
+	return func(s *Service) {
+		if defaultQueue != "" {
+			s.defaultQueue = defaultQueue
+		}
+		s.departmentTopics = departmentTopics
+	}
+}
+
+// route выбирает очередь по main_approver, а если его нет в оргструктуре
+// ящика — по первому известному из required_approvers. answer равен nil,
+// если model_answer не удалось разобрать.
+func (s *Service) route(mb *mailbox, id string, answer *ModelAnswer) Route {
+	if answer == nil {
+		return Route{Queue: mb.defaultQueue}
+	}
+
+	candidates := append([]string{answer.MainApprover}, answer.RequiredApprovers...)
+	for _, approver := range candidates {
+		if unit, ok := mb.hierarchy.Unit(approver); ok {
+			return Route{Queue: unit.ID, Department: unit.Department}
+		}
+	}
+
+	s.log.Warn("main approver not found in hierarchy",
+		slog.String("id", id),
+		slog.String("mailbox", mb.id),
+		slog.String("main_approver", answer.MainApprover),
+	)
+	return Route{Queue: mb.defaultQueue}
+}
+
+// GetInbox возвращает письма, ожидающие решения в очереди команды. Для id
+// департамента возвращаются письма всех его команд. По умолчанию первыми идут
+// письма с ближайшим SLA-дедлайном.
+func (s *Service) GetInbox(ctx context.Context, team string, filter ListFilter) ([]Mail, error) {
+	if team == "" {
+		return nil, fmt.Errorf("%w: team is empty", ErrUnknownTeam)
+	}
+	if !s.knownQueue(requestTenant(ctx), team) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTeam, team)
+	}
+
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+	if filter.Sort == "" {
+		filter.Sort = SortSLADeadline
+	}
+
+	mails, err := s.repo.ListInbox(ctx, team, filter)
+	if err != nil {
+		return nil, fmt.Errorf("list inbox: %w", err)
+	}
+	s.attachSenders(ctx, mails)
+	return mails, nil
+}
@@ -0,0 +1,81 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Размер страницы результатов поиска.
+const (
+	DefaultSearchLimit = 50
+	MaxSearchLimit     = 200
+)
+
+// ErrSearchUnavailable — хранилище не может искать по письмам: при
+// шифровании полей search_vector строится из пустых колонок.
+var ErrSearchUnavailable = errors.New("full-text search is unavailable with field encryption")
+
+// SearchQuery — запрос полнотекстового поиска по письмам. Text разбирается
+// как websearch_to_tsquery: слова, "фразы в кавычках", OR и -исключения.
+// Остальные поля — необязательные фильтры.
+type SearchQuery struct {
+	Text           string
+	Classification string
+	Category       string // category из ответа модели
+	Mailbox        string // id общего ящика
+	Team           string // queue или department письма
+	Status         string
+	SLAStates      []string
+	From           string // подстрока адреса отправителя
+	ReceivedFrom   *time.Time
+	ReceivedTo     *time.Time
+	Limit          int
+	Offset         int
+}
+
+// SearchResult — найденное письмо с релевантностью и фрагментами: текст
+// фрагментов экранирован как HTML, совпадения обёрнуты в <mark>.
+type SearchResult struct {
+	Mail
+	Rank      float64         `json:"rank"`
+	Highlight SearchHighlight `json:"highlight"`
+}
+
+type SearchHighlight struct {
+	Subject        string `json:"subject,omitempty"`
+	Input          string `json:"input,omitempty"`
+	RequestSummary string `json:"request_summary,omitempty"`
+}
+
+// SearchMails ищет письма по тексту, теме и полям ответа модели
+// (request_summary, tags, requisites) с учётом русской и английской морфологии.
+func (s *Service) SearchMails(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	query.Text = strings.TrimSpace(query.Text)
+	if query.Text == "" {
+		return nil, fmt.Errorf("%w: q is empty", ErrInvalidFilter)
+	}
+	for _, state := range query.SLAStates {
+		switch state {
+		case SLAOk, SLAAtRisk, SLABreached, SLAMet, SLARejected:
+		default:
+			return nil, fmt.Errorf("%w: unknown sla_state %q", ErrInvalidFilter, state)
+		}
+	}
+	if query.ReceivedFrom != nil && query.ReceivedTo != nil && query.ReceivedTo.Before(*query.ReceivedFrom) {
+		return nil, fmt.Errorf("%w: received_to is before received_from", ErrInvalidFilter)
+	}
+	if query.Limit <= 0 {
+		query.Limit = DefaultSearchLimit
+	}
+	query.Limit = min(query.Limit, MaxSearchLimit)
+	query.Offset = max(query.Offset, 0)
+
+	results, err := s.repo.SearchMails(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("search mails: %w", err)
+	}
+	return results, nil
+}
@@ -2,15 +2,25 @@ package messages
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/mail"
-	"os"
+	"net/textproto"
+	"sort"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/google/uuid"
+
+	"messages-service/internal/extract"
+	"messages-service/internal/mailparse"
+	"messages-service/internal/org"
 )
 
 type Repository interface {
@@ -18,45 +28,231 @@ type Repository interface {
 	GetMail(ctx context.Context, id string) (*Mail, error)
 	IncrementAttempts(ctx context.Context, id string) error
 	MarkAsFailed(ctx context.Context, id string, reason string) error
-	SaveLLMResult(ctx context.Context, id string, classification string, modelAnswer json.RawMessage) error
-	ListProcessed(ctx context.Context) ([]Mail, error)
-	ApproveMail(ctx context.Context, id string) error
+	// SaveLLMResult сохраняет ответ модели, очередь и заново создаёт
+	// ожидающие шаги согласования; принятые решения сохраняются.
+	SaveLLMResult(ctx context.Context, id string, result LLMResult) error
+	ListProcessed(ctx context.Context, filter ListFilter) ([]Mail, error)
+	// ApproveMail утверждает письмо; с queueReply в той же транзакции ставит
+	// ответ отправителю в очередь исходящей почты. Уже утверждённое письмо не
+	// меняется, и тогда возвращается false.
+	ApproveMail(ctx context.Context, id string, queueReply bool) (bool, error)
+	// RejectMail отклоняет письмо; утверждённое письмо не меняется, и тогда
+	// возвращается ErrApprovalConflict.
+	RejectMail(ctx context.Context, id string) error
 	SaveAssistantResponse(ctx context.Context, id string, response json.RawMessage, markProcessed bool) error
+	ResetForReprocessing(ctx context.Context, id string) error
+	// CreateMails вставляет письма одной транзакцией и возвращает id реально
+	// вставленных строк; письма с уже существующим id пропускаются.
+	CreateMails(ctx context.Context, mails []*Mail) (map[string]bool, error)
+	ListAttachments(ctx context.Context, mailID string) ([]Attachment, error)
+	GetAttachment(ctx context.Context, id string) (*Attachment, error)
+	// FindThread возвращает id ветки по ссылкам из заголовков или по теме;
+	// пустая строка — ветка не найдена.
+	FindThread(ctx context.Context, lookup ThreadLookup) (string, error)
+	// ListThread возвращает письма ветки в порядке получения.
+	ListThread(ctx context.Context, threadID string) ([]ThreadMail, error)
+	// ListInbox возвращает неутверждённые обработанные письма очереди команды
+	// или всех команд департамента, от старых к новым.
+	ListInbox(ctx context.Context, team string, filter ListFilter) ([]Mail, error)
+	ListApprovalSteps(ctx context.Context, mailID string) ([]ApprovalStep, error)
+	// DecideApprovalStep записывает решение по ожидающему шагу команды и
+	// возвращает итоговый статус согласования письма. Отказ отклоняет письмо
+	// и пропускает оставшиеся шаги; после последней подписи письмо утверждается,
+	// а с queueReply в той же транзакции ответ ставится в очередь.
+	DecideApprovalStep(ctx context.Context, mailID string, decision ApprovalDecision, queueReply bool) (string, error)
+	// SearchMails ищет письма полнотекстовым поиском, от релевантных к менее
+	// релевантным.
+	SearchMails(ctx context.Context, query SearchQuery) ([]SearchResult, error)
+	SetLegalHold(ctx context.Context, id string, hold bool, reason string) error
+	// ListSenderMails возвращает id писем отправителя, кроме стоящих на
+	// legal hold, и число пропущенных из-за legal hold.
+	ListSenderMails(ctx context.Context, email string) ([]string, int, error)
+	// DeleteMails удаляет письма со всеми связанными данными и возвращает
+	// число удалённых писем и ключи их вложений в BlobStore.
+	DeleteMails(ctx context.Context, ids []string) (int, []string, error)
+	// FindSenderID возвращает id отправителя по адресу в нижнем регистре;
+	// пустая строка — отправитель не найден.
+	FindSenderID(ctx context.Context, email string) (string, error)
+	// GetSender возвращает карточку отправителя с historyLimit последними
+	// письмами; nil — отправитель не найден.
+	GetSender(ctx context.Context, id string, complaintCategories []string, historyLimit int) (*SenderProfile, error)
+	// SenderSummaries возвращает статистику по отправителям, ключ — id.
+	SenderSummaries(ctx context.Context, ids []string, complaintCategories []string) (map[string]SenderSummary, error)
+	// DeleteSender удаляет отправителя, если у него не осталось писем, и его
+	// организацию, если к ней больше никто не привязан.
+	DeleteSender(ctx context.Context, email string) error
+	// ReserveTenantMails атомарно списывает n писем с суточного счётчика
+	// тенанта; false — квота limit не позволяет, ничего не списано.
+	ReserveTenantMails(ctx context.Context, tenant string, day time.Time, n, limit int) (bool, error)
+	// ReleaseTenantMails возвращает на счётчик тенанта n списанных писем.
+	ReleaseTenantMails(ctx context.Context, tenant string, day time.Time, n int) error
+}
+
+// LLMResult — провалидированный ответ модели вместе с результатами маршрутизации.
+type LLMResult struct {
+	Classification string
+	ModelAnswer    json.RawMessage
+	// Answer — разобранный ModelAnswer для колонок category, urgency и др.;
+	// nil, если ответ не разобрался.
+	Answer        *ModelAnswer
+	Route         Route
+	ApprovalSteps []ApprovalStep
+	// SLA — срок обработки от received_at; 0 — без SLA.
+	SLA time.Duration
+	// Requisites — реквизиты из ответа модели для привязки отправителя к
+	// организации.
+	Requisites Requisites
+}
+
+// ListFilter — фильтры и сортировка списков писем.
+type ListFilter struct {
+	SLAStates    []string // пусто — любые
+	Mailbox      string   // id общего ящика
+	Category     string
+	Urgency      string
+	MainApprover string
+	Tags         []string // письмо должно содержать все теги
+	LegalRisks   *bool    // true — только с непустым legal_risks, false — только без
+	Sort         string   // SortUpdatedAt / SortReceivedAt / SortSLADeadline
+}
+
+// Сортировки списков писем.
+const (
+	SortUpdatedAt   = "updated_at"   // сначала недавно изменённые
+	SortReceivedAt  = "received_at"  // сначала старые
+	SortSLADeadline = "sla_deadline" // сначала ближайший дедлайн, письма без SLA в конце
+)
+
+// BlobStore хранит содержимое вложений; в БД лежат только метаданные и ключ.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// ProducerMessage — ключ и тело сообщения для пакетной отправки.
+type ProducerMessage struct {
+	Key   string
+	Value []byte
 }
 
 type Producer interface {
 	Send(ctx context.Context, topic string, key string, value []byte) error
+	SendBatch(ctx context.Context, topic string, msgs []ProducerMessage) error
+}
+
+// ReplyQueue ставит ответ на утверждённое письмо в очередь исходящей почты.
+type ReplyQueue interface {
+	EnqueueReply(ctx context.Context, mailID string) error
+}
+
+// ResultCache хранит провалидированные ответы LLM по хэшу содержимого письма.
+// Get возвращает nil без ошибки, если записи нет или она устарела.
+type ResultCache interface {
+	Get(ctx context.Context, key string) (*CachedResult, error)
+	Set(ctx context.Context, key string, result CachedResult) error
+	Delete(ctx context.Context, key string) error
+}
+
+type CachedResult struct {
+	Classification string          `json:"classification"`
+	ModelAnswer    json.RawMessage `json:"model_answer"`
 }
 
 type Mail struct {
 	ID             string          // UUID
+	TenantID       string          // tenant_id, дочерняя компания-владелец письма
+	ThreadID       string          // thread_id, id первого письма ветки
+	SenderID       string          // sender_id, отправитель в справочнике корреспондентов
 	Input          string          // текст письма
 	From           string          // from_email
-	To             string          // to_email
+	To             []string        // to_emails
+	Cc             []string        // cc
+	Mailbox        string          // mailbox, id общего ящика; пусто — настройки сервиса
+	Subject        string          // subject
+	MessageID      string          // message_id из заголовка Message-ID
+	InReplyTo      string          // in_reply_to
+	References     []string        // mail_references, цепочка References
 	ReceivedAt     time.Time       // received_at
 	Attempts       int             // attempts
-	Status         string          // new / processed / failed / error ...
+	Status         string          // new / processed / failed / filtered ...
 	Classification string          // класс письма (important/normal/...)
+	Queue          string          // queue, команда главного согласующего
+	Department     string          // department, департамент очереди
+	SLADeadline    *time.Time      // sla_deadline, срок решения по письму
+	SLAState       string          // sla_state: ok / at_risk / breached / met / rejected
+	Category       string          // category из ответа модели
+	Urgency        string          // urgency из ответа модели
+	MainApprover   string          // main_approver из ответа модели
+	Tags           []string        // tags из ответа модели
+	LegalRisks     string          // legal_risks из ответа модели
 	ModelAnswer    json.RawMessage // сырой json с ответом модели
 	AssistantResp  json.RawMessage // ответ ассистента, если он добавлен вручную
 	Processed      bool            // processed flag
 	IsApproved     bool            // оператор утвердил ответ
 	FailedReason   string          // причина фейла, если статус failed
+	FilterKind     string          // filter_kind, вид срабатывания префильтра
+	FilterReason   string          // filter_reason, если статус filtered
 	UpdatedAt      time.Time       // updated_at
+	Attachments    []Attachment    // вложения из таблицы attachments
+	Sender         *SenderSummary  // сводка по отправителю, есть в списках писем
+
+	// Headers и DeliveryStatus есть только у только что принятого письма и
+	// не сохраняются: они нужны префильтру.
+	Headers        map[string]string
+	DeliveryStatus *mailparse.DeliveryStatus
+}
+
+type Attachment struct {
+	ID            string    // UUID
+	MailID        string    // mail_id
+	Filename      string    // имя файла из письма
+	ContentType   string    // MIME-тип
+	Size          int64     // размер в байтах
+	SHA256        string    // hex sha256 содержимого
+	StorageKey    string    // ключ в BlobStore
+	ExtractedText string    // текст, извлечённый для LLM (пусто для бинарных форматов)
+	CreatedAt     time.Time // created_at
+}
+
+type AttachmentDTO struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"` // base64 в JSON
 }
 
 type IncomingMessageDTO struct {
-	ID         string    `json:"id,omitempty"`
-	Input      string    `json:"input"`
-	From       string    `json:"from"`
-	To         string    `json:"to"`
-	ReceivedAt time.Time `json:"received_at,omitempty"`
+	ID         string      `json:"id,omitempty"`
+	Input      string      `json:"input"`
+	From       string      `json:"from"`
+	To         AddressList `json:"to"`
+	Cc         AddressList `json:"cc,omitempty"`
+	Subject    string      `json:"subject,omitempty"`
+	MessageID  string      `json:"message_id,omitempty"`
+	InReplyTo  string      `json:"in_reply_to,omitempty"`
+	References []string    `json:"references,omitempty"`
+	ReceivedAt time.Time   `json:"received_at,omitempty"`
+	// Headers — исходные заголовки письма (Auto-Submitted, Precedence,
+	// List-Id и т. п.), по которым работает префильтр.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Mailbox — id общего ящика, если он известен заранее (ящик IMAP);
+	// иначе ящик определяется по DeliveredTo, To и Cc.
+	Mailbox string `json:"mailbox,omitempty"`
+	// DeliveredTo — адреса доставки: получатели из конверта SMTP или
+	// заголовков Delivered-To / X-Original-To. Не сохраняются.
+	DeliveredTo AddressList `json:"delivered_to,omitempty"`
+
+	Attachments []AttachmentDTO `json:"attachments,omitempty"`
+
+	// DeliveryStatus заполняется при разборе .eml, если письмо — отчёт о недоставке.
+	DeliveryStatus *mailparse.DeliveryStatus `json:"-"`
 }
 
 type ValidateMessageDTO struct {
 	ID             string          `json:"id"`
 	Classification string          `json:"classification"`
 	ModelAnswer    json.RawMessage `json:"model_answer"`
+	Source         string          `json:"source,omitempty"` // live / stub, проставляется llm-service
 }
 
 type AssistantResponseDTO struct {
@@ -66,39 +262,178 @@ type AssistantResponseDTO struct {
 }
 
 type ApproveDTO struct {
-	ID string `json:"id"`
+	ID       string `json:"id"`
+	Team     string `json:"team,omitempty"`     // команда, от имени которой принимается решение
+	Approver string `json:"approver,omitempty"` // кто принял решение
+	Decision string `json:"decision,omitempty"` // approve (по умолчанию) / reject
+	Comment  string `json:"comment,omitempty"`
+}
+
+// Статусы элементов пакетной загрузки.
+const (
+	BatchStatusQueued    = "queued"
+	BatchStatusCached    = "cached"
+	BatchStatusFiltered  = "filtered"
+	BatchStatusDuplicate = "duplicate"
+	BatchStatusInvalid   = "invalid"
+	BatchStatusError     = "error"
+)
+
+type BatchItemResult struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type ReprocessDTO struct {
+	ID          string `json:"id"`
+	BypassCache bool   `json:"bypass_cache"`
 }
 
 type LLMTaskMessage struct {
 	ID         string    `json:"id"`
+	TenantID   string    `json:"tenant_id"`
+	Subject    string    `json:"subject,omitempty"`
 	Input      string    `json:"input"`
 	From       string    `json:"from"`
-	To         string    `json:"to"`
+	To         []string  `json:"to"`
+	Cc         []string  `json:"cc,omitempty"`
 	ReceivedAt time.Time `json:"received_at"`
+
+	// Mailbox и PromptVersion — общий ящик письма и версия промпта для него.
+	Mailbox       string `json:"mailbox,omitempty"`
+	PromptVersion string `json:"prompt_version,omitempty"`
+	// Organization — оргструктура ящика в формате файла org; llm-service
+	// подставляет её в промпт вместо своего org.json.
+	Organization json.RawMessage `json:"organization,omitempty"`
+	// Provider и Model — бэкенд и модель LLM тенанта.
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+
+	Attachments []LLMAttachment `json:"attachments,omitempty"`
+
+	ThreadID string             `json:"thread_id,omitempty"`
+	Thread   []LLMThreadMessage `json:"thread,omitempty"` // предыдущие письма ветки, от старых к новым
+}
+
+// LLMAttachment — текст вложения, передаваемый модели вместе с телом письма.
+type LLMAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Text        string `json:"text"`
 }
 
 type ProcessedMessage struct {
 	ID             string          `json:"id"`
+	TenantID       string          `json:"tenant_id"`
 	Classification string          `json:"classification"`
 	ModelAnswer    json.RawMessage `json:"model_answer"`
+	Queue          string          `json:"queue"`
+	Department     string          `json:"department,omitempty"`
 }
 
 type FailedMessage struct {
 	ID        string          `json:"id"`
+	TenantID  string          `json:"tenant_id"`
 	Reason    string          `json:"reason"`
 	Timestamp time.Time       `json:"timestamp"`
 	Payload   json.RawMessage `json:"payload,omitempty"`
 }
 
+// SourceStub помечает ответы, которые llm-service сформировал без обращения к модели.
+const SourceStub = "stub"
+
+// ErrInvalidMessage оборачивает ошибки разбора и валидации входящего письма.
+var ErrInvalidMessage = errors.New("invalid message")
+
+// ErrNotQueued означает, что письмо сохранено, но задача LLM не отправлена в
+// Kafka. Такое письмо можно переотправить через ReprocessMessage.
+var ErrNotQueued = errors.New("mail stored but not queued")
+
+// ErrStubAnswer возвращается, если пришёл ответ-заглушка, а приём заглушек запрещён.
+var ErrStubAnswer = errors.New("stub llm answers are not allowed")
+
 type Service struct {
-	repo            Repository
-	producer        Producer
-	log             *slog.Logger
-	maxAttempts     int
-	inputTopic      string
-	outputTopic     string
-	deadLetterTopic string
-	hierarchy       map[string]any
+	repo             Repository
+	producer         Producer
+	log              *slog.Logger
+	maxAttempts      int
+	inputTopic       string
+	outputTopic      string
+	deadLetterTopic  string
+	hierarchy        *org.Hierarchy
+	allowStubAnswers bool
+	cache            ResultCache
+	promptVersion    string
+	provider         string
+	model            string
+	blobs            BlobStore
+	maxTextChars     int
+	replies          ReplyQueue
+	defaultQueue     string
+	departmentTopics map[string]string
+	approvals        *ApprovalPolicy
+	sla              *SLAPolicy
+	events           []EventSink
+	prefilter        PreFilter
+	correspondents   CorrespondentPolicy
+	usage            UsageLimiter
+
+	tenantConfigs []TenantConfig
+	tenants       map[string]*tenant
+
+	mailboxConfigs   []MailboxConfig
+	mailboxes        map[string]*mailbox
+	mailboxByAddress map[string]*mailbox
+
+	threadWindow          time.Duration
+	threadContextMessages int
+	threadContextChars    int
+}
+
+// Option настраивает необязательные параметры сервиса.
+type Option func(*Service)
+
+// WithStubAnswers разрешает или запрещает приём ответов-заглушек llm-service.
+func WithStubAnswers(allowed bool) Option {
+	return func(s *Service) {
+		s.allowStubAnswers = allowed
+	}
+}
+
+// WithResultCache включает кэш ответов LLM. Версия промпта и модель входят в
+// ключ, чтобы смена любой из них не отдавала устаревшие ответы.
+func WithResultCache(cache ResultCache, promptVersion, model string) Option {
+	return func(s *Service) {
+		s.cache = cache
+		s.promptVersion = promptVersion
+		s.model = model
+	}
+}
+
+// WithPromptVersion задаёт версию промпта, которая передаётся в задаче LLM;
+// у общих ящиков она может быть своей.
+func WithPromptVersion(version string) Option {
+	return func(s *Service) {
+		s.promptVersion = version
+	}
+}
+
+// WithAttachments задаёт хранилище вложений и лимит текста, извлекаемого из
+// одного вложения для LLM.
+func WithAttachments(blobs BlobStore, maxTextChars int) Option {
+	return func(s *Service) {
+		s.blobs = blobs
+		s.maxTextChars = maxTextChars
+	}
+}
+
+// WithReplyQueue включает отправку ответа отправителю после аппрува.
+func WithReplyQueue(replies ReplyQueue) Option {
+	return func(s *Service) {
+		s.replies = replies
+	}
 }
 
 func NewService(
@@ -108,10 +443,11 @@ func NewService(
 	maxAttempts int,
 	inputTopic, outputTopic, deadLetterTopic string,
 	hierarchyPath string,
+	opts ...Option,
 ) *Service {
 	hierarchy := loadHierarchy(hierarchyPath, log)
 
-	return &Service{
+	s := &Service{
 		repo:            repo,
 		producer:        producer,
 		log:             log,
@@ -120,27 +456,363 @@ func NewService(
 		outputTopic:     outputTopic,
 		deadLetterTopic: deadLetterTopic,
 		hierarchy:       hierarchy,
+		defaultQueue:    DefaultQueue,
+		correspondents:  DefaultCorrespondentPolicy,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	s.initTenants()
+	s.initMailboxes()
+
+	return s
 }
 
 func (s *Service) ProcessIncomingMessage(ctx context.Context, dto IncomingMessageDTO) (string, error) {
-	if dto.Input == "" {
-		return "", errors.New("input is empty")
+	mailEntity, err := newMail(dto)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	if err := s.assignMailbox(ctx, mailEntity, dto); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	id := mailEntity.ID
+
+	// Письмо без тенанта в запросе (SMTP, IMAP) сохраняется в сессии тенанта
+	// своего ящика.
+	ctx = ContextWithTenant(ctx, mailEntity.TenantID)
+	reservation, err := s.reserveQuota(ctx, s.tenant(mailEntity.TenantID), 1)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.storeAttachments(ctx, mailEntity, dto.Attachments); err != nil {
+		s.releaseQuota(ctx, reservation, 1)
+		return "", err
+	}
+	s.assignThread(ctx, mailEntity)
+	filtered := s.applyPreFilter(ctx, mailEntity)
+	s.startSLA(mailEntity)
+
+	if err := s.repo.CreateMail(ctx, mailEntity); err != nil {
+		s.deleteBlobs(context.WithoutCancel(ctx), attachmentKeys(mailEntity))
+		s.releaseQuota(ctx, reservation, 1)
+		s.log.Error("failed to save mail",
+			slog.Any("error", err),
+			slog.String("id", id),
+		)
+		return "", fmt.Errorf("save mail: %w", err)
+	}
+
+	if filtered {
+		s.emitFiltered(ctx, mailEntity)
+		return id, nil
+	}
+
+	if hit, err := s.applyCachedResult(ctx, mailEntity); err != nil {
+		return "", err
+	} else if hit {
+		return id, nil
+	}
+
+	if err := s.sendLLMTask(ctx, mailEntity); err != nil {
+		return id, fmt.Errorf("%w: %v", ErrNotQueued, err)
+	}
+
+	s.log.Info("incoming message queued for llm",
+		slog.String("id", id),
+		slog.String("topic", s.inputTopic),
+	)
+
+	return id, nil
+}
+
+// ProcessRawMessage принимает письмо в формате RFC 5322 (.eml), извлекает
+// заголовки и текстовое тело и передаёт его в обычный путь приёма.
+func (s *Service) ProcessRawMessage(ctx context.Context, raw io.Reader) (string, error) {
+	dto, err := RawToDTO(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	return s.ProcessIncomingMessage(ctx, dto)
+}
+
+// RawToDTO разбирает письмо RFC 5322 во входящий DTO.
+func RawToDTO(raw io.Reader) (IncomingMessageDTO, error) {
+	parsed, err := mailparse.Parse(raw)
+	if err != nil {
+		return IncomingMessageDTO{}, fmt.Errorf("parse raw message: %w", err)
 	}
-	if dto.From == "" || dto.To == "" {
-		return "", errors.New("from/to must be set")
+	attachments := make([]AttachmentDTO, 0, len(parsed.Attachments))
+	for _, a := range parsed.Attachments {
+		attachments = append(attachments, AttachmentDTO{
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Data:        a.Data,
+		})
+	}
+
+	// Без заголовка To получатели остаются пустыми: вызывающий может взять их
+	// из конверта, иначе письмо не пройдёт валидацию.
+	dto := IncomingMessageDTO{
+		Input:      parsed.Text,
+		From:       parsed.From,
+		To:         parsed.To,
+		Cc:         parsed.Cc,
+		Subject:    parsed.Subject,
+		MessageID:  parsed.MessageID,
+		InReplyTo:  parsed.InReplyTo,
+		References: parsed.References,
+		Headers:    make(map[string]string, len(parsed.Header)),
+
+		Attachments:    attachments,
+		DeliveryStatus: parsed.DeliveryStatus,
+	}
+	for key, values := range parsed.Header {
+		if len(values) > 0 {
+			dto.Headers[key] = values[0]
+		}
+	}
+	for _, key := range []string{"Delivered-To", "X-Original-To"} {
+		for _, value := range parsed.Header[key] {
+			if addr, err := mail.ParseAddress(value); err == nil {
+				dto.DeliveredTo = append(dto.DeliveredTo, addr.Address)
+			}
+		}
+	}
+	if !parsed.Date.IsZero() && parsed.Date.Before(time.Now()) {
+		dto.ReceivedAt = parsed.Date
+	}
+
+	return dto, nil
+}
+
+// ProcessIncomingBatch сохраняет пакет писем одной транзакцией и публикует
+// задачи LLM одним вызовом. Ошибки отдельных писем не прерывают пакет и
+// возвращаются в результатах по каждому элементу.
+func (s *Service) ProcessIncomingBatch(ctx context.Context, dtos []IncomingMessageDTO) ([]BatchItemResult, error) {
+	// Пакет целиком принадлежит тенанту запроса.
+	ctx = ContextWithTenant(ctx, requestTenant(ctx))
+
+	results := make([]BatchItemResult, len(dtos))
+	valid := make([]*Mail, 0, len(dtos))
+	indexByID := make(map[string]int, len(dtos))
+
+	for i, dto := range dtos {
+		results[i] = BatchItemResult{Index: i, ID: dto.ID}
+
+		mailEntity, err := newMail(dto)
+		if err == nil {
+			err = s.assignMailbox(ctx, mailEntity, dto)
+		}
+		if err != nil {
+			results[i].Status = BatchStatusInvalid
+			results[i].Reason = err.Error()
+			continue
+		}
+		results[i].ID = mailEntity.ID
+
+		if _, seen := indexByID[mailEntity.ID]; seen {
+			results[i].Status = BatchStatusDuplicate
+			results[i].Reason = "duplicate id in batch"
+			continue
+		}
+		indexByID[mailEntity.ID] = i
+		valid = append(valid, mailEntity)
+	}
+
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	// С квоты списываются только прошедшие проверку письма; те, что потом не
+	// сохранились, возвращаются.
+	reservation, err := s.reserveQuota(ctx, s.tenant(requestTenant(ctx)), len(valid))
+	if err != nil {
+		return nil, err
+	}
+
+	mails := make([]*Mail, 0, len(valid))
+	threadByMessageID := make(map[string]string)
+
+	for _, mailEntity := range valid {
+		i := indexByID[mailEntity.ID]
+		if err := s.storeAttachments(ctx, mailEntity, dtos[i].Attachments); err != nil {
+			results[i].Status = BatchStatusError
+			results[i].Reason = err.Error()
+			continue
+		}
+
+		// Ответ на письмо из этого же пакета ещё не найдётся в БД.
+		if threadID := batchThread(threadByMessageID, mailEntity); threadID != "" {
+			mailEntity.ThreadID = threadID
+		} else {
+			s.assignThread(ctx, mailEntity)
+		}
+		if mailEntity.MessageID != "" {
+			threadByMessageID[mailEntity.MessageID] = mailEntity.ThreadID
+		}
+		s.applyPreFilter(ctx, mailEntity)
+		s.startSLA(mailEntity)
+
+		mails = append(mails, mailEntity)
+	}
+	s.releaseQuota(ctx, reservation, len(valid)-len(mails))
+
+	if len(mails) == 0 {
+		return results, nil
+	}
+
+	inserted, err := s.repo.CreateMails(ctx, mails)
+	if err != nil {
+		s.deleteBlobs(context.WithoutCancel(ctx), attachmentKeys(mails...))
+		s.releaseQuota(ctx, reservation, len(mails))
+		s.log.Error("failed to save mail batch",
+			slog.Any("error", err),
+			slog.Int("size", len(mails)),
+		)
+		return nil, fmt.Errorf("save mail batch: %w", err)
+	}
+
+	toQueue := make([]*Mail, 0, len(inserted))
+	existing := 0
+	for _, m := range mails {
+		i := indexByID[m.ID]
+		if !inserted[m.ID] {
+			// Вложения выгружены под ключами нового письма, а оно не вставлено.
+			s.deleteBlobs(ctx, attachmentKeys(m))
+			existing++
+			results[i].Status = BatchStatusDuplicate
+			results[i].Reason = "mail with this id already exists"
+			continue
+		}
+		if m.Status == StatusFiltered {
+			results[i].Status = BatchStatusFiltered
+			results[i].Reason = m.FilterReason
+			s.emitFiltered(ctx, m)
+			continue
+		}
+
+		hit, err := s.applyCachedResult(ctx, m)
+		switch {
+		case err != nil:
+			results[i].Status = BatchStatusError
+			results[i].Reason = err.Error()
+		case hit:
+			results[i].Status = BatchStatusCached
+		default:
+			toQueue = append(toQueue, m)
+		}
+	}
+
+	s.releaseQuota(ctx, reservation, existing)
+
+	if len(toQueue) == 0 {
+		return results, nil
+	}
+
+	batch := make([]ProducerMessage, 0, len(toQueue))
+	tasks := make([]LLMTaskMessage, 0, len(toQueue))
+	for _, m := range toQueue {
+		task := s.newLLMTask(m)
+		task.Thread = s.threadContext(ctx, m)
+		data, err := json.Marshal(task)
+		if err != nil {
+			return nil, fmt.Errorf("marshal llm task: %w", err)
+		}
+		batch = append(batch, ProducerMessage{Key: m.ID, Value: data})
+		tasks = append(tasks, task)
+	}
+
+	status, reason := BatchStatusQueued, ""
+	if err := s.producer.SendBatch(ctx, s.inputTopic, batch); err != nil {
+		// Письма уже сохранены со статусом new — их можно переотправить через /reprocess.
+		s.log.Error("failed to send llm task batch to kafka",
+			slog.Any("error", err),
+			slog.String("topic", s.inputTopic),
+			slog.Int("size", len(batch)),
+		)
+		status, reason = BatchStatusError, "saved but not queued: "+err.Error()
+	} else {
+		s.recordTokens(ctx, tasks...)
+	}
+	for _, m := range toQueue {
+		i := indexByID[m.ID]
+		results[i].Status = status
+		results[i].Reason = reason
+		if status == BatchStatusQueued {
+			s.emitQueued(ctx, m)
+		}
+	}
+
+	s.log.Info("incoming batch processed",
+		slog.Int("size", len(dtos)),
+		slog.Int("inserted", len(inserted)),
+		slog.Int("queued", len(toQueue)),
+	)
+
+	return results, nil
+}
+
+func batchThread(threadByMessageID map[string]string, m *Mail) string {
+	for _, ref := range threadRefs(m) {
+		if threadID, ok := threadByMessageID[ref]; ok {
+			return threadID
+		}
+	}
+	return ""
+}
+
+// newMail валидирует входящее письмо и собирает сущность для сохранения.
+// ValidMessageID проверяет идентификатор из Message-ID, In-Reply-To или
+// References без угловых скобок. Он попадает в заголовки ответа, поэтому
+// переводы строк, пробелы и скобки в нём запрещены: иначе через него можно
+// дописать в ответ свои заголовки.
+func ValidMessageID(id string) bool {
+	return id != "" && !strings.ContainsFunc(id, func(r rune) bool {
+		return r == '<' || r == '>' || unicode.IsSpace(r) || unicode.IsControl(r)
+	})
+}
+
+func newMail(dto IncomingMessageDTO) (*Mail, error) {
+	if dto.Input == "" && len(dto.Attachments) == 0 {
+		return nil, errors.New("input is empty")
+	}
+	if dto.From == "" || len(dto.To) == 0 {
+		return nil, errors.New("from/to must be set")
 	}
 
 	if _, err := mail.ParseAddress(dto.From); err != nil {
-		return "", fmt.Errorf("invalid from address: %w", err)
+		return nil, fmt.Errorf("invalid from address: %w", err)
 	}
-	if _, err := mail.ParseAddress(dto.To); err != nil {
-		return "", fmt.Errorf("invalid to address: %w", err)
+	for _, to := range dto.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return nil, fmt.Errorf("invalid to address: %w", err)
+		}
+	}
+	for _, cc := range dto.Cc {
+		if _, err := mail.ParseAddress(cc); err != nil {
+			return nil, fmt.Errorf("invalid cc address: %w", err)
+		}
+	}
+
+	if dto.MessageID != "" && !ValidMessageID(dto.MessageID) {
+		return nil, fmt.Errorf("invalid message_id %q", dto.MessageID)
+	}
+	if dto.InReplyTo != "" && !ValidMessageID(dto.InReplyTo) {
+		return nil, fmt.Errorf("invalid in_reply_to %q", dto.InReplyTo)
+	}
+	for _, ref := range dto.References {
+		if !ValidMessageID(ref) {
+			return nil, fmt.Errorf("invalid references item %q", ref)
+		}
 	}
 
 	id := dto.ID
 	if id == "" {
 		id = uuid.NewString()
+	} else if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("invalid id: %w", err)
 	}
 
 	receivedAt := dto.ReceivedAt
@@ -148,58 +820,331 @@ func (s *Service) ProcessIncomingMessage(ctx context.Context, dto IncomingMessag
 		receivedAt = time.Now().UTC()
 	}
 
-	mailEntity := &Mail{
+	headers := make(map[string]string, len(dto.Headers))
+	for key, value := range dto.Headers {
+		headers[textproto.CanonicalMIMEHeaderKey(key)] = value
+	}
+
+	return &Mail{
 		ID:         id,
 		Input:      dto.Input,
 		From:       dto.From,
 		To:         dto.To,
+		Cc:         dto.Cc,
+		Subject:    dto.Subject,
+		MessageID:  dto.MessageID,
+		InReplyTo:  dto.InReplyTo,
+		References: dto.References,
 		ReceivedAt: receivedAt,
 		Attempts:   0,
 		Status:     "new",
 		Processed:  false,
 		IsApproved: false,
+
+		Headers:        headers,
+		DeliveryStatus: dto.DeliveryStatus,
+	}, nil
+}
+
+func (s *Service) newLLMTask(m *Mail) LLMTaskMessage {
+	mb := s.mailbox(m)
+	task := LLMTaskMessage{
+		ID:            m.ID,
+		TenantID:      m.TenantID,
+		Subject:       m.Subject,
+		Input:         m.Input,
+		From:          m.From,
+		To:            m.To,
+		Cc:            m.Cc,
+		ReceivedAt:    m.ReceivedAt,
+		Mailbox:       m.Mailbox,
+		PromptVersion: mb.promptVersion,
+		Organization:  mb.organization,
+		Provider:      mb.tenant.provider,
+		Model:         mb.tenant.model,
+	}
+	if m.ThreadID != m.ID {
+		task.ThreadID = m.ThreadID
+	}
+	for _, a := range m.Attachments {
+		if a.ExtractedText == "" {
+			continue
+		}
+		task.Attachments = append(task.Attachments, LLMAttachment{
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Text:        a.ExtractedText,
+		})
 	}
+	return task
+}
 
-	if err := s.repo.CreateMail(ctx, mailEntity); err != nil {
-		s.log.Error("failed to save mail",
-			slog.Any("error", err),
-			slog.String("id", id),
-		)
-		return "", fmt.Errorf("save mail: %w", err)
+// storeAttachments выгружает содержимое вложений в BlobStore, извлекает из
+// них текст и заполняет mailEntity.Attachments. Метаданные сохраняются в БД
+// вместе с письмом в CreateMail; если письмо не сохранилось, вызывающий
+// удаляет выгруженное по attachmentKeys.
+func (s *Service) storeAttachments(ctx context.Context, mailEntity *Mail, dtos []AttachmentDTO) error {
+	for _, dto := range dtos {
+		sum := sha256.Sum256(dto.Data)
+		contentType := dto.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		a := Attachment{
+			ID:          uuid.NewString(),
+			MailID:      mailEntity.ID,
+			Filename:    dto.Filename,
+			ContentType: contentType,
+			Size:        int64(len(dto.Data)),
+			SHA256:      hex.EncodeToString(sum[:]),
+		}
+
+		if s.blobs != nil {
+			a.StorageKey = mailEntity.ID + "/" + a.ID
+			if err := s.blobs.Put(ctx, a.StorageKey, dto.Data, contentType); err != nil {
+				s.deleteBlobs(context.WithoutCancel(ctx), attachmentKeys(mailEntity))
+				mailEntity.Attachments = nil
+				s.log.Error("failed to store attachment",
+					slog.Any("error", err),
+					slog.String("id", mailEntity.ID),
+					slog.String("filename", dto.Filename),
+				)
+				return fmt.Errorf("store attachment %q: %w", dto.Filename, err)
+			}
+		}
+
+		text, err := extract.Text(dto.Filename, contentType, dto.Data, s.maxTextChars)
+		switch {
+		case err == nil:
+			a.ExtractedText = text
+		case !errors.Is(err, extract.ErrUnsupported):
+			s.log.Warn("failed to extract attachment text",
+				slog.Any("error", err),
+				slog.String("id", mailEntity.ID),
+				slog.String("filename", dto.Filename),
+			)
+		}
+
+		mailEntity.Attachments = append(mailEntity.Attachments, a)
 	}
 
-	task := LLMTaskMessage{
-		ID:         id,
-		Input:      mailEntity.Input,
-		From:       mailEntity.From,
-		To:         mailEntity.To,
-		ReceivedAt: mailEntity.ReceivedAt,
+	return nil
+}
+
+// attachmentKeys возвращает ключи выгруженных вложений писем.
+func attachmentKeys(mails ...*Mail) []string {
+	var keys []string
+	for _, m := range mails {
+		for _, a := range m.Attachments {
+			if a.StorageKey != "" {
+				keys = append(keys, a.StorageKey)
+			}
+		}
+	}
+	return keys
+}
+
+// deleteBlobs удаляет содержимое вложений; ошибки только журналируются.
+func (s *Service) deleteBlobs(ctx context.Context, keys []string) {
+	if s.blobs == nil {
+		return
+	}
+	for _, key := range keys {
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			s.log.Warn("failed to delete attachment blob", slog.Any("error", err), slog.String("key", key))
+		}
 	}
+}
+
+func (s *Service) ListAttachments(ctx context.Context, mailID string) ([]Attachment, error) {
+	attachments, err := s.repo.ListAttachments(ctx, mailID)
+	if err != nil {
+		return nil, fmt.Errorf("list attachments: %w", err)
+	}
+	return attachments, nil
+}
+
+// GetAttachmentContent возвращает метаданные вложения и его содержимое из BlobStore.
+func (s *Service) GetAttachmentContent(ctx context.Context, id string) (*Attachment, []byte, error) {
+	a, err := s.repo.GetAttachment(ctx, id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get attachment: %w", err)
+	}
+	if s.blobs == nil || a.StorageKey == "" {
+		return nil, nil, errors.New("attachment content is not stored")
+	}
+
+	data, err := s.blobs.Get(ctx, a.StorageKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read attachment blob: %w", err)
+	}
+	return a, data, nil
+}
+
+// ReprocessMessage повторно отправляет письмо в LLM по запросу оператора.
+// С BypassCache=true кэш не читается, а новый ответ модели перезапишет запись в кэше.
+func (s *Service) ReprocessMessage(ctx context.Context, dto ReprocessDTO) error {
+	if dto.ID == "" {
+		return errors.New("id is empty")
+	}
+
+	mailEntity, err := s.repo.GetMail(ctx, dto.ID)
+	if err != nil {
+		return fmt.Errorf("get mail: %w", err)
+	}
+	if err := s.reserveIngest(ctx, 0); err != nil {
+		return err
+	}
+
+	if err := s.repo.ResetForReprocessing(ctx, dto.ID); err != nil {
+		return fmt.Errorf("reset mail: %w", err)
+	}
+
+	if !dto.BypassCache {
+		if hit, err := s.applyCachedResult(ctx, mailEntity); err != nil {
+			return err
+		} else if hit {
+			return nil
+		}
+	}
+
+	if err := s.sendLLMTask(ctx, mailEntity); err != nil {
+		return err
+	}
+
+	s.log.Info("message requeued for reprocessing",
+		slog.String("id", dto.ID),
+		slog.Bool("bypass_cache", dto.BypassCache),
+	)
+
+	return nil
+}
+
+func (s *Service) sendLLMTask(ctx context.Context, mailEntity *Mail) error {
+	task := s.newLLMTask(mailEntity)
+	task.Thread = s.threadContext(ctx, mailEntity)
 
 	data, err := json.Marshal(task)
 	if err != nil {
 		s.log.Error("failed to marshal llm task",
 			slog.Any("error", err),
-			slog.String("id", id),
+			slog.String("id", mailEntity.ID),
 		)
-		return "", fmt.Errorf("marshal llm task: %w", err)
+		return fmt.Errorf("marshal llm task: %w", err)
 	}
 
-	if err := s.producer.Send(ctx, s.inputTopic, id, data); err != nil {
+	if err := s.producer.Send(ctx, s.inputTopic, mailEntity.ID, data); err != nil {
 		s.log.Error("failed to send llm task to kafka",
 			slog.Any("error", err),
-			slog.String("id", id),
+			slog.String("id", mailEntity.ID),
 			slog.String("topic", s.inputTopic),
 		)
-		return "", fmt.Errorf("send to kafka: %w", err)
+		return fmt.Errorf("send to kafka: %w", err)
 	}
 
-	s.log.Info("incoming message queued for llm",
-		slog.String("id", id),
-		slog.String("topic", s.inputTopic),
-	)
+	s.recordTokens(ctx, task)
+	s.emitQueued(ctx, mailEntity)
+	return nil
+}
 
-	return id, nil
+// applyCachedResult сохраняет ответ из кэша вместо вызова LLM. Возвращает
+// true, если ответ найден и письмо обработано. Ошибки чтения кэша не критичны.
+func (s *Service) applyCachedResult(ctx context.Context, mailEntity *Mail) (bool, error) {
+	if s.cache == nil {
+		return false, nil
+	}
+
+	cached, err := s.cache.Get(ctx, s.cacheKey(mailEntity))
+	if err != nil {
+		s.log.Warn("failed to read llm result cache",
+			slog.Any("error", err),
+			slog.String("id", mailEntity.ID),
+		)
+		return false, nil
+	}
+	if cached == nil {
+		return false, nil
+	}
+
+	dto := ValidateMessageDTO{
+		ID:             mailEntity.ID,
+		Classification: cached.Classification,
+		ModelAnswer:    cached.ModelAnswer,
+	}
+	if err := s.acceptResult(ctx, mailEntity, dto); err != nil {
+		return false, err
+	}
+
+	s.log.Info("llm result served from cache", slog.String("id", mailEntity.ID))
+
+	return true, nil
+}
+
+func (s *Service) storeCachedResult(ctx context.Context, mailEntity *Mail, dto ValidateMessageDTO) {
+	if s.cache == nil {
+		return
+	}
+
+	result := CachedResult{
+		Classification: dto.Classification,
+		ModelAnswer:    dto.ModelAnswer,
+	}
+	if err := s.cache.Set(ctx, s.cacheKey(mailEntity), result); err != nil {
+		s.log.Warn("failed to store llm result in cache",
+			slog.Any("error", err),
+			slog.String("id", dto.ID),
+		)
+	}
+}
+
+// cacheKey учитывает хэши вложений: одинаковый текст с разными вложениями —
+// разные письма для модели. Для ответов в существующей ветке в ключ входит
+// id ветки: модель видела предыдущую переписку, и её ответ не подходит для
+// такого же текста без контекста. Кэш общий для тенантов, поэтому в ключ
+// входит тенант; для default его нет, чтобы не сбрасывать прежние записи.
+// Оргструктура ящика тоже входит в ключ: по ней модель выбирает согласующих.
+func (s *Service) cacheKey(m *Mail) string {
+	mb := s.mailbox(m)
+	h := sha256.New()
+	if m.TenantID != DefaultTenant {
+		h.Write([]byte("tenant:" + m.TenantID))
+		h.Write([]byte{0})
+	}
+	h.Write([]byte(mb.promptVersion))
+	h.Write([]byte{0})
+	h.Write([]byte(mb.tenant.model))
+	h.Write([]byte{0})
+	h.Write(mb.organization)
+	h.Write([]byte{0})
+	h.Write([]byte(normalizeInput(m.Input)))
+	if m.ThreadID != "" && m.ThreadID != m.ID {
+		h.Write([]byte{0})
+		h.Write([]byte("thread:" + m.ThreadID))
+	}
+
+	hashes := make([]string, 0, len(m.Attachments))
+	for _, a := range m.Attachments {
+		hashes = append(hashes, a.SHA256)
+	}
+	sort.Strings(hashes)
+	for _, sum := range hashes {
+		h.Write([]byte{0})
+		h.Write([]byte(sum))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeInput убирает различия, не влияющие на смысл письма: переводы
+// строк, пробелы по краям и повторяющиеся пробелы.
+func normalizeInput(input string) string {
+	input = strings.ReplaceAll(input, "\r\n", "\n")
+	lines := strings.Split(input, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
 }
 
 func (s *Service) ValidateProcessedMessage(ctx context.Context, dto ValidateMessageDTO) error {
@@ -207,6 +1152,11 @@ func (s *Service) ValidateProcessedMessage(ctx context.Context, dto ValidateMess
 		return errors.New("id is empty")
 	}
 
+	if isStubAnswer(dto) && !s.allowStubAnswers {
+		s.log.Warn("stub llm answer rejected", slog.String("id", dto.ID))
+		return ErrStubAnswer
+	}
+
 	if err := s.validateLLMOutput(dto); err != nil {
 		s.log.Warn("llm output validation failed",
 			slog.String("id", dto.ID),
@@ -215,7 +1165,50 @@ func (s *Service) ValidateProcessedMessage(ctx context.Context, dto ValidateMess
 		return s.handleInvalidLLMOutput(ctx, dto, err)
 	}
 
-	if err := s.repo.SaveLLMResult(ctx, dto.ID, dto.Classification, dto.ModelAnswer); err != nil {
+	mailEntity, err := s.repo.GetMail(ctx, dto.ID)
+	if err != nil {
+		return fmt.Errorf("get mail: %w", err)
+	}
+	// Сервисный ключ воркера LLM не привязан к тенанту: ответ сохраняется
+	// в сессии тенанта письма.
+	ctx = ContextWithTenant(ctx, mailEntity.TenantID)
+
+	if err := s.acceptResult(ctx, mailEntity, dto); err != nil {
+		return err
+	}
+
+	s.storeCachedResult(ctx, mailEntity, dto)
+
+	return nil
+}
+
+// acceptResult сохраняет провалидированный ответ вместе с очередью команды и
+// публикует его в output_topic и топик департамента, если он задан.
+func (s *Service) acceptResult(ctx context.Context, mailEntity *Mail, dto ValidateMessageDTO) error {
+	mb := s.mailbox(mailEntity)
+	outputTopic := mb.tenant.outputTopic
+	answer, err := ParseModelAnswer(dto.ModelAnswer)
+	if err != nil {
+		s.log.Warn("failed to parse model answer for routing",
+			slog.Any("error", err),
+			slog.String("id", dto.ID),
+		)
+		answer = nil
+	}
+	route := s.route(mb, dto.ID, answer)
+
+	result := LLMResult{
+		Classification: dto.Classification,
+		ModelAnswer:    dto.ModelAnswer,
+		Answer:         answer,
+		Route:          route,
+		ApprovalSteps:  s.approvalSteps(mb, dto.ID, answer, route),
+		SLA:            mb.slaDuration(answer),
+	}
+	if answer != nil {
+		result.Requisites = ParseRequisites(answer.Requisites)
+	}
+	if err := s.repo.SaveLLMResult(ctx, dto.ID, result); err != nil {
 		s.log.Error("failed to save llm result",
 			slog.Any("error", err),
 			slog.String("id", dto.ID),
@@ -225,8 +1218,11 @@ func (s *Service) ValidateProcessedMessage(ctx context.Context, dto ValidateMess
 
 	msg := ProcessedMessage{
 		ID:             dto.ID,
+		TenantID:       mailEntity.TenantID,
 		Classification: dto.Classification,
 		ModelAnswer:    dto.ModelAnswer,
+		Queue:          route.Queue,
+		Department:     route.Department,
 	}
 
 	data, err := json.Marshal(msg)
@@ -238,21 +1234,46 @@ func (s *Service) ValidateProcessedMessage(ctx context.Context, dto ValidateMess
 		return fmt.Errorf("marshal processed message: %w", err)
 	}
 
-	if err := s.producer.Send(ctx, s.outputTopic, dto.ID, data); err != nil {
+	if err := s.producer.Send(ctx, outputTopic, dto.ID, data); err != nil {
 		s.log.Error("failed to send processed message to kafka",
 			slog.Any("error", err),
 			slog.String("id", dto.ID),
-			slog.String("topic", s.outputTopic),
+			slog.String("topic", outputTopic),
 		)
 		return fmt.Errorf("send processed to kafka: %w", err)
 	}
 
+	if topic := mb.departmentTopics[route.Department]; topic != "" && topic != outputTopic {
+		if err := s.producer.Send(ctx, topic, dto.ID, data); err != nil {
+			s.log.Error("failed to send processed message to department topic",
+				slog.Any("error", err),
+				slog.String("id", dto.ID),
+				slog.String("topic", topic),
+			)
+			return fmt.Errorf("send processed to department topic: %w", err)
+		}
+	}
+
 	s.log.Info("llm result accepted",
 		slog.String("id", dto.ID),
 		slog.String("classification", dto.Classification),
-		slog.String("topic", s.outputTopic),
+		slog.String("queue", route.Queue),
+		slog.String("topic", outputTopic),
 	)
 
+	event := NewEvent(EventMailProcessed, dto.ID)
+	event.Team = route.Queue
+	event.Status = "processed"
+	event.Data = map[string]any{
+		"classification": dto.Classification,
+		"department":     route.Department,
+	}
+	if answer != nil {
+		event.Data["category"] = answer.Category
+		event.Data["urgency"] = answer.Urgency
+	}
+	s.emit(ctx, event)
+
 	return nil
 }
 
@@ -272,6 +1293,22 @@ func (s *Service) validateLLMOutput(dto ValidateMessageDTO) error {
 	return nil
 }
 
+// isStubAnswer распознаёт заглушку как по явному полю source, так и по метке
+// внутри model_answer (если воркер переложил ответ llm-service целиком).
+func isStubAnswer(dto ValidateMessageDTO) bool {
+	if dto.Source == SourceStub {
+		return true
+	}
+
+	var marker struct {
+		Source string `json:"source"`
+	}
+	if err := json.Unmarshal(dto.ModelAnswer, &marker); err != nil {
+		return false
+	}
+	return marker.Source == SourceStub
+}
+
 func (s *Service) handleInvalidLLMOutput(ctx context.Context, dto ValidateMessageDTO, validationErr error) error {
 	mailEntity, err := s.repo.GetMail(ctx, dto.ID)
 	if err != nil {
@@ -281,6 +1318,7 @@ func (s *Service) handleInvalidLLMOutput(ctx context.Context, dto ValidateMessag
 		)
 		return fmt.Errorf("get mail: %w", err)
 	}
+	ctx = ContextWithTenant(ctx, mailEntity.TenantID)
 
 	currentAttempts := mailEntity.Attempts
 
@@ -299,6 +1337,7 @@ func (s *Service) handleInvalidLLMOutput(ctx context.Context, dto ValidateMessag
 
 		failedMsg := FailedMessage{
 			ID:        dto.ID,
+			TenantID:  mailEntity.TenantID,
 			Reason:    reason,
 			Timestamp: time.Now().UTC(),
 			Payload:   failedPayload,
@@ -327,6 +1366,11 @@ func (s *Service) handleInvalidLLMOutput(ctx context.Context, dto ValidateMessag
 			slog.Int("attempts", currentAttempts+1),
 		)
 
+		event := NewEvent(EventMailFailed, dto.ID)
+		event.Status = "failed"
+		event.Data = map[string]any{"reason": reason}
+		s.emit(ctx, event)
+
 		return nil
 	}
 
@@ -338,30 +1382,15 @@ func (s *Service) handleInvalidLLMOutput(ctx context.Context, dto ValidateMessag
 		return fmt.Errorf("increment attempts: %w", err)
 	}
 
-	task := LLMTaskMessage{
-		ID:         mailEntity.ID,
-		Input:      mailEntity.Input,
-		From:       mailEntity.From,
-		To:         mailEntity.To,
-		ReceivedAt: mailEntity.ReceivedAt,
-	}
-
-	data, err := json.Marshal(task)
-	if err != nil {
-		s.log.Error("failed to marshal llm retry task",
-			slog.Any("error", err),
-			slog.String("id", dto.ID),
-		)
-		return fmt.Errorf("marshal llm retry task: %w", err)
+	// Пока письмо было в обработке, такой же текст мог получить валидный ответ.
+	if hit, err := s.applyCachedResult(ctx, mailEntity); err != nil {
+		return err
+	} else if hit {
+		return nil
 	}
 
-	if err := s.producer.Send(ctx, s.inputTopic, dto.ID, data); err != nil {
-		s.log.Error("failed to send llm retry task to kafka",
-			slog.Any("error", err),
-			slog.String("id", dto.ID),
-			slog.String("topic", s.inputTopic),
-		)
-		return fmt.Errorf("send retry to kafka: %w", err)
+	if err := s.sendLLMTask(ctx, mailEntity); err != nil {
+		return fmt.Errorf("send retry: %w", err)
 	}
 
 	s.log.Info("llm task requeued",
@@ -373,21 +1402,34 @@ func (s *Service) handleInvalidLLMOutput(ctx context.Context, dto ValidateMessag
 	return nil
 }
 
-func (s *Service) GetProcessedMessages(ctx context.Context) ([]Mail, error) {
-	mails, err := s.repo.ListProcessed(ctx)
+func (s *Service) GetProcessedMessages(ctx context.Context, filter ListFilter) ([]Mail, error) {
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+
+	mails, err := s.repo.ListProcessed(ctx, filter)
 	if err != nil {
 		return nil, fmt.Errorf("list processed: %w", err)
 	}
+	s.attachSenders(ctx, mails)
 	return mails, nil
 }
 
-func (s *Service) ApproveMessage(ctx context.Context, dto ApproveDTO) error {
-	if dto.ID == "" {
-		return errors.New("id is empty")
-	}
+// ErrInvalidFilter — неизвестное значение фильтра или сортировки списка.
+var ErrInvalidFilter = errors.New("invalid list filter")
 
-	if err := s.repo.ApproveMail(ctx, dto.ID); err != nil {
-		return fmt.Errorf("approve mail: %w", err)
+func (f ListFilter) validate() error {
+	switch f.Sort {
+	case "", SortUpdatedAt, SortReceivedAt, SortSLADeadline:
+	default:
+		return fmt.Errorf("%w: unknown sort %q", ErrInvalidFilter, f.Sort)
+	}
+	for _, state := range f.SLAStates {
+		switch state {
+		case SLAOk, SLAAtRisk, SLABreached, SLAMet, SLARejected:
+		default:
+			return fmt.Errorf("%w: unknown sla_state %q", ErrInvalidFilter, state)
+		}
 	}
 	return nil
 }
@@ -408,27 +1450,28 @@ func (s *Service) AddAssistantResponse(ctx context.Context, dto AssistantRespons
 	if err := s.repo.SaveAssistantResponse(ctx, dto.ID, dto.AssistantResponse, dto.MarkProcessed); err != nil {
 		return fmt.Errorf("save assistant response: %w", err)
 	}
+
+	event := NewEvent(EventMailAssistantResponseAdded, dto.ID)
+	event.Data = map[string]any{"mark_processed": dto.MarkProcessed}
+	if dto.MarkProcessed {
+		event.Status = "processed"
+	}
+	s.emit(ctx, event)
 	return nil
 }
 
-func loadHierarchy(path string, log *slog.Logger) map[string]any {
+func loadHierarchy(path string, log *slog.Logger) *org.Hierarchy {
 	if path == "" {
 		return nil
 	}
 
-	data, err := os.ReadFile(path)
+	hierarchy, err := org.Load(path)
 	if err != nil {
-		log.Warn("failed to read hierarchy file", slog.Any("error", err), slog.String("path", path))
-		return nil
-	}
-
-	var hierarchy map[string]any
-	if err := json.Unmarshal(data, &hierarchy); err != nil {
-		log.Warn("failed to parse hierarchy file", slog.Any("error", err), slog.String("path", path))
+		log.Warn("failed to load hierarchy file", slog.Any("error", err), slog.String("path", path))
 		return nil
 	}
 
-	log.Info("hierarchy loaded", slog.Int("nodes", len(hierarchy)))
+	log.Info("hierarchy loaded", slog.Int("units", hierarchy.Size()))
 
 	return hierarchy
 }
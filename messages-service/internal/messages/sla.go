@@ -0,0 +1,71 @@
+package messages
+
+import (
+	"strings"
+	"time"
+)
+
+// Состояния SLA письма.
+const (
+	SLAOk       = "ok"
+	SLAAtRisk   = "at_risk"  // прошла заданная доля срока
+	SLABreached = "breached" // срок истёк до решения по письму
+	SLAMet      = "met"      // письмо утверждено в срок
+	SLARejected = "rejected" // письмо отклонено в срок; выполнением SLA не считается
+)
+
+// SLAPolicy задаёт срок обработки письма от received_at до решения по нему.
+type SLAPolicy struct {
+	// Default — срок для urgency без своей настройки; 0 — такие письма без SLA.
+	Default time.Duration
+	// Urgency — сроки по urgency из ответа модели (low/medium/high/immediate).
+	Urgency map[string]time.Duration
+	// Categories переопределяет сроки по urgency для отдельных категорий.
+	Categories map[string]map[string]time.Duration
+}
+
+// WithSLA включает расчёт SLA-дедлайна: при приёме письма — по сроку по
+// умолчанию, после ответа модели — по категории и срочности.
+func WithSLA(policy SLAPolicy) Option {
+	return func(s *Service) {
+		s.sla = &policy
+	}
+}
+
+// Deadline возвращает срок обработки для категории и срочности.
+func (p SLAPolicy) Deadline(category, urgency string) time.Duration {
+	urgency = strings.ToLower(strings.TrimSpace(urgency))
+	if d, ok := p.Categories[category][urgency]; ok {
+		return d
+	}
+	if d, ok := p.Urgency[urgency]; ok {
+		return d
+	}
+	return p.Default
+}
+
+func (mb *mailbox) slaDuration(answer *ModelAnswer) time.Duration {
+	if mb.sla == nil {
+		return 0
+	}
+	if answer == nil {
+		return mb.sla.Default
+	}
+	return mb.sla.Deadline(answer.Category, answer.Urgency)
+}
+
+// startSLA запускает SLA при приёме письма, чтобы письмо, которое не дойдёт
+// до модели или уйдёт в dead-letter, тоже эскалировалось. Отсеянные
+// префильтром письма SLA не получают.
+func (s *Service) startSLA(m *Mail) {
+	if m.Status == StatusFiltered {
+		return
+	}
+	d := s.mailbox(m).slaDuration(nil)
+	if d <= 0 {
+		return
+	}
+	deadline := m.ReceivedAt.Add(d)
+	m.SLADeadline = &deadline
+	m.SLAState = SLAOk
+}
@@ -0,0 +1,253 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"time"
+)
+
+// DefaultTenant — тенант писем, пришедших без ключа тенанта и не в ящик
+// другого тенанта, и всех писем при выключенной изоляции.
+const DefaultTenant = "default"
+
+// ErrQuotaExceeded — исчерпана суточная квота тенанта или клиента API.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// tenantIDPattern ограничивает id тенанта: он попадает в настройки сессий
+// PostgreSQL и в сообщения Kafka.
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+type tenantKey struct{}
+
+// ContextWithTenant привязывает запрос к тенанту: хранилище выполняет
+// запросы такого ctx в сессии тенанта, и чужие строки ему не видны.
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext возвращает тенанта запроса; пустая строка — запрос
+// фонового воркера или сервисного ключа, не привязанный к тенанту.
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+type callerKey struct{}
+
+// ContextWithCaller запоминает, кто выполняет запрос: несекретный
+// идентификатор API-ключа. Согласующим записывается он, а не имя из тела.
+func ContextWithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFromContext возвращает автора запроса; пустая строка — запрос без
+// аутентификации.
+func CallerFromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+// TenantConfig — настройки дочерней компании. Пустые поля берутся из
+// настроек сервиса.
+type TenantConfig struct {
+	ID            string
+	HierarchyPath string
+	PromptVersion string
+	Provider      string
+	Model         string
+	OutputTopic   string
+	// MailsPerDay — сколько писем тенант может передать за сутки UTC; 0 — без ограничения.
+	MailsPerDay int
+}
+
+// tenant — настройки, с которыми обрабатываются письма тенанта.
+type tenant struct {
+	id          string
+	provider    string
+	model       string
+	outputTopic string
+	mailsPerDay int
+	// defaults — настройки писем тенанта вне общих ящиков.
+	defaults *mailbox
+}
+
+// WithTenants задаёт тенантов. Тенант default есть всегда: без своей записи
+// он работает с настройками сервиса.
+func WithTenants(configs []TenantConfig) Option {
+	return func(s *Service) {
+		s.tenantConfigs = configs
+	}
+}
+
+// WithLLM задаёт бэкенд и модель LLM, которые передаются в задаче; у
+// тенантов они могут быть своими.
+func WithLLM(provider, model string) Option {
+	return func(s *Service) {
+		s.provider = provider
+		s.model = model
+	}
+}
+
+// ValidateTenants проверяет id тенантов.
+func ValidateTenants(configs []TenantConfig) error {
+	ids := make(map[string]bool, len(configs))
+	for _, cfg := range configs {
+		if !tenantIDPattern.MatchString(cfg.ID) {
+			return fmt.Errorf("invalid tenant id %q: use lowercase latin letters, digits, - and _", cfg.ID)
+		}
+		if ids[cfg.ID] {
+			return fmt.Errorf("duplicate tenant id %q", cfg.ID)
+		}
+		ids[cfg.ID] = true
+		if cfg.MailsPerDay < 0 {
+			return fmt.Errorf("tenant %s: mails_per_day must not be negative", cfg.ID)
+		}
+	}
+	return nil
+}
+
+// initTenants собирает тенантов после применения опций; вызывается до
+// initMailboxes, ящики наследуют настройки своего тенанта.
+func (s *Service) initTenants() {
+	base := mailbox{
+		hierarchy:        s.hierarchy,
+		organization:     s.organizationJSON(s.hierarchy),
+		promptVersion:    s.promptVersion,
+		defaultQueue:     s.defaultQueue,
+		departmentTopics: s.departmentTopics,
+		sla:              s.sla,
+	}
+	s.tenants = make(map[string]*tenant, len(s.tenantConfigs)+1)
+
+	configs := s.tenantConfigs
+	if !containsTenant(configs, DefaultTenant) {
+		configs = append([]TenantConfig{{ID: DefaultTenant}}, configs...)
+	}
+	for _, cfg := range configs {
+		t := &tenant{
+			id:          cfg.ID,
+			provider:    s.provider,
+			model:       s.model,
+			outputTopic: s.outputTopic,
+			mailsPerDay: cfg.MailsPerDay,
+		}
+		if cfg.Provider != "" {
+			t.provider = cfg.Provider
+		}
+		if cfg.Model != "" {
+			t.model = cfg.Model
+		}
+		if cfg.OutputTopic != "" {
+			t.outputTopic = cfg.OutputTopic
+		}
+
+		defaults := base
+		defaults.tenant = t
+		if cfg.HierarchyPath != "" {
+			defaults.hierarchy = loadHierarchy(cfg.HierarchyPath, s.log.With(slog.String("tenant", cfg.ID)))
+			defaults.organization = s.organizationJSON(defaults.hierarchy)
+		}
+		if cfg.PromptVersion != "" {
+			defaults.promptVersion = cfg.PromptVersion
+		}
+		t.defaults = &defaults
+
+		s.tenants[cfg.ID] = t
+	}
+}
+
+func containsTenant(configs []TenantConfig, id string) bool {
+	for _, cfg := range configs {
+		if cfg.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// tenant возвращает настройки тенанта; для тенанта, удалённого из конфига, —
+// настройки default.
+func (s *Service) tenant(id string) *tenant {
+	if t, ok := s.tenants[id]; ok {
+		return t
+	}
+	return s.tenants[DefaultTenant]
+}
+
+// requestTenant — тенант запроса или default для запросов без тенанта.
+func requestTenant(ctx context.Context) string {
+	if tenant := TenantFromContext(ctx); tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}
+
+// quotaReservation — списание с суточных квот тенанта и клиента API, которое
+// можно частично вернуть.
+type quotaReservation struct {
+	tenant string // пусто — у тенанта нет квоты писем
+	day    time.Time
+}
+
+// reserveQuota списывает n новых писем с суточной квоты тенанта и клиента
+// API запроса. Списание атомарно: параллельные запросы не превышают квоту.
+// Вызывающий списывает только провалидированные письма без дублей, а не
+// сохранившиеся потом возвращает через releaseQuota.
+func (s *Service) reserveQuota(ctx context.Context, t *tenant, n int) (*quotaReservation, error) {
+	r := &quotaReservation{day: time.Now().UTC().Truncate(24 * time.Hour)}
+
+	if t.mailsPerDay > 0 {
+		ok, err := s.repo.ReserveTenantMails(ctx, t.id, r.day, n, t.mailsPerDay)
+		if err != nil {
+			return nil, fmt.Errorf("reserve tenant mails: %w", err)
+		}
+		if !ok {
+			s.log.Warn("tenant mail quota exceeded",
+				slog.String("tenant", t.id),
+				slog.Int("mails_per_day", t.mailsPerDay),
+				slog.Int("requested", n),
+			)
+			return nil, &QuotaError{
+				Scope:  "tenant " + t.id,
+				Metric: UsageMails,
+				Limit:  int64(t.mailsPerDay),
+				Reset:  r.day.Add(24 * time.Hour),
+			}
+		}
+		r.tenant = t.id
+	}
+
+	if err := s.reserveIngest(ctx, n); err != nil {
+		s.releaseTenantMails(ctx, r, n)
+		return nil, err
+	}
+	return r, nil
+}
+
+// releaseQuota возвращает на счётчики тенанта и клиента API n списанных
+// писем, которые не сохранились.
+func (s *Service) releaseQuota(ctx context.Context, r *quotaReservation, n int) {
+	if n <= 0 {
+		return
+	}
+	s.releaseTenantMails(ctx, r, n)
+	s.releaseIngest(ctx, n)
+}
+
+// releaseTenantMails возвращает письма на счётчик тенанта. Ошибка только
+// логируется: тенант в худшем случае недосчитается этих писем до конца суток.
+func (s *Service) releaseTenantMails(ctx context.Context, r *quotaReservation, n int) {
+	if r.tenant == "" {
+		return
+	}
+	if err := s.repo.ReleaseTenantMails(context.WithoutCancel(ctx), r.tenant, r.day, n); err != nil {
+		s.log.Error("failed to release tenant mails",
+			slog.Any("error", err),
+			slog.String("tenant", r.tenant),
+			slog.Int("mails", n),
+		)
+	}
+}
@@ -0,0 +1,316 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Направления сообщений в ветке переписки.
+const (
+	DirectionInbound  = "inbound"
+	DirectionOutbound = "outbound"
+)
+
+// ThreadLookup описывает, по чему искать ветку для нового письма.
+type ThreadLookup struct {
+	MessageIDs  []string  // In-Reply-To и References письма
+	SubjectNorm string    // нормализованная тема; пусто — поиск по теме не нужен
+	From        string    // адрес отправителя в нижнем регистре
+	Since       time.Time // нижняя граница received_at для поиска по теме
+}
+
+// ThreadMail — письмо ветки вместе с состоянием отправки ответа на него.
+type ThreadMail struct {
+	Mail
+	Reply *ReplyDelivery // nil, если ответ не ставился в очередь отправки
+}
+
+type ReplyDelivery struct {
+	Status    string
+	MessageID string
+	SentAt    *time.Time
+	UpdatedAt time.Time
+}
+
+// ThreadEntry — одно сообщение в представлении переписки: входящее письмо
+// или утверждённый ответ на него.
+type ThreadEntry struct {
+	Direction      string    `json:"direction"`
+	MailID         string    `json:"mail_id"`
+	From           string    `json:"from"`
+	To             []string  `json:"to"`
+	Subject        string    `json:"subject,omitempty"`
+	MessageID      string    `json:"message_id,omitempty"`
+	Text           string    `json:"text"`
+	At             time.Time `json:"at"`
+	Status         string    `json:"status"`
+	Classification string    `json:"classification,omitempty"`
+}
+
+type Thread struct {
+	ID      string        `json:"id"`
+	Entries []ThreadEntry `json:"messages"`
+}
+
+// LLMThreadMessage — предыдущее письмо ветки, передаваемое модели как контекст.
+type LLMThreadMessage struct {
+	ReceivedAt     time.Time `json:"received_at"`
+	From           string    `json:"from"`
+	Subject        string    `json:"subject,omitempty"`
+	Text           string    `json:"text"`
+	Classification string    `json:"classification,omitempty"`
+	Category       string    `json:"category,omitempty"`
+	Reply          string    `json:"reply,omitempty"` // утверждённый ответ, если был
+}
+
+// WithThreading задаёт окно поиска ветки по теме письма и объём контекста
+// ветки в задаче LLM. Без этой опции ветки строятся только по заголовкам,
+// а контекст модели не передаётся.
+func WithThreading(subjectWindow time.Duration, contextMessages, contextChars int) Option {
+	return func(s *Service) {
+		s.threadWindow = subjectWindow
+		s.threadContextMessages = contextMessages
+		s.threadContextChars = contextChars
+	}
+}
+
+// subjectPrefix совпадает с цепочкой префиксов ответа и пересылки: "Re:",
+// "Fwd:", "RE[2]:", "Отв:", "Пересл:" и т.п.
+var subjectPrefix = regexp.MustCompile(`(?i)^(\s*(re|fwd?|aw|wg|sv|отв|ответ|пересл)(\[\d+\])?\s*:\s*)+`)
+
+// NormalizeSubject убирает префиксы ответа и пересылки, приводит тему к
+// нижнему регистру и схлопывает пробелы. Второе значение — были ли префиксы.
+func NormalizeSubject(subject string) (string, bool) {
+	stripped := subjectPrefix.ReplaceAllString(subject, "")
+	isReply := len(stripped) != len(subject)
+	return strings.ToLower(strings.Join(strings.Fields(stripped), " ")), isReply
+}
+
+// assignThread находит ветку для нового письма: сначала по Message-ID из
+// In-Reply-To/References (включая наши отправленные ответы), затем по теме
+// с префиксом ответа от того же отправителя. Иначе письмо открывает новую
+// ветку. Ошибки поиска не мешают приёму письма.
+func (s *Service) assignThread(ctx context.Context, m *Mail) {
+	m.ThreadID = m.ID
+
+	lookup := ThreadLookup{MessageIDs: threadRefs(m)}
+	if subject, isReply := NormalizeSubject(m.Subject); isReply && subject != "" && s.threadWindow > 0 {
+		lookup.SubjectNorm = subject
+		lookup.From = addressOf(m.From)
+		lookup.Since = m.ReceivedAt.Add(-s.threadWindow)
+	}
+	if len(lookup.MessageIDs) == 0 && lookup.SubjectNorm == "" {
+		return
+	}
+
+	threadID, err := s.repo.FindThread(ctx, lookup)
+	if err != nil {
+		s.log.Warn("failed to find mail thread",
+			slog.Any("error", err),
+			slog.String("id", m.ID),
+		)
+		return
+	}
+	if threadID != "" {
+		m.ThreadID = threadID
+	}
+}
+
+func threadRefs(m *Mail) []string {
+	refs := make([]string, 0, len(m.References)+1)
+	if m.InReplyTo != "" {
+		refs = append(refs, m.InReplyTo)
+	}
+	for _, ref := range m.References {
+		if ref != "" && ref != m.InReplyTo {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// firstAddress — первый адрес списка или пустая строка.
+func firstAddress(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	return list[0]
+}
+
+func addressOf(raw string) string {
+	if addr, err := mail.ParseAddress(raw); err == nil {
+		return strings.ToLower(addr.Address)
+	}
+	return strings.ToLower(strings.TrimSpace(raw))
+}
+
+// GetThread возвращает переписку по id ветки или id любого письма в ней.
+func (s *Service) GetThread(ctx context.Context, id string) (*Thread, error) {
+	if id == "" {
+		return nil, fmt.Errorf("%w: id is empty", ErrInvalidMessage)
+	}
+
+	mails, err := s.repo.ListThread(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("list thread: %w", err)
+	}
+	if len(mails) == 0 {
+		m, err := s.repo.GetMail(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("get mail: %w", err)
+		}
+		if mails, err = s.repo.ListThread(ctx, m.ThreadID); err != nil {
+			return nil, fmt.Errorf("list thread: %w", err)
+		}
+	}
+	if len(mails) == 0 {
+		return nil, fmt.Errorf("thread %s not found", id)
+	}
+
+	thread := &Thread{ID: mails[0].ThreadID}
+	for _, tm := range mails {
+		thread.Entries = append(thread.Entries, ThreadEntry{
+			Direction:      DirectionInbound,
+			MailID:         tm.ID,
+			From:           tm.From,
+			To:             tm.To,
+			Subject:        tm.Subject,
+			MessageID:      tm.MessageID,
+			Text:           tm.Input,
+			At:             tm.ReceivedAt,
+			Status:         tm.Status,
+			Classification: tm.Classification,
+		})
+
+		if !tm.IsApproved {
+			continue
+		}
+		reply := ThreadEntry{
+			Direction: DirectionOutbound,
+			MailID:    tm.ID,
+			From:      firstAddress(tm.To),
+			To:        []string{tm.From},
+			Subject:   tm.Subject,
+			Text:      ReplyText(&tm.Mail),
+			At:        tm.UpdatedAt,
+			Status:    "approved",
+		}
+		if tm.Reply != nil {
+			reply.MessageID = tm.Reply.MessageID
+			reply.Status = tm.Reply.Status
+			reply.At = tm.Reply.UpdatedAt
+			if tm.Reply.SentAt != nil {
+				reply.At = *tm.Reply.SentAt
+			}
+		}
+		thread.Entries = append(thread.Entries, reply)
+	}
+
+	sort.SliceStable(thread.Entries, func(i, j int) bool {
+		return thread.Entries[i].At.Before(thread.Entries[j].At)
+	})
+
+	return thread, nil
+}
+
+// threadContext собирает последние письма ветки, полученные раньше m, для
+// передачи модели. Ошибка чтения ветки не мешает отправке задачи.
+func (s *Service) threadContext(ctx context.Context, m *Mail) []LLMThreadMessage {
+	if s.threadContextMessages <= 0 || m.ThreadID == "" || m.ThreadID == m.ID {
+		return nil
+	}
+
+	mails, err := s.repo.ListThread(ctx, m.ThreadID)
+	if err != nil {
+		s.log.Warn("failed to load thread context",
+			slog.Any("error", err),
+			slog.String("id", m.ID),
+			slog.String("thread_id", m.ThreadID),
+		)
+		return nil
+	}
+
+	var prior []LLMThreadMessage
+	for _, tm := range mails {
+		if tm.ID == m.ID || !tm.ReceivedAt.Before(m.ReceivedAt) {
+			continue
+		}
+		item := LLMThreadMessage{
+			ReceivedAt:     tm.ReceivedAt,
+			From:           tm.From,
+			Subject:        tm.Subject,
+			Text:           truncate(tm.Input, s.threadContextChars),
+			Classification: tm.Classification,
+			Category:       answerField(tm.ModelAnswer, "category"),
+		}
+		if tm.IsApproved {
+			item.Reply = truncate(ReplyText(&tm.Mail), s.threadContextChars)
+		}
+		prior = append(prior, item)
+	}
+
+	if len(prior) > s.threadContextMessages {
+		prior = prior[len(prior)-s.threadContextMessages:]
+	}
+	return prior
+}
+
+// ReplyText достаёт текст ответа на письмо. assistant_response может быть
+// JSON-строкой или объектом с одним из полей text / response /
+// recommended_response / summary; без него берётся recommended_response модели.
+func ReplyText(m *Mail) string {
+	if text := textFromJSON(m.AssistantResp, "text", "response", "recommended_response", "summary"); text != "" {
+		return text
+	}
+	return textFromJSON(m.ModelAnswer, "recommended_response")
+}
+
+// answerField достаёт строковое поле из объекта model_answer.
+func answerField(raw json.RawMessage, key string) string {
+	var obj map[string]any
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return ""
+	}
+	v, _ := obj[key].(string)
+	return v
+}
+
+func textFromJSON(raw json.RawMessage, keys ...string) string {
+	if len(raw) == 0 {
+		return ""
+	}
+
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return strings.TrimSpace(str)
+	}
+
+	var obj map[string]any
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return ""
+	}
+	for _, key := range keys {
+		if v, ok := obj[key].(string); ok && strings.TrimSpace(v) != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+func truncate(text string, maxChars int) string {
+	if maxChars <= 0 {
+		return text
+	}
+	runes := []rune(text)
+	if len(runes) <= maxChars {
+		return text
+	}
+	return string(runes[:maxChars]) + "…"
+}
@@ -0,0 +1,103 @@
+package messages
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// Метрики суточного расхода.
+const (
+	UsageMails     = "mails"
+	UsageLLMTokens = "llm_tokens"
+)
+
+// charsPerToken — сколько символов текста задачи считать одним токеном
+// модели при оценке расхода.
+const charsPerToken = 3
+
+// QuotaError — исчерпана суточная квота. Reset — когда квота обновится.
+type QuotaError struct {
+	Scope  string // тенант или клиент API
+	Metric string
+	Limit  int64
+	Reset  time.Time
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: %s: %d %s per day", ErrQuotaExceeded, e.Scope, e.Limit, e.Metric)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// UsageLimiter учитывает суточный расход клиента API, от имени которого
+// выполняется запрос. Запросы без клиента (SMTP, IMAP, фоновые воркеры) не
+// учитываются.
+type UsageLimiter interface {
+	// Reserve списывает n единиц metric или, если квота этого не позволяет,
+	// возвращает *QuotaError и ничего не списывает. n = 0 только проверяет,
+	// что квота ещё не исчерпана.
+	Reserve(ctx context.Context, metric string, n int64) error
+	// Record учитывает уже израсходованные единицы без проверки квоты;
+	// отрицательное n возвращает списанное Reserve.
+	Record(ctx context.Context, metric string, n int64)
+}
+
+// WithUsageLimiter включает суточные квоты клиентов API на письма и токены LLM.
+func WithUsageLimiter(l UsageLimiter) Option {
+	return func(s *Service) {
+		s.usage = l
+	}
+}
+
+// reserveIngest списывает с клиента n писем и проверяет, что у него остались
+// токены LLM. Токены списываются по факту отправки задач: размер задачи
+// известен только после сборки ветки.
+func (s *Service) reserveIngest(ctx context.Context, n int) error {
+	if s.usage == nil {
+		return nil
+	}
+	if err := s.usage.Reserve(ctx, UsageLLMTokens, 0); err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+	return s.usage.Reserve(ctx, UsageMails, int64(n))
+}
+
+// releaseIngest возвращает клиенту n писем, списанных reserveIngest.
+func (s *Service) releaseIngest(ctx context.Context, n int) {
+	if s.usage == nil {
+		return
+	}
+	s.usage.Record(context.WithoutCancel(ctx), UsageMails, -int64(n))
+}
+
+// recordTokens учитывает оценку токенов отправленных задач.
+func (s *Service) recordTokens(ctx context.Context, tasks ...LLMTaskMessage) {
+	if s.usage == nil {
+		return
+	}
+	var tokens int64
+	for _, task := range tasks {
+		tokens += estimateTokens(task)
+	}
+	s.usage.Record(ctx, UsageLLMTokens, tokens)
+}
+
+// estimateTokens оценивает размер промпта задачи: llm-service не сообщает
+// фактический расход, поэтому считается текст письма, вложений и ветки.
+func estimateTokens(task LLMTaskMessage) int64 {
+	chars := utf8.RuneCountInString(task.Subject) + utf8.RuneCountInString(task.Input)
+	for _, a := range task.Attachments {
+		chars += utf8.RuneCountInString(a.Text)
+	}
+	for _, m := range task.Thread {
+		chars += utf8.RuneCountInString(m.Subject) + utf8.RuneCountInString(m.Text) + utf8.RuneCountInString(m.Reply)
+	}
+	return int64(chars/charsPerToken + 1)
+}
@@ -0,0 +1,93 @@
+package org
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Hierarchy — оргструктура из configs/hierarchy.json: департаменты и их
+// команды. Методы безопасно вызывать у nil: пустая структура не знает ни
+// одного подразделения.
+type Hierarchy struct {
+	Organization struct {
+		Departments []Department `json:"departments"`
+	} `json:"organization"`
+
+	units map[string]Unit
+}
+
+type Department struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Teams []Team `json:"teams"`
+}
+
+type Team struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Employees []Employee `json:"employees"`
+}
+
+type Employee struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Position string `json:"position"`
+}
+
+// Unit — департамент или команда, найденные по id.
+type Unit struct {
+	ID         string
+	Name       string
+	Department string // id департамента; для департамента совпадает с ID
+	IsTeam     bool
+}
+
+func Load(path string) (*Hierarchy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read hierarchy: %w", err)
+	}
+
+	var h Hierarchy
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("parse hierarchy: %w", err)
+	}
+
+	h.units = make(map[string]Unit)
+	for _, d := range h.Organization.Departments {
+		h.units[d.ID] = Unit{ID: d.ID, Name: d.Name, Department: d.ID}
+		for _, t := range d.Teams {
+			if _, dup := h.units[t.ID]; dup {
+				return nil, fmt.Errorf("duplicate unit id %q", t.ID)
+			}
+			h.units[t.ID] = Unit{ID: t.ID, Name: t.Name, Department: d.ID, IsTeam: true}
+		}
+	}
+
+	return &h, nil
+}
+
+// Unit возвращает департамент или команду по id.
+func (h *Hierarchy) Unit(id string) (Unit, bool) {
+	if h == nil {
+		return Unit{}, false
+	}
+	u, ok := h.units[id]
+	return u, ok
+}
+
+func (h *Hierarchy) Departments() []Department {
+	if h == nil {
+		return nil
+	}
+	return h.Organization.Departments
+}
+
+// Size — число департаментов и команд.
+func (h *Hierarchy) Size() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.units)
+}
@@ -0,0 +1,107 @@
+package outbound
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"messages-service/internal/messages"
+)
+
+// ErrNoReplyText — у письма нет ни ответа оператора, ни recommended_response.
+var ErrNoReplyText = errors.New("mail has no reply text")
+
+// ErrInvalidAddress — адрес отправителя письма или from_address не разбирается.
+var ErrInvalidAddress = errors.New("invalid reply address")
+
+// Reply — готовое к отправке письмо-ответ. From и To — голые адреса для
+// MAIL FROM и RCPT TO.
+type Reply struct {
+	MessageID string
+	From      string
+	To        string
+	Raw       []byte
+}
+
+// ComposeReply собирает ответ на письмо с корректными In-Reply-To и References.
+// Текст берётся из ответа оператора, а если его нет — из recommended_response модели.
+func ComposeReply(m *messages.Mail, from, domain string) (*Reply, error) {
+	text := messages.ReplyText(m)
+	if text == "" {
+		return nil, ErrNoReplyText
+	}
+
+	// from_address и адрес отправителя могут быть с именем ("Имя <a@b>"):
+	// в конверт идёт только адрес, в заголовки — адрес с именем в
+	// кодировке RFC 2047.
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("%w: from_address %q: %v", ErrInvalidAddress, from, err)
+	}
+	toAddr, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return nil, fmt.Errorf("%w: sender %q: %v", ErrInvalidAddress, m.From, err)
+	}
+
+	messageID := uuid.NewString() + "@" + domain
+
+	subject := m.Subject
+	if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+		subject = strings.TrimSpace("Re: " + subject)
+	}
+
+	var buf bytes.Buffer
+	writeHeader(&buf, "From", fromAddr.String())
+	writeHeader(&buf, "To", toAddr.String())
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", subject))
+	writeHeader(&buf, "Date", time.Now().UTC().Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", "<"+messageID+">")
+	// Идентификаторы проверяются при приёме письма; здесь они проверяются
+	// ещё раз, чтобы письмо из старых данных не дописало в ответ заголовки.
+	if messages.ValidMessageID(m.MessageID) {
+		refs := make([]string, 0, len(m.References)+1)
+		for _, ref := range m.References {
+			if messages.ValidMessageID(ref) {
+				refs = append(refs, "<"+ref+">")
+			}
+		}
+		refs = append(refs, "<"+m.MessageID+">")
+
+		writeHeader(&buf, "In-Reply-To", "<"+m.MessageID+">")
+		writeHeader(&buf, "References", strings.Join(refs, " "))
+	}
+	writeHeader(&buf, "Auto-Submitted", "auto-replied")
+	writeHeader(&buf, "MIME-Version", "1.0")
+	writeHeader(&buf, "Content-Type", "text/plain; charset=utf-8")
+	writeHeader(&buf, "Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n"))); err != nil {
+		return nil, fmt.Errorf("encode reply body: %w", err)
+	}
+	if err := qp.Close(); err != nil {
+		return nil, fmt.Errorf("encode reply body: %w", err)
+	}
+
+	return &Reply{
+		MessageID: messageID,
+		From:      fromAddr.Address,
+		To:        toAddr.Address,
+		Raw:       buf.Bytes(),
+	}, nil
+}
+
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	buf.WriteString(": ")
+	buf.WriteString(value)
+	buf.WriteString("\r\n")
+}
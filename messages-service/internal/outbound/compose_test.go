@@ -0,0 +1,97 @@
+package outbound
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"messages-service/internal/messages"
+)
+
+func TestComposeReplyThreadHeaders(t *testing.T) {
+	m := &messages.Mail{
+		From:          "Клиент <client@example.com>",
+		Subject:       "Счёт",
+		MessageID:     "m2@example.com",
+		References:    []string{"m0@example.com", "m1@example.com"},
+		AssistantResp: json.RawMessage(`"Добрый день"`),
+	}
+
+	reply, err := ComposeReply(m, "support@corp.example", "corp.example")
+	if err != nil {
+		t.Fatalf("ComposeReply: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(reply.Raw))
+	if err != nil {
+		t.Fatalf("read reply: %v", err)
+	}
+	if got := msg.Header.Get("In-Reply-To"); got != "<m2@example.com>" {
+		t.Errorf("In-Reply-To = %q", got)
+	}
+	if got := msg.Header.Get("References"); got != "<m0@example.com> <m1@example.com> <m2@example.com>" {
+		t.Errorf("References = %q", got)
+	}
+	if reply.To != "client@example.com" || reply.From != "support@corp.example" {
+		t.Errorf("envelope = %q -> %q", reply.From, reply.To)
+	}
+}
+
+// Идентификаторы из старых данных, не прошедшие бы проверку при приёме, не
+// должны дописывать в ответ свои заголовки.
+func TestComposeReplySkipsInvalidMessageIDs(t *testing.T) {
+	tests := []struct {
+		name       string
+		messageID  string
+		references []string
+		inReplyTo  string
+		refs       string
+	}{
+		{
+			name:      "crlf in message id",
+			messageID: "m@x>\r\nBcc: victim@example.com\r\nX-A: <y",
+		},
+		{
+			name:      "bare lf in message id",
+			messageID: "m@x\nBcc: victim@example.com",
+		},
+		{
+			name:       "bad reference is dropped",
+			messageID:  "m2@example.com",
+			references: []string{"ok@example.com", "bad>\r\nBcc: victim@example.com", "with space@x", ""},
+			inReplyTo:  "<m2@example.com>",
+			refs:       "<ok@example.com> <m2@example.com>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &messages.Mail{
+				From:          "client@example.com",
+				MessageID:     tt.messageID,
+				References:    tt.references,
+				AssistantResp: json.RawMessage(`"ok"`),
+			}
+			reply, err := ComposeReply(m, "support@corp.example", "corp.example")
+			if err != nil {
+				t.Fatalf("ComposeReply: %v", err)
+			}
+
+			header, _, _ := strings.Cut(string(reply.Raw), "\r\n\r\n")
+			if strings.Contains(header, "Bcc") || strings.Contains(header, "X-A") {
+				t.Fatalf("injected header in reply:\n%s", header)
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(reply.Raw))
+			if err != nil {
+				t.Fatalf("read reply: %v", err)
+			}
+			if got := msg.Header.Get("In-Reply-To"); got != tt.inReplyTo {
+				t.Errorf("In-Reply-To = %q, want %q", got, tt.inReplyTo)
+			}
+			if got := msg.Header.Get("References"); got != tt.refs {
+				t.Errorf("References = %q, want %q", got, tt.refs)
+			}
+		})
+	}
+}
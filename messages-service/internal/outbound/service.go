@@ -0,0 +1,169 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"messages-service/internal/config"
+	"messages-service/internal/messages"
+)
+
+// Статусы доставки ответа; те же значения проставляются в mails.status.
+const (
+	StatusPending    = "pending"
+	StatusSent       = "sent"
+	StatusSendFailed = "send_failed"
+)
+
+// claimLease — на сколько попытка доставки «занимает» запись, чтобы другие
+// реплики не отправили тот же ответ параллельно.
+const claimLease = 5 * time.Minute
+
+type Delivery struct {
+	ID        string
+	MailID    string
+	Status    string
+	Attempts  int
+	LastError string
+	MessageID string
+	SentAt    *time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type Store interface {
+	GetMail(ctx context.Context, id string) (*messages.Mail, error)
+	CreateDelivery(ctx context.Context, mailID string) error
+	ClaimDueDeliveries(ctx context.Context, limit int, maxAttempts int, lease time.Duration) ([]Delivery, error)
+	MarkDeliverySent(ctx context.Context, d Delivery, messageID string) error
+	// MarkDeliveryFailed с nextAttemptAt == nil прекращает повторы.
+	MarkDeliveryFailed(ctx context.Context, d Delivery, reason string, nextAttemptAt *time.Time) error
+}
+
+type Sender interface {
+	Send(ctx context.Context, from string, to []string, raw []byte) error
+}
+
+type Service struct {
+	cfg    config.OutboundConfig
+	store  Store
+	sender Sender
+	log    *slog.Logger
+}
+
+func NewService(cfg config.OutboundConfig, store Store, sender Sender, log *slog.Logger) *Service {
+	return &Service{
+		cfg:    cfg,
+		store:  store,
+		sender: sender,
+		log:    log,
+	}
+}
+
+// EnqueueReply ставит ответ на письмо в очередь отправки. Повторный вызов для
+// того же письма не создаёт вторую доставку: неотправленная доставка
+// возвращается в очередь со сброшенным счётчиком, отправленная не трогается.
+func (s *Service) EnqueueReply(ctx context.Context, mailID string) error {
+	if err := s.store.CreateDelivery(ctx, mailID); err != nil {
+		return fmt.Errorf("create delivery: %w", err)
+	}
+	s.log.Info("reply queued for delivery", slog.String("id", mailID))
+	return nil
+}
+
+// Run периодически отправляет ответы из очереди до отмены ctx.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.deliverDue(ctx); err != nil && ctx.Err() == nil {
+			s.log.Error("outbound delivery cycle failed", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) deliverDue(ctx context.Context) error {
+	deliveries, err := s.store.ClaimDueDeliveries(ctx, s.cfg.BatchSize, s.cfg.MaxAttempts, claimLease)
+	if err != nil {
+		return fmt.Errorf("claim deliveries: %w", err)
+	}
+
+	for _, d := range deliveries {
+		if ctx.Err() != nil {
+			return nil
+		}
+		s.deliver(ctx, d)
+	}
+
+	return nil
+}
+
+func (s *Service) deliver(ctx context.Context, d Delivery) {
+	messageID, err := s.send(ctx, d)
+	if err == nil {
+		if err := s.store.MarkDeliverySent(ctx, d, messageID); err != nil {
+			s.log.Error("failed to mark delivery sent", slog.Any("error", err), slog.String("id", d.MailID))
+			return
+		}
+		s.log.Info("reply sent", slog.String("id", d.MailID), slog.String("message_id", messageID))
+		return
+	}
+
+	var next *time.Time
+	// Без текста ответа или с неразбираемым адресом повторять бессмысленно:
+	// доставка вернётся в очередь при повторном аппруве после добавления
+	// ответа оператором.
+	permanent := errors.Is(err, ErrNoReplyText) || errors.Is(err, ErrInvalidAddress)
+	if !permanent && d.Attempts+1 < s.cfg.MaxAttempts {
+		at := time.Now().UTC().Add(s.backoff(d.Attempts + 1))
+		next = &at
+	}
+
+	if markErr := s.store.MarkDeliveryFailed(ctx, d, err.Error(), next); markErr != nil {
+		s.log.Error("failed to mark delivery failed", slog.Any("error", markErr), slog.String("id", d.MailID))
+	}
+	s.log.Warn("reply delivery failed",
+		slog.Any("error", err),
+		slog.String("id", d.MailID),
+		slog.Int("attempt", d.Attempts+1),
+	)
+}
+
+func (s *Service) send(ctx context.Context, d Delivery) (string, error) {
+	mailEntity, err := s.store.GetMail(ctx, d.MailID)
+	if err != nil {
+		return "", fmt.Errorf("get mail: %w", err)
+	}
+
+	reply, err := ComposeReply(mailEntity, s.cfg.FromAddress, s.cfg.MessageIDDomain)
+	if err != nil {
+		return "", err
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	if err := s.sender.Send(sendCtx, reply.From, []string{reply.To}, reply.Raw); err != nil {
+		return "", err
+	}
+	return reply.MessageID, nil
+}
+
+// backoff растёт экспоненциально от RetryBackoff и ограничен часом.
+func (s *Service) backoff(attempt int) time.Duration {
+	delay := s.cfg.RetryBackoff
+	for i := 1; i < attempt && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	return min(delay, time.Hour)
+}
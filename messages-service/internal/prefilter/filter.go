@@ -0,0 +1,277 @@
+package prefilter
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"slices"
+	"strings"
+
+	"messages-service/internal/config"
+	"messages-service/internal/messages"
+)
+
+// Действия правила.
+const (
+	ActionFilter = "filter"
+	ActionAllow  = "allow"
+)
+
+// Filter — локальный префильтр: проверяет заголовки автоответов и рассылок,
+// отчёты о недоставке, списки отправителей и правила из конфига — в этом
+// порядке. Автоответы и bounce-и отсеиваются и у разрешённых отправителей.
+type Filter struct {
+	cfg   config.PrefilterConfig
+	allow senderList
+	deny  senderList
+	rules []rule
+}
+
+type rule struct {
+	name       string
+	action     string
+	conditions []condition
+}
+
+type condition struct {
+	field  string
+	header string // каноническое имя для полей header:<Имя>
+	re     *regexp.Regexp
+}
+
+func New(cfg config.PrefilterConfig) (*Filter, error) {
+	f := &Filter{
+		cfg:   cfg,
+		allow: newSenderList(cfg.AllowSenders),
+		deny:  newSenderList(cfg.DenySenders),
+	}
+
+	for i, rc := range cfg.Rules {
+		name := rc.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+		r := rule{name: name, action: rc.Action}
+		if r.action == "" {
+			r.action = ActionFilter
+		}
+		if r.action != ActionFilter && r.action != ActionAllow {
+			return nil, fmt.Errorf("prefilter %s: unknown action %q", name, rc.Action)
+		}
+		if len(rc.Match) == 0 {
+			return nil, fmt.Errorf("prefilter %s: match is empty", name)
+		}
+		for field, pattern := range rc.Match {
+			if !validField(field) {
+				return nil, fmt.Errorf("prefilter %s: unknown field %q", name, field)
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("prefilter %s: field %s: %w", name, field, err)
+			}
+			c := condition{field: field, re: re}
+			if header, ok := strings.CutPrefix(field, "header:"); ok {
+				c.header = textproto.CanonicalMIMEHeaderKey(header)
+			}
+			r.conditions = append(r.conditions, c)
+		}
+		f.rules = append(f.rules, r)
+	}
+
+	return f, nil
+}
+
+// Check реализует messages.PreFilter.
+func (f *Filter) Check(_ context.Context, m *messages.Mail) (*messages.FilterVerdict, error) {
+	if f.cfg.Bounces {
+		if v := bounce(m); v != nil {
+			return v, nil
+		}
+	}
+	if f.cfg.AutoReplies {
+		if v := autoReply(m); v != nil {
+			return v, nil
+		}
+	}
+	if f.cfg.Bulk {
+		if v := bulk(m); v != nil {
+			return v, nil
+		}
+	}
+
+	sender := senderAddress(m.From)
+	if f.allow.contains(sender) {
+		return nil, nil
+	}
+	if f.deny.contains(sender) {
+		return &messages.FilterVerdict{
+			Kind:   messages.FilterDeniedSender,
+			Reason: "sender is denied: " + sender,
+		}, nil
+	}
+
+	for _, r := range f.rules {
+		if !r.match(m) {
+			continue
+		}
+		if r.action == ActionAllow {
+			return nil, nil
+		}
+		return &messages.FilterVerdict{
+			Kind:   messages.FilterRule,
+			Reason: "matched rule " + r.name,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// bounce распознаёт отчёты о недоставке: DSN по RFC 3464, пустой
+// Return-Path и письма от MAILER-DAEMON / postmaster.
+func bounce(m *messages.Mail) *messages.FilterVerdict {
+	if m.DeliveryStatus != nil {
+		reason := "delivery status notification"
+		if r, ok := m.DeliveryStatus.Failed(); ok {
+			reason = fmt.Sprintf("delivery %s for %s", r.Action, r.Recipient)
+			if r.Status != "" {
+				reason += ": " + r.Status
+			}
+			if r.Diagnostic != "" {
+				reason += " " + r.Diagnostic
+			}
+		}
+		return &messages.FilterVerdict{Kind: messages.FilterBounce, Reason: reason}
+	}
+
+	if strings.TrimSpace(m.Headers["Return-Path"]) == "<>" {
+		return &messages.FilterVerdict{Kind: messages.FilterBounce, Reason: "null return path"}
+	}
+
+	local, _, _ := strings.Cut(senderAddress(m.From), "@")
+	if local == "mailer-daemon" || local == "postmaster" {
+		return &messages.FilterVerdict{Kind: messages.FilterBounce, Reason: "sent by " + local}
+	}
+	return nil
+}
+
+// autoReply распознаёт автоответы по RFC 3834 и распространённым
+// нестандартным заголовкам.
+func autoReply(m *messages.Mail) *messages.FilterVerdict {
+	if value := strings.ToLower(strings.TrimSpace(m.Headers["Auto-Submitted"])); value != "" && value != "no" {
+		return &messages.FilterVerdict{Kind: messages.FilterAutoReply, Reason: "Auto-Submitted: " + value}
+	}
+	if precedence(m) == "auto_reply" {
+		return &messages.FilterVerdict{Kind: messages.FilterAutoReply, Reason: "Precedence: auto_reply"}
+	}
+	for _, header := range []string{"X-Autoreply", "X-Autorespond"} {
+		if m.Headers[header] != "" {
+			return &messages.FilterVerdict{Kind: messages.FilterAutoReply, Reason: header + " header"}
+		}
+	}
+	return nil
+}
+
+// bulk распознаёт рассылки.
+func bulk(m *messages.Mail) *messages.FilterVerdict {
+	switch p := precedence(m); p {
+	case "bulk", "list", "junk":
+		return &messages.FilterVerdict{Kind: messages.FilterBulk, Reason: "Precedence: " + p}
+	}
+	if m.Headers["List-Unsubscribe"] != "" {
+		return &messages.FilterVerdict{Kind: messages.FilterBulk, Reason: "List-Unsubscribe header"}
+	}
+	return nil
+}
+
+func precedence(m *messages.Mail) string {
+	return strings.ToLower(strings.TrimSpace(m.Headers["Precedence"]))
+}
+
+func (r rule) match(m *messages.Mail) bool {
+	for _, c := range r.conditions {
+		if !c.match(m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c condition) match(m *messages.Mail) bool {
+	switch c.field {
+	case "from":
+		return c.re.MatchString(m.From)
+	case "to":
+		for _, to := range slices.Concat(m.To, m.Cc) {
+			if c.re.MatchString(to) {
+				return true
+			}
+		}
+		return false
+	case "subject":
+		return c.re.MatchString(m.Subject)
+	case "body":
+		return c.re.MatchString(m.Input)
+	case "attachment":
+		for _, a := range m.Attachments {
+			if c.re.MatchString(a.Filename) {
+				return true
+			}
+		}
+		return false
+	default:
+		return c.re.MatchString(m.Headers[c.header])
+	}
+}
+
+func validField(field string) bool {
+	switch field {
+	case "from", "to", "subject", "body", "attachment":
+		return true
+	}
+	name, ok := strings.CutPrefix(field, "header:")
+	return ok && name != ""
+}
+
+// senderList — адреса и домены (@example.com или example.com) в нижнем регистре.
+type senderList struct {
+	addresses map[string]bool
+	domains   map[string]bool
+}
+
+func newSenderList(entries []string) senderList {
+	list := senderList{addresses: make(map[string]bool), domains: make(map[string]bool)}
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case strings.HasPrefix(entry, "@"):
+			list.domains[entry[1:]] = true
+		case strings.Contains(entry, "@"):
+			list.addresses[entry] = true
+		default:
+			list.domains[entry] = true
+		}
+	}
+	return list
+}
+
+func (l senderList) contains(address string) bool {
+	if address == "" {
+		return false
+	}
+	if l.addresses[address] {
+		return true
+	}
+	_, domain, _ := strings.Cut(address, "@")
+	return l.domains[domain]
+}
+
+// senderAddress возвращает адрес отправителя без имени в нижнем регистре.
+func senderAddress(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		return strings.ToLower(addr.Address)
+	}
+	return strings.ToLower(strings.TrimSpace(from))
+}
@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// memoryMaxBuckets — после стольких корзин Memory удаляет полные: они
+	// ничем не отличаются от новой.
+	memoryMaxBuckets = 100000
+	// memoryUsageDays — за сколько дней Memory хранит суточные счётчики.
+	memoryUsageDays = 7
+)
+
+// Memory хранит корзины и счётчики в памяти процесса. У каждой реплики
+// свои лимиты, и счётчики теряются при перезапуске.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	usage   map[UsageKey]int64
+	day     time.Time
+}
+
+// bucket хранит лимиты, с которыми его наполняли в последний раз: у
+// клиентов разных тенантов они разные.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	rate    float64
+	burst   int
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]*bucket),
+		usage:   make(map[UsageKey]int64),
+	}
+}
+
+func (m *Memory) Take(_ context.Context, client string, rate float64, burst int) (float64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	b, ok := m.buckets[client]
+	if !ok {
+		if len(m.buckets) >= memoryMaxBuckets {
+			m.pruneBuckets(now)
+		}
+		b = &bucket{tokens: float64(burst), updated: now}
+		m.buckets[client] = b
+	}
+
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+	b.rate, b.burst = rate, burst
+	if b.tokens < 1 {
+		return b.tokens, false, nil
+	}
+	b.tokens--
+	return b.tokens, true, nil
+}
+
+func (m *Memory) pruneBuckets(now time.Time) {
+	for client, b := range m.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.rate >= float64(b.burst) {
+			delete(m.buckets, client)
+		}
+	}
+}
+
+func (m *Memory) Reserve(_ context.Context, key UsageKey, n, limit int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollover(key.Day)
+	key = usageKey(key)
+	if limit > 0 && m.usage[key]+max(n, 1) > limit {
+		return false, nil
+	}
+	m.usage[key] += n
+	return true, nil
+}
+
+func (m *Memory) Record(_ context.Context, key UsageKey, n int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollover(key.Day)
+	m.usage[usageKey(key)] += n
+	return nil
+}
+
+func (m *Memory) Usage(_ context.Context, day time.Time, tenant string) ([]UsageRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rows := make([]UsageRow, 0)
+	for key, used := range m.usage {
+		if !key.Day.Equal(day) || (tenant != "" && key.Tenant != tenant) {
+			continue
+		}
+		rows = append(rows, UsageRow{Client: key.Client, Tenant: key.Tenant, Metric: key.Metric, Used: used})
+	}
+	return rows, nil
+}
+
+// rollover удаляет счётчики старше memoryUsageDays при смене суток.
+func (m *Memory) rollover(day time.Time) {
+	if !day.After(m.day) {
+		return
+	}
+	m.day = day
+	oldest := day.AddDate(0, 0, -memoryUsageDays)
+	for key := range m.usage {
+		if key.Day.Before(oldest) {
+			delete(m.usage, key)
+		}
+	}
+}
+
+// usageKey приводит день к UTC, чтобы ключи одного дня совпадали.
+func usageKey(key UsageKey) UsageKey {
+	key.Day = key.Day.UTC()
+	return key
+}
@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Postgres хранит корзины и счётчики в таблицах rate_limit_buckets и
+// rate_limit_usage, общих для всех реплик сервиса. Таблицы вне RLS: запросы
+// идут через общий пул и сами фильтруют по тенанту.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) Take(ctx context.Context, client string, rate float64, burst int) (float64, bool, error) {
+	// Корзина пополняется по времени сервера БД, чтобы расхождение часов
+	// реплик не влияло на лимит.
+	const takeQuery = `
+INSERT INTO rate_limit_buckets AS b (client, tokens, updated_at)
+VALUES ($1, $3::float8 - 1, NOW())
+ON CONFLICT (client) DO UPDATE
+SET tokens = LEAST($3::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $2) - 1,
+updated_at = NOW()
+WHERE LEAST($3::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $2) >= 1
+RETURNING tokens;
+`
+	const levelQuery = `
+SELECT LEAST($3::float8, tokens + EXTRACT(EPOCH FROM NOW() - updated_at) * $2)
+FROM rate_limit_buckets
+WHERE client = $1;
+`
+
+	var tokens float64
+	err := p.db.QueryRowContext(ctx, takeQuery, client, rate, burst).Scan(&tokens)
+	if err == nil {
+		return tokens, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+
+	if err := p.db.QueryRowContext(ctx, levelQuery, client, rate, burst).Scan(&tokens); err != nil {
+		return 0, false, err
+	}
+	return tokens, false, nil
+}
+
+func (p *Postgres) Reserve(ctx context.Context, key UsageKey, n, limit int64) (bool, error) {
+	const query = `
+INSERT INTO rate_limit_usage AS u (client, tenant_id, day, metric, used)
+VALUES ($1, $2, $3::date, $4, $5)
+ON CONFLICT (client, day, metric) DO UPDATE
+SET used = u.used + EXCLUDED.used
+WHERE $6::bigint = 0 OR u.used + GREATEST(EXCLUDED.used, 1) <= $6::bigint
+RETURNING used;
+`
+
+	var used int64
+	err := p.db.QueryRowContext(ctx, query, key.Client, key.Tenant, dayParam(key.Day), key.Metric, n, limit).Scan(&used)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (p *Postgres) Record(ctx context.Context, key UsageKey, n int64) error {
+	const query = `
+INSERT INTO rate_limit_usage AS u (client, tenant_id, day, metric, used)
+VALUES ($1, $2, $3::date, $4, $5)
+ON CONFLICT (client, day, metric) DO UPDATE
+SET used = u.used + EXCLUDED.used;
+`
+
+	_, err := p.db.ExecContext(ctx, query, key.Client, key.Tenant, dayParam(key.Day), key.Metric, n)
+	return err
+}
+
+func (p *Postgres) Usage(ctx context.Context, day time.Time, tenant string) ([]UsageRow, error) {
+	const query = `
+SELECT client, tenant_id, metric, used
+FROM rate_limit_usage
+WHERE day = $1::date
+AND ($2 = '' OR tenant_id = $2)
+ORDER BY client, metric;
+`
+
+	rows, err := p.db.QueryContext(ctx, query, dayParam(day), tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []UsageRow
+	for rows.Next() {
+		var row UsageRow
+		if err := rows.Scan(&row.Client, &row.Tenant, &row.Metric, &row.Used); err != nil {
+			return nil, err
+		}
+		usage = append(usage, row)
+	}
+	return usage, rows.Err()
+}
+
+// dayParam передаёт день строкой: приведение timestamptz к date зависит от
+// часового пояса сессии.
+func dayParam(day time.Time) string {
+	return day.UTC().Format(time.DateOnly)
+}
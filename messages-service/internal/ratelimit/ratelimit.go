@@ -0,0 +1,349 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"messages-service/internal/config"
+	"messages-service/internal/messages"
+)
+
+const (
+	BackendMemory   = "memory"
+	BackendPostgres = "postgres"
+
+	KeyByAPIKey = "api_key"
+	KeyByTenant = "tenant"
+)
+
+// MetricRequests — суточный счётчик запросов клиента; квоты на него нет.
+const MetricRequests = "requests"
+
+// Client — клиент API, с которого списываются запросы и квоты. Tenant
+// пуст для запросов без тенанта.
+type Client struct {
+	ID     string
+	Tenant string
+}
+
+type clientKey struct{}
+
+// ContextWithClient привязывает запрос к клиенту: квоты сервиса
+// списываются с него.
+func ContextWithClient(ctx context.Context, c Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+func ClientFromContext(ctx context.Context) (Client, bool) {
+	c, ok := ctx.Value(clientKey{}).(Client)
+	return c, ok
+}
+
+// Limits — лимиты одного клиента. Нулевые значения — без ограничения.
+type Limits struct {
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst"`
+	MailsPerDay       int64   `json:"mails_per_day"`
+	LLMTokensPerDay   int64   `json:"llm_tokens_per_day"`
+}
+
+// Decision — результат проверки корзины запросов.
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter — через сколько появится следующий запрос; Reset — через
+	// сколько корзина наполнится целиком.
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// UsageKey — суточный счётчик клиента.
+type UsageKey struct {
+	Client string
+	Tenant string
+	Day    time.Time
+	Metric string
+}
+
+// UsageRow — значение суточного счётчика.
+type UsageRow struct {
+	Client string
+	Tenant string
+	Metric string
+	Used   int64
+}
+
+// Store хранит корзины запросов и суточные счётчики. Memory подходит для
+// одной реплики, Postgres — для нескольких.
+type Store interface {
+	// Take забирает из корзины клиента один запрос, если он там есть, и
+	// возвращает оставшееся (или текущее, если запрос не прошёл) число
+	// запросов в корзине.
+	Take(ctx context.Context, client string, rate float64, burst int) (tokens float64, allowed bool, err error)
+	// Reserve увеличивает счётчик на n, если он не превысит limit (0 — без
+	// ограничения); n = 0 проверяет, что счётчик меньше limit.
+	Reserve(ctx context.Context, key UsageKey, n, limit int64) (bool, error)
+	// Record увеличивает счётчик без проверки.
+	Record(ctx context.Context, key UsageKey, n int64) error
+	// Usage возвращает счётчики за день; tenant фильтрует по тенанту.
+	Usage(ctx context.Context, day time.Time, tenant string) ([]UsageRow, error)
+}
+
+// Limiter ограничивает частоту запросов клиентов и их суточный расход
+// писем и токенов LLM.
+type Limiter struct {
+	store    Store
+	keyBy    string
+	defaults Limits
+	tenants  map[string]Limits
+	log      *slog.Logger
+}
+
+// New создаёт ограничитель с хранилищем по настройкам; db нужен для
+// backend=postgres.
+func New(cfg config.RateLimitConfig, db *sql.DB, log *slog.Logger) (*Limiter, error) {
+	var store Store
+	switch cfg.Backend {
+	case "", BackendMemory:
+		store = NewMemory()
+	case BackendPostgres:
+		store = NewPostgres(db)
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend %q", cfg.Backend)
+	}
+
+	switch cfg.KeyBy {
+	case "", KeyByAPIKey, KeyByTenant:
+	default:
+		return nil, fmt.Errorf("unknown rate limit key_by %q", cfg.KeyBy)
+	}
+
+	defaults := limits(cfg.Limits)
+	if err := validateLimits(defaults); err != nil {
+		return nil, fmt.Errorf("rate limit: %w", err)
+	}
+	tenants := make(map[string]Limits, len(cfg.Tenants))
+	for tenant, tc := range cfg.Tenants {
+		l := defaults
+		override := limits(tc)
+		if override.RequestsPerSecond > 0 {
+			l.RequestsPerSecond = override.RequestsPerSecond
+		}
+		if override.Burst > 0 {
+			l.Burst = override.Burst
+		}
+		if override.MailsPerDay > 0 {
+			l.MailsPerDay = override.MailsPerDay
+		}
+		if override.LLMTokensPerDay > 0 {
+			l.LLMTokensPerDay = override.LLMTokensPerDay
+		}
+		if err := validateLimits(l); err != nil {
+			return nil, fmt.Errorf("rate limit for tenant %s: %w", tenant, err)
+		}
+		tenants[tenant] = l
+	}
+
+	keyBy := cfg.KeyBy
+	if keyBy == "" {
+		keyBy = KeyByAPIKey
+	}
+	return &Limiter{
+		store:    store,
+		keyBy:    keyBy,
+		defaults: defaults,
+		tenants:  tenants,
+		log:      log,
+	}, nil
+}
+
+func limits(cfg config.ClientLimitsConfig) Limits {
+	return Limits{
+		RequestsPerSecond: cfg.RequestsPerSecond,
+		Burst:             cfg.Burst,
+		MailsPerDay:       cfg.MailsPerDay,
+		LLMTokensPerDay:   cfg.LLMTokensPerDay,
+	}
+}
+
+func validateLimits(l Limits) error {
+	if l.RequestsPerSecond < 0 || l.Burst < 0 || l.MailsPerDay < 0 || l.LLMTokensPerDay < 0 {
+		return errors.New("limits must not be negative")
+	}
+	if l.RequestsPerSecond > 0 && l.Burst < 1 {
+		return errors.New("burst must be at least 1 when requests_per_second is set")
+	}
+	return nil
+}
+
+// KeyBy — по чему различаются клиенты: KeyByAPIKey или KeyByTenant.
+func (l *Limiter) KeyBy() string {
+	return l.keyBy
+}
+
+// Limits возвращает лимиты клиентов тенанта.
+func (l *Limiter) Limits(tenant string) Limits {
+	if limits, ok := l.tenants[tenant]; ok {
+		return limits
+	}
+	return l.defaults
+}
+
+// Allow забирает запрос из корзины клиента. Ошибка хранилища не блокирует
+// запрос: ограничение частоты — защита, а не учёт.
+func (l *Limiter) Allow(ctx context.Context, c Client) Decision {
+	limits := l.Limits(c.Tenant)
+	if limits.RequestsPerSecond <= 0 {
+		return Decision{Allowed: true}
+	}
+
+	tokens, allowed, err := l.store.Take(ctx, c.ID, limits.RequestsPerSecond, limits.Burst)
+	if err != nil {
+		l.log.Warn("rate limit check failed, request allowed",
+			slog.Any("error", err),
+			slog.String("client", c.ID),
+		)
+		return Decision{Allowed: true}
+	}
+
+	d := Decision{
+		Allowed:   allowed,
+		Limit:     limits.Burst,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     refill(float64(limits.Burst)-tokens, limits.RequestsPerSecond),
+	}
+	if !allowed {
+		d.RetryAfter = refill(1-tokens, limits.RequestsPerSecond)
+	}
+	return d
+}
+
+func refill(missing, rate float64) time.Duration {
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / rate * float64(time.Second))
+}
+
+// Reserve списывает n единиц metric с клиента запроса (messages.UsageLimiter).
+func (l *Limiter) Reserve(ctx context.Context, metric string, n int64) error {
+	c, ok := ClientFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	limit := l.quota(c.Tenant, metric)
+	day := today()
+
+	if limit > 0 && n > limit {
+		return l.quotaError(c, metric, limit, day)
+	}
+	reserved, err := l.store.Reserve(ctx, UsageKey{Client: c.ID, Tenant: c.Tenant, Day: day, Metric: metric}, n, limit)
+	if err != nil {
+		l.log.Warn("quota check failed, usage not counted",
+			slog.Any("error", err),
+			slog.String("client", c.ID),
+			slog.String("metric", metric),
+		)
+		return nil
+	}
+	if !reserved {
+		return l.quotaError(c, metric, limit, day)
+	}
+	return nil
+}
+
+// Record учитывает расход клиента запроса без проверки квоты.
+func (l *Limiter) Record(ctx context.Context, metric string, n int64) {
+	c, ok := ClientFromContext(ctx)
+	if !ok || n == 0 {
+		return
+	}
+	if err := l.store.Record(ctx, UsageKey{Client: c.ID, Tenant: c.Tenant, Day: today(), Metric: metric}, n); err != nil {
+		l.log.Warn("failed to record usage",
+			slog.Any("error", err),
+			slog.String("client", c.ID),
+			slog.String("metric", metric),
+		)
+	}
+}
+
+func (l *Limiter) quota(tenant, metric string) int64 {
+	limits := l.Limits(tenant)
+	switch metric {
+	case messages.UsageMails:
+		return limits.MailsPerDay
+	case messages.UsageLLMTokens:
+		return limits.LLMTokensPerDay
+	default:
+		return 0
+	}
+}
+
+func (l *Limiter) quotaError(c Client, metric string, limit int64, day time.Time) error {
+	l.log.Warn("client quota exceeded",
+		slog.String("client", c.ID),
+		slog.String("tenant", c.Tenant),
+		slog.String("metric", metric),
+		slog.Int64("limit", limit),
+	)
+	return &messages.QuotaError{
+		Scope:  "client " + c.ID,
+		Metric: metric,
+		Limit:  limit,
+		Reset:  day.Add(24 * time.Hour),
+	}
+}
+
+// ClientUsage — расход клиента за день вместе с его лимитами.
+type ClientUsage struct {
+	Client    string `json:"client"`
+	Tenant    string `json:"tenant,omitempty"`
+	Requests  int64  `json:"requests"`
+	Mails     int64  `json:"mails"`
+	LLMTokens int64  `json:"llm_tokens"`
+	Limits    Limits `json:"limits"`
+}
+
+// Usage возвращает расход клиентов за день UTC; непустой tenant оставляет
+// только клиентов тенанта.
+func (l *Limiter) Usage(ctx context.Context, day time.Time, tenant string) ([]ClientUsage, error) {
+	rows, err := l.store.Usage(ctx, day.UTC().Truncate(24*time.Hour), tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	byClient := make(map[string]*ClientUsage)
+	for _, row := range rows {
+		u, ok := byClient[row.Client]
+		if !ok {
+			u = &ClientUsage{Client: row.Client, Tenant: row.Tenant, Limits: l.Limits(row.Tenant)}
+			byClient[row.Client] = u
+		}
+		switch row.Metric {
+		case MetricRequests:
+			u.Requests = row.Used
+		case messages.UsageMails:
+			u.Mails = row.Used
+		case messages.UsageLLMTokens:
+			u.LLMTokens = row.Used
+		}
+	}
+
+	usage := make([]ClientUsage, 0, len(byClient))
+	for _, u := range byClient {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Client < usage[j].Client })
+	return usage, nil
+}
+
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
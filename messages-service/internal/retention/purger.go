@@ -0,0 +1,150 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"messages-service/internal/config"
+	"messages-service/internal/messages"
+)
+
+// Действия правила хранения.
+const (
+	// ActionPurgeBody удаляет текст письма и вложения, оставляя метаданные
+	// и ответ модели.
+	ActionPurgeBody = "purge_body"
+	// ActionPurgeContent дополнительно удаляет model_answer и assistant_response.
+	ActionPurgeContent = "purge_content"
+	// ActionDelete удаляет письмо целиком вместе с вложениями, шагами
+	// согласования, событиями и доставками webhook-ов.
+	ActionDelete = "delete"
+)
+
+// Rule — правило хранения: письма с подходящим статусом и категорией,
+// полученные раньше After назад, обрабатываются действием Action.
+type Rule struct {
+	Action     string
+	Statuses   []string
+	Categories []string
+	After      time.Duration
+}
+
+func (r Rule) validate() error {
+	switch r.Action {
+	case ActionPurgeBody, ActionPurgeContent, ActionDelete:
+	default:
+		return fmt.Errorf("unknown retention action %q", r.Action)
+	}
+	if r.After <= 0 {
+		return fmt.Errorf("retention rule %s: after must be positive", r.Action)
+	}
+	return nil
+}
+
+type Store interface {
+	// PurgeMails применяет правило к не более чем limit письмам, полученным
+	// до before и не стоящим на legal hold. Возвращает число обработанных
+	// писем и ключи удалённых вложений в хранилище blob'ов.
+	PurgeMails(ctx context.Context, rule Rule, before time.Time, limit int) (int, []string, error)
+}
+
+// Purger периодически применяет правила хранения пачками по BatchSize.
+type Purger struct {
+	cfg   config.RetentionConfig
+	rules []Rule
+	store Store
+	blobs messages.BlobStore
+	log   *slog.Logger
+}
+
+func NewPurger(cfg config.RetentionConfig, store Store, blobs messages.BlobStore, log *slog.Logger) (*Purger, error) {
+	rules := make([]Rule, 0, len(cfg.Rules))
+	for _, rc := range cfg.Rules {
+		rule := Rule{
+			Action:     rc.Action,
+			Statuses:   rc.Statuses,
+			Categories: rc.Categories,
+			After:      rc.After,
+		}
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return &Purger{
+		cfg:   cfg,
+		rules: rules,
+		store: store,
+		blobs: blobs,
+		log:   log,
+	}, nil
+}
+
+// Run применяет правила каждые Interval до отмены ctx.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		for _, rule := range p.rules {
+			if err := p.apply(ctx, rule); err != nil && ctx.Err() == nil {
+				p.log.Error("retention rule failed",
+					slog.Any("error", err),
+					slog.String("action", rule.Action),
+				)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Purger) apply(ctx context.Context, rule Rule) error {
+	before := time.Now().Add(-rule.After)
+	total := 0
+	for ctx.Err() == nil {
+		n, keys, err := p.store.PurgeMails(ctx, rule, before, p.cfg.BatchSize)
+		if err != nil {
+			return fmt.Errorf("purge mails: %w", err)
+		}
+		p.deleteBlobs(ctx, keys)
+
+		total += n
+		if n < p.cfg.BatchSize {
+			break
+		}
+	}
+
+	if total > 0 {
+		p.log.Info("retention rule applied",
+			slog.String("action", rule.Action),
+			slog.Any("statuses", rule.Statuses),
+			slog.Any("categories", rule.Categories),
+			slog.Int("mails", total),
+		)
+	}
+	return nil
+}
+
+// deleteBlobs удаляет содержимое вложений. Строки уже удалены, поэтому
+// ошибка только оставляет в хранилище недоступный файл.
+func (p *Purger) deleteBlobs(ctx context.Context, keys []string) {
+	if p.blobs == nil {
+		return
+	}
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := p.blobs.Delete(ctx, key); err != nil {
+			p.log.Warn("failed to delete attachment blob", slog.Any("error", err), slog.String("key", key))
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package sla
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"messages-service/internal/config"
+	"messages-service/internal/messages"
+)
+
+// Escalation — письмо, перешедшее в состояние at_risk или breached.
+type Escalation struct {
+	MailID     string    `json:"id"`
+	TenantID   string    `json:"tenant_id"`
+	State      string    `json:"sla_state"`
+	Deadline   time.Time `json:"sla_deadline"`
+	ReceivedAt time.Time `json:"received_at"`
+	Queue      string    `json:"queue,omitempty"`
+	Department string    `json:"department,omitempty"`
+	From       string    `json:"from"`
+	Subject    string    `json:"subject,omitempty"`
+	Category   string    `json:"category,omitempty"`
+	Urgency    string    `json:"urgency,omitempty"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+type Store interface {
+	// UpdateSLAStates переводит открытые письма в at_risk, когда прошла доля
+	// atRiskRatio срока, и в breached после дедлайна. Возвращает только
+	// письма, чьё состояние изменилось в этом вызове.
+	UpdateSLAStates(ctx context.Context, atRiskRatio float64) ([]Escalation, error)
+}
+
+type Producer interface {
+	Send(ctx context.Context, topic string, key string, value []byte) error
+}
+
+// Scheduler периодически пересчитывает состояние SLA и публикует эскалации
+// в Kafka и на webhook.
+type Scheduler struct {
+	cfg      config.SLAConfig
+	store    Store
+	producer Producer
+	client   *http.Client
+	events   []messages.EventSink
+	log      *slog.Logger
+}
+
+func NewScheduler(cfg config.SLAConfig, store Store, producer Producer, log *slog.Logger) *Scheduler {
+	return &Scheduler{
+		cfg:      cfg,
+		store:    store,
+		producer: producer,
+		client:   &http.Client{Timeout: cfg.WebhookTimeout},
+		log:      log,
+	}
+}
+
+// WithEvents подключает получателей событий mail.sla_at_risk и
+// mail.sla_breached.
+func (s *Scheduler) WithEvents(sinks ...messages.EventSink) *Scheduler {
+	s.events = append(s.events, sinks...)
+	return s
+}
+
+// Run проверяет SLA каждые CheckInterval до отмены ctx.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.check(ctx); err != nil && ctx.Err() == nil {
+			s.log.Error("sla check failed", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) check(ctx context.Context) error {
+	escalations, err := s.store.UpdateSLAStates(ctx, s.cfg.AtRiskRatio)
+	if err != nil {
+		return fmt.Errorf("update sla states: %w", err)
+	}
+
+	// Состояние уже сохранено: ошибка публикации не откатывает его, иначе
+	// следующий цикл отправил бы дубль остальным получателям.
+	for _, e := range escalations {
+		s.escalate(ctx, e)
+	}
+	return nil
+}
+
+func (s *Scheduler) escalate(ctx context.Context, e Escalation) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		s.log.Error("failed to marshal escalation", slog.Any("error", err), slog.String("id", e.MailID))
+		return
+	}
+
+	if s.cfg.EscalationTopic != "" {
+		if err := s.producer.Send(ctx, s.cfg.EscalationTopic, e.MailID, data); err != nil {
+			s.log.Error("failed to send escalation to kafka",
+				slog.Any("error", err),
+				slog.String("id", e.MailID),
+				slog.String("topic", s.cfg.EscalationTopic),
+			)
+		}
+	}
+
+	if s.cfg.WebhookURL != "" {
+		if err := s.postWebhook(ctx, data); err != nil {
+			s.log.Error("failed to post escalation webhook",
+				slog.Any("error", err),
+				slog.String("id", e.MailID),
+			)
+		}
+	}
+
+	s.publishEvent(ctx, e)
+
+	s.log.Warn("mail sla escalated",
+		slog.String("id", e.MailID),
+		slog.String("sla_state", e.State),
+		slog.String("queue", e.Queue),
+		slog.Time("sla_deadline", e.Deadline),
+	)
+}
+
+func (s *Scheduler) publishEvent(ctx context.Context, e Escalation) {
+	eventType := messages.EventMailSLAAtRisk
+	if e.State == messages.SLABreached {
+		eventType = messages.EventMailSLABreached
+	}
+
+	event := messages.NewEvent(eventType, e.MailID)
+	event.TenantID = e.TenantID
+	event.Team = e.Queue
+	event.Data = map[string]any{
+		"sla_state":    e.State,
+		"sla_deadline": e.Deadline,
+		"department":   e.Department,
+		"category":     e.Category,
+		"urgency":      e.Urgency,
+	}
+	for _, sink := range s.events {
+		if err := sink.Publish(ctx, event); err != nil {
+			s.log.Warn("failed to publish sla event",
+				slog.Any("error", err),
+				slog.String("id", e.MailID),
+				slog.String("type", eventType),
+			)
+		}
+	}
+}
+
+func (s *Scheduler) postWebhook(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.WebhookURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"messages-service/internal/messages"
+)
+
+// answerFields — колонки, денормализованные из model_answer, чтобы по ним
+// можно было фильтровать и строить индексы без JSON-операторов.
+type answerFields struct {
+	category          sql.NullString
+	urgency           sql.NullString
+	mainApprover      sql.NullString
+	requiredApprovers any
+	tags              any
+	legalRisks        sql.NullString
+}
+
+// newAnswerFields раскладывает ответ модели по колонкам; для nil все колонки NULL.
+// С sealed теги и юридические риски открыто не пишутся: они есть только
+// в model_answer_enc, а от legal_risks остаётся отметка legalRisksMarker.
+func newAnswerFields(answer *messages.ModelAnswer, sealed bool) answerFields {
+	if answer == nil {
+		return answerFields{requiredApprovers: pq.Array([]string{}), tags: pq.Array([]string{})}
+	}
+	fields := answerFields{
+		category:          nullString(answer.Category),
+		urgency:           nullString(answer.Urgency),
+		mainApprover:      nullString(answer.MainApprover),
+		requiredApprovers: pq.Array(nonNil(answer.RequiredApprovers)),
+		tags:              pq.Array(nonNil(answer.Tags)),
+		legalRisks:        sql.NullString{String: answer.LegalRisks, Valid: true},
+	}
+	if sealed {
+		fields.tags = pq.Array([]string{})
+		if answer.LegalRisks != "" {
+			fields.legalRisks.String = legalRisksMarker
+		}
+	}
+	return fields
+}
+
+// AnswerRow — сохранённый ответ модели для заполнения колонок из model_answer.
+type AnswerRow struct {
+	ID          string
+	ModelAnswer json.RawMessage
+}
+
+// ListAnswersForBackfill возвращает до limit писем с ответом модели и id
+// больше afterID по возрастанию id. С onlyMissing — только письма, у которых
+// колонки ещё не заполнены.
+func (r *Repo) ListAnswersForBackfill(ctx context.Context, afterID string, limit int, onlyMissing bool) ([]AnswerRow, error) {
+	const query = `
+SELECT id, model_answer, model_answer_enc
+FROM mails
+WHERE (model_answer IS NOT NULL OR model_answer_enc IS NOT NULL)
+  AND ($1 = '' OR id > $1::uuid)
+  AND (NOT $3 OR legal_risks IS NULL)
+ORDER BY id
+LIMIT $2;
+`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, afterID, limit, onlyMissing)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var answers []AnswerRow
+	for rows.Next() {
+		var row AnswerRow
+		var sealed []byte
+		if err := rows.Scan(&row.ID, &row.ModelAnswer, &sealed); err != nil {
+			return nil, err
+		}
+		if sealed != nil {
+			plaintext, err := r.open(ctx, sealed, row.ID, "model_answer")
+			if err != nil {
+				return nil, err
+			}
+			row.ModelAnswer = plaintext
+		}
+		answers = append(answers, row)
+	}
+
+	return answers, rows.Err()
+}
+
+// SaveAnswerFields заполняет колонки из model_answer, не трогая updated_at:
+// письмо по сути не менялось.
+func (r *Repo) SaveAnswerFields(ctx context.Context, id string, answer *messages.ModelAnswer) error {
+	const query = `
+UPDATE mails
+SET category = $2,
+urgency = $3,
+main_approver = $4,
+required_approvers = $5,
+tags = $6,
+legal_risks = $7
+WHERE id = $1;
+`
+
+	fields := newAnswerFields(answer, r.cipher != nil)
+	res, err := r.conn(ctx).ExecContext(ctx, query,
+		id,
+		fields.category,
+		fields.urgency,
+		fields.mainApprover,
+		fields.requiredApprovers,
+		fields.tags,
+		fields.legalRisks,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("mail id %s not found", id)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"messages-service/internal/messages"
+)
+
+// replaceApprovalSteps заменяет ожидающие шаги письма новыми. Решённые шаги
+// остаются, и для их команд новый шаг не создаётся.
+func replaceApprovalSteps(ctx context.Context, tx *sql.Tx, mailID string, steps []messages.ApprovalStep) error {
+	const deletePending = `DELETE FROM approval_steps WHERE mail_id = $1 AND status = 'pending';`
+	const decidedTeams = `SELECT team FROM approval_steps WHERE mail_id = $1;`
+	const insert = `
+INSERT INTO approval_steps (id, mail_id, step_order, team, status)
+VALUES ($1, $2, $3, $4, $5);
+`
+
+	if _, err := tx.ExecContext(ctx, deletePending, mailID); err != nil {
+		return fmt.Errorf("delete approval steps: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, decidedTeams, mailID)
+	if err != nil {
+		return fmt.Errorf("list decided approval steps: %w", err)
+	}
+	decided := make(map[string]bool)
+	for rows.Next() {
+		var team string
+		if err := rows.Scan(&team); err != nil {
+			rows.Close()
+			return err
+		}
+		decided[team] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		if decided[step.Team] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, insert, uuid.NewString(), mailID, step.Order, step.Team, step.Status); err != nil {
+			return fmt.Errorf("insert approval step %s: %w", step.Team, err)
+		}
+	}
+	return nil
+}
+
+func (r *Repo) ListApprovalSteps(ctx context.Context, mailID string) ([]messages.ApprovalStep, error) {
+	const query = `
+SELECT id, mail_id, step_order, team, status, approver, comment, decided_at
+FROM approval_steps
+WHERE mail_id = $1
+ORDER BY step_order, created_at, team;
+`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, mailID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []messages.ApprovalStep
+	for rows.Next() {
+		var step messages.ApprovalStep
+		var approver sql.NullString
+		var comment sql.NullString
+		var decidedAt sql.NullTime
+		if err := rows.Scan(
+			&step.ID,
+			&step.MailID,
+			&step.Order,
+			&step.Team,
+			&step.Status,
+			&approver,
+			&comment,
+			&decidedAt,
+		); err != nil {
+			return nil, err
+		}
+		step.Approver = approver.String
+		step.Comment = comment.String
+		if decidedAt.Valid {
+			t := decidedAt.Time
+			step.DecidedAt = &t
+		}
+		steps = append(steps, step)
+	}
+
+	return steps, rows.Err()
+}
+
+// DecideApprovalStep блокирует строку письма, чтобы параллельные решения
+// разных команд видели результат друг друга при подсчёте итогового статуса.
+func (r *Repo) DecideApprovalStep(ctx context.Context, mailID string, d messages.ApprovalDecision, queueReply bool) (string, error) {
+	const lockMail = `SELECT id FROM mails WHERE id = $1 FOR UPDATE;`
+	const decide = `
+UPDATE approval_steps
+SET status = $3,
+approver = $4,
+comment = $5,
+decided_at = NOW()
+WHERE mail_id = $1 AND team = $2 AND status = 'pending';
+`
+	const skipRest = `
+UPDATE approval_steps
+SET status = 'skipped'
+WHERE mail_id = $1 AND status = 'pending';
+`
+	const countPending = `
+SELECT COUNT(*) FROM approval_steps WHERE mail_id = $1 AND status <> 'approved';
+`
+	const finish = `
+UPDATE mails
+SET is_approved = $2,
+status = $3,
+sla_state = CASE
+    WHEN sla_state IN ('ok', 'at_risk') AND $2 THEN 'met'
+    WHEN sla_state IN ('ok', 'at_risk') THEN 'rejected'
+    ELSE sla_state
+END,
+updated_at = NOW()
+WHERE id = $1;
+`
+
+	tx, err := r.conn(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var id string
+	if err := tx.QueryRowContext(ctx, lockMail, mailID).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("mail id %s not found", mailID)
+		}
+		return "", err
+	}
+
+	stepStatus := messages.StepApproved
+	if d.Decision == messages.DecisionReject {
+		stepStatus = messages.StepRejected
+	}
+	res, err := tx.ExecContext(ctx, decide, mailID, d.Team, stepStatus, d.Approver, nullString(d.Comment))
+	if err != nil {
+		return "", err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if rows == 0 {
+		return "", fmt.Errorf("%w: team %s has no pending step", messages.ErrApprovalConflict, d.Team)
+	}
+
+	status := messages.StepPending
+	if stepStatus == messages.StepRejected {
+		if _, err := tx.ExecContext(ctx, skipRest, mailID); err != nil {
+			return "", err
+		}
+		status = messages.StatusRejected
+	} else {
+		var remaining int
+		if err := tx.QueryRowContext(ctx, countPending, mailID).Scan(&remaining); err != nil {
+			return "", err
+		}
+		if remaining == 0 {
+			status = messages.StatusApproved
+		}
+	}
+
+	if status != messages.StepPending {
+		if _, err := tx.ExecContext(ctx, finish, mailID, status == messages.StatusApproved, status); err != nil {
+			return "", err
+		}
+	}
+	if status == messages.StatusApproved && queueReply {
+		if err := createDeliveryTx(ctx, tx, mailID); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return status, nil
+}
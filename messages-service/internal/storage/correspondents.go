@@ -0,0 +1,388 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+
+	"github.com/lib/pq"
+
+	"messages-service/internal/messages"
+)
+
+// upsertSenders заводит отправителей писем в справочнике тенанта и
+// проставляет письмам SenderID. Письма одного вызова принадлежат одному
+// тенанту. Адреса сортируются, чтобы параллельные пачки брали блокировки
+// строк senders в одном порядке.
+func (r *Repo) upsertSenders(ctx context.Context, tx *sql.Tx, mails []*messages.Mail) error {
+	const query = `
+INSERT INTO senders (tenant_id, email, display_name)
+SELECT $1, * FROM unnest($2::text[], $3::text[])
+ON CONFLICT (tenant_id, email) DO UPDATE
+SET display_name = CASE WHEN EXCLUDED.display_name <> '' THEN EXCLUDED.display_name ELSE senders.display_name END,
+updated_at = NOW()
+RETURNING id, email;
+`
+
+	names := make(map[string]string)
+	for _, m := range mails {
+		email, name := messages.SenderAddress(m.From)
+		if email == "" {
+			continue
+		}
+		if _, ok := names[email]; !ok || name != "" {
+			names[email] = name
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	emails := make([]string, 0, len(names))
+	for email := range names {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	displayNames := make([]string, len(emails))
+	for i, email := range emails {
+		displayNames[i] = names[email]
+	}
+
+	rows, err := tx.QueryContext(ctx, query, tenantID(mails[0]), pq.Array(emails), pq.Array(displayNames))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]string, len(emails))
+	for rows.Next() {
+		var id, email string
+		if err := rows.Scan(&id, &email); err != nil {
+			return err
+		}
+		ids[email] = id
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range mails {
+		email, _ := messages.SenderAddress(m.From)
+		m.SenderID = ids[email]
+	}
+	return nil
+}
+
+// linkOrganization находит или заводит организацию по реквизитам в
+// справочнике тенанта письма и привязывает к ней отправителя письма.
+// Контрагент с ИНН ищется по ИНН, без ИНН — по названию. Отправителя, уже
+// привязанного к организации, реквизиты не трогают: в письме могут быть
+// реквизиты третьей стороны.
+func linkOrganization(ctx context.Context, tx *sql.Tx, mailID string, req messages.Requisites) error {
+	const byINN = `
+INSERT INTO organizations (tenant_id, inn, name)
+SELECT tenant_id, $2, $3 FROM mails WHERE id = $1
+ON CONFLICT (tenant_id, inn) WHERE inn IS NOT NULL DO UPDATE
+SET name = CASE WHEN EXCLUDED.name <> '' THEN EXCLUDED.name ELSE organizations.name END,
+updated_at = NOW()
+RETURNING id;
+`
+	const findByName = `
+SELECT o.id
+FROM organizations o
+JOIN mails m ON m.tenant_id = o.tenant_id
+WHERE m.id = $1 AND lower(o.name) = lower($2)
+ORDER BY o.inn NULLS LAST, o.created_at
+LIMIT 1;
+`
+	const byName = `
+INSERT INTO organizations (tenant_id, name)
+SELECT tenant_id, $2 FROM mails WHERE id = $1
+ON CONFLICT (tenant_id, lower(name)) WHERE inn IS NULL DO UPDATE
+SET updated_at = NOW()
+RETURNING id;
+`
+	const linkedQuery = `
+SELECT EXISTS (
+    SELECT 1
+    FROM senders s
+    JOIN mails m ON m.sender_id = s.id
+    WHERE m.id = $1 AND s.organization_id IS NOT NULL
+);
+`
+	const link = `
+UPDATE senders
+SET organization_id = $2,
+updated_at = NOW()
+WHERE id = (SELECT sender_id FROM mails WHERE id = $1)
+  AND organization_id IS NULL;
+`
+
+	if req.Empty() {
+		return nil
+	}
+
+	var linked bool
+	if err := tx.QueryRowContext(ctx, linkedQuery, mailID).Scan(&linked); err != nil {
+		return err
+	}
+	if linked {
+		return nil
+	}
+
+	var orgID string
+	if req.INN != "" {
+		if err := tx.QueryRowContext(ctx, byINN, mailID, req.INN, req.Organization).Scan(&orgID); err != nil {
+			return err
+		}
+	} else {
+		err := tx.QueryRowContext(ctx, findByName, mailID, req.Organization).Scan(&orgID)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = tx.QueryRowContext(ctx, byName, mailID, req.Organization).Scan(&orgID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, link, mailID, orgID)
+	return err
+}
+
+// LinkSenderOrganization привязывает отправителя письма к организации по
+// реквизитам; используется командой backfill для уже обработанных писем.
+func (r *Repo) LinkSenderOrganization(ctx context.Context, mailID string, req messages.Requisites) error {
+	if req.Empty() {
+		return nil
+	}
+
+	tx, err := r.conn(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := linkOrganization(ctx, tx, mailID, req); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *Repo) FindSenderID(ctx context.Context, email string) (string, error) {
+	const query = `SELECT id FROM senders WHERE email = $1;`
+
+	var id string
+	err := r.conn(ctx).QueryRowContext(ctx, query, email).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return id, nil
+}
+
+func (r *Repo) GetSender(ctx context.Context, id string, complaintCategories []string, historyLimit int) (*messages.SenderProfile, error) {
+	const query = `
+SELECT s.id, s.email, s.display_name, o.id, o.inn, o.name,
+COUNT(m.id),
+COUNT(m.id) FILTER (WHERE m.category = ANY($2)),
+MIN(m.received_at),
+MAX(m.received_at)
+FROM senders s
+LEFT JOIN organizations o ON o.id = s.organization_id
+LEFT JOIN mails m ON m.sender_id = s.id
+WHERE s.id = $1
+GROUP BY s.id, o.id;
+`
+	const categoriesQuery = `
+SELECT category, COUNT(*)
+FROM mails
+WHERE sender_id = $1 AND category IS NOT NULL
+GROUP BY category;
+`
+	historyQuery := `
+SELECT ` + mailListColumns + `, processed
+FROM mails
+WHERE sender_id = $1
+ORDER BY received_at DESC, id
+LIMIT $2;
+`
+
+	var (
+		profile   messages.SenderProfile
+		org       organizationColumns
+		firstSeen sql.NullTime
+		lastSeen  sql.NullTime
+	)
+	err := r.conn(ctx).QueryRowContext(ctx, query, id, pq.Array(complaintCategories)).Scan(
+		&profile.ID,
+		&profile.Email,
+		&profile.DisplayName,
+		&org.id,
+		&org.inn,
+		&org.name,
+		&profile.MailCount,
+		&profile.ComplaintCount,
+		&firstSeen,
+		&lastSeen,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	profile.Organization = org.organization()
+	if firstSeen.Valid {
+		profile.FirstSeenAt = &firstSeen.Time
+	}
+	if lastSeen.Valid {
+		profile.LastSeenAt = &lastSeen.Time
+	}
+
+	profile.Categories = make(map[string]int)
+	rows, err := r.conn(ctx).QueryContext(ctx, categoriesQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			category string
+			count    int
+		)
+		if err := rows.Scan(&category, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		profile.Categories[category] = count
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = r.conn(ctx).QueryContext(ctx, historyQuery, id, historyLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profile.Mails = []messages.Mail{}
+	for rows.Next() {
+		var processed bool
+		mail, sealed, err := scanListMail(rows, &processed)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.openListMail(ctx, &mail, sealed); err != nil {
+			return nil, err
+		}
+		mail.Processed = processed
+		profile.Mails = append(profile.Mails, mail)
+	}
+
+	return &profile, rows.Err()
+}
+
+func (r *Repo) SenderSummaries(ctx context.Context, ids []string, complaintCategories []string) (map[string]messages.SenderSummary, error) {
+	const query = `
+SELECT s.id, s.email, o.id, o.inn, o.name,
+COUNT(m.id),
+COUNT(m.id) FILTER (WHERE m.category = ANY($2))
+FROM senders s
+LEFT JOIN organizations o ON o.id = s.organization_id
+LEFT JOIN mails m ON m.sender_id = s.id
+WHERE s.id = ANY($1::uuid[])
+GROUP BY s.id, o.id;
+`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, pq.Array(ids), pq.Array(complaintCategories))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := make(map[string]messages.SenderSummary, len(ids))
+	for rows.Next() {
+		var (
+			summary messages.SenderSummary
+			org     organizationColumns
+		)
+		if err := rows.Scan(
+			&summary.ID,
+			&summary.Email,
+			&org.id,
+			&org.inn,
+			&org.name,
+			&summary.MailCount,
+			&summary.ComplaintCount,
+		); err != nil {
+			return nil, err
+		}
+		summary.Organization = org.organization()
+		summaries[summary.ID] = summary
+	}
+
+	return summaries, rows.Err()
+}
+
+func (r *Repo) DeleteSender(ctx context.Context, email string) error {
+	const query = `
+WITH del AS (
+    DELETE FROM senders s
+    WHERE s.email = $1
+      AND NOT EXISTS (SELECT 1 FROM mails m WHERE m.sender_id = s.id)
+    RETURNING s.organization_id
+)
+DELETE FROM organizations o
+USING del
+WHERE o.id = del.organization_id
+  AND NOT EXISTS (SELECT 1 FROM senders s WHERE s.organization_id = o.id AND s.email <> $1);
+`
+
+	_, err := r.conn(ctx).ExecContext(ctx, query, email)
+	return err
+}
+
+// deleteOrphanSenders удаляет из ids отправителей без писем и их
+// организации, к которым больше не привязан ни один отправитель.
+func deleteOrphanSenders(ctx context.Context, tx *sql.Tx, ids []string) error {
+	const query = `
+WITH del AS (
+    DELETE FROM senders s
+    WHERE s.id = ANY($1::uuid[])
+      AND NOT EXISTS (SELECT 1 FROM mails m WHERE m.sender_id = s.id)
+    RETURNING s.id, s.organization_id
+)
+DELETE FROM organizations o
+USING del
+WHERE o.id = del.organization_id
+  AND NOT EXISTS (
+      SELECT 1 FROM senders s
+      WHERE s.organization_id = o.id AND s.id NOT IN (SELECT id FROM del)
+  );
+`
+
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, query, pq.Array(ids))
+	return err
+}
+
+// organizationColumns — колонки organizations из LEFT JOIN.
+type organizationColumns struct {
+	id   sql.NullString
+	inn  sql.NullString
+	name sql.NullString
+}
+
+func (c organizationColumns) organization() *messages.Organization {
+	if !c.id.Valid {
+		return nil
+	}
+	return &messages.Organization{ID: c.id.String, INN: c.inn.String, Name: c.name.String}
+}
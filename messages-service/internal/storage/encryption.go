@@ -0,0 +1,462 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"messages-service/internal/fieldcrypt"
+	"messages-service/internal/messages"
+)
+
+// ErrEncrypted — в строке есть зашифрованные значения, а Repo создан без шифра.
+var ErrEncrypted = errors.New("value is encrypted but encryption is not configured")
+
+type Option func(*Repo)
+
+// WithCipher включает шифрование текста писем, ответов модели и ассистента
+// и текста вложений. Открытые значения, записанные до включения,
+// по-прежнему читаются; их шифрует ReencryptMails.
+func WithCipher(c *fieldcrypt.Cipher) Option {
+	return func(r *Repo) {
+		r.cipher = c
+	}
+}
+
+// sealedMail — зашифрованные колонки письма; nil — значение хранится открыто.
+type sealedMail struct {
+	input             []byte
+	modelAnswer       []byte
+	assistantResponse []byte
+}
+
+// fieldAAD привязывает шифртекст к строке и колонке.
+func fieldAAD(id, column string) []byte {
+	return []byte(column + ":" + id)
+}
+
+// sealText возвращает значения для открытой и зашифрованной колонок.
+func (r *Repo) sealText(ctx context.Context, text, id, column string) (string, []byte, error) {
+	if r.cipher == nil {
+		return text, nil, nil
+	}
+	sealed, err := r.cipher.Encrypt(ctx, []byte(text), fieldAAD(id, column))
+	if err != nil {
+		return "", nil, fmt.Errorf("encrypt %s: %w", column, err)
+	}
+	return "", sealed, nil
+}
+
+// sealJSON — то же для JSON-колонок; nil остаётся NULL в обеих колонках.
+func (r *Repo) sealJSON(ctx context.Context, value json.RawMessage, id, column string) (json.RawMessage, []byte, error) {
+	if r.cipher == nil || value == nil {
+		return value, nil, nil
+	}
+	sealed, err := r.cipher.Encrypt(ctx, value, fieldAAD(id, column))
+	if err != nil {
+		return nil, nil, fmt.Errorf("encrypt %s: %w", column, err)
+	}
+	return nil, sealed, nil
+}
+
+func (r *Repo) open(ctx context.Context, sealed []byte, id, column string) ([]byte, error) {
+	if r.cipher == nil {
+		return nil, ErrEncrypted
+	}
+	plaintext, err := r.cipher.Decrypt(ctx, sealed, fieldAAD(id, column))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s of %s: %w", column, id, err)
+	}
+	return plaintext, nil
+}
+
+// openMail подставляет расшифрованные значения в поля письма.
+func (r *Repo) openMail(ctx context.Context, id string, sealed sealedMail, input *string, modelAnswer, assistantResponse *json.RawMessage) error {
+	if sealed.input != nil {
+		plaintext, err := r.open(ctx, sealed.input, id, "input")
+		if err != nil {
+			return err
+		}
+		*input = string(plaintext)
+	}
+	if sealed.modelAnswer != nil {
+		plaintext, err := r.open(ctx, sealed.modelAnswer, id, "model_answer")
+		if err != nil {
+			return err
+		}
+		*modelAnswer = plaintext
+	}
+	if sealed.assistantResponse != nil {
+		plaintext, err := r.open(ctx, sealed.assistantResponse, id, "assistant_response")
+		if err != nil {
+			return err
+		}
+		*assistantResponse = plaintext
+	}
+	return nil
+}
+
+// legalRisksMarker — значение legal_risks зашифрованного письма с непустыми
+// рисками: фильтр has_legal_risks и его индекс работают, а сам текст
+// хранится только в model_answer_enc.
+const legalRisksMarker = "*"
+
+// openListMail расшифровывает письмо из списка и восстанавливает tags и
+// legal_risks, которых нет в открытых колонках.
+func (r *Repo) openListMail(ctx context.Context, mail *messages.Mail, sealed sealedMail) error {
+	if err := r.openMail(ctx, mail.ID, sealed, &mail.Input, &mail.ModelAnswer, &mail.AssistantResp); err != nil {
+		return err
+	}
+	if sealed.modelAnswer == nil {
+		return nil
+	}
+	answer, err := messages.ParseModelAnswer(mail.ModelAnswer)
+	if err != nil {
+		// Такой ответ и при сохранении не раскладывается по колонкам.
+		return nil
+	}
+	mail.Tags = nonNil(answer.Tags)
+	mail.LegalRisks = answer.LegalRisks
+	return nil
+}
+
+// checkListFilter отклоняет фильтр по тегам: при шифровании колонка tags пуста.
+func (r *Repo) checkListFilter(filter messages.ListFilter) error {
+	if r.cipher != nil && len(filter.Tags) > 0 {
+		return fmt.Errorf("%w: tag filter is unavailable with encryption", messages.ErrInvalidFilter)
+	}
+	return nil
+}
+
+// encKeyID — значение enc_key_id для строки, в которую пишется хотя бы
+// одно зашифрованное поле. В UPDATE оно подставляется через
+// COALESCE(enc_key_id, ...): более старый ключ остаётся, чтобы строку
+// перешифровал ReencryptMails.
+func (r *Repo) encKeyID() sql.NullString {
+	if r.cipher == nil {
+		return sql.NullString{}
+	}
+	return nullString(r.cipher.KeyID())
+}
+
+// ReencryptMails шифрует текущим ключом письма с открытыми значениями или
+// со значениями под другим мастер-ключом и убирает открытые tags и
+// legal_risks (см. newAnswerFields). updated_at не меняется.
+func (r *Repo) ReencryptMails(ctx context.Context, limit int) (int, error) {
+	const selectQuery = `
+SELECT id, input, input_enc, model_answer, model_answer_enc, assistant_response, assistant_response_enc
+FROM mails
+WHERE input <> ''
+   OR model_answer IS NOT NULL
+   OR assistant_response IS NOT NULL
+   OR cardinality(tags) > 0
+   OR legal_risks NOT IN ('', '*')
+   OR enc_key_id <> $1
+ORDER BY id
+LIMIT $2
+FOR UPDATE SKIP LOCKED;
+`
+	const updateQuery = `
+UPDATE mails
+SET input = '',
+input_enc = $2,
+model_answer = NULL,
+model_answer_enc = $3,
+assistant_response = NULL,
+assistant_response_enc = $4,
+tags = '{}',
+legal_risks = CASE WHEN legal_risks <> '' THEN '*' ELSE legal_risks END,
+enc_key_id = $5
+WHERE id = $1;
+`
+
+	if r.cipher == nil {
+		return 0, ErrEncrypted
+	}
+	keyID := r.cipher.KeyID()
+
+	tx, err := r.conn(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	type row struct {
+		id                string
+		input             string
+		modelAnswer       json.RawMessage
+		assistantResponse json.RawMessage
+		sealed            sealedMail
+	}
+
+	rows, err := tx.QueryContext(ctx, selectQuery, keyID, limit)
+	if err != nil {
+		return 0, err
+	}
+	var batch []row
+	for rows.Next() {
+		var (
+			m                 row
+			assistantResponse sql.NullString
+		)
+		if err := rows.Scan(
+			&m.id,
+			&m.input,
+			&m.sealed.input,
+			&m.modelAnswer,
+			&m.sealed.modelAnswer,
+			&assistantResponse,
+			&m.sealed.assistantResponse,
+		); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if assistantResponse.Valid {
+			m.assistantResponse = json.RawMessage(assistantResponse.String)
+		}
+		batch = append(batch, m)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, err
+	}
+	rows.Close()
+
+	for _, m := range batch {
+		if err := r.openMail(ctx, m.id, m.sealed, &m.input, &m.modelAnswer, &m.assistantResponse); err != nil {
+			return 0, err
+		}
+
+		var sealed sealedMail
+		if m.input != "" {
+			if _, sealed.input, err = r.sealText(ctx, m.input, m.id, "input"); err != nil {
+				return 0, err
+			}
+		}
+		if _, sealed.modelAnswer, err = r.sealJSON(ctx, m.modelAnswer, m.id, "model_answer"); err != nil {
+			return 0, err
+		}
+		if _, sealed.assistantResponse, err = r.sealJSON(ctx, m.assistantResponse, m.id, "assistant_response"); err != nil {
+			return 0, err
+		}
+
+		var rowKeyID sql.NullString
+		if sealed.input != nil || sealed.modelAnswer != nil || sealed.assistantResponse != nil {
+			rowKeyID = nullString(keyID)
+		}
+		if _, err := tx.ExecContext(ctx, updateQuery,
+			m.id,
+			sealed.input,
+			sealed.modelAnswer,
+			sealed.assistantResponse,
+			rowKeyID,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(batch), nil
+}
+
+// ReencryptAttachments — то же для extracted_text вложений.
+func (r *Repo) ReencryptAttachments(ctx context.Context, limit int) (int, error) {
+	const selectQuery = `
+SELECT id, extracted_text, extracted_text_enc
+FROM attachments
+WHERE extracted_text <> ''
+   OR enc_key_id <> $1
+ORDER BY id
+LIMIT $2
+FOR UPDATE SKIP LOCKED;
+`
+	const updateQuery = `
+UPDATE attachments
+SET extracted_text = '',
+extracted_text_enc = $2,
+enc_key_id = $3
+WHERE id = $1;
+`
+
+	if r.cipher == nil {
+		return 0, ErrEncrypted
+	}
+	keyID := r.cipher.KeyID()
+
+	tx, err := r.conn(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	type row struct {
+		id     string
+		text   string
+		sealed []byte
+	}
+
+	rows, err := tx.QueryContext(ctx, selectQuery, keyID, limit)
+	if err != nil {
+		return 0, err
+	}
+	var batch []row
+	for rows.Next() {
+		var a row
+		if err := rows.Scan(&a.id, &a.text, &a.sealed); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, a)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, err
+	}
+	rows.Close()
+
+	for _, a := range batch {
+		if a.sealed != nil {
+			plaintext, err := r.open(ctx, a.sealed, a.id, "extracted_text")
+			if err != nil {
+				return 0, err
+			}
+			a.text = string(plaintext)
+		}
+
+		var (
+			sealed   []byte
+			rowKeyID sql.NullString
+		)
+		if a.text != "" {
+			if _, sealed, err = r.sealText(ctx, a.text, a.id, "extracted_text"); err != nil {
+				return 0, err
+			}
+			rowKeyID = nullString(keyID)
+		}
+		if _, err := tx.ExecContext(ctx, updateQuery, a.id, sealed, rowKeyID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(batch), nil
+}
+
+// ReencryptEvents — то же для данных событий журнала.
+func (r *Repo) ReencryptEvents(ctx context.Context, limit int) (int, error) {
+	return r.reencryptJSON(ctx, limit, sealedColumn{
+		table:  "mail_events",
+		key:    "seq",
+		aad:    "id",
+		plain:  "data",
+		sealed: "data_enc",
+	})
+}
+
+// ReencryptWebhookDeliveries — то же для тел доставок вебхуков.
+func (r *Repo) ReencryptWebhookDeliveries(ctx context.Context, limit int) (int, error) {
+	return r.reencryptJSON(ctx, limit, sealedColumn{
+		table:  "webhook_deliveries",
+		key:    "id",
+		aad:    "event_id",
+		plain:  "payload",
+		sealed: "payload_enc",
+	})
+}
+
+// sealedColumn описывает открытую JSON-колонку таблицы и её зашифрованную
+// пару. Шифртекст привязан к значению колонки aad и имени колонки plain.
+type sealedColumn struct {
+	table  string
+	key    string
+	aad    string
+	plain  string
+	sealed string
+}
+
+func (r *Repo) reencryptJSON(ctx context.Context, limit int, c sealedColumn) (int, error) {
+	selectQuery := fmt.Sprintf(`
+SELECT %[2]s, %[3]s, %[4]s, %[5]s
+FROM %[1]s
+WHERE %[4]s IS NOT NULL
+   OR enc_key_id <> $1
+ORDER BY %[2]s
+LIMIT $2
+FOR UPDATE SKIP LOCKED;
+`, c.table, c.key, c.aad, c.plain, c.sealed)
+	updateQuery := fmt.Sprintf(`
+UPDATE %[1]s
+SET %[3]s = NULL,
+%[4]s = $2,
+enc_key_id = $3
+WHERE %[2]s = $1;
+`, c.table, c.key, c.plain, c.sealed)
+
+	if r.cipher == nil {
+		return 0, ErrEncrypted
+	}
+	keyID := r.cipher.KeyID()
+
+	tx, err := r.conn(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	type row struct {
+		key    string
+		aad    string
+		value  []byte
+		sealed []byte
+	}
+
+	rows, err := tx.QueryContext(ctx, selectQuery, keyID, limit)
+	if err != nil {
+		return 0, err
+	}
+	var batch []row
+	for rows.Next() {
+		var v row
+		if err := rows.Scan(&v.key, &v.aad, &v.value, &v.sealed); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, v)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, err
+	}
+	rows.Close()
+
+	for _, v := range batch {
+		if v.sealed != nil {
+			if v.value, err = r.open(ctx, v.sealed, v.aad, c.plain); err != nil {
+				return 0, err
+			}
+		}
+
+		_, sealed, err := r.sealJSON(ctx, v.value, v.aad, c.plain)
+		if err != nil {
+			return 0, err
+		}
+		var rowKeyID sql.NullString
+		if sealed != nil {
+			rowKeyID = nullString(keyID)
+		}
+		if _, err := tx.ExecContext(ctx, updateQuery, v.key, sealed, rowKeyID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(batch), nil
+}
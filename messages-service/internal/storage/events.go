@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+
+	"messages-service/internal/messages"
+	"messages-service/internal/stream"
+)
+
+// eventsLockKey — ключ advisory-блокировки записи в журнал событий.
+const eventsLockKey = 0x6d61696c5f657674 // "mail_evt"
+
+// AppendEvent берёт seq и фиксирует событие под транзакционной
+// advisory-блокировкой, поэтому события становятся видны строго в порядке
+// seq. Иначе читатель с курсором seq > last пропустил бы событие, которое
+// получило меньший seq, но закоммитилось позже.
+func (r *Repo) AppendEvent(ctx context.Context, event messages.Event) (stream.Record, error) {
+	const lock = `SELECT pg_advisory_xact_lock($1);`
+	const query = `
+INSERT INTO mail_events (id, type, mail_id, team, status, data, data_enc, enc_key_id, at)
+VALUES ($1, $2, $3, COALESCE(NULLIF($4, ''), (SELECT queue FROM mails WHERE id = $3), ''), $5, $6, $7, $8, $9)
+RETURNING seq, team, tenant_id;
+`
+
+	var data json.RawMessage
+	if len(event.Data) > 0 {
+		var err error
+		if data, err = json.Marshal(event.Data); err != nil {
+			return stream.Record{}, err
+		}
+	}
+	data, dataEnc, err := r.sealJSON(ctx, data, event.ID, "data")
+	if err != nil {
+		return stream.Record{}, err
+	}
+	var keyID sql.NullString
+	if dataEnc != nil {
+		keyID = r.encKeyID()
+	}
+
+	tx, err := r.conn(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return stream.Record{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, lock, int64(eventsLockKey)); err != nil {
+		return stream.Record{}, err
+	}
+	record := stream.Record{Event: event}
+	if err := tx.QueryRowContext(ctx, query,
+		event.ID, event.Type, event.MailID, event.Team, event.Status, []byte(data), dataEnc, keyID, event.At,
+	).Scan(&record.Seq, &record.Team, &record.TenantID); err != nil {
+		return stream.Record{}, err
+	}
+	return record, tx.Commit()
+}
+
+func (r *Repo) ListEvents(ctx context.Context, after int64, filter stream.Filter, limit int) ([]stream.Record, error) {
+	const query = `
+SELECT seq, id, type, mail_id, tenant_id, team, status, data, data_enc, at
+FROM mail_events
+WHERE seq > $1
+  AND ($2 = '' OR team = $2)
+  AND (cardinality($3::text[]) = 0 OR status = ANY($3))
+  AND ($5 = '' OR tenant_id = $5)
+ORDER BY seq
+LIMIT $4;
+`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, after, filter.Team, pq.Array(nonNil(filter.Statuses)), limit, filter.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []stream.Record
+	for rows.Next() {
+		var (
+			record  stream.Record
+			data    []byte
+			dataEnc []byte
+		)
+		if err := rows.Scan(
+			&record.Seq, &record.ID, &record.Type, &record.MailID,
+			&record.TenantID, &record.Team, &record.Status, &data, &dataEnc, &record.At,
+		); err != nil {
+			return nil, err
+		}
+		if dataEnc != nil {
+			if data, err = r.open(ctx, dataEnc, record.ID, "data"); err != nil {
+				return nil, err
+			}
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &record.Data); err != nil {
+				return nil, err
+			}
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+func (r *Repo) LatestEventSeq(ctx context.Context) (int64, error) {
+	var seq sql.NullInt64
+	if err := r.conn(ctx).QueryRowContext(ctx, `SELECT MAX(seq) FROM mail_events;`).Scan(&seq); err != nil {
+		return 0, err
+	}
+	return seq.Int64, nil
+}
+
+func (r *Repo) DeleteEventsBefore(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.conn(ctx).ExecContext(ctx, `DELETE FROM mail_events WHERE at < $1;`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
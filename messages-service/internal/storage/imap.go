@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+func (r *Repo) GetIMAPCheckpoint(ctx context.Context, mailbox string) (uint32, uint32, error) {
+	const query = `
+SELECT uid_validity, last_uid
+FROM imap_checkpoints
+WHERE mailbox = $1;
+`
+
+	var uidValidity, lastUID int64
+	err := r.conn(ctx).QueryRowContext(ctx, query, mailbox).Scan(&uidValidity, &lastUID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+
+	return uint32(uidValidity), uint32(lastUID), nil
+}
+
+func (r *Repo) SaveIMAPCheckpoint(ctx context.Context, mailbox string, uidValidity uint32, lastUID uint32) error {
+	const query = `
+INSERT INTO imap_checkpoints (mailbox, uid_validity, last_uid, updated_at)
+VALUES ($1, $2, $3, NOW())
+ON CONFLICT (mailbox) DO UPDATE
+SET uid_validity = EXCLUDED.uid_validity,
+last_uid = EXCLUDED.last_uid,
+updated_at = NOW();
+`
+
+	_, err := r.conn(ctx).ExecContext(ctx, query, mailbox, int64(uidValidity), int64(lastUID))
+	return err
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"messages-service/internal/outbound"
+
+	"github.com/google/uuid"
+)
+
+// createDeliveryQuery ставит ответ в очередь; неотправленная доставка
+// возвращается в очередь со сброшенным счётчиком, отправленная не трогается.
+const createDeliveryQuery = `
+INSERT INTO outbound_deliveries (id, mail_id, status)
+VALUES ($1, $2, 'pending')
+ON CONFLICT (mail_id) DO UPDATE
+SET status = 'pending',
+attempts = 0,
+next_attempt_at = NOW(),
+updated_at = NOW()
+WHERE outbound_deliveries.status <> 'sent';
+`
+
+func (r *Repo) CreateDelivery(ctx context.Context, mailID string) error {
+	_, err := r.conn(ctx).ExecContext(ctx, createDeliveryQuery, uuid.NewString(), mailID)
+	return err
+}
+
+// createDeliveryTx ставит ответ в очередь в транзакции аппрува, чтобы
+// утверждённое письмо не осталось без ответа, если процесс упадёт между
+// двумя шагами.
+func createDeliveryTx(ctx context.Context, tx *sql.Tx, mailID string) error {
+	if _, err := tx.ExecContext(ctx, createDeliveryQuery, uuid.NewString(), mailID); err != nil {
+		return fmt.Errorf("create delivery: %w", err)
+	}
+	return nil
+}
+
+// ClaimDueDeliveries забирает доставки, готовые к попытке, и сдвигает их
+// next_attempt_at на lease, чтобы их не взяла другая реплика.
+func (r *Repo) ClaimDueDeliveries(ctx context.Context, limit int, maxAttempts int, lease time.Duration) ([]outbound.Delivery, error) {
+	const query = `
+UPDATE outbound_deliveries
+SET next_attempt_at = NOW() + $3::int * INTERVAL '1 second',
+updated_at = NOW()
+WHERE id IN (
+    SELECT id
+    FROM outbound_deliveries
+    WHERE status IN ('pending', 'send_failed')
+    AND attempts < $2
+    AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, mail_id, status, attempts, COALESCE(last_error, ''), created_at, updated_at;
+`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, limit, maxAttempts, int(lease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []outbound.Delivery
+	for rows.Next() {
+		var d outbound.Delivery
+		if err := rows.Scan(&d.ID, &d.MailID, &d.Status, &d.Attempts, &d.LastError, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (r *Repo) MarkDeliverySent(ctx context.Context, d outbound.Delivery, messageID string) error {
+	return r.finishDeliveryAttempt(ctx, d, outbound.StatusSent, "", messageID, nil)
+}
+
+func (r *Repo) MarkDeliveryFailed(ctx context.Context, d outbound.Delivery, reason string, nextAttemptAt *time.Time) error {
+	return r.finishDeliveryAttempt(ctx, d, outbound.StatusSendFailed, reason, "", nextAttemptAt)
+}
+
+// finishDeliveryAttempt в одной транзакции пишет попытку в журнал, обновляет
+// доставку и статус письма.
+func (r *Repo) finishDeliveryAttempt(ctx context.Context, d outbound.Delivery, status, reason, messageID string, nextAttemptAt *time.Time) error {
+	tx, err := r.conn(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	const attemptQuery = `
+INSERT INTO outbound_attempts (delivery_id, attempt, status, error)
+VALUES ($1, $2, $3, $4);
+`
+	if _, err := tx.ExecContext(ctx, attemptQuery, d.ID, d.Attempts+1, status, nullString(reason)); err != nil {
+		return fmt.Errorf("insert attempt: %w", err)
+	}
+
+	const deliveryQuery = `
+UPDATE outbound_deliveries
+SET status = $2,
+attempts = attempts + 1,
+last_error = $3,
+message_id = COALESCE($4, message_id),
+next_attempt_at = COALESCE($5, 'infinity'::timestamptz),
+sent_at = CASE WHEN $2 = 'sent' THEN NOW() ELSE sent_at END,
+updated_at = NOW()
+WHERE id = $1;
+`
+	var next sql.NullTime
+	if nextAttemptAt != nil {
+		next = sql.NullTime{Time: *nextAttemptAt, Valid: true}
+	}
+	if _, err := tx.ExecContext(ctx, deliveryQuery, d.ID, status, nullString(reason), nullString(messageID), next); err != nil {
+		return fmt.Errorf("update delivery: %w", err)
+	}
+
+	const mailQuery = `
+UPDATE mails
+SET status = $2,
+updated_at = NOW()
+WHERE id = $1;
+`
+	if _, err := tx.ExecContext(ctx, mailQuery, d.MailID, status); err != nil {
+		return fmt.Errorf("update mail status: %w", err)
+	}
+
+	return tx.Commit()
+}
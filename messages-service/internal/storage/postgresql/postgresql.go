@@ -3,15 +3,29 @@ package postgresql
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"messages-service/internal/config"
+	"strings"
+	"sync"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+// AllTenants — тенант сессий общего пула: политики RLS (миграция
+// 020_tenants) показывают ему строки всех тенантов.
+const AllTenants = "*"
+
 type Storage struct {
 	DB *sql.DB
+
+	connector  *pq.Connector
+	tenantRole string
+
+	mu      sync.Mutex
+	tenants map[string]*sql.DB
 }
 
 func New(cfg config.PostgreConfig) (*Storage, error) {
@@ -19,10 +33,11 @@ func New(cfg config.PostgreConfig) (*Storage, error) {
 
 	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode)
-	db, err := sql.Open("postgres", connStr)
+	connector, err := pq.NewConnector(connStr)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	db := sql.OpenDB(tenantConnector{base: connector, tenant: AllTenants})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -31,9 +46,153 @@ func New(cfg config.PostgreConfig) (*Storage, error) {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &Storage{DB: db}, nil
+	return &Storage{
+		DB:         db,
+		connector:  connector,
+		tenantRole: cfg.TenantRole,
+		tenants:    make(map[string]*sql.DB),
+	}, nil
+}
+
+// TenantDB возвращает пул соединений тенанта: в его сессиях задан
+// app.tenant_id и, если настроена, роль postgresql.tenant_role. Пул
+// открывается при первом обращении.
+func (s *Storage) TenantDB(tenant string) *sql.DB {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	db, ok := s.tenants[tenant]
+	if !ok {
+		db = sql.OpenDB(tenantConnector{base: s.connector, tenant: tenant, role: s.tenantRole})
+		s.tenants[tenant] = db
+	}
+	return db
+}
+
+// tenantTables — таблицы под политикой tenant_isolation (миграция 020_tenants).
+var tenantTables = []string{
+	"mails", "attachments", "approval_steps", "mail_events",
+	"outbound_deliveries", "outbound_attempts",
+	"webhook_subscriptions", "webhook_deliveries",
+	"senders", "organizations",
+}
+
+// CheckTenantIsolation проверяет, что политики RLS действуют на сессии
+// тенанта: её роль не суперпользователь и без BYPASSRLS, RLS включён и
+// принудителен для всех таблиц тенантов, чужие письма не видны, а строку
+// чужого тенанта сессия записать не может. Последняя проверка выполняется
+// в откатываемой транзакции и работает и на пустой БД. Вызывается при
+// старте, чтобы ошибка в настройке роли или миграции не открыла тенантам
+// данные друг друга.
+func (s *Storage) CheckTenantIsolation(ctx context.Context, tenant string) error {
+	const bypassQuery = `SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user;`
+	const tablesQuery = `
+SELECT COALESCE(array_agg(t.name), '{}')
+FROM unnest($1::text[]) AS t(name)
+LEFT JOIN pg_class c ON c.oid = to_regclass(t.name)
+WHERE c.oid IS NULL OR NOT (c.relrowsecurity AND c.relforcerowsecurity);
+`
+	const foreignQuery = `SELECT EXISTS (SELECT 1 FROM mails WHERE tenant_id <> $1);`
+	const probeQuery = `
+INSERT INTO webhook_subscriptions (id, url, secret, event_types, tenant_id)
+VALUES (gen_random_uuid(), '', '', '{}', $1);
+`
+
+	db := s.TenantDB(tenant)
+
+	var bypass bool
+	if err := db.QueryRowContext(ctx, bypassQuery).Scan(&bypass); err != nil {
+		return fmt.Errorf("tenant %s: check role: %w", tenant, err)
+	}
+	if bypass {
+		return fmt.Errorf("tenant %s: database role bypasses row level security, set postgresql.tenant_role", tenant)
+	}
+
+	var unprotected []string
+	if err := db.QueryRowContext(ctx, tablesQuery, pq.Array(tenantTables)).Scan(pq.Array(&unprotected)); err != nil {
+		return fmt.Errorf("tenant %s: check tables: %w", tenant, err)
+	}
+	if len(unprotected) > 0 {
+		return fmt.Errorf("tenant %s: row level security is not enabled and forced on %s, apply migration 020_tenants",
+			tenant, strings.Join(unprotected, ", "))
+	}
+
+	var foreign bool
+	if err := db.QueryRowContext(ctx, foreignQuery, tenant).Scan(&foreign); err != nil {
+		return fmt.Errorf("tenant %s: check isolation: %w", tenant, err)
+	}
+	if foreign {
+		return fmt.Errorf("tenant %s: mails of other tenants are visible", tenant)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("tenant %s: check isolation: %w", tenant, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, probeQuery, tenant+"-isolation-probe")
+	var pqErr *pq.Error
+	switch {
+	case err == nil:
+		return fmt.Errorf("tenant %s: rows of other tenants can be written", tenant)
+	case errors.As(err, &pqErr) && pqErr.Code == rlsViolation && strings.Contains(pqErr.Message, "row-level security"):
+		return nil
+	default:
+		return fmt.Errorf("tenant %s: check isolation: %w", tenant, err)
+	}
 }
 
+// rlsViolation — SQLSTATE insufficient_privilege, с которым PostgreSQL
+// отклоняет строку, не прошедшую WITH CHECK политики (и запрос без прав,
+// поэтому проверяется и текст ошибки).
+const rlsViolation = "42501"
+
 func (s *Storage) Close() error {
-	return s.DB.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := []error{s.DB.Close()}
+	for _, db := range s.tenants {
+		errs = append(errs, db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// tenantConnector задаёт тенанта и роль каждой новой сессии. Значения
+// фиксируются на всё время жизни соединения, поэтому запросы пула не могут
+// выйти за пределы тенанта.
+type tenantConnector struct {
+	base   driver.Connector
+	tenant string
+	role   string
+}
+
+func (c tenantConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.base.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		_ = conn.Close()
+		return nil, errors.New("postgres driver does not support ExecContext")
+	}
+
+	statements := []string{"SET app.tenant_id = " + pq.QuoteLiteral(c.tenant)}
+	if c.role != "" {
+		statements = append(statements, "SET ROLE "+pq.QuoteIdentifier(c.role))
+	}
+	for _, stmt := range statements {
+		if _, err := execer.ExecContext(ctx, stmt, nil); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("init session for tenant %s: %w", c.tenant, err)
+		}
+	}
+	return conn, nil
+}
+
+func (c tenantConnector) Driver() driver.Driver {
+	return c.base.Driver()
 }
@@ -6,84 +6,326 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"messages-service/internal/fieldcrypt"
 	"messages-service/internal/messages"
+	"strings"
+
+	"github.com/lib/pq"
 )
 
+// mailInsertChunk ограничивает число строк в одном INSERT, чтобы не упереться
+// в лимит параметров PostgreSQL (65535).
+const mailInsertChunk = 1000
+
 type Repo struct {
-	db *sql.DB
+	db      *sql.DB
+	cipher  *fieldcrypt.Cipher
+	tenants TenantPools
 }
 
-func NewMessagesRepo(db *sql.DB) *Repo {
-	return &Repo{db: db}
+func NewMessagesRepo(db *sql.DB, opts ...Option) *Repo {
+	r := &Repo{db: db}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 func (r *Repo) CreateMail(ctx context.Context, m *messages.Mail) error {
 	const query = `
 INSERT INTO mails
-(id, input, from_email, to_email, received_at, attempts, status, processed, is_approved)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+(id, input, from_email, to_emails, received_at, attempts, status, processed, is_approved,
+subject, cc, message_id, in_reply_to, mail_references, thread_id, subject_norm, input_enc, enc_key_id,
+filter_kind, filter_reason, sender_id, mailbox, tenant_id, sla_deadline, sla_state)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25);
 `
 
-	_, err := r.db.ExecContext(ctx, query,
+	input, inputEnc, err := r.sealText(ctx, m.Input, m.ID, "input")
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.conn(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := r.upsertSenders(ctx, tx, []*messages.Mail{m}); err != nil {
+		return fmt.Errorf("upsert sender: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, query,
 		m.ID,
-		m.Input,
+		input,
 		m.From,
-		m.To,
+		pq.Array(nonNil(m.To)),
 		m.ReceivedAt,
 		m.Attempts,
 		m.Status,
 		m.Processed,
 		m.IsApproved,
+		m.Subject,
+		pq.Array(nonNil(m.Cc)),
+		nullString(m.MessageID),
+		nullString(m.InReplyTo),
+		pq.Array(nonNil(m.References)),
+		threadID(m),
+		subjectNorm(m.Subject),
+		inputEnc,
+		r.encKeyID(),
+		nullString(m.FilterKind),
+		nullString(m.FilterReason),
+		nullString(m.SenderID),
+		nullString(m.Mailbox),
+		tenantID(m),
+		m.SLADeadline,
+		nullString(m.SLAState),
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	if err := r.insertAttachments(ctx, tx, m.Attachments); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *Repo) insertAttachments(ctx context.Context, tx *sql.Tx, attachments []messages.Attachment) error {
+	const query = `
+INSERT INTO attachments
+(id, mail_id, filename, content_type, size_bytes, sha256, storage_key, extracted_text, extracted_text_enc, enc_key_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+`
+
+	for _, a := range attachments {
+		text, textEnc, err := r.sealText(ctx, a.ExtractedText, a.ID, "extracted_text")
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, query,
+			a.ID,
+			a.MailID,
+			a.Filename,
+			a.ContentType,
+			a.Size,
+			a.SHA256,
+			a.StorageKey,
+			text,
+			textEnc,
+			r.encKeyID(),
+		); err != nil {
+			return fmt.Errorf("insert attachment %s: %w", a.Filename, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Repo) CreateMails(ctx context.Context, mails []*messages.Mail) (map[string]bool, error) {
+	const columns = 25
+
+	tx, err := r.conn(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := r.upsertSenders(ctx, tx, mails); err != nil {
+		return nil, fmt.Errorf("upsert senders: %w", err)
+	}
+
+	inserted := make(map[string]bool, len(mails))
+	for start := 0; start < len(mails); start += mailInsertChunk {
+		end := min(start+mailInsertChunk, len(mails))
+		chunk := mails[start:end]
+
+		var sb strings.Builder
+		sb.WriteString(`
+INSERT INTO mails
+(id, input, from_email, to_emails, received_at, attempts, status, processed, is_approved,
+subject, cc, message_id, in_reply_to, mail_references, thread_id, subject_norm, input_enc, enc_key_id,
+filter_kind, filter_reason, sender_id, mailbox, tenant_id, sla_deadline, sla_state)
+VALUES `)
+
+		args := make([]any, 0, len(chunk)*columns)
+		for i, m := range chunk {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString("(")
+			for c := 1; c <= columns; c++ {
+				if c > 1 {
+					sb.WriteString(", ")
+				}
+				fmt.Fprintf(&sb, "$%d", i*columns+c)
+			}
+			sb.WriteString(")")
+
+			input, inputEnc, err := r.sealText(ctx, m.Input, m.ID, "input")
+			if err != nil {
+				return nil, err
+			}
+			args = append(args,
+				m.ID,
+				input,
+				m.From,
+				pq.Array(nonNil(m.To)),
+				m.ReceivedAt,
+				m.Attempts,
+				m.Status,
+				m.Processed,
+				m.IsApproved,
+				m.Subject,
+				pq.Array(nonNil(m.Cc)),
+				nullString(m.MessageID),
+				nullString(m.InReplyTo),
+				pq.Array(nonNil(m.References)),
+				threadID(m),
+				subjectNorm(m.Subject),
+				inputEnc,
+				r.encKeyID(),
+				nullString(m.FilterKind),
+				nullString(m.FilterReason),
+				nullString(m.SenderID),
+				nullString(m.Mailbox),
+				tenantID(m),
+				m.SLADeadline,
+				nullString(m.SLAState),
+			)
+		}
+		sb.WriteString("\nON CONFLICT (id) DO NOTHING\nRETURNING id;")
+
+		rows, err := tx.QueryContext(ctx, sb.String(), args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			inserted[id] = true
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rows.Close()
+	}
+
+	for _, m := range mails {
+		if !inserted[m.ID] {
+			continue
+		}
+		if err := r.insertAttachments(ctx, tx, m.Attachments); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return inserted, nil
 }
 
 func (r *Repo) GetMail(ctx context.Context, id string) (*messages.Mail, error) {
 	const query = `
 SELECT
 id,
+tenant_id,
+thread_id,
+sender_id,
 input,
 from_email,
-to_email,
+to_emails,
+cc,
+mailbox,
+subject,
+message_id,
+in_reply_to,
+mail_references,
 received_at,
 attempts,
 status,
 classification,
+queue,
+department,
+sla_deadline,
+sla_state,
 model_answer,
 failed_reason,
+filter_kind,
+filter_reason,
 assistant_response,
 processed,
 is_approved,
-updated_at
+updated_at,
+input_enc,
+model_answer_enc,
+assistant_response_enc
 FROM mails
 WHERE id = $1;
 `
 
-	row := r.db.QueryRowContext(ctx, query, id)
+	row := r.conn(ctx).QueryRowContext(ctx, query, id)
 
 	var mail messages.Mail
+	var sealed sealedMail
 	var modelAnswer json.RawMessage
 	var assistantResponse sql.NullString
 	var classification sql.NullString
 	var failedReason sql.NullString
+	var filterKind sql.NullString
+	var filterReason sql.NullString
 	var processed sql.NullBool
 	var approved sql.NullBool
+	var messageID sql.NullString
+	var inReplyTo sql.NullString
+	var queue sql.NullString
+	var department sql.NullString
+	var slaDeadline sql.NullTime
+	var slaState sql.NullString
+	var senderID sql.NullString
+	var mailbox sql.NullString
 
 	err := row.Scan(
 		&mail.ID,
+		&mail.TenantID,
+		&mail.ThreadID,
+		&senderID,
 		&mail.Input,
 		&mail.From,
-		&mail.To,
+		pq.Array(&mail.To),
+		pq.Array(&mail.Cc),
+		&mailbox,
+		&mail.Subject,
+		&messageID,
+		&inReplyTo,
+		pq.Array(&mail.References),
 		&mail.ReceivedAt,
 		&mail.Attempts,
 		&mail.Status,
 		&classification,
+		&queue,
+		&department,
+		&slaDeadline,
+		&slaState,
 		&modelAnswer,
 		&failedReason,
+		&filterKind,
+		&filterReason,
 		&assistantResponse,
 		&processed,
 		&approved,
 		&mail.UpdatedAt,
+		&sealed.input,
+		&sealed.modelAnswer,
+		&sealed.assistantResponse,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -95,6 +337,16 @@ WHERE id = $1;
 	if classification.Valid {
 		mail.Classification = classification.String
 	}
+	mail.SenderID = senderID.String
+	mail.Mailbox = mailbox.String
+	mail.MessageID = messageID.String
+	mail.InReplyTo = inReplyTo.String
+	mail.Queue = queue.String
+	mail.Department = department.String
+	mail.SLAState = slaState.String
+	if slaDeadline.Valid {
+		mail.SLADeadline = &slaDeadline.Time
+	}
 	if modelAnswer != nil {
 		mail.ModelAnswer = modelAnswer
 	}
@@ -104,16 +356,103 @@ WHERE id = $1;
 	if failedReason.Valid {
 		mail.FailedReason = failedReason.String
 	}
+	mail.FilterKind = filterKind.String
+	mail.FilterReason = filterReason.String
 	if processed.Valid {
 		mail.Processed = processed.Bool
 	}
 	if approved.Valid {
 		mail.IsApproved = approved.Bool
 	}
+	if err := r.openMail(ctx, mail.ID, sealed, &mail.Input, &mail.ModelAnswer, &mail.AssistantResp); err != nil {
+		return nil, err
+	}
+
+	attachments, err := r.ListAttachments(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("list attachments: %w", err)
+	}
+	mail.Attachments = attachments
 
 	return &mail, nil
 }
 
+const attachmentColumns = `id, mail_id, filename, content_type, size_bytes, sha256, storage_key, extracted_text, extracted_text_enc, created_at`
+
+func (r *Repo) ListAttachments(ctx context.Context, mailID string) ([]messages.Attachment, error) {
+	query := `
+SELECT ` + attachmentColumns + `
+FROM attachments
+WHERE mail_id = $1
+ORDER BY created_at, filename;
+`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, mailID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []messages.Attachment
+	for rows.Next() {
+		a, err := r.scanAttachment(ctx, rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, *a)
+	}
+
+	return attachments, rows.Err()
+}
+
+func (r *Repo) GetAttachment(ctx context.Context, id string) (*messages.Attachment, error) {
+	query := `
+SELECT ` + attachmentColumns + `
+FROM attachments
+WHERE id = $1;
+`
+
+	a, err := r.scanAttachment(ctx, r.conn(ctx).QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("attachment not found: %w", err)
+		}
+		return nil, err
+	}
+	return a, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func (r *Repo) scanAttachment(ctx context.Context, row rowScanner) (*messages.Attachment, error) {
+	var a messages.Attachment
+	var textEnc []byte
+	if err := row.Scan(
+		&a.ID,
+		&a.MailID,
+		&a.Filename,
+		&a.ContentType,
+		&a.Size,
+		&a.SHA256,
+		&a.StorageKey,
+		&a.ExtractedText,
+		&textEnc,
+		&a.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if textEnc != nil {
+		text, err := r.open(ctx, textEnc, a.ID, "extracted_text")
+		if err != nil {
+			return nil, err
+		}
+		a.ExtractedText = string(text)
+	}
+	return &a, nil
+}
+
 func (r *Repo) IncrementAttempts(ctx context.Context, id string) error {
 	const query = `
 		UPDATE mails
@@ -121,7 +460,7 @@ func (r *Repo) IncrementAttempts(ctx context.Context, id string) error {
 		    updated_at = NOW()
 		WHERE id = $1;
 	`
-	res, err := r.db.ExecContext(ctx, query, id)
+	res, err := r.conn(ctx).ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -137,36 +476,87 @@ func (r *Repo) IncrementAttempts(ctx context.Context, id string) error {
 	return nil
 }
 
-func (r *Repo) SaveLLMResult(ctx context.Context, id string, classification string, modelAnswer json.RawMessage) error {
+func (r *Repo) SaveLLMResult(ctx context.Context, id string, result messages.LLMResult) error {
 	const query = `
 UPDATE mails
 SET classification = $2,
 model_answer = $3,
+queue = $4,
+department = $5,
+sla_deadline = CASE
+    WHEN sla_state IN ('met', 'rejected', 'breached') THEN sla_deadline
+    WHEN $6::int > 0 THEN received_at + $6::int * INTERVAL '1 second'
+END,
+sla_state = CASE
+    WHEN sla_state IN ('met', 'rejected', 'breached') THEN sla_state
+    WHEN $6::int <= 0 THEN NULL
+    WHEN sla_state = 'at_risk' THEN sla_state
+    ELSE 'ok'
+END,
+category = $7,
+urgency = $8,
+main_approver = $9,
+required_approvers = $10,
+tags = $11,
+legal_risks = $12,
+model_answer_enc = $13,
+enc_key_id = COALESCE(enc_key_id, $14),
 processed = TRUE,
-status = 'processed',
+status = CASE WHEN is_approved OR status = 'rejected' THEN status ELSE 'processed' END,
 attempts = 0,
 updated_at = NOW()
-WHERE id = $1;
+WHERE id = $1
+RETURNING is_approved OR status = 'rejected';
 `
 
-	res, err := r.db.ExecContext(ctx, query,
-		id,
-		classification,
-		modelAnswer,
-	)
+	fields := newAnswerFields(result.Answer, r.cipher != nil)
+	modelAnswer, modelAnswerEnc, err := r.sealJSON(ctx, result.ModelAnswer, id, "model_answer")
 	if err != nil {
 		return err
 	}
 
-	rows, err := res.RowsAffected()
+	tx, err := r.conn(ctx).BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	if rows == 0 {
+	defer func() { _ = tx.Rollback() }()
+
+	var decided bool
+	err = tx.QueryRowContext(ctx, query,
+		id,
+		result.Classification,
+		modelAnswer,
+		result.Route.Queue,
+		nullString(result.Route.Department),
+		int(result.SLA.Seconds()),
+		fields.category,
+		fields.urgency,
+		fields.mainApprover,
+		fields.requiredApprovers,
+		fields.tags,
+		fields.legalRisks,
+		modelAnswerEnc,
+		r.encKeyID(),
+	).Scan(&decided)
+	if errors.Is(err, sql.ErrNoRows) {
 		return fmt.Errorf("mail id %s not found", id)
 	}
+	if err != nil {
+		return err
+	}
 
-	return nil
+	// Решение по письму повторная обработка не отменяет.
+	if !decided {
+		if err := replaceApprovalSteps(ctx, tx, id, result.ApprovalSteps); err != nil {
+			return err
+		}
+	}
+
+	if err := linkOrganization(ctx, tx, id, result.Requisites); err != nil {
+		return fmt.Errorf("link organization: %w", err)
+	}
+
+	return tx.Commit()
 }
 
 func (r *Repo) MarkAsFailed(ctx context.Context, id string, reason string) error {
@@ -179,7 +569,7 @@ updated_at = NOW()
 WHERE id = $1;
 `
 
-	res, err := r.db.ExecContext(ctx, query,
+	res, err := r.conn(ctx).ExecContext(ctx, query,
 		id,
 		reason,
 	)
@@ -198,15 +588,93 @@ WHERE id = $1;
 	return nil
 }
 
-func (r *Repo) ListProcessed(ctx context.Context) ([]messages.Mail, error) {
-	const query = `
-SELECT id, input, from_email, to_email, received_at, attempts, status, classification, model_answer, assistant_response, is_approved, updated_at
+const mailListColumns = `id, tenant_id, thread_id, sender_id, input, from_email, to_emails, cc, mailbox, subject, message_id, in_reply_to, mail_references,
+received_at, attempts, status, classification, queue, department, sla_deadline, sla_state,
+category, urgency, main_approver, tags, legal_risks, filter_kind, filter_reason,
+model_answer, assistant_response, is_approved, updated_at,
+input_enc, model_answer_enc, assistant_response_enc`
+
+func (r *Repo) ListProcessed(ctx context.Context, filter messages.ListFilter) ([]messages.Mail, error) {
+	if err := r.checkListFilter(filter); err != nil {
+		return nil, err
+	}
+	query := `
+SELECT ` + mailListColumns + `
+FROM mails
+WHERE processed = TRUE`
+
+	query, args := applyListFilter(query, nil, filter, messages.SortUpdatedAt)
+	return r.listMails(ctx, query, args...)
+}
+
+func (r *Repo) ListInbox(ctx context.Context, team string, filter messages.ListFilter) ([]messages.Mail, error) {
+	if err := r.checkListFilter(filter); err != nil {
+		return nil, err
+	}
+	query := `
+SELECT ` + mailListColumns + `
 FROM mails
 WHERE processed = TRUE
-ORDER BY updated_at DESC;
-`
+  AND is_approved = FALSE
+  AND status <> 'rejected'
+  AND (queue = $1 OR department = $1 OR EXISTS (
+      SELECT 1 FROM approval_steps s
+      WHERE s.mail_id = mails.id AND s.team = $1 AND s.status = 'pending'
+  ))`
+
+	query, args := applyListFilter(query, []any{team}, filter, messages.SortReceivedAt)
+	return r.listMails(ctx, query, args...)
+}
+
+// applyListFilter дописывает к запросу условия фильтра и ORDER BY.
+func applyListFilter(query string, args []any, filter messages.ListFilter, defaultSort string) (string, []any) {
+	add := func(cond string, value any) {
+		args = append(args, value)
+		query += "\n  AND " + fmt.Sprintf(cond, len(args))
+	}
+	if len(filter.SLAStates) > 0 {
+		add("sla_state = ANY($%d)", pq.Array(filter.SLAStates))
+	}
+	if filter.Mailbox != "" {
+		add("mailbox = $%d", filter.Mailbox)
+	}
+	if filter.Category != "" {
+		add("category = $%d", filter.Category)
+	}
+	if filter.Urgency != "" {
+		add("urgency = $%d", filter.Urgency)
+	}
+	if filter.MainApprover != "" {
+		add("main_approver = $%d", filter.MainApprover)
+	}
+	if len(filter.Tags) > 0 {
+		add("tags @> $%d", pq.Array(filter.Tags))
+	}
+	if filter.LegalRisks != nil {
+		if *filter.LegalRisks {
+			query += "\n  AND legal_risks <> ''"
+		} else {
+			query += "\n  AND coalesce(legal_risks, '') = ''"
+		}
+	}
+
+	sort := filter.Sort
+	if sort == "" {
+		sort = defaultSort
+	}
+	switch sort {
+	case messages.SortReceivedAt:
+		query += "\nORDER BY received_at, id;"
+	case messages.SortSLADeadline:
+		query += "\nORDER BY sla_deadline NULLS LAST, received_at, id;"
+	default:
+		query += "\nORDER BY updated_at DESC;"
+	}
+	return query, args
+}
 
-	rows, err := r.db.QueryContext(ctx, query)
+func (r *Repo) listMails(ctx context.Context, query string, args ...any) ([]messages.Mail, error) {
+	rows, err := r.conn(ctx).QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -214,43 +682,165 @@ ORDER BY updated_at DESC;
 
 	var mails []messages.Mail
 	for rows.Next() {
-		var mail messages.Mail
-		var assistantResponse sql.NullString
-		if err := rows.Scan(
-			&mail.ID,
-			&mail.Input,
-			&mail.From,
-			&mail.To,
-			&mail.ReceivedAt,
-			&mail.Attempts,
-			&mail.Status,
-			&mail.Classification,
-			&mail.ModelAnswer,
-			&assistantResponse,
-			&mail.IsApproved,
-			&mail.UpdatedAt,
-		); err != nil {
+		mail, sealed, err := scanListMail(rows)
+		if err != nil {
 			return nil, err
 		}
-		if assistantResponse.Valid {
-			mail.AssistantResp = json.RawMessage(assistantResponse.String)
+		if err := r.openListMail(ctx, &mail, sealed); err != nil {
+			return nil, err
 		}
 		mail.Processed = true
 		mails = append(mails, mail)
 	}
 
-	return mails, nil
+	return mails, rows.Err()
+}
+
+// scanListMail читает строку с колонками mailListColumns; extra — колонки,
+// выбранные после них. Зашифрованные значения возвращаются отдельно, их
+// расшифровывает openMail.
+func scanListMail(row rowScanner, extra ...any) (messages.Mail, sealedMail, error) {
+	var mail messages.Mail
+	var sealed sealedMail
+	var classification sql.NullString
+	var queue sql.NullString
+	var department sql.NullString
+	var slaDeadline sql.NullTime
+	var slaState sql.NullString
+	var category sql.NullString
+	var urgency sql.NullString
+	var mainApprover sql.NullString
+	var legalRisks sql.NullString
+	var filterKind sql.NullString
+	var filterReason sql.NullString
+	var assistantResponse sql.NullString
+	var messageID sql.NullString
+	var inReplyTo sql.NullString
+	var senderID sql.NullString
+	var mailbox sql.NullString
+	dest := []any{
+		&mail.ID,
+		&mail.TenantID,
+		&mail.ThreadID,
+		&senderID,
+		&mail.Input,
+		&mail.From,
+		pq.Array(&mail.To),
+		pq.Array(&mail.Cc),
+		&mailbox,
+		&mail.Subject,
+		&messageID,
+		&inReplyTo,
+		pq.Array(&mail.References),
+		&mail.ReceivedAt,
+		&mail.Attempts,
+		&mail.Status,
+		&classification,
+		&queue,
+		&department,
+		&slaDeadline,
+		&slaState,
+		&category,
+		&urgency,
+		&mainApprover,
+		pq.Array(&mail.Tags),
+		&legalRisks,
+		&filterKind,
+		&filterReason,
+		&mail.ModelAnswer,
+		&assistantResponse,
+		&mail.IsApproved,
+		&mail.UpdatedAt,
+		&sealed.input,
+		&sealed.modelAnswer,
+		&sealed.assistantResponse,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return mail, sealed, err
+	}
+	if assistantResponse.Valid {
+		mail.AssistantResp = json.RawMessage(assistantResponse.String)
+	}
+	mail.Classification = classification.String
+	mail.Queue = queue.String
+	mail.Department = department.String
+	mail.SLAState = slaState.String
+	if slaDeadline.Valid {
+		mail.SLADeadline = &slaDeadline.Time
+	}
+	mail.Category = category.String
+	mail.Urgency = urgency.String
+	mail.MainApprover = mainApprover.String
+	mail.LegalRisks = legalRisks.String
+	mail.FilterKind = filterKind.String
+	mail.FilterReason = filterReason.String
+	mail.SenderID = senderID.String
+	mail.Mailbox = mailbox.String
+	mail.MessageID = messageID.String
+	mail.InReplyTo = inReplyTo.String
+	return mail, sealed, nil
 }
 
-func (r *Repo) ApproveMail(ctx context.Context, id string) error {
+// ApproveMail меняет только неутверждённое письмо, поэтому повторный аппрув,
+// в том числе параллельный, не ставит ответ в очередь второй раз.
+func (r *Repo) ApproveMail(ctx context.Context, id string, queueReply bool) (bool, error) {
 	const query = `
 UPDATE mails
 SET is_approved = TRUE,
+status = 'approved',
+sla_state = CASE WHEN sla_state IN ('ok', 'at_risk') THEN 'met' ELSE sla_state END,
 updated_at = NOW()
-WHERE id = $1;
+WHERE id = $1 AND NOT is_approved;
 `
+	const exists = `SELECT EXISTS (SELECT 1 FROM mails WHERE id = $1);`
 
-	res, err := r.db.ExecContext(ctx, query, id)
+	tx, err := r.conn(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		var found bool
+		if err := tx.QueryRowContext(ctx, exists, id).Scan(&found); err != nil {
+			return false, err
+		}
+		if !found {
+			return false, fmt.Errorf("mail id %s not found", id)
+		}
+		return false, nil
+	}
+
+	if queueReply {
+		if err := createDeliveryTx(ctx, tx, id); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// RejectMail не трогает утверждённое письмо: его ответ мог уже уйти
+// отправителю, и отказ после аппрува был бы гонкой с ним.
+func (r *Repo) RejectMail(ctx context.Context, id string) error {
+	const query = `
+UPDATE mails
+SET status = 'rejected',
+sla_state = CASE WHEN sla_state IN ('ok', 'at_risk') THEN 'rejected' ELSE sla_state END,
+updated_at = NOW()
+WHERE id = $1 AND NOT is_approved;
+`
+	const exists = `SELECT EXISTS (SELECT 1 FROM mails WHERE id = $1);`
+
+	res, err := r.conn(ctx).ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -260,7 +850,14 @@ WHERE id = $1;
 		return err
 	}
 	if rows == 0 {
-		return fmt.Errorf("mail id %s not found", id)
+		var found bool
+		if err := r.db.QueryRowContext(ctx, exists, id).Scan(&found); err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("mail id %s not found", id)
+		}
+		return fmt.Errorf("%w: mail %s is already approved", messages.ErrApprovalConflict, id)
 	}
 
 	return nil
@@ -270,13 +867,49 @@ func (r *Repo) SaveAssistantResponse(ctx context.Context, id string, response js
 	const query = `
 UPDATE mails
 SET assistant_response = $2,
+assistant_response_enc = $4,
+enc_key_id = COALESCE(enc_key_id, $5),
 processed = CASE WHEN $3 THEN TRUE ELSE processed END,
 status = CASE WHEN $3 THEN 'processed' ELSE status END,
 updated_at = NOW()
 WHERE id = $1;
 `
 
-	res, err := r.db.ExecContext(ctx, query, id, response, markProcessed)
+	plain, sealed, err := r.sealJSON(ctx, response, id, "assistant_response")
+	if err != nil {
+		return err
+	}
+
+	res, err := r.conn(ctx).ExecContext(ctx, query, id, plain, markProcessed, sealed, r.encKeyID())
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("mail id %s not found", id)
+	}
+
+	return nil
+}
+
+func (r *Repo) ResetForReprocessing(ctx context.Context, id string) error {
+	const query = `
+UPDATE mails
+SET status = CASE WHEN is_approved OR status = 'rejected' THEN status ELSE 'new' END,
+processed = FALSE,
+attempts = 0,
+failed_reason = NULL,
+filter_kind = NULL,
+filter_reason = NULL,
+updated_at = NOW()
+WHERE id = $1;
+`
+
+	res, err := r.conn(ctx).ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -291,3 +924,23 @@ WHERE id = $1;
 
 	return nil
 }
+
+// tenantID — тенант письма; письма без тенанта принадлежат default.
+func tenantID(m *messages.Mail) string {
+	if m.TenantID == "" {
+		return messages.DefaultTenant
+	}
+	return m.TenantID
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nonNil нужен, чтобы pq.Array записал пустой массив, а не NULL.
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"messages-service/internal/retention"
+)
+
+// retentionTarget отбирает пачку писем под правило. Письма на legal hold и
+// уже очищенные до нужного уровня не попадают в выборку.
+const retentionTarget = `
+SELECT id
+FROM mails
+WHERE received_at < $1
+  AND legal_hold = FALSE
+  AND (cardinality($2::text[]) = 0 OR status = ANY($2))
+  AND (cardinality($3::text[]) = 0 OR category = ANY($3))
+  %s
+ORDER BY received_at
+LIMIT $4
+FOR UPDATE SKIP LOCKED`
+
+func (r *Repo) PurgeMails(ctx context.Context, rule retention.Rule, before time.Time, limit int) (int, []string, error) {
+	var query string
+	switch rule.Action {
+	case retention.ActionPurgeBody:
+		query = `
+WITH target AS (` + fmt.Sprintf(retentionTarget, "AND purge_level IS NULL") + `),
+att AS (
+    DELETE FROM attachments a USING target t
+    WHERE a.mail_id = t.id
+    RETURNING a.storage_key
+),
+upd AS (
+    UPDATE mails m
+    SET input = '',
+    input_enc = NULL,
+    purge_level = 'body',
+    purged_at = NOW(),
+    updated_at = NOW()
+    FROM target t
+    WHERE m.id = t.id
+    RETURNING m.id
+)
+SELECT (SELECT COUNT(*) FROM upd), COALESCE((SELECT array_agg(storage_key) FILTER (WHERE storage_key <> '') FROM att), '{}');
+`
+	case retention.ActionPurgeContent:
+		query = `
+WITH target AS (` + fmt.Sprintf(retentionTarget, "AND purge_level IS DISTINCT FROM 'content'") + `),
+att AS (
+    DELETE FROM attachments a USING target t
+    WHERE a.mail_id = t.id
+    RETURNING a.storage_key
+),
+upd AS (
+    UPDATE mails m
+    SET input = '',
+    input_enc = NULL,
+    model_answer = NULL,
+    model_answer_enc = NULL,
+    assistant_response = NULL,
+    assistant_response_enc = NULL,
+    enc_key_id = NULL,
+    purge_level = 'content',
+    purged_at = NOW(),
+    updated_at = NOW()
+    FROM target t
+    WHERE m.id = t.id
+    RETURNING m.id
+)
+SELECT (SELECT COUNT(*) FROM upd), COALESCE((SELECT array_agg(storage_key) FILTER (WHERE storage_key <> '') FROM att), '{}');
+`
+	case retention.ActionDelete:
+		return r.deleteExpiredMails(ctx, rule, before, limit)
+	default:
+		return 0, nil, fmt.Errorf("unknown retention action %q", rule.Action)
+	}
+
+	var (
+		count int
+		keys  []string
+	)
+	err := r.conn(ctx).QueryRowContext(ctx, query,
+		before,
+		pq.Array(nonNil(rule.Statuses)),
+		pq.Array(nonNil(rule.Categories)),
+		limit,
+	).Scan(&count, pq.Array(&keys))
+	if err != nil {
+		return 0, nil, err
+	}
+	return count, keys, nil
+}
+
+// deleteExpiredMails удаляет пачку писем под правило так же, как DeleteMails,
+// и в той же транзакции — отправителей, у которых не осталось писем.
+func (r *Repo) deleteExpiredMails(ctx context.Context, rule retention.Rule, before time.Time, limit int) (int, []string, error) {
+	query := `
+WITH target AS (` + fmt.Sprintf(retentionTarget, "") + `),
+att AS (
+    SELECT a.storage_key
+    FROM attachments a
+    JOIN target t ON a.mail_id = t.id
+),
+hooks AS (
+    DELETE FROM webhook_deliveries d USING target t
+    WHERE d.mail_id = t.id
+),
+del AS (
+    DELETE FROM mails m USING target t
+    WHERE m.id = t.id
+    RETURNING m.id, m.sender_id
+)
+SELECT (SELECT COUNT(*) FROM del),
+COALESCE((SELECT array_agg(storage_key) FILTER (WHERE storage_key <> '') FROM att), '{}'),
+COALESCE((SELECT array_agg(DISTINCT sender_id) FILTER (WHERE sender_id IS NOT NULL) FROM del), '{}');
+`
+
+	tx, err := r.conn(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var (
+		count   int
+		keys    []string
+		senders []string
+	)
+	if err := tx.QueryRowContext(ctx, query,
+		before,
+		pq.Array(nonNil(rule.Statuses)),
+		pq.Array(nonNil(rule.Categories)),
+		limit,
+	).Scan(&count, pq.Array(&keys), pq.Array(&senders)); err != nil {
+		return 0, nil, err
+	}
+
+	// Запись об отправителе — тоже персональные данные; отправители, у
+	// которых остались письма (в том числе на legal hold), не удаляются.
+	if err := deleteOrphanSenders(ctx, tx, senders); err != nil {
+		return 0, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+	return count, keys, nil
+}
+
+func (r *Repo) SetLegalHold(ctx context.Context, id string, hold bool, reason string) error {
+	const query = `
+UPDATE mails
+SET legal_hold = $2,
+legal_hold_reason = CASE WHEN $2 THEN $3 END,
+updated_at = NOW()
+WHERE id = $1;
+`
+
+	res, err := r.conn(ctx).ExecContext(ctx, query, id, hold, nullString(reason))
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("mail id %s not found", id)
+	}
+	return nil
+}
+
+// senderCondition совпадает с адресом как в виде "a@b", так и "Имя <a@b>".
+// $2 — тот же адрес после escapeLike: "_" и "%" в адресе совпадают только
+// сами с собой.
+const senderCondition = `(lower(from_email) = $1 OR lower(from_email) LIKE '%<' || $2 || '>' ESCAPE '\')`
+
+func (r *Repo) ListSenderMails(ctx context.Context, email string) (ids []string, held int, err error) {
+	query := `
+SELECT id, legal_hold
+FROM mails
+WHERE ` + senderCondition + `;
+`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, email, escapeLike(email))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id   string
+			hold bool
+		)
+		if err := rows.Scan(&id, &hold); err != nil {
+			return nil, 0, err
+		}
+		if hold {
+			held++
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, held, rows.Err()
+}
+
+// DeleteMails удаляет письма не на legal hold вместе с доставками
+// webhook-ов; вложения, шаги согласования, события и исходящие ответы
+// удаляются каскадно. Возвращает число удалённых писем и ключи вложений.
+func (r *Repo) DeleteMails(ctx context.Context, ids []string) (int, []string, error) {
+	const query = `
+WITH target AS (
+    SELECT id FROM mails
+    WHERE id = ANY($1::uuid[]) AND legal_hold = FALSE
+    FOR UPDATE
+),
+att AS (
+    SELECT a.storage_key
+    FROM attachments a
+    JOIN target t ON a.mail_id = t.id
+),
+hooks AS (
+    DELETE FROM webhook_deliveries d USING target t
+    WHERE d.mail_id = t.id
+),
+del AS (
+    DELETE FROM mails m USING target t
+    WHERE m.id = t.id
+    RETURNING m.id
+)
+SELECT (SELECT COUNT(*) FROM del), COALESCE((SELECT array_agg(storage_key) FILTER (WHERE storage_key <> '') FROM att), '{}');
+`
+
+	var (
+		count int
+		keys  []string
+	)
+	if err := r.conn(ctx).QueryRowContext(ctx, query, pq.Array(ids)).Scan(&count, pq.Array(&keys)); err != nil {
+		return 0, nil, err
+	}
+	return count, keys, nil
+}
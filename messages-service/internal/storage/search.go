@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"messages-service/internal/messages"
+)
+
+// ts_headline отмечает совпадения символами из области частного
+// использования Unicode: текст письма экранируется как HTML, и только потом
+// метки заменяются на <mark>, чтобы разметка из письма не попала в ответ.
+const (
+	highlightStart = "\ue000"
+	highlightStop  = "\ue001"
+)
+
+// headlineOptions — параметры ts_headline для фрагментов текста письма,
+// highlightAllOptions — для коротких полей, которые подсвечиваются целиком.
+const (
+	headlineOptions     = `StartSel="` + highlightStart + `", StopSel="` + highlightStop + `", MaxFragments=3, MaxWords=25, MinWords=8, FragmentDelimiter=" … "`
+	highlightAllOptions = `StartSel="` + highlightStart + `", StopSel="` + highlightStop + `", HighlightAll=true`
+)
+
+var highlightMarks = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+
+// markHighlight экранирует фрагмент ts_headline и расставляет <mark>.
+func markHighlight(fragment string) string {
+	return highlightMarks.Replace(html.EscapeString(fragment))
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike экранирует спецсимволы LIKE, чтобы значение совпадало буквально.
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
+
+// SearchMails сначала отбирает страницу id по рангу, и только для неё
+// считает ts_headline: подсветка дорогая и не должна выполняться для всех
+// совпавших писем.
+func (r *Repo) SearchMails(ctx context.Context, query messages.SearchQuery) ([]messages.SearchResult, error) {
+	if r.cipher != nil {
+		return nil, messages.ErrSearchUnavailable
+	}
+	args := []any{query.Text}
+	where := "search_vector @@ q.query"
+	add := func(cond string, value any) {
+		args = append(args, value)
+		where += "\n      AND " + fmt.Sprintf(cond, len(args))
+	}
+
+	if query.Classification != "" {
+		add("classification = $%d", query.Classification)
+	}
+	if query.Mailbox != "" {
+		add("mailbox = $%d", query.Mailbox)
+	}
+	if query.Category != "" {
+		add("category = $%d", query.Category)
+	}
+	if query.Team != "" {
+		add("(queue = $%[1]d OR department = $%[1]d)", query.Team)
+	}
+	if query.Status != "" {
+		add("status = $%d", query.Status)
+	}
+	if len(query.SLAStates) > 0 {
+		add("sla_state = ANY($%d)", pq.Array(query.SLAStates))
+	}
+	if query.From != "" {
+		add(`from_email ILIKE '%%' || $%d || '%%' ESCAPE '\'`, escapeLike(query.From))
+	}
+	if query.ReceivedFrom != nil {
+		add("received_at >= $%d", *query.ReceivedFrom)
+	}
+	if query.ReceivedTo != nil {
+		add("received_at < $%d", *query.ReceivedTo)
+	}
+	args = append(args, query.Limit, query.Offset)
+
+	sqlQuery := fmt.Sprintf(`
+WITH q AS (
+    SELECT websearch_to_tsquery('russian', $1) || websearch_to_tsquery('english', $1) AS query
+),
+hits AS (
+    SELECT id, ts_rank_cd(search_vector, q.query) AS rank
+    FROM mails, q
+    WHERE %s
+    ORDER BY rank DESC, received_at DESC
+    LIMIT $%d OFFSET $%d
+)
+SELECT `+mailListColumns+`, processed, hits.rank,
+ts_headline('russian', subject, q.query, '`+highlightAllOptions+`'),
+ts_headline('russian', input, q.query, '`+headlineOptions+`'),
+ts_headline('russian', coalesce(model_answer->>'request_summary', ''), q.query, '`+highlightAllOptions+`')
+FROM hits
+JOIN mails USING (id)
+CROSS JOIN q
+ORDER BY hits.rank DESC, received_at DESC;
+`, where, len(args)-1, len(args))
+
+	rows, err := r.conn(ctx).QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []messages.SearchResult
+	for rows.Next() {
+		var (
+			result    messages.SearchResult
+			processed bool
+		)
+		mail, sealed, err := scanListMail(rows,
+			&processed,
+			&result.Rank,
+			&result.Highlight.Subject,
+			&result.Highlight.Input,
+			&result.Highlight.RequestSummary,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.openListMail(ctx, &mail, sealed); err != nil {
+			return nil, err
+		}
+		mail.Processed = processed
+		result.Mail = mail
+		result.Highlight.Subject = markHighlight(result.Highlight.Subject)
+		result.Highlight.Input = markHighlight(result.Highlight.Input)
+		result.Highlight.RequestSummary = markHighlight(result.Highlight.RequestSummary)
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
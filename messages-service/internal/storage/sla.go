@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"messages-service/internal/sla"
+)
+
+func (r *Repo) UpdateSLAStates(ctx context.Context, atRiskRatio float64) ([]sla.Escalation, error) {
+	const query = `
+WITH at_risk AS (
+    UPDATE mails
+    SET sla_state = 'at_risk'
+    WHERE sla_state = 'ok'
+      AND sla_deadline > NOW()
+      AND NOW() >= received_at + (sla_deadline - received_at) * $1::float8
+    RETURNING id, tenant_id, sla_state, sla_deadline, received_at, queue, department, from_email, subject, category, urgency
+), breached AS (
+    UPDATE mails
+    SET sla_state = 'breached'
+    WHERE sla_state IN ('ok', 'at_risk')
+      AND sla_deadline <= NOW()
+    RETURNING id, tenant_id, sla_state, sla_deadline, received_at, queue, department, from_email, subject, category, urgency
+)
+SELECT id, tenant_id, sla_state, sla_deadline, received_at, queue, department, from_email, subject,
+    COALESCE(category, ''), COALESCE(urgency, '')
+FROM (SELECT * FROM at_risk UNION ALL SELECT * FROM breached) changed
+ORDER BY sla_deadline;
+`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, atRiskRatio)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now().UTC()
+	var escalations []sla.Escalation
+	for rows.Next() {
+		var e sla.Escalation
+		var queue sql.NullString
+		var department sql.NullString
+		if err := rows.Scan(
+			&e.MailID,
+			&e.TenantID,
+			&e.State,
+			&e.Deadline,
+			&e.ReceivedAt,
+			&queue,
+			&department,
+			&e.From,
+			&e.Subject,
+			&e.Category,
+			&e.Urgency,
+		); err != nil {
+			return nil, err
+		}
+		e.Queue = queue.String
+		e.Department = department.String
+		e.DetectedAt = now
+		escalations = append(escalations, e)
+	}
+
+	return escalations, rows.Err()
+}
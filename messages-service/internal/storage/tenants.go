@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"messages-service/internal/messages"
+)
+
+// TenantPools выдаёт пул соединений тенанта, в сессиях которого действуют
+// политики RLS (postgresql.Storage).
+type TenantPools interface {
+	TenantDB(tenant string) *sql.DB
+}
+
+// WithTenantPools включает изоляцию тенантов: запросы ctx с тенантом
+// (messages.ContextWithTenant) выполняются в его пуле и не видят строк
+// других тенантов, даже если в запросе забыт фильтр.
+func WithTenantPools(pools TenantPools) Option {
+	return func(r *Repo) {
+		r.tenants = pools
+	}
+}
+
+// conn возвращает пул тенанта запроса; запросы без тенанта — фоновые
+// воркеры — идут через общий пул.
+func (r *Repo) conn(ctx context.Context) *sql.DB {
+	if r.tenants != nil {
+		if tenant := messages.TenantFromContext(ctx); tenant != "" {
+			return r.tenants.TenantDB(tenant)
+		}
+	}
+	return r.db
+}
+
+// ReserveTenantMails атомарно списывает n писем со счётчика тенанта за day;
+// false — списание превысило бы limit, счётчик не изменился. Счётчик
+// меняется через общий пул: таблица не под RLS.
+func (r *Repo) ReserveTenantMails(ctx context.Context, tenant string, day time.Time, n, limit int) (bool, error) {
+	const query = `
+INSERT INTO tenant_mail_usage AS u (tenant_id, day, mails)
+SELECT $1, $2::date, $3::int
+WHERE $3::int <= $4::int
+ON CONFLICT (tenant_id, day) DO UPDATE
+SET mails = u.mails + EXCLUDED.mails
+WHERE u.mails + EXCLUDED.mails <= $4::int
+RETURNING mails;
+`
+
+	var mails int
+	err := r.db.QueryRowContext(ctx, query, tenant, day.UTC().Format(time.DateOnly), n, limit).Scan(&mails)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseTenantMails возвращает на счётчик тенанта за day n писем, списанных
+// ReserveTenantMails, но не сохранённых. Счётчик не уходит ниже нуля.
+func (r *Repo) ReleaseTenantMails(ctx context.Context, tenant string, day time.Time, n int) error {
+	const query = `
+UPDATE tenant_mail_usage
+SET mails = GREATEST(mails - $3::int, 0)
+WHERE tenant_id = $1 AND day = $2::date;
+`
+
+	_, err := r.db.ExecContext(ctx, query, tenant, day.UTC().Format(time.DateOnly), n)
+	return err
+}
@@ -0,0 +1,387 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"messages-service/internal/config"
+	"messages-service/internal/messages"
+	"messages-service/internal/storage/postgresql"
+	"messages-service/internal/stream"
+	"messages-service/internal/webhook"
+)
+
+// Интеграционные тесты изоляции тенантов используют ту же базу из
+// MESSAGES_TEST_POSTGRES_DSN, что и тесты шифрования. Они работают с двумя
+// тенантами со случайными id и удаляют свои строки после себя.
+
+const testTenantRole = "messages_tenant"
+
+// tenantFixture — письмо с вложением, событие и подписка одного тенанта.
+type tenantFixture struct {
+	tenant       string
+	ctx          context.Context
+	mailID       string
+	attachmentID string
+	event        stream.Record
+	subscription string
+}
+
+func openTestStorage(t *testing.T) *postgresql.Storage {
+	t.Helper()
+
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDSNEnv)
+	}
+	applyMigrations(t, dsn)
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("parse %s: %v", testDSNEnv, err)
+	}
+	port := 5432
+	if u.Port() != "" {
+		if port, err = strconv.Atoi(u.Port()); err != nil {
+			t.Fatalf("parse port: %v", err)
+		}
+	}
+	password, _ := u.User.Password()
+	sslMode := u.Query().Get("sslmode")
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	st, err := postgresql.New(config.PostgreConfig{
+		Host:       u.Hostname(),
+		Port:       port,
+		User:       u.User.Username(),
+		Password:   password,
+		DBName:     strings.TrimPrefix(u.Path, "/"),
+		SSLMode:    sslMode,
+		TenantRole: testTenantRole,
+	})
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	return st
+}
+
+func newTestTenant(t *testing.T, st *postgresql.Storage, repo *Repo, word string) tenantFixture {
+	t.Helper()
+
+	f := tenantFixture{
+		tenant:       "it-" + uuid.NewString()[:8],
+		mailID:       uuid.NewString(),
+		attachmentID: uuid.NewString(),
+		subscription: uuid.NewString(),
+	}
+	f.ctx = messages.ContextWithTenant(context.Background(), f.tenant)
+	sender := f.tenant + "@example.com"
+
+	t.Cleanup(func() {
+		ctx := context.Background()
+		for _, query := range []string{
+			`DELETE FROM webhook_subscriptions WHERE tenant_id = $1;`,
+			`DELETE FROM mails WHERE tenant_id = $1;`,
+			`DELETE FROM senders WHERE tenant_id = $1;`,
+			`DELETE FROM tenant_mail_usage WHERE tenant_id = $1;`,
+		} {
+			if _, err := st.DB.ExecContext(ctx, query, f.tenant); err != nil {
+				t.Errorf("cleanup tenant %s: %v", f.tenant, err)
+			}
+		}
+	})
+
+	mail := &messages.Mail{
+		ID:         f.mailID,
+		TenantID:   f.tenant,
+		Input:      "Письмо тенанта " + f.tenant + ": " + word,
+		From:       "Sender <" + sender + ">",
+		To:         []string{"support@example.com"},
+		Subject:    word + " " + f.tenant,
+		ReceivedAt: time.Now().UTC(),
+		Status:     "new",
+		Attachments: []messages.Attachment{{
+			ID:            f.attachmentID,
+			MailID:        f.mailID,
+			Filename:      "contract.txt",
+			ContentType:   "text/plain",
+			Size:          4,
+			SHA256:        "00",
+			StorageKey:    "test/" + f.attachmentID,
+			ExtractedText: "text",
+		}},
+	}
+	if err := repo.CreateMail(f.ctx, mail); err != nil {
+		t.Fatalf("create mail of %s: %v", f.tenant, err)
+	}
+	// Письмо становится обработанным, как после ответа модели.
+	if _, err := st.DB.Exec(`UPDATE mails SET processed = TRUE, status = 'processed' WHERE id = $1;`, f.mailID); err != nil {
+		t.Fatalf("mark processed: %v", err)
+	}
+
+	// События пишет фоновый код из общего пула; тенант берётся из письма.
+	record, err := repo.AppendEvent(context.Background(), messages.Event{
+		ID:     uuid.NewString(),
+		Type:   messages.EventMailProcessed,
+		MailID: f.mailID,
+		Status: "processed",
+		Data:   map[string]any{"subject": mail.Subject},
+		At:     time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatalf("append event: %v", err)
+	}
+	if record.TenantID != f.tenant {
+		t.Fatalf("event tenant = %q, want %q", record.TenantID, f.tenant)
+	}
+	f.event = record
+
+	if err := repo.CreateSubscription(f.ctx, webhook.Subscription{
+		ID:         f.subscription,
+		URL:        "https://hooks.example.com/" + f.tenant,
+		Secret:     "secret",
+		EventTypes: []string{messages.EventMailProcessed},
+		Active:     true,
+		CreatedAt:  time.Now().UTC(),
+	}); err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+	n, err := repo.CreateWebhookDeliveries(context.Background(), record.Event, []byte(`{"subject":"`+mail.Subject+`"}`))
+	if err != nil {
+		t.Fatalf("create webhook deliveries: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("deliveries for the event of %s = %d, want 1 (only its own subscription)", f.tenant, n)
+	}
+
+	return f
+}
+
+func setupTenants(t *testing.T) (*postgresql.Storage, *Repo, tenantFixture, tenantFixture, string) {
+	t.Helper()
+
+	st := openTestStorage(t)
+	repo := NewMessagesRepo(st.DB, WithTenantPools(st))
+	word := "probe" + strings.ReplaceAll(uuid.NewString()[:8], "-", "")
+	a := newTestTenant(t, st, repo, word)
+	b := newTestTenant(t, st, repo, word)
+	return st, repo, a, b, word
+}
+
+func TestTenantSessions(t *testing.T) {
+	st, _, a, _, _ := setupTenants(t)
+
+	var tenant, role string
+	if err := st.TenantDB(a.tenant).QueryRowContext(a.ctx,
+		`SELECT current_setting('app.tenant_id'), current_user;`,
+	).Scan(&tenant, &role); err != nil {
+		t.Fatalf("read session settings: %v", err)
+	}
+	if tenant != a.tenant || role != testTenantRole {
+		t.Fatalf("session = (%q, %q), want (%q, %q)", tenant, role, a.tenant, testTenantRole)
+	}
+
+	if err := st.CheckTenantIsolation(context.Background(), a.tenant); err != nil {
+		t.Fatalf("CheckTenantIsolation: %v", err)
+	}
+}
+
+func TestTenantIsolationMails(t *testing.T) {
+	_, repo, a, b, word := setupTenants(t)
+
+	if _, err := repo.GetMail(b.ctx, a.mailID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetMail of a foreign mail: err = %v, want not found", err)
+	}
+	mail, err := repo.GetMail(a.ctx, a.mailID)
+	if err != nil {
+		t.Fatalf("GetMail of an own mail: %v", err)
+	}
+	if mail.TenantID != a.tenant || len(mail.Attachments) != 1 {
+		t.Fatalf("own mail: tenant %q, %d attachments", mail.TenantID, len(mail.Attachments))
+	}
+
+	processed, err := repo.ListProcessed(b.ctx, messages.ListFilter{})
+	if err != nil {
+		t.Fatalf("ListProcessed: %v", err)
+	}
+	ids := make([]string, 0, len(processed))
+	for _, m := range processed {
+		if m.TenantID != b.tenant {
+			t.Fatalf("ListProcessed of %s returned a mail of %s", b.tenant, m.TenantID)
+		}
+		ids = append(ids, m.ID)
+	}
+	if len(ids) != 1 || ids[0] != b.mailID {
+		t.Fatalf("ListProcessed = %v, want [%s]", ids, b.mailID)
+	}
+
+	results, err := repo.SearchMails(b.ctx, messages.SearchQuery{Text: word, Limit: 10})
+	if err != nil {
+		t.Fatalf("SearchMails: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != b.mailID {
+		t.Fatalf("SearchMails found %d mails, want only %s", len(results), b.mailID)
+	}
+
+	if err := repo.SetLegalHold(b.ctx, a.mailID, true, "foreign"); err == nil {
+		t.Fatal("SetLegalHold updated a foreign mail")
+	}
+}
+
+func TestTenantIsolationAttachments(t *testing.T) {
+	_, repo, a, b, _ := setupTenants(t)
+
+	if _, err := repo.GetAttachment(b.ctx, a.attachmentID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetAttachment of a foreign attachment: err = %v, want not found", err)
+	}
+	attachments, err := repo.ListAttachments(b.ctx, a.mailID)
+	if err != nil {
+		t.Fatalf("ListAttachments: %v", err)
+	}
+	if len(attachments) != 0 {
+		t.Fatalf("ListAttachments of a foreign mail returned %d attachments", len(attachments))
+	}
+	if _, err := repo.GetAttachment(a.ctx, a.attachmentID); err != nil {
+		t.Fatalf("GetAttachment of an own attachment: %v", err)
+	}
+}
+
+func TestTenantIsolationEvents(t *testing.T) {
+	_, repo, a, b, _ := setupTenants(t)
+
+	after := min(a.event.Seq, b.event.Seq) - 1
+	records, err := repo.ListEvents(b.ctx, after, stream.Filter{}, 1000)
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	var own bool
+	for _, r := range records {
+		if r.TenantID != b.tenant {
+			t.Fatalf("ListEvents of %s returned an event of %s", b.tenant, r.TenantID)
+		}
+		own = own || r.ID == b.event.ID
+	}
+	if !own {
+		t.Fatalf("ListEvents of %s does not return its own event", b.tenant)
+	}
+}
+
+func TestTenantIsolationWebhooks(t *testing.T) {
+	_, repo, a, b, _ := setupTenants(t)
+
+	subs, err := repo.ListSubscriptions(b.ctx)
+	if err != nil {
+		t.Fatalf("ListSubscriptions: %v", err)
+	}
+	if len(subs) != 1 || subs[0].ID != b.subscription {
+		t.Fatalf("ListSubscriptions of %s returned %d subscriptions, want only its own", b.tenant, len(subs))
+	}
+
+	foreign, err := repo.ListWebhookDeliveries(b.ctx, a.subscription, "", 10)
+	if err != nil {
+		t.Fatalf("ListWebhookDeliveries: %v", err)
+	}
+	if len(foreign) != 0 {
+		t.Fatalf("ListWebhookDeliveries of a foreign subscription returned %d deliveries", len(foreign))
+	}
+	own, err := repo.ListWebhookDeliveries(a.ctx, a.subscription, "", 10)
+	if err != nil {
+		t.Fatalf("ListWebhookDeliveries: %v", err)
+	}
+	if len(own) != 1 || own[0].EventID != a.event.ID {
+		t.Fatalf("ListWebhookDeliveries of an own subscription returned %d deliveries", len(own))
+	}
+
+	if err := repo.DeleteSubscription(b.ctx, a.subscription); !errors.Is(err, webhook.ErrSubscriptionNotFound) {
+		t.Fatalf("DeleteSubscription of a foreign subscription: err = %v, want not found", err)
+	}
+}
+
+func TestReserveTenantMailsIsAtomic(t *testing.T) {
+	_, repo, a, _, _ := setupTenants(t)
+
+	const (
+		limit    = 5
+		requests = 20
+	)
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		reserved int
+	)
+	for range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := repo.ReserveTenantMails(a.ctx, a.tenant, day, 1, limit)
+			if err != nil {
+				t.Errorf("ReserveTenantMails: %v", err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if reserved != limit {
+		t.Fatalf("reserved %d mails of %d concurrently, want exactly %d", reserved, requests, limit)
+	}
+
+	// Пакет больше квоты не списывается, даже частично.
+	ok, err := repo.ReserveTenantMails(a.ctx, a.tenant, day.Add(24*time.Hour), limit+1, limit)
+	if err != nil {
+		t.Fatalf("ReserveTenantMails: %v", err)
+	}
+	if ok {
+		t.Fatal("a batch larger than the quota was reserved")
+	}
+}
+
+func TestReleaseTenantMails(t *testing.T) {
+	_, repo, a, _, _ := setupTenants(t)
+
+	const limit = 3
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+
+	if ok, err := repo.ReserveTenantMails(a.ctx, a.tenant, day, limit, limit); err != nil || !ok {
+		t.Fatalf("ReserveTenantMails = %t, %v", ok, err)
+	}
+	if err := repo.ReleaseTenantMails(a.ctx, a.tenant, day, 2); err != nil {
+		t.Fatalf("ReleaseTenantMails: %v", err)
+	}
+
+	// Возвращённые письма снова можно списать, но не больше квоты.
+	if ok, err := repo.ReserveTenantMails(a.ctx, a.tenant, day, 2, limit); err != nil || !ok {
+		t.Fatalf("ReserveTenantMails after release = %t, %v", ok, err)
+	}
+	if ok, err := repo.ReserveTenantMails(a.ctx, a.tenant, day, 1, limit); err != nil || ok {
+		t.Fatalf("ReserveTenantMails over the quota = %t, %v", ok, err)
+	}
+
+	// Счётчик не уходит ниже нуля.
+	if err := repo.ReleaseTenantMails(a.ctx, a.tenant, day, limit+10); err != nil {
+		t.Fatalf("ReleaseTenantMails: %v", err)
+	}
+	if ok, err := repo.ReserveTenantMails(a.ctx, a.tenant, day, limit+1, limit); err != nil || ok {
+		t.Fatalf("ReserveTenantMails after releasing too much = %t, %v", ok, err)
+	}
+}
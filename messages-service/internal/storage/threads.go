@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/lib/pq"
+
+	"messages-service/internal/messages"
+)
+
+// FindThread ищет ветку сначала по Message-ID входящих писем и отправленных
+// ответов, затем по нормализованной теме от того же отправителя.
+func (r *Repo) FindThread(ctx context.Context, lookup messages.ThreadLookup) (string, error) {
+	const byRefs = `
+SELECT thread_id FROM (
+    SELECT m.thread_id, m.received_at AS at
+    FROM mails m
+    WHERE m.message_id = ANY($1)
+    UNION ALL
+    SELECT m.thread_id, COALESCE(d.sent_at, d.updated_at) AS at
+    FROM outbound_deliveries d
+    JOIN mails m ON m.id = d.mail_id
+    WHERE d.message_id = ANY($1)
+) refs
+ORDER BY at DESC
+LIMIT 1;
+`
+	const bySubject = `
+SELECT thread_id
+FROM mails
+WHERE subject_norm = $1
+  AND (lower(from_email) = $2 OR lower(from_email) LIKE '%<' || $4 || '>' ESCAPE '\')
+  AND received_at >= $3
+ORDER BY received_at DESC
+LIMIT 1;
+`
+
+	var threadID string
+	if len(lookup.MessageIDs) > 0 {
+		err := r.conn(ctx).QueryRowContext(ctx, byRefs, pq.Array(lookup.MessageIDs)).Scan(&threadID)
+		if err == nil {
+			return threadID, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+	}
+
+	if lookup.SubjectNorm == "" || lookup.From == "" {
+		return "", nil
+	}
+	err := r.conn(ctx).QueryRowContext(ctx, bySubject, lookup.SubjectNorm, lookup.From, lookup.Since, escapeLike(lookup.From)).Scan(&threadID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return threadID, nil
+}
+
+func (r *Repo) ListThread(ctx context.Context, threadID string) ([]messages.ThreadMail, error) {
+	const query = `
+SELECT m.id, m.thread_id, m.input, m.from_email, m.to_emails, m.cc, m.subject, m.message_id,
+m.received_at, m.status, m.classification, m.model_answer, m.assistant_response, m.is_approved, m.updated_at,
+m.input_enc, m.model_answer_enc, m.assistant_response_enc,
+d.status, d.message_id, d.sent_at, d.updated_at
+FROM mails m
+LEFT JOIN outbound_deliveries d ON d.mail_id = m.id
+WHERE m.thread_id = $1
+ORDER BY m.received_at, m.id;
+`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, threadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mails []messages.ThreadMail
+	for rows.Next() {
+		var tm messages.ThreadMail
+		var sealed sealedMail
+		var messageID sql.NullString
+		var classification sql.NullString
+		var assistantResponse sql.NullString
+		var replyStatus sql.NullString
+		var replyMessageID sql.NullString
+		var replySentAt sql.NullTime
+		var replyUpdatedAt sql.NullTime
+		if err := rows.Scan(
+			&tm.ID,
+			&tm.ThreadID,
+			&tm.Input,
+			&tm.From,
+			pq.Array(&tm.To),
+			pq.Array(&tm.Cc),
+			&tm.Subject,
+			&messageID,
+			&tm.ReceivedAt,
+			&tm.Status,
+			&classification,
+			&tm.ModelAnswer,
+			&assistantResponse,
+			&tm.IsApproved,
+			&tm.UpdatedAt,
+			&sealed.input,
+			&sealed.modelAnswer,
+			&sealed.assistantResponse,
+			&replyStatus,
+			&replyMessageID,
+			&replySentAt,
+			&replyUpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		tm.MessageID = messageID.String
+		tm.Classification = classification.String
+		if assistantResponse.Valid {
+			tm.AssistantResp = json.RawMessage(assistantResponse.String)
+		}
+		if err := r.openMail(ctx, tm.ID, sealed, &tm.Input, &tm.ModelAnswer, &tm.AssistantResp); err != nil {
+			return nil, err
+		}
+		if replyStatus.Valid {
+			tm.Reply = &messages.ReplyDelivery{
+				Status:    replyStatus.String,
+				MessageID: replyMessageID.String,
+				UpdatedAt: replyUpdatedAt.Time,
+			}
+			if replySentAt.Valid {
+				sentAt := replySentAt.Time
+				tm.Reply.SentAt = &sentAt
+			}
+		}
+		mails = append(mails, tm)
+	}
+
+	return mails, rows.Err()
+}
+
+// threadID подстраховывает письма, которым сервис не назначил ветку: такое
+// письмо открывает собственную.
+func threadID(m *messages.Mail) string {
+	if m.ThreadID == "" {
+		return m.ID
+	}
+	return m.ThreadID
+}
+
+func subjectNorm(subject string) string {
+	norm, _ := messages.NormalizeSubject(subject)
+	return norm
+}
@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"messages-service/internal/messages"
+	"messages-service/internal/webhook"
+)
+
+func (r *Repo) CreateSubscription(ctx context.Context, sub webhook.Subscription) error {
+	const query = `
+INSERT INTO webhook_subscriptions (id, url, secret, event_types, active, created_at)
+VALUES ($1, $2, $3, $4, $5, $6);
+`
+
+	_, err := r.conn(ctx).ExecContext(ctx, query, sub.ID, sub.URL, sub.Secret, pq.Array(sub.EventTypes), sub.Active, sub.CreatedAt)
+	return err
+}
+
+func (r *Repo) ListSubscriptions(ctx context.Context) ([]webhook.Subscription, error) {
+	const query = `
+SELECT id, url, event_types, active, created_at
+FROM webhook_subscriptions
+ORDER BY created_at;
+`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []webhook.Subscription
+	for rows.Next() {
+		var sub webhook.Subscription
+		if err := rows.Scan(&sub.ID, &sub.URL, pq.Array(&sub.EventTypes), &sub.Active, &sub.CreatedAt); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+func (r *Repo) DeleteSubscription(ctx context.Context, id string) error {
+	res, err := r.conn(ctx).ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1;`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: %s", webhook.ErrSubscriptionNotFound, id)
+	}
+
+	return nil
+}
+
+func (r *Repo) CreateWebhookDeliveries(ctx context.Context, event messages.Event, payload []byte) (int, error) {
+	const query = `
+INSERT INTO webhook_deliveries (id, subscription_id, event_id, event_type, mail_id, payload, payload_enc, enc_key_id)
+SELECT gen_random_uuid(), s.id, $1, $2, $3, $4, $6, $7
+FROM webhook_subscriptions s
+WHERE s.active AND $2 = ANY(s.event_types)
+  AND s.tenant_id = COALESCE(NULLIF($5, ''), (SELECT tenant_id FROM mails WHERE id = $3::uuid), 'default')
+ON CONFLICT (subscription_id, event_id) DO NOTHING;
+`
+
+	// Тело содержит данные события, поэтому шифруется так же; AAD общий для
+	// всех доставок события.
+	plain, sealed, err := r.sealJSON(ctx, payload, event.ID, "payload")
+	if err != nil {
+		return 0, err
+	}
+	var keyID sql.NullString
+	if sealed != nil {
+		keyID = r.encKeyID()
+	}
+
+	// Событие уходит только подпискам тенанта письма, в том числе когда его
+	// публикует фоновый воркер из общего пула.
+	res, err := r.conn(ctx).ExecContext(ctx, query,
+		event.ID, event.Type, nullString(event.MailID), []byte(plain), event.TenantID, sealed, keyID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rows), nil
+}
+
+// ClaimDueWebhookDeliveries забирает доставки, готовые к попытке, вместе с
+// URL и секретом подписки и сдвигает их next_attempt_at на lease.
+func (r *Repo) ClaimDueWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]webhook.Delivery, error) {
+	const query = `
+UPDATE webhook_deliveries d
+SET next_attempt_at = NOW() + $2::int * INTERVAL '1 second',
+updated_at = NOW()
+FROM webhook_subscriptions s
+WHERE s.id = d.subscription_id
+AND d.id IN (
+    SELECT id
+    FROM webhook_deliveries
+    WHERE status = 'pending'
+    AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING d.id, d.subscription_id, d.event_id, d.event_type, d.payload, d.payload_enc, d.status, d.attempts, d.created_at, s.url, s.secret;
+`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, limit, int(lease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []webhook.Delivery
+	for rows.Next() {
+		var (
+			d      webhook.Delivery
+			sealed []byte
+		)
+		if err := rows.Scan(
+			&d.ID,
+			&d.SubscriptionID,
+			&d.EventID,
+			&d.EventType,
+			&d.Payload,
+			&sealed,
+			&d.Status,
+			&d.Attempts,
+			&d.CreatedAt,
+			&d.URL,
+			&d.Secret,
+		); err != nil {
+			return nil, err
+		}
+		if sealed != nil {
+			if d.Payload, err = r.open(ctx, sealed, d.EventID, "payload"); err != nil {
+				return nil, err
+			}
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (r *Repo) FinishWebhookDelivery(ctx context.Context, d webhook.Delivery, status string, responseStatus int, reason string, nextAttemptAt *time.Time) error {
+	const query = `
+UPDATE webhook_deliveries
+SET status = $2,
+attempts = attempts + 1,
+response_status = $3,
+last_error = $4,
+next_attempt_at = COALESCE($5, 'infinity'::timestamptz),
+delivered_at = CASE WHEN $2 = 'delivered' THEN NOW() ELSE delivered_at END,
+updated_at = NOW()
+WHERE id = $1;
+`
+
+	var next sql.NullTime
+	if nextAttemptAt != nil {
+		next = sql.NullTime{Time: *nextAttemptAt, Valid: true}
+	}
+	respStatus := sql.NullInt64{Int64: int64(responseStatus), Valid: responseStatus != 0}
+
+	_, err := r.conn(ctx).ExecContext(ctx, query, d.ID, status, respStatus, nullString(reason), next)
+	return err
+}
+
+func (r *Repo) ListWebhookDeliveries(ctx context.Context, subscriptionID, status string, limit int) ([]webhook.Delivery, error) {
+	const query = `
+SELECT id, subscription_id, event_id, event_type, payload, payload_enc, status, attempts, last_error, response_status,
+next_attempt_at, delivered_at, created_at
+FROM webhook_deliveries
+WHERE subscription_id = $1
+AND ($2 = '' OR status = $2)
+ORDER BY created_at DESC
+LIMIT $3;
+`
+
+	rows, err := r.conn(ctx).QueryContext(ctx, query, subscriptionID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []webhook.Delivery
+	for rows.Next() {
+		var d webhook.Delivery
+		var sealed []byte
+		var lastError sql.NullString
+		var responseStatus sql.NullInt64
+		var nextAttemptAt sql.NullTime
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(
+			&d.ID,
+			&d.SubscriptionID,
+			&d.EventID,
+			&d.EventType,
+			&d.Payload,
+			&sealed,
+			&d.Status,
+			&d.Attempts,
+			&lastError,
+			&responseStatus,
+			&nextAttemptAt,
+			&deliveredAt,
+			&d.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if sealed != nil {
+			if d.Payload, err = r.open(ctx, sealed, d.EventID, "payload"); err != nil {
+				return nil, err
+			}
+		}
+		d.LastError = lastError.String
+		d.ResponseStatus = int(responseStatus.Int64)
+		if d.Status == webhook.StatusPending && nextAttemptAt.Valid {
+			d.NextAttemptAt = &nextAttemptAt.Time
+		}
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,255 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"messages-service/internal/config"
+	"messages-service/internal/messages"
+)
+
+// pageSize — сколько событий читать из журнала за один запрос.
+const pageSize = 500
+
+// Record — событие из журнала с его порядковым номером. Seq передаётся
+// клиенту как id SSE-события и используется для докачки по Last-Event-ID.
+type Record struct {
+	Seq int64 `json:"seq"`
+	messages.Event
+}
+
+// Filter отбирает события для подписчика. Пустые поля не ограничивают выборку.
+type Filter struct {
+	Tenant   string // тенант подписчика; пусто — изоляция тенантов выключена
+	Team     string
+	Statuses []string
+}
+
+func (f Filter) Match(r Record) bool {
+	if f.Tenant != "" && r.TenantID != f.Tenant {
+		return false
+	}
+	if f.Team != "" && r.Team != f.Team {
+		return false
+	}
+	return len(f.Statuses) == 0 || slices.Contains(f.Statuses, r.Status)
+}
+
+type Store interface {
+	// AppendEvent пишет событие в журнал. Пустой Team заполняется очередью письма.
+	AppendEvent(ctx context.Context, event messages.Event) (Record, error)
+	// ListEvents возвращает до limit событий с seq > after по возрастанию seq.
+	// События должны становиться видимыми в порядке seq, чтобы курсор
+	// seq > after не пропускал события, закоммиченные позже.
+	ListEvents(ctx context.Context, after int64, filter Filter, limit int) ([]Record, error)
+	LatestEventSeq(ctx context.Context) (int64, error)
+	DeleteEventsBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Broker пишет события сервиса в журнал mail_events и раздаёт их открытым
+// SSE-подключениям. Новые события читаются из журнала, а не из памяти,
+// поэтому клиент любой реплики видит события всех реплик, а БД опрашивает
+// один цикл на реплику независимо от числа клиентов.
+type Broker struct {
+	cfg   config.EventsConfig
+	store Store
+	log   *slog.Logger
+
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+
+	notify chan struct{}
+}
+
+// Subscription — открытое подключение к потоку событий.
+type Subscription struct {
+	C      <-chan Record
+	ch     chan Record
+	filter Filter
+	broker *Broker
+	once   sync.Once
+}
+
+func NewBroker(cfg config.EventsConfig, store Store, log *slog.Logger) *Broker {
+	return &Broker{
+		cfg:    cfg,
+		store:  store,
+		log:    log,
+		subs:   make(map[*Subscription]struct{}),
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Publish реализует messages.EventSink.
+func (b *Broker) Publish(ctx context.Context, event messages.Event) error {
+	if _, err := b.store.AppendEvent(ctx, event); err != nil {
+		return fmt.Errorf("append event: %w", err)
+	}
+
+	// Событие этой реплики раздаём сразу, не дожидаясь тика.
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Subscribe открывает подписку на новые события. Если клиент не успевает
+// читать и буфер переполнен, канал закрывается: клиент переподключается с
+// Last-Event-ID и дочитывает пропущенное из журнала.
+func (b *Broker) Subscribe(filter Filter) *Subscription {
+	ch := make(chan Record, b.cfg.BufferSize)
+	sub := &Subscription{C: ch, ch: ch, filter: filter, broker: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return sub
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.closeLocked()
+}
+
+func (s *Subscription) closeLocked() {
+	s.once.Do(func() {
+		delete(s.broker.subs, s)
+		close(s.ch)
+	})
+}
+
+// Replay передаёт в fn сохранённые события после seq after, подходящие под
+// фильтр, страницами по ReplayLimit, пока журнал не будет дочитан. Ошибка fn
+// останавливает докачку. Возвращает seq последнего переданного события.
+func (b *Broker) Replay(ctx context.Context, after int64, filter Filter, fn func(Record) error) (int64, error) {
+	limit := b.cfg.ReplayLimit
+	if limit <= 0 {
+		limit = pageSize
+	}
+	for {
+		records, err := b.store.ListEvents(ctx, after, filter, limit)
+		if err != nil {
+			return after, fmt.Errorf("list events: %w", err)
+		}
+		for _, r := range records {
+			if err := fn(r); err != nil {
+				return after, err
+			}
+			after = r.Seq
+		}
+		if len(records) < limit {
+			return after, nil
+		}
+	}
+}
+
+// Run читает новые события из журнала и раздаёт их подписчикам до отмены
+// ctx, затем закрывает все подписки. Раз в час удаляет события старше Retention.
+func (b *Broker) Run(ctx context.Context) {
+	defer b.closeAll()
+
+	// Подписчикам раздаются только события после старта; до успешного
+	// чтения текущего seq журнал не раздаётся, чтобы не выдать его целиком.
+	last, err := b.store.LatestEventSeq(ctx)
+	ready := err == nil
+	if err != nil && ctx.Err() == nil {
+		b.log.Error("failed to read latest event seq", slog.Any("error", err))
+	}
+
+	ticker := time.NewTicker(b.cfg.PollInterval)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	b.cleanup(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cleanup.C:
+			b.cleanup(ctx)
+			continue
+		case <-ticker.C:
+		case <-b.notify:
+		}
+
+		if !ready {
+			if last, err = b.store.LatestEventSeq(ctx); err != nil {
+				if ctx.Err() == nil {
+					b.log.Error("failed to read latest event seq", slog.Any("error", err))
+				}
+				continue
+			}
+			ready = true
+		}
+		if last, err = b.dispatch(ctx, last); err != nil && ctx.Err() == nil {
+			b.log.Error("event stream poll failed", slog.Any("error", err))
+		}
+	}
+}
+
+func (b *Broker) dispatch(ctx context.Context, last int64) (int64, error) {
+	for {
+		records, err := b.store.ListEvents(ctx, last, Filter{}, pageSize)
+		if err != nil {
+			return last, fmt.Errorf("list events: %w", err)
+		}
+
+		b.mu.Lock()
+		for _, r := range records {
+			for sub := range b.subs {
+				if !sub.filter.Match(r) {
+					continue
+				}
+				select {
+				case sub.ch <- r:
+				default:
+					b.log.Warn("event stream subscriber is too slow, closing")
+					sub.closeLocked()
+				}
+			}
+			last = r.Seq
+		}
+		b.mu.Unlock()
+
+		if len(records) < pageSize {
+			return last, nil
+		}
+	}
+}
+
+func (b *Broker) cleanup(ctx context.Context) {
+	if b.cfg.Retention <= 0 {
+		return
+	}
+	deleted, err := b.store.DeleteEventsBefore(ctx, time.Now().Add(-b.cfg.Retention))
+	if err != nil {
+		if ctx.Err() == nil {
+			b.log.Error("failed to delete old events", slog.Any("error", err))
+		}
+		return
+	}
+	if deleted > 0 {
+		b.log.Info("old events deleted", slog.Int64("count", deleted))
+	}
+}
+
+func (b *Broker) closeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		sub.closeLocked()
+	}
+}
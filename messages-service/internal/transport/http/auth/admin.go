@@ -0,0 +1,57 @@
+package authhttp
+
+import (
+	"crypto/sha256"
+	"log/slog"
+	"net/http"
+)
+
+// adminPaths — административные пути. Middleware пропускает их без ключа
+// тенанта: ключ администратора проверяет Admin.
+var adminPaths = map[string]bool{
+	"/admin/usage": true,
+}
+
+// Admin пускает к административным эндпоинтам только запросы с ключом
+// администратора. Ключи тенантов и сервисные ключи к ним не подходят.
+type Admin struct {
+	keys map[[sha256.Size]byte]bool
+	log  *slog.Logger
+}
+
+// NewAdmin собирает ключи администратора из конфига и переменной окружения
+// (через запятую). Без ключей административные эндпоинты закрыты.
+func NewAdmin(static []string, env string, log *slog.Logger) *Admin {
+	a := &Admin{keys: make(map[[sha256.Size]byte]bool), log: log}
+	for _, key := range keys(static, env) {
+		a.keys[sha256.Sum256([]byte(key))] = true
+	}
+	return a
+}
+
+// Enabled — задан хотя бы один ключ администратора.
+func (a *Admin) Enabled() bool {
+	return len(a.keys) > 0
+}
+
+// Wrap отвечает 401 без ключа и 403 с ключом, который не является ключом
+// администратора.
+func (a *Admin) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := credentials(r)
+		if key == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="messages-service"`)
+			writeError(w, http.StatusUnauthorized, "admin api key required")
+			return
+		}
+		if !a.keys[sha256.Sum256([]byte(key))] {
+			a.log.Warn("rejected admin request",
+				slog.String("path", r.URL.Path),
+				slog.String("remote_addr", r.RemoteAddr),
+			)
+			writeError(w, http.StatusForbidden, "admin api key required")
+			return
+		}
+		next(w, r)
+	}
+}
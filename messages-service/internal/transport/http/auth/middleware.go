@@ -0,0 +1,155 @@
+package authhttp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"messages-service/internal/config"
+	"messages-service/internal/messages"
+)
+
+// servicePaths — пути, доступные по сервисному ключу. Запрос сервисного
+// ключа не привязан к тенанту: сервис берёт тенанта из письма.
+var servicePaths = map[string]bool{
+	"/validate_processed_message": true,
+}
+
+// publicPaths — пути, доступные без ключа.
+var publicPaths = map[string]bool{
+	"/healthz": true,
+}
+
+// Authenticator определяет тенанта запроса по API-ключу. Ключи хранятся
+// как sha256, чтобы сравнение не зависело от длины совпавшего префикса.
+type Authenticator struct {
+	tenants  map[[sha256.Size]byte]string
+	services map[[sha256.Size]byte]bool
+	log      *slog.Logger
+}
+
+// New собирает ключи тенантов и сервисные ключи из секции tenancy.
+func New(cfg config.TenancyConfig, log *slog.Logger) (*Authenticator, error) {
+	a := &Authenticator{
+		tenants:  make(map[[sha256.Size]byte]string),
+		services: make(map[[sha256.Size]byte]bool),
+		log:      log,
+	}
+
+	for _, tc := range cfg.Tenants {
+		for _, key := range keys(tc.APIKeys, tc.APIKeysEnv) {
+			sum := sha256.Sum256([]byte(key))
+			if owner, ok := a.tenants[sum]; ok {
+				return nil, fmt.Errorf("tenant %s: api key already used by tenant %s", tc.ID, owner)
+			}
+			a.tenants[sum] = tc.ID
+		}
+	}
+	for _, key := range keys(cfg.ServiceKeys, cfg.ServiceKeysEnv) {
+		sum := sha256.Sum256([]byte(key))
+		if owner, ok := a.tenants[sum]; ok {
+			return nil, fmt.Errorf("service key is already used by tenant %s", owner)
+		}
+		a.services[sum] = true
+	}
+
+	if len(a.tenants) == 0 {
+		return nil, errors.New("tenancy enabled but no tenant api keys configured")
+	}
+	return a, nil
+}
+
+// Middleware пропускает запрос с ключом тенанта, записав тенанта и KeyID в ctx, и
+// запрос сервисного ключа к servicePaths. Остальные получают 401 или 403.
+// publicPaths и adminPaths проходят без проверки: у вторых её делает Admin.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] || adminPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := credentials(r)
+		if key == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="messages-service"`)
+			writeError(w, http.StatusUnauthorized, "api key required")
+			return
+		}
+
+		sum := sha256.Sum256([]byte(key))
+		if tenant, ok := a.tenants[sum]; ok {
+			ctx := messages.ContextWithTenant(r.Context(), tenant)
+			next.ServeHTTP(w, r.WithContext(messages.ContextWithCaller(ctx, KeyID(r))))
+			return
+		}
+		if a.services[sum] {
+			if !servicePaths[r.URL.Path] {
+				writeError(w, http.StatusForbidden, "service key is not allowed for this endpoint")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		a.log.Warn("rejected request with unknown api key",
+			slog.String("path", r.URL.Path),
+			slog.String("remote_addr", r.RemoteAddr),
+		)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="messages-service", error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, "invalid api key")
+	})
+}
+
+// KeyID — несекретный идентификатор ключа запроса для логов и лимитов:
+// префикс его sha256. Пустая строка — запрос без ключа.
+func KeyID(r *http.Request) string {
+	key := credentials(r)
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:6])
+}
+
+// credentials достаёт ключ из Authorization: Bearer или X-API-Key.
+func credentials(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// keys объединяет ключи из конфига и из переменной окружения (через запятую).
+func keys(static []string, env string) []string {
+	all := make([]string, 0, len(static))
+	for _, key := range static {
+		if key = strings.TrimSpace(key); key != "" {
+			all = append(all, key)
+		}
+	}
+	if env == "" {
+		return all
+	}
+	for _, key := range strings.Split(os.Getenv(env), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			all = append(all, key)
+		}
+	}
+	return all
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
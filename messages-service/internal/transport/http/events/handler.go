@@ -0,0 +1,151 @@
+package eventshttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"messages-service/internal/messages"
+	"messages-service/internal/stream"
+)
+
+type Handler struct {
+	broker    *stream.Broker
+	heartbeat time.Duration
+	log       *slog.Logger
+}
+
+func New(broker *stream.Broker, heartbeat time.Duration, log *slog.Logger) *Handler {
+	return &Handler{
+		broker:    broker,
+		heartbeat: heartbeat,
+		log:       log,
+	}
+}
+
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/events", h.handleEvents)
+}
+
+// handleEvents отдаёт поток событий в формате text/event-stream. При
+// переподключении браузер присылает Last-Event-ID, и пропущенные события
+// дочитываются из журнала перед живым потоком.
+func (h *Handler) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := stream.Filter{
+		Tenant: messages.TenantFromContext(r.Context()),
+		Team:   strings.TrimSpace(query.Get("team")),
+	}
+	for _, status := range strings.Split(query.Get("status"), ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = query.Get("last_event_id")
+	}
+	var after int64
+	if lastID != "" {
+		var err error
+		if after, err = strconv.ParseInt(lastID, 10, 64); err != nil || after < 0 {
+			writeError(w, http.StatusBadRequest, "invalid Last-Event-ID")
+			return
+		}
+	}
+
+	// Подписываемся до чтения журнала, чтобы не потерять события между
+	// докачкой и живым потоком; дубли отсекаются по seq.
+	sub := h.broker.Subscribe(filter)
+	defer sub.Close()
+
+	// Поток живёт дольше WriteTimeout сервера.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.log.Warn("failed to clear write deadline", slog.Any("error", err))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// Журнал дочитывается страницами до конца; если за это время буфер
+	// подписки переполнится, клиент переподключится и продолжит с
+	// последнего отданного id.
+	if lastID != "" {
+		var err error
+		after, err = h.broker.Replay(r.Context(), after, filter, func(record stream.Record) error {
+			return writeEvent(w, record)
+		})
+		if err != nil {
+			if r.Context().Err() == nil {
+				h.log.Error("failed to replay events", slog.Any("error", err))
+			}
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case record, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if record.Seq <= after {
+				continue
+			}
+			if err := writeEvent(w, record); err != nil {
+				return
+			}
+			after = record.Seq
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w io.Writer, record stream.Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", record.Seq, record.Type, data)
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -1,13 +1,31 @@
 package messageshttp
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"messages-service/internal/messages"
 )
 
+const (
+	// maxBatchItems ограничивает размер одного запроса /process/batch.
+	maxBatchItems = 5000
+	// maxBatchBytes ограничивает размер тела /process/batch.
+	maxBatchBytes = 64 << 20
+	// maxRawMessageBytes ограничивает размер письма в /process/raw.
+	maxRawMessageBytes = 25 << 20
+)
+
 type Handler struct {
 	svc *messages.Service
 	log *slog.Logger
@@ -22,10 +40,22 @@ func New(svc *messages.Service, log *slog.Logger) *Handler {
 
 func (h *Handler) Register(mux *http.ServeMux) {
 	mux.HandleFunc("/process", h.handleProcess)
+	mux.HandleFunc("/process/batch", h.handleProcessBatch)
+	mux.HandleFunc("/process/raw", h.handleProcessRaw)
 	mux.HandleFunc("/validate_processed_message", h.handleValidateProcessedMessage)
 	mux.HandleFunc("/processed", h.handleGetProcessed)
+	mux.HandleFunc("/inbox", h.handleGetInbox)
 	mux.HandleFunc("/approve", h.handleApprove)
+	mux.HandleFunc("/approvals/{id}", h.handleGetApprovals)
 	mux.HandleFunc("/add-assistant-response", h.handleAddAssistantResponse)
+	mux.HandleFunc("/reprocess", h.handleReprocess)
+	mux.HandleFunc("/mails/search", h.handleSearch)
+	mux.HandleFunc("/mails/{id}/attachments", h.handleListAttachments)
+	mux.HandleFunc("/mails/{id}/legal-hold", h.handleLegalHold)
+	mux.HandleFunc("/mails/{id}/resend-reply", h.handleResendReply)
+	mux.HandleFunc("/senders/{id}", h.handleSender)
+	mux.HandleFunc("/attachments/{id}", h.handleGetAttachment)
+	mux.HandleFunc("/threads/{id}", h.handleGetThread)
 	mux.HandleFunc("/healthz", h.handleHealth)
 }
 
@@ -45,10 +75,26 @@ func (h *Handler) handleProcess(w http.ResponseWriter, r *http.Request) {
 	}
 
 	id, err := h.svc.ProcessIncomingMessage(r.Context(), dto)
+	if errors.Is(err, messages.ErrNotQueued) {
+		// Письмо уже сохранено: клиент не должен слать его повторно.
+		h.log.Error("incoming message stored but not queued",
+			slog.Any("error", err),
+			slog.String("id", id),
+		)
+		writeJSON(w, http.StatusAccepted, map[string]any{"status": "not_queued", "id": id})
+		return
+	}
 	if err != nil {
 		h.log.Error("failed to process incoming message",
 			slog.Any("error", err),
 		)
+		if errors.Is(err, messages.ErrInvalidMessage) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if writeQuotaError(w, err) {
+			return
+		}
 		writeError(w, http.StatusInternalServerError, "failed to process message")
 		return
 	}
@@ -56,6 +102,168 @@ func (h *Handler) handleProcess(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusAccepted, map[string]any{"status": "queued", "id": id})
 }
 
+func (h *Handler) handleProcessRaw(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	defer r.Body.Close()
+
+	id, err := h.svc.ProcessRawMessage(r.Context(), http.MaxBytesReader(w, r.Body, maxRawMessageBytes))
+	if errors.Is(err, messages.ErrNotQueued) {
+		h.log.Error("raw message stored but not queued",
+			slog.Any("error", err),
+			slog.String("id", id),
+		)
+		writeJSON(w, http.StatusAccepted, map[string]any{"status": "not_queued", "id": id})
+		return
+	}
+	if err != nil {
+		h.log.Error("failed to process raw message", slog.Any("error", err))
+		if errors.Is(err, messages.ErrInvalidMessage) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if writeQuotaError(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to process message")
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]any{"status": "queued", "id": id})
+}
+
+func (h *Handler) handleProcessBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	defer r.Body.Close()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBytes)
+	dtos, decodeErrs, err := decodeBatch(r)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errBatchTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("batch exceeds %d items", maxBatchItems))
+		return
+	case errors.As(err, &maxBytesErr):
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("batch exceeds %d bytes", maxBatchBytes))
+		return
+	case err != nil:
+		h.log.Error("failed to decode /process/batch body", slog.Any("error", err))
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results, err := h.svc.ProcessIncomingBatch(r.Context(), dtos)
+	if err != nil {
+		h.log.Error("failed to process incoming batch", slog.Any("error", err))
+		if writeQuotaError(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to process batch")
+		return
+	}
+
+	// Строки NDJSON, которые не удалось разобрать, встраиваем в общий список
+	// результатов, сохраняя их позиции во входном потоке.
+	all := make([]messages.BatchItemResult, 0, len(results)+len(decodeErrs))
+	next := 0
+	for pos := 0; pos < len(results)+len(decodeErrs); pos++ {
+		if reason, ok := decodeErrs[pos]; ok {
+			all = append(all, messages.BatchItemResult{Index: pos, Status: messages.BatchStatusInvalid, Reason: reason})
+			continue
+		}
+		item := results[next]
+		item.Index = pos
+		all = append(all, item)
+		next++
+	}
+
+	summary := make(map[string]int)
+	for _, item := range all {
+		summary[item.Status]++
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"results": all, "summary": summary})
+}
+
+// errBatchTooLarge — в пакете больше maxBatchItems элементов.
+var errBatchTooLarge = errors.New("batch too large")
+
+// decodeBatch читает пакет писем как JSON-массив или как NDJSON
+// (Content-Type application/x-ndjson). Для NDJSON возвращает причины ошибок
+// разбора по номеру строки, чтобы остальные элементы пакета не терялись.
+// Чтение прекращается на элементе сверх maxBatchItems.
+func decodeBatch(r *http.Request) ([]messages.IncomingMessageDTO, map[int]string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		var dtos []messages.IncomingMessageDTO
+		decodeErrs := make(map[int]string)
+
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		pos := 0
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			if pos == maxBatchItems {
+				return nil, nil, errBatchTooLarge
+			}
+			var dto messages.IncomingMessageDTO
+			if err := json.Unmarshal(line, &dto); err != nil {
+				decodeErrs[pos] = "invalid json: " + err.Error()
+			} else {
+				dtos = append(dtos, dto)
+			}
+			pos++
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, fmt.Errorf("read ndjson body: %w", err)
+		}
+		return dtos, decodeErrs, nil
+	default:
+		errInvalid := errors.New("invalid json body: expected array of messages")
+		dec := json.NewDecoder(r.Body)
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			return nil, nil, bodyError(err, errInvalid)
+		}
+		var dtos []messages.IncomingMessageDTO
+		for dec.More() {
+			if len(dtos) == maxBatchItems {
+				return nil, nil, errBatchTooLarge
+			}
+			var dto messages.IncomingMessageDTO
+			if err := dec.Decode(&dto); err != nil {
+				return nil, nil, bodyError(err, errInvalid)
+			}
+			dtos = append(dtos, dto)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, nil, bodyError(err, errInvalid)
+		}
+		return dtos, nil, nil
+	}
+}
+
+// bodyError сохраняет превышение размера тела, остальные ошибки чтения
+// заменяет на invalid.
+func bodyError(err, invalid error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return err
+	}
+	return invalid
+}
+
 func (h *Handler) handleValidateProcessedMessage(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -71,7 +279,15 @@ func (h *Handler) handleValidateProcessedMessage(w http.ResponseWriter, r *http.
 		return
 	}
 
+	if dto.Source == "" {
+		dto.Source = r.Header.Get("X-LLM-Source")
+	}
+
 	if err := h.svc.ValidateProcessedMessage(r.Context(), dto); err != nil {
+		if errors.Is(err, messages.ErrStubAnswer) {
+			writeError(w, http.StatusUnprocessableEntity, "stub llm answers are not accepted")
+			return
+		}
 		h.log.Error("failed to validate processed message",
 			slog.Any("error", err),
 			slog.String("id", dto.ID),
@@ -89,8 +305,18 @@ func (h *Handler) handleGetProcessed(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	items, err := h.svc.GetProcessedMessages(r.Context())
+	filter, err := listFilter(r)
 	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	items, err := h.svc.GetProcessedMessages(r.Context(), filter)
+	if err != nil {
+		if errors.Is(err, messages.ErrInvalidFilter) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		h.log.Error("failed to list processed messages", slog.Any("error", err))
 		writeError(w, http.StatusInternalServerError, "failed to list processed messages")
 		return
@@ -99,6 +325,154 @@ func (h *Handler) handleGetProcessed(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"messages": items})
 }
 
+func (h *Handler) handleGetInbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := listFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	team := r.URL.Query().Get("team")
+	items, err := h.svc.GetInbox(r.Context(), team, filter)
+	if err != nil {
+		if errors.Is(err, messages.ErrUnknownTeam) || errors.Is(err, messages.ErrInvalidFilter) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.log.Error("failed to list inbox", slog.Any("error", err), slog.String("team", team))
+		writeError(w, http.StatusInternalServerError, "failed to list inbox")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"team": team, "messages": items})
+}
+
+// listFilter читает ?sla_state=at_risk,breached&mailbox=...&category=...&tag=...&legal_risks=true&sort=sla_deadline.
+func listFilter(r *http.Request) (messages.ListFilter, error) {
+	q := r.URL.Query()
+	filter := messages.ListFilter{
+		SLAStates:    splitList(q["sla_state"]),
+		Mailbox:      q.Get("mailbox"),
+		Category:     q.Get("category"),
+		Urgency:      strings.ToLower(strings.TrimSpace(q.Get("urgency"))),
+		MainApprover: q.Get("main_approver"),
+		Tags:         splitList(q["tag"]),
+		Sort:         q.Get("sort"),
+	}
+	if value := q.Get("legal_risks"); value != "" {
+		legalRisks, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("%w: invalid legal_risks %q", messages.ErrInvalidFilter, value)
+		}
+		filter.LegalRisks = &legalRisks
+	}
+	return filter, nil
+}
+
+// splitList собирает значения повторяющегося параметра, каждое из которых
+// может содержать несколько значений через запятую.
+func splitList(values []string) []string {
+	var out []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+	}
+	return out
+}
+
+func (h *Handler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	query, err := searchQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results, err := h.svc.SearchMails(r.Context(), query)
+	if err != nil {
+		if errors.Is(err, messages.ErrInvalidFilter) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, messages.ErrSearchUnavailable) {
+			writeError(w, http.StatusNotImplemented, err.Error())
+			return
+		}
+		h.log.Error("failed to search mails", slog.Any("error", err))
+		writeError(w, http.StatusInternalServerError, "failed to search mails")
+		return
+	}
+	if results == nil {
+		results = []messages.SearchResult{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"results": results})
+}
+
+// searchQuery читает параметры /mails/search. Даты принимаются в RFC 3339
+// или как YYYY-MM-DD.
+func searchQuery(r *http.Request) (messages.SearchQuery, error) {
+	q := r.URL.Query()
+	query := messages.SearchQuery{
+		Text:           q.Get("q"),
+		Classification: q.Get("classification"),
+		Category:       q.Get("category"),
+		Mailbox:        q.Get("mailbox"),
+		Team:           q.Get("team"),
+		Status:         q.Get("status"),
+		From:           q.Get("from"),
+		SLAStates:      splitList(q["sla_state"]),
+	}
+
+	var err error
+	if query.ReceivedFrom, err = queryTime(q.Get("received_from")); err != nil {
+		return query, fmt.Errorf("invalid received_from: %w", err)
+	}
+	if query.ReceivedTo, err = queryTime(q.Get("received_to")); err != nil {
+		return query, fmt.Errorf("invalid received_to: %w", err)
+	}
+	if query.Limit, err = queryInt(q.Get("limit")); err != nil {
+		return query, fmt.Errorf("invalid limit: %w", err)
+	}
+	if query.Offset, err = queryInt(q.Get("offset")); err != nil {
+		return query, fmt.Errorf("invalid offset: %w", err)
+	}
+	return query, nil
+}
+
+func queryTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func queryInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
 func (h *Handler) handleApprove(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -113,13 +487,38 @@ func (h *Handler) handleApprove(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.svc.ApproveMessage(r.Context(), dto); err != nil {
-		h.log.Error("failed to approve message", slog.Any("error", err), slog.String("id", dto.ID))
-		writeError(w, http.StatusInternalServerError, "failed to approve message")
+	status, err := h.svc.ApproveMessage(r.Context(), dto)
+	if err != nil {
+		switch {
+		case errors.Is(err, messages.ErrInvalidApproval):
+			writeError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, messages.ErrApprovalConflict):
+			writeError(w, http.StatusConflict, err.Error())
+		default:
+			h.log.Error("failed to approve message", slog.Any("error", err), slog.String("id", dto.ID))
+			writeError(w, http.StatusInternalServerError, "failed to approve message")
+		}
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": "approved", "id": dto.ID})
+	writeJSON(w, http.StatusOK, map[string]string{"status": status, "id": dto.ID})
+}
+
+func (h *Handler) handleGetApprovals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id := r.PathValue("id")
+	state, err := h.svc.GetApprovals(r.Context(), id)
+	if err != nil {
+		h.log.Error("failed to get approvals", slog.Any("error", err), slog.String("id", id))
+		writeError(w, http.StatusNotFound, "mail not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, state)
 }
 
 func (h *Handler) handleAddAssistantResponse(w http.ResponseWriter, r *http.Request) {
@@ -145,6 +544,184 @@ func (h *Handler) handleAddAssistantResponse(w http.ResponseWriter, r *http.Requ
 	writeJSON(w, http.StatusOK, map[string]string{"status": "saved", "id": dto.ID})
 }
 
+func (h *Handler) handleReprocess(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	defer r.Body.Close()
+	var dto messages.ReprocessDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		h.log.Error("failed to decode reprocess body", slog.Any("error", err))
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	if err := h.svc.ReprocessMessage(r.Context(), dto); err != nil {
+		h.log.Error("failed to reprocess message", slog.Any("error", err), slog.String("id", dto.ID))
+		if writeQuotaError(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to reprocess message")
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "requeued", "id": dto.ID})
+}
+
+func (h *Handler) handleResendReply(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id := r.PathValue("id")
+	if err := h.svc.ResendReply(r.Context(), id); err != nil {
+		if errors.Is(err, messages.ErrApprovalConflict) {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		h.log.Error("failed to resend reply", slog.Any("error", err), slog.String("id", id))
+		writeError(w, http.StatusNotFound, "mail not found")
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": id, "status": "pending"})
+}
+
+func (h *Handler) handleListAttachments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id := r.PathValue("id")
+	items, err := h.svc.ListAttachments(r.Context(), id)
+	if err != nil {
+		h.log.Error("failed to list attachments", slog.Any("error", err), slog.String("id", id))
+		writeError(w, http.StatusInternalServerError, "failed to list attachments")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"attachments": items})
+}
+
+type legalHoldDTO struct {
+	Hold   bool   `json:"hold"`
+	Reason string `json:"reason"`
+}
+
+func (h *Handler) handleLegalHold(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	defer r.Body.Close()
+	var dto legalHoldDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		h.log.Error("failed to decode legal hold body", slog.Any("error", err))
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	id := r.PathValue("id")
+	if err := h.svc.SetLegalHold(r.Context(), id, dto.Hold, dto.Reason); err != nil {
+		h.log.Error("failed to set legal hold", slog.Any("error", err), slog.String("id", id))
+		writeError(w, http.StatusNotFound, "mail not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "legal_hold": dto.Hold})
+}
+
+// handleSender: GET — карточка отправителя по id или адресу, DELETE — удаление
+// данных отправителя по адресу.
+func (h *Handler) handleSender(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.handleGetSender(w, r)
+	case http.MethodDelete:
+		h.handleForgetSender(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *Handler) handleGetSender(w http.ResponseWriter, r *http.Request) {
+	profile, err := h.svc.GetSender(r.Context(), r.PathValue("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, messages.ErrInvalidMessage):
+			writeError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, messages.ErrSenderNotFound):
+			writeError(w, http.StatusNotFound, "sender not found")
+		default:
+			h.log.Error("failed to get sender", slog.Any("error", err))
+			writeError(w, http.StatusInternalServerError, "failed to get sender")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func (h *Handler) handleForgetSender(w http.ResponseWriter, r *http.Request) {
+	result, err := h.svc.ForgetSender(r.Context(), r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, messages.ErrInvalidMessage) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.log.Error("failed to delete sender data", slog.Any("error", err))
+		writeError(w, http.StatusInternalServerError, "failed to delete sender data")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h *Handler) handleGetAttachment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id := r.PathValue("id")
+	attachment, data, err := h.svc.GetAttachmentContent(r.Context(), id)
+	if err != nil {
+		h.log.Error("failed to get attachment", slog.Any("error", err), slog.String("id", id))
+		writeError(w, http.StatusNotFound, "attachment not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	// Содержимое присылает отправитель письма: браузер не должен угадывать
+	// тип и исполнять, например, HTML под видом картинки.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+func (h *Handler) handleGetThread(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id := r.PathValue("id")
+	thread, err := h.svc.GetThread(r.Context(), id)
+	if err != nil {
+		h.log.Error("failed to get thread", slog.Any("error", err), slog.String("id", id))
+		writeError(w, http.StatusNotFound, "thread not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, thread)
+}
+
 func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -154,6 +731,23 @@ func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
+// writeQuotaError отвечает 429, если исчерпана суточная квота: Retry-After
+// и RateLimit-Reset указывают на её обновление в полночь UTC.
+func writeQuotaError(w http.ResponseWriter, err error) bool {
+	var quotaErr *messages.QuotaError
+	if !errors.As(err, &quotaErr) {
+		return false
+	}
+
+	retryAfter := strconv.Itoa(max(1, int(math.Ceil(time.Until(quotaErr.Reset).Seconds()))))
+	w.Header().Set("Retry-After", retryAfter)
+	w.Header().Set("RateLimit-Limit", strconv.FormatInt(quotaErr.Limit, 10))
+	w.Header().Set("RateLimit-Remaining", "0")
+	w.Header().Set("RateLimit-Reset", retryAfter)
+	writeError(w, http.StatusTooManyRequests, err.Error())
+	return true
+}
+
 func writeJSON(w http.ResponseWriter, status int, payload any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,147 @@
+package ratelimithttp
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"messages-service/internal/messages"
+	"messages-service/internal/ratelimit"
+	authhttp "messages-service/internal/transport/http/auth"
+)
+
+type Handler struct {
+	limiter *ratelimit.Limiter
+	// authenticated — ключи проверяет authhttp. Без проверки ключ
+	// запроса ничего не значит, и клиенты различаются по IP.
+	authenticated bool
+	admin         *authhttp.Admin
+	log           *slog.Logger
+}
+
+func New(limiter *ratelimit.Limiter, authenticated bool, admin *authhttp.Admin, log *slog.Logger) *Handler {
+	return &Handler{
+		limiter:       limiter,
+		authenticated: authenticated,
+		admin:         admin,
+		log:           log,
+	}
+}
+
+// Register подключает /admin/usage, если заданы ключи администратора.
+func (h *Handler) Register(mux *http.ServeMux) {
+	if !h.admin.Enabled() {
+		h.log.Info("admin usage endpoint disabled: no admin keys configured")
+		return
+	}
+	mux.HandleFunc("/admin/usage", h.admin.Wrap(h.handleUsage))
+}
+
+// Middleware ограничивает частоту запросов клиента и привязывает запрос к
+// клиенту, чтобы сервис списывал с него суточные квоты. Подключается после
+// authhttp: клиент определяется по ключу или тенанту запроса.
+func (h *Handler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := h.client(r)
+		ctx := ratelimit.ContextWithClient(r.Context(), client)
+
+		// Воркер LLM присылает результаты по каждому письму; его запросы не
+		// ограничиваются, иначе лимит клиента (или общего IP) задержит ответы
+		// модели. Расход при этом учитывается.
+		if r.URL.Path == "/validate_processed_message" && messages.TenantFromContext(ctx) == "" {
+			h.limiter.Record(ctx, ratelimit.MetricRequests, 1)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		decision := h.limiter.Allow(ctx, client)
+		if decision.Limit > 0 {
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(decision.Reset)))
+		}
+		if !decision.Allowed {
+			retryAfter := max(1, seconds(decision.RetryAfter))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(retryAfter))
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+
+		h.limiter.Record(ctx, ratelimit.MetricRequests, 1)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (h *Handler) client(r *http.Request) ratelimit.Client {
+	tenant := messages.TenantFromContext(r.Context())
+	if h.authenticated {
+		if h.limiter.KeyBy() == ratelimit.KeyByTenant && tenant != "" {
+			return ratelimit.Client{ID: "tenant:" + tenant, Tenant: tenant}
+		}
+		if id := authhttp.KeyID(r); id != "" {
+			return ratelimit.Client{ID: id, Tenant: tenant}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return ratelimit.Client{ID: "ip:" + host, Tenant: tenant}
+}
+
+// seconds округляет длительность вверх до целых секунд для заголовков.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func (h *Handler) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	day := time.Now().UTC()
+	if raw := r.URL.Query().Get("date"); raw != "" {
+		parsed, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid date, use YYYY-MM-DD")
+			return
+		}
+		day = parsed
+	}
+
+	// Администратор видит всех клиентов или, с ?tenant=, клиентов тенанта.
+	tenant := r.URL.Query().Get("tenant")
+	usage, err := h.limiter.Usage(r.Context(), day, tenant)
+	if err != nil {
+		h.log.Error("failed to load usage", slog.Any("error", err))
+		writeError(w, http.StatusInternalServerError, "failed to load usage")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"date":    day.Format(time.DateOnly),
+		"clients": usage,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
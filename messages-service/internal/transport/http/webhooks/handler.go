@@ -0,0 +1,124 @@
+package webhookshttp
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"messages-service/internal/webhook"
+)
+
+type Handler struct {
+	svc *webhook.Service
+	log *slog.Logger
+}
+
+func New(svc *webhook.Service, log *slog.Logger) *Handler {
+	return &Handler{
+		svc: svc,
+		log: log,
+	}
+}
+
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/webhooks", h.handleSubscriptions)
+	mux.HandleFunc("/webhooks/{id}", h.handleSubscription)
+	mux.HandleFunc("/webhooks/{id}/deliveries", h.handleDeliveries)
+}
+
+type subscribeDTO struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Secret     string   `json:"secret,omitempty"`
+}
+
+func (h *Handler) handleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		subs, err := h.svc.ListSubscriptions(r.Context())
+		if err != nil {
+			h.log.Error("failed to list webhook subscriptions", slog.Any("error", err))
+			writeError(w, http.StatusInternalServerError, "failed to list subscriptions")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"subscriptions": subs})
+
+	case http.MethodPost:
+		defer r.Body.Close()
+		var dto subscribeDTO
+		if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+			h.log.Error("failed to decode webhook subscription body", slog.Any("error", err))
+			writeError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+
+		sub, err := h.svc.Subscribe(r.Context(), webhook.Subscription{
+			URL:        dto.URL,
+			EventTypes: dto.EventTypes,
+			Secret:     dto.Secret,
+		})
+		if err != nil {
+			if errors.Is(err, webhook.ErrInvalidSubscription) {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			h.log.Error("failed to create webhook subscription", slog.Any("error", err))
+			writeError(w, http.StatusInternalServerError, "failed to create subscription")
+			return
+		}
+		writeJSON(w, http.StatusCreated, sub)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *Handler) handleSubscription(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id := r.PathValue("id")
+	if err := h.svc.Unsubscribe(r.Context(), id); err != nil {
+		if errors.Is(err, webhook.ErrSubscriptionNotFound) {
+			writeError(w, http.StatusNotFound, "subscription not found")
+			return
+		}
+		h.log.Error("failed to delete webhook subscription", slog.Any("error", err), slog.String("id", id))
+		writeError(w, http.StatusInternalServerError, "failed to delete subscription")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted", "id": id})
+}
+
+func (h *Handler) handleDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id := r.PathValue("id")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	deliveries, err := h.svc.ListDeliveries(r.Context(), id, r.URL.Query().Get("status"), limit)
+	if err != nil {
+		h.log.Error("failed to list webhook deliveries", slog.Any("error", err), slog.String("id", id))
+		writeError(w, http.StatusInternalServerError, "failed to list deliveries")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"deliveries": deliveries})
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
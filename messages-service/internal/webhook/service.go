@@ -0,0 +1,318 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"messages-service/internal/config"
+	"messages-service/internal/messages"
+)
+
+// Статусы доставки webhook-а.
+const (
+	StatusPending   = "pending" // ждёт первой или повторной попытки
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed" // попытки исчерпаны
+)
+
+// Заголовки запроса к подписчику.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// claimMargin — запас аренды доставок сверх времени на запросы пакета.
+const claimMargin = time.Minute
+
+// EventTypes — события, на которые можно подписаться.
+var EventTypes = []string{
+	messages.EventMailQueued,
+	messages.EventMailFiltered,
+	messages.EventMailProcessed,
+	messages.EventMailFailed,
+	messages.EventMailApproved,
+	messages.EventMailRejected,
+	messages.EventMailSLAAtRisk,
+	messages.EventMailSLABreached,
+	messages.EventMailAssistantResponseAdded,
+}
+
+// ErrInvalidSubscription — неверный URL или тип события в подписке.
+var ErrInvalidSubscription = errors.New("invalid webhook subscription")
+
+// ErrSubscriptionNotFound — подписки с таким id нет.
+var ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+
+type Subscription struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"` // отдаётся только при создании
+	EventTypes []string  `json:"event_types"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type Delivery struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastError      string          `json:"last_error,omitempty"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+
+	// URL и Secret подписки заполняются при захвате доставки на отправку.
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+type Store interface {
+	CreateSubscription(ctx context.Context, sub Subscription) error
+	ListSubscriptions(ctx context.Context) ([]Subscription, error)
+	DeleteSubscription(ctx context.Context, id string) error
+	// CreateWebhookDeliveries создаёт доставки события всем активным
+	// подписчикам этого типа события.
+	CreateWebhookDeliveries(ctx context.Context, event messages.Event, payload []byte) (int, error)
+	ClaimDueWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error)
+	// FinishWebhookDelivery записывает итог попытки; nextAttemptAt == nil
+	// для неуспешной попытки прекращает повторы.
+	FinishWebhookDelivery(ctx context.Context, d Delivery, status string, responseStatus int, reason string, nextAttemptAt *time.Time) error
+	ListWebhookDeliveries(ctx context.Context, subscriptionID, status string, limit int) ([]Delivery, error)
+}
+
+// Service хранит подписки, ставит события в очередь доставки и отправляет их.
+type Service struct {
+	cfg    config.WebhooksConfig
+	store  Store
+	client *http.Client
+	log    *slog.Logger
+}
+
+func NewService(cfg config.WebhooksConfig, store Store, log *slog.Logger) *Service {
+	return &Service{
+		cfg:    cfg,
+		store:  store,
+		client: &http.Client{Timeout: cfg.Timeout},
+		log:    log,
+	}
+}
+
+// Publish реализует messages.EventSink: событие сохраняется как доставки
+// подписчикам в той же БД, поэтому переживает перезапуск сервиса.
+func (s *Service) Publish(ctx context.Context, event messages.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+
+	n, err := s.store.CreateWebhookDeliveries(ctx, event, payload)
+	if err != nil {
+		return fmt.Errorf("create webhook deliveries: %w", err)
+	}
+	if n > 0 {
+		s.log.Debug("webhook deliveries queued",
+			slog.String("id", event.MailID),
+			slog.String("type", event.Type),
+			slog.Int("subscriptions", n),
+		)
+	}
+	return nil
+}
+
+// Subscribe создаёт подписку. Если секрет не задан, он генерируется и
+// возвращается в ответе один раз.
+func (s *Service) Subscribe(ctx context.Context, sub Subscription) (*Subscription, error) {
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an absolute http(s) url", ErrInvalidSubscription)
+	}
+	if len(sub.EventTypes) == 0 {
+		return nil, fmt.Errorf("%w: event_types is empty", ErrInvalidSubscription)
+	}
+	for _, t := range sub.EventTypes {
+		if !slices.Contains(EventTypes, t) {
+			return nil, fmt.Errorf("%w: unknown event type %q", ErrInvalidSubscription, t)
+		}
+	}
+
+	if sub.Secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("generate secret: %w", err)
+		}
+		sub.Secret = hex.EncodeToString(buf)
+	}
+	sub.ID = uuid.NewString()
+	sub.Active = true
+	sub.CreatedAt = time.Now().UTC()
+
+	if err := s.store.CreateSubscription(ctx, sub); err != nil {
+		return nil, fmt.Errorf("create subscription: %w", err)
+	}
+	return &sub, nil
+}
+
+func (s *Service) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	subs, err := s.store.ListSubscriptions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list subscriptions: %w", err)
+	}
+	return subs, nil
+}
+
+func (s *Service) Unsubscribe(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrSubscriptionNotFound
+	}
+	if err := s.store.DeleteSubscription(ctx, id); err != nil {
+		return fmt.Errorf("delete subscription: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) ListDeliveries(ctx context.Context, subscriptionID, status string, limit int) ([]Delivery, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	deliveries, err := s.store.ListWebhookDeliveries(ctx, subscriptionID, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// Run периодически отправляет доставки из очереди до отмены ctx.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.deliverDue(ctx); err != nil && ctx.Err() == nil {
+			s.log.Error("webhook delivery cycle failed", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) deliverDue(ctx context.Context) error {
+	deliveries, err := s.store.ClaimDueWebhookDeliveries(ctx, s.cfg.BatchSize, s.claimLease())
+	if err != nil {
+		return fmt.Errorf("claim deliveries: %w", err)
+	}
+
+	for _, d := range deliveries {
+		if ctx.Err() != nil {
+			return nil
+		}
+		s.deliver(ctx, d)
+	}
+	return nil
+}
+
+// claimLease — на сколько выборка «занимает» доставки, чтобы их не взяла
+// другая реплика. Доставки пакета отправляются по очереди, и каждая может
+// ждать ответа до Timeout, поэтому аренда покрывает весь пакет.
+func (s *Service) claimLease() time.Duration {
+	return time.Duration(max(s.cfg.BatchSize, 1))*s.cfg.Timeout + claimMargin
+}
+
+func (s *Service) deliver(ctx context.Context, d Delivery) {
+	responseStatus, err := s.post(ctx, d)
+	if err == nil {
+		if err := s.store.FinishWebhookDelivery(ctx, d, StatusDelivered, responseStatus, "", nil); err != nil {
+			s.log.Error("failed to mark webhook delivered", slog.Any("error", err), slog.String("delivery_id", d.ID))
+		}
+		return
+	}
+
+	status := StatusFailed
+	var next *time.Time
+	if d.Attempts+1 < s.cfg.MaxAttempts {
+		at := time.Now().UTC().Add(s.backoff(d.Attempts + 1))
+		next = &at
+		status = StatusPending
+	}
+
+	if markErr := s.store.FinishWebhookDelivery(ctx, d, status, responseStatus, err.Error(), next); markErr != nil {
+		s.log.Error("failed to mark webhook delivery failed", slog.Any("error", markErr), slog.String("delivery_id", d.ID))
+	}
+	s.log.Warn("webhook delivery failed",
+		slog.Any("error", err),
+		slog.String("delivery_id", d.ID),
+		slog.String("type", d.EventType),
+		slog.Int("attempt", d.Attempts+1),
+	)
+}
+
+func (s *Service) post(ctx context.Context, d Delivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, d.EventType)
+	req.Header.Set(HeaderDelivery, d.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, "sha256="+Sign(d.Secret, timestamp, d.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("subscriber responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign считает HMAC-SHA256 от "<timestamp>.<body>" в hex. Подписчик
+// проверяет подпись тем же секретом и отбрасывает запросы со старым timestamp.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff растёт экспоненциально от RetryBackoff и ограничен часом.
+func (s *Service) backoff(attempt int) time.Duration {
+	delay := s.cfg.RetryBackoff
+	for i := 1; i < attempt && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	return min(delay, time.Hour)
+}
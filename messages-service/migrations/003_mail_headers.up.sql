@@ -0,0 +1,7 @@
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS subject TEXT NOT NULL DEFAULT '';
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS cc TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS message_id TEXT;
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS in_reply_to TEXT;
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS mail_references TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_mails_message_id ON mails (message_id);
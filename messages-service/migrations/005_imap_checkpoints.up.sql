@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS imap_checkpoints (
+    mailbox TEXT PRIMARY KEY,
+    uid_validity BIGINT NOT NULL,
+    last_uid BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS outbound_deliveries (
+    id UUID PRIMARY KEY,
+    mail_id UUID NOT NULL UNIQUE REFERENCES mails (id) ON DELETE CASCADE,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    message_id TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbound_deliveries_due ON outbound_deliveries (status, next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS outbound_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    delivery_id UUID NOT NULL REFERENCES outbound_deliveries (id) ON DELETE CASCADE,
+    attempt INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    error TEXT,
+    attempted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbound_attempts_delivery_id ON outbound_attempts (delivery_id);
@@ -0,0 +1,18 @@
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS thread_id UUID;
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS subject_norm TEXT NOT NULL DEFAULT '';
+
+-- Уже сохранённые письма становятся отдельными ветками.
+UPDATE mails SET thread_id = id WHERE thread_id IS NULL;
+-- Нормализация совпадает с messages.NormalizeSubject: префиксы ответа
+-- убираются, пробелы схлопываются, тема приводится к нижнему регистру.
+UPDATE mails
+SET subject_norm = lower(btrim(regexp_replace(
+    regexp_replace(subject, '^(\s*(re|fwd?|aw|wg|sv|отв|ответ|пересл)(\[\d+\])?\s*:\s*)+', '', 'i'),
+    '\s+', ' ', 'g')))
+WHERE subject <> '';
+
+ALTER TABLE mails ALTER COLUMN thread_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_mails_thread_id ON mails (thread_id, received_at);
+CREATE INDEX IF NOT EXISTS idx_mails_subject_norm ON mails (subject_norm, received_at) WHERE subject_norm <> '';
+CREATE INDEX IF NOT EXISTS idx_outbound_deliveries_message_id ON outbound_deliveries (message_id);
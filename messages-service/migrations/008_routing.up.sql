@@ -0,0 +1,5 @@
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS queue TEXT;
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS department TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_mails_queue_inbox ON mails (queue, received_at) WHERE processed = TRUE AND is_approved = FALSE;
+CREATE INDEX IF NOT EXISTS idx_mails_department_inbox ON mails (department, received_at) WHERE processed = TRUE AND is_approved = FALSE;
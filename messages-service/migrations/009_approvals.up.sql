@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS approval_steps (
+    id UUID PRIMARY KEY,
+    mail_id UUID NOT NULL REFERENCES mails (id) ON DELETE CASCADE,
+    step_order INTEGER NOT NULL,
+    team TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    approver TEXT,
+    comment TEXT,
+    decided_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (mail_id, team)
+);
+
+CREATE INDEX IF NOT EXISTS idx_approval_steps_team_pending ON approval_steps (team) WHERE status = 'pending';
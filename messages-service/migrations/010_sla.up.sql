@@ -0,0 +1,5 @@
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS sla_deadline TIMESTAMPTZ;
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS sla_state TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_mails_sla_open ON mails (sla_deadline) WHERE sla_state IN ('ok', 'at_risk');
+CREATE INDEX IF NOT EXISTS idx_mails_sla_state ON mails (sla_state);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS mail_events (
+    seq BIGSERIAL PRIMARY KEY,
+    id UUID NOT NULL UNIQUE,
+    type TEXT NOT NULL,
+    mail_id UUID NOT NULL REFERENCES mails (id) ON DELETE CASCADE,
+    team TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT '',
+    data JSONB,
+    at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mail_events_team ON mail_events (team, seq);
+CREATE INDEX IF NOT EXISTS idx_mail_events_at ON mail_events (at);
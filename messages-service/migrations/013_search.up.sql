@@ -0,0 +1,18 @@
+-- Поисковый вектор по теме (вес A), полям ответа модели (B) и тексту письма (C)
+-- в русской и английской конфигурациях.
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('russian', coalesce(subject, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(subject, '')), 'A') ||
+    setweight(to_tsvector('russian',
+        coalesce(model_answer->>'request_summary', '') || ' ' ||
+        coalesce(model_answer->>'tags', '') || ' ' ||
+        coalesce(model_answer->>'requisites', '')), 'B') ||
+    setweight(to_tsvector('english',
+        coalesce(model_answer->>'request_summary', '') || ' ' ||
+        coalesce(model_answer->>'tags', '') || ' ' ||
+        coalesce(model_answer->>'requisites', '')), 'B') ||
+    setweight(to_tsvector('russian', coalesce(input, '')), 'C') ||
+    setweight(to_tsvector('english', coalesce(input, '')), 'C')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_mails_search_vector ON mails USING GIN (search_vector);
@@ -0,0 +1,14 @@
+-- Поля ответа модели, вынесенные из model_answer для фильтрации. Заполняются
+-- при сохранении результата; для уже обработанных писем — командой cmd/backfill.
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS category TEXT;
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS urgency TEXT;
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS main_approver TEXT;
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS required_approvers TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS legal_risks TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_mails_category ON mails (category, received_at);
+CREATE INDEX IF NOT EXISTS idx_mails_urgency ON mails (urgency, received_at);
+CREATE INDEX IF NOT EXISTS idx_mails_main_approver ON mails (main_approver);
+CREATE INDEX IF NOT EXISTS idx_mails_tags ON mails USING GIN (tags);
+CREATE INDEX IF NOT EXISTS idx_mails_legal_risks ON mails (received_at) WHERE legal_risks <> '';
@@ -0,0 +1,10 @@
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS legal_hold BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS legal_hold_reason TEXT;
+-- purge_level: NULL — письмо не чистилось, body — удалён текст и вложения,
+-- content — дополнительно ответ модели и ассистента.
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS purge_level TEXT;
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS purged_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_mails_retention ON mails (received_at) WHERE legal_hold = FALSE;
+CREATE INDEX IF NOT EXISTS idx_mails_from_email_lower ON mails (lower(from_email));
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_mail_id ON webhook_deliveries (mail_id);
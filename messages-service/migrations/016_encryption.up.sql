@@ -0,0 +1,27 @@
+-- Зашифрованные значения колонок (формат — internal/fieldcrypt). Пока
+-- шифрование выключено, *_enc пусты; при включённом открытые колонки
+-- остаются пустыми (input = '', model_answer и assistant_response — NULL).
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS input_enc BYTEA;
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS model_answer_enc BYTEA;
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS assistant_response_enc BYTEA;
+-- enc_key_id — самый старый мастер-ключ среди зашифрованных полей строки;
+-- по нему фоновое перешифрование находит строки после смены ключа.
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS enc_key_id TEXT;
+
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS extracted_text_enc BYTEA;
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS enc_key_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_mails_enc_key_id ON mails (enc_key_id) WHERE enc_key_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_attachments_enc_key_id ON attachments (enc_key_id) WHERE enc_key_id IS NOT NULL;
+
+-- Данные событий и тела вебхуков (тема, отправитель, теги) хранятся так же,
+-- как поля писем: открытая колонка пуста, значение — в *_enc.
+ALTER TABLE mail_events ADD COLUMN IF NOT EXISTS data_enc BYTEA;
+ALTER TABLE mail_events ADD COLUMN IF NOT EXISTS enc_key_id TEXT;
+
+ALTER TABLE webhook_deliveries ALTER COLUMN payload DROP NOT NULL;
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS payload_enc BYTEA;
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS enc_key_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_mail_events_enc_key_id ON mail_events (enc_key_id) WHERE enc_key_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_enc_key_id ON webhook_deliveries (enc_key_id) WHERE enc_key_id IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Письма, отсеянные префильтром до LLM, получают status = 'filtered'.
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS filter_kind TEXT;
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS filter_reason TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_mails_filtered ON mails (filter_kind, received_at) WHERE status = 'filtered';
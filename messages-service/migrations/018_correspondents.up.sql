@@ -0,0 +1,44 @@
+-- Справочник корреспондентов: отправители по нормализованному адресу и
+-- контрагенты по реквизитам (ИНН, название организации) из ответа модели.
+-- Справочник не шифруется даже при encryption.enabled: по адресу, ИНН и
+-- названию работают уникальные индексы и поиск (см. README, «Шифрование данных»).
+CREATE TABLE IF NOT EXISTS organizations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    inn TEXT,
+    name TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Контрагент с ИНН один на ИНН; без ИНН — один на название.
+CREATE UNIQUE INDEX IF NOT EXISTS organizations_inn_key ON organizations (inn) WHERE inn IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS organizations_name_key ON organizations (lower(name)) WHERE inn IS NULL;
+
+CREATE TABLE IF NOT EXISTS senders (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    email TEXT NOT NULL UNIQUE, -- адрес без имени в нижнем регистре
+    display_name TEXT NOT NULL DEFAULT '',
+    organization_id UUID REFERENCES organizations (id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_senders_organization_id ON senders (organization_id);
+
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS sender_id UUID REFERENCES senders (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_mails_sender_id ON mails (sender_id, received_at DESC);
+
+-- Отправители уже принятых писем. Адрес берётся из угловых скобок, если
+-- они есть, — так же, как его нормализует сервис.
+INSERT INTO senders (email)
+SELECT DISTINCT lower(trim(COALESCE(substring(from_email FROM '<([^<>]+)>'), from_email)))
+FROM mails
+WHERE trim(from_email) <> ''
+ON CONFLICT DO NOTHING;
+
+UPDATE mails m
+SET sender_id = s.id
+FROM senders s
+WHERE m.sender_id IS NULL
+  AND s.email = lower(trim(COALESCE(substring(m.from_email FROM '<([^<>]+)>'), m.from_email)));
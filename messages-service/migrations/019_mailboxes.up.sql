@@ -0,0 +1,22 @@
+-- Получатели письма хранятся списком; прежний единственный адрес
+-- становится первым элементом. Переименование и смена типа выполняются
+-- только пока есть старая колонка, чтобы миграцию можно было повторить.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'mails' AND column_name = 'to_email'
+    ) THEN
+        ALTER TABLE mails RENAME COLUMN to_email TO to_emails;
+        ALTER TABLE mails ALTER COLUMN to_emails DROP DEFAULT;
+        ALTER TABLE mails ALTER COLUMN to_emails TYPE TEXT[]
+            USING CASE WHEN to_emails = '' THEN '{}'::TEXT[] ELSE ARRAY[to_emails] END;
+    END IF;
+END $$;
+ALTER TABLE mails ALTER COLUMN to_emails SET DEFAULT '{}';
+
+-- mailbox — id общего ящика из конфига; NULL — письмо обработано с
+-- настройками сервиса.
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS mailbox TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_mails_mailbox ON mails (mailbox, received_at) WHERE mailbox IS NOT NULL;
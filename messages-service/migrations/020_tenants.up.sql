@@ -0,0 +1,101 @@
+-- Тенанты: у каждой строки данных писем есть tenant_id, а политики RLS
+-- показывают сессии только строки её тенанта. Тенант сессии задаётся в
+-- app.tenant_id при открытии соединения (internal/storage/postgresql);
+-- '*' — общий пул фоновых воркеров, который видит всех тенантов. Сессия без
+-- app.tenant_id не видит ничего.
+CREATE OR REPLACE FUNCTION app_tenant() RETURNS TEXT
+LANGUAGE sql STABLE AS $$
+    SELECT NULLIF(current_setting('app.tenant_id', true), '')
+$$;
+
+-- Тенант новой строки по умолчанию: тенант сессии, для общего пула и
+-- существующих строк — default.
+CREATE OR REPLACE FUNCTION app_tenant_default() RETURNS TEXT
+LANGUAGE sql STABLE AS $$
+    SELECT COALESCE(NULLIF(app_tenant(), '*'), 'default')
+$$;
+
+-- Строки, принадлежащие письму или подписке, получают тенант родителя:
+-- фоновый воркер пишет их из общего пула, а сессия тенанта не может
+-- привязать строку к чужому письму — родитель ей не виден, и вставка
+-- падает на NOT NULL.
+CREATE OR REPLACE FUNCTION set_tenant_from_parent() RETURNS TRIGGER
+LANGUAGE plpgsql AS $$
+BEGIN
+    EXECUTE format('SELECT tenant_id FROM %I WHERE id = $1.%I', TG_ARGV[0], TG_ARGV[1])
+        INTO NEW.tenant_id
+        USING NEW;
+    RETURN NEW;
+END;
+$$;
+
+DO $$
+DECLARE
+    t TEXT;
+BEGIN
+    FOREACH t IN ARRAY ARRAY[
+        'mails', 'attachments', 'approval_steps', 'mail_events',
+        'outbound_deliveries', 'outbound_attempts',
+        'webhook_subscriptions', 'webhook_deliveries',
+        'senders', 'organizations'
+    ] LOOP
+        EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT app_tenant_default()', t);
+        EXECUTE format('ALTER TABLE %I ENABLE ROW LEVEL SECURITY', t);
+        EXECUTE format('ALTER TABLE %I FORCE ROW LEVEL SECURITY', t);
+        EXECUTE format('DROP POLICY IF EXISTS tenant_isolation ON %I', t);
+        EXECUTE format(
+            'CREATE POLICY tenant_isolation ON %I USING (app_tenant() = ''*'' OR tenant_id = app_tenant()) '
+            'WITH CHECK (app_tenant() = ''*'' OR tenant_id = app_tenant())', t);
+    END LOOP;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS attachments_tenant ON attachments;
+CREATE TRIGGER attachments_tenant BEFORE INSERT ON attachments
+    FOR EACH ROW EXECUTE FUNCTION set_tenant_from_parent('mails', 'mail_id');
+DROP TRIGGER IF EXISTS approval_steps_tenant ON approval_steps;
+CREATE TRIGGER approval_steps_tenant BEFORE INSERT ON approval_steps
+    FOR EACH ROW EXECUTE FUNCTION set_tenant_from_parent('mails', 'mail_id');
+DROP TRIGGER IF EXISTS mail_events_tenant ON mail_events;
+CREATE TRIGGER mail_events_tenant BEFORE INSERT ON mail_events
+    FOR EACH ROW EXECUTE FUNCTION set_tenant_from_parent('mails', 'mail_id');
+DROP TRIGGER IF EXISTS outbound_deliveries_tenant ON outbound_deliveries;
+CREATE TRIGGER outbound_deliveries_tenant BEFORE INSERT ON outbound_deliveries
+    FOR EACH ROW EXECUTE FUNCTION set_tenant_from_parent('mails', 'mail_id');
+DROP TRIGGER IF EXISTS outbound_attempts_tenant ON outbound_attempts;
+CREATE TRIGGER outbound_attempts_tenant BEFORE INSERT ON outbound_attempts
+    FOR EACH ROW EXECUTE FUNCTION set_tenant_from_parent('outbound_deliveries', 'delivery_id');
+DROP TRIGGER IF EXISTS webhook_deliveries_tenant ON webhook_deliveries;
+CREATE TRIGGER webhook_deliveries_tenant BEFORE INSERT ON webhook_deliveries
+    FOR EACH ROW EXECUTE FUNCTION set_tenant_from_parent('webhook_subscriptions', 'subscription_id');
+
+CREATE INDEX IF NOT EXISTS idx_mails_tenant_created_at ON mails (tenant_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_tenant_id ON webhook_subscriptions (tenant_id);
+
+-- Справочник корреспондентов у каждого тенанта свой: один адрес или ИНН у
+-- двух тенантов — разные записи.
+ALTER TABLE senders DROP CONSTRAINT IF EXISTS senders_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS senders_tenant_email_key ON senders (tenant_id, email);
+
+DROP INDEX IF EXISTS organizations_inn_key;
+DROP INDEX IF EXISTS organizations_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS organizations_inn_key ON organizations (tenant_id, inn) WHERE inn IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS organizations_name_key ON organizations (tenant_id, lower(name)) WHERE inn IS NULL;
+
+-- Роль, под которой работают пулы тенантов (postgresql.tenant_role). Она
+-- нужна, если сервис подключается суперпользователем или ролью с
+-- BYPASSRLS: на них политики не действуют.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'messages_tenant') THEN
+        CREATE ROLE messages_tenant NOLOGIN NOBYPASSRLS;
+    END IF;
+END;
+$$;
+
+GRANT messages_tenant TO CURRENT_USER;
+GRANT USAGE ON SCHEMA public TO messages_tenant;
+GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO messages_tenant;
+GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA public TO messages_tenant;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO messages_tenant;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT USAGE, SELECT ON SEQUENCES TO messages_tenant;
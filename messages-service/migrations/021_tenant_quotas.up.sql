@@ -0,0 +1,10 @@
+-- Суточные счётчики писем тенантов для quotas.mails_per_day. Квота
+-- резервируется одним UPSERT с условием, поэтому параллельные запросы не
+-- могут её превысить. Таблица вне RLS: сервис обращается к ней из общего
+-- пула.
+CREATE TABLE IF NOT EXISTS tenant_mail_usage (
+    tenant_id TEXT NOT NULL,
+    day DATE NOT NULL,
+    mails INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (tenant_id, day)
+);
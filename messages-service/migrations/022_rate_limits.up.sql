@@ -0,0 +1,19 @@
+-- Ограничение частоты запросов и суточные квоты клиентов API для
+-- rate_limit.backend=postgres. Таблицы общие для всех тенантов и не под RLS:
+-- сервис обращается к ним из общего пула и фильтрует по tenant_id сам.
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    client TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_usage (
+    client TEXT NOT NULL,
+    tenant_id TEXT NOT NULL DEFAULT '',
+    day DATE NOT NULL,
+    metric TEXT NOT NULL, -- requests / mails / llm_tokens
+    used BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (client, day, metric)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_usage_day_tenant ON rate_limit_usage (day, tenant_id);